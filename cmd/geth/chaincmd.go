@@ -144,14 +144,14 @@ The first argument must be the directory containing the blockchain to download f
 	removedbCommand = cli.Command{
 		Action:    utils.MigrateFlags(removeDB),
 		Name:      "removedb",
-		Usage:     "Remove blockchain and state databases",
+		Usage:     "Remove blockchain and state databases and the header dataset",
 		ArgsUsage: " ",
 		Flags: []cli.Flag{
 			utils.DataDirFlag,
 		},
 		Category: "BLOCKCHAIN COMMANDS",
 		Description: `
-Remove blockchain and state databases`,
+Remove blockchain and state databases and the Trie-Hashimoto header dataset`,
 	}
 	dumpCommand = cli.Command{
 		Action:    utils.MigrateFlags(dump),
@@ -214,10 +214,6 @@ func initGenesis(ctx *cli.Context) error {
 
 	for _, name := range []string{"chaindata", "lightchaindata"} {
 		chaindb, err := stack.OpenDatabase(name, 0, 0, "")
-		// impt: open (memory / level) db for trie node when init geth (jmlee)
-		for i:=0;i<trie.GlobalTrieNodeDBLength;i++{
-			trie.GlobalTrieNodeDB[i], _ = stack.OpenDatabase("indexedNodes/index"+strconv.Itoa(i), 0, 0, "")
-		}
 		if err != nil {
 			utils.Fatalf("Failed to open database: %v", err)
 		}
@@ -477,6 +473,13 @@ func removeDB(ctx *cli.Context) error {
 	} else {
 		log.Info("Light node database missing", "path", path)
 	}
+	// Remove the Trie-Hashimoto header dataset
+	path = stack.ResolvePath(config.Eth.THDatasetDir)
+	if common.FileExist(path) {
+		confirmAndRemoveDB(path, "Trie-Hashimoto header dataset")
+	} else {
+		log.Info("Trie-Hashimoto header dataset missing", "path", path)
+	}
 	return nil
 }
 
@@ -80,6 +80,10 @@ var (
 		utils.EthashDatasetDirFlag,
 		utils.EthashDatasetsInMemoryFlag,
 		utils.EthashDatasetsOnDiskFlag,
+		utils.THVerifierFlag,
+		utils.THKeepRecentFlag,
+		utils.THColdAfterFlag,
+		utils.THShardsFlag,
 		utils.TxPoolLocalsFlag,
 		utils.TxPoolNoLocalsFlag,
 		utils.TxPoolJournalFlag,
@@ -127,8 +131,6 @@ var (
 		utils.MinerLegacyExtraDataFlag,
 		utils.MinerRecommitIntervalFlag,
 		utils.MinerNoVerfiyFlag,
-		utils.MinerIMPTFlag,
-		utils.MinerFakeIMPTFlag,
 		utils.NATFlag,
 		utils.NoDiscoverFlag,
 		utils.DiscoveryV5Flag,
@@ -220,8 +222,11 @@ func init() {
 		// See misccmd.go:
 		makecacheCommand,
 		makedagCommand,
+		makethdatasetCommand,
 		versionCommand,
 		licenseCommand,
+		// See thcmd.go:
+		thCommand,
 		// See config.go
 		dumpConfigCommand,
 		// See retesteth.go
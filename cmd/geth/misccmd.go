@@ -25,6 +25,8 @@ import (
 
 	"github.com/ethereum/go-ethereum/cmd/utils"
 	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/thdataset"
 	"github.com/ethereum/go-ethereum/eth"
 	"github.com/ethereum/go-ethereum/params"
 	"gopkg.in/urfave/cli.v1"
@@ -55,6 +57,26 @@ The makedag command generates an ethash DAG in <outputDir>.
 
 This command exists to support the system testing project.
 Regular users do not need to execute it.
+`,
+	}
+	makethdatasetCommand = cli.Command{
+		Action:    utils.MigrateFlags(makethdataset),
+		Name:      "makethdataset",
+		Usage:     "Generate Trie-Hashimoto header dataset",
+		ArgsUsage: "[<outputDir>]",
+		Flags: []cli.Flag{
+			utils.DataDirFlag,
+			utils.AncientFlag,
+			utils.CacheFlag,
+			utils.SyncModeFlag,
+		},
+		Category: "MISCELLANEOUS COMMANDS",
+		Description: `
+The makethdataset command generates the Trie-Hashimoto header dataset of the
+local chain up to its current head in <outputDir>, or in the datadir if no
+directory is given. An existing dataset is extended instead of regenerated.
+
+Regular users do not need to execute it, geth maintains the dataset itself.
 `,
 	}
 	versionCommand = cli.Command{
@@ -106,6 +128,48 @@ func makedag(ctx *cli.Context) error {
 	return nil
 }
 
+// makethdataset generates the Trie-Hashimoto header dataset of the local chain
+// into the provided folder.
+func makethdataset(ctx *cli.Context) error {
+	args := ctx.Args()
+	if len(args) > 1 {
+		utils.Fatalf(`Usage: geth makethdataset [<outputdir>]`)
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	db := utils.MakeChainDatabase(ctx, stack)
+	defer db.Close()
+
+	config := rawdb.ReadChainConfig(db, rawdb.ReadCanonicalHash(db, 0))
+	if config == nil {
+		utils.Fatalf("No chain config found, is the database initialised?")
+	}
+	th := config.TrieHashimoto
+	if th == nil || th.Fake || !th.ReadHeader {
+		utils.Fatalf("Chain does not mix block headers into trie mining")
+	}
+	dir := stack.ResolvePath(eth.DefaultConfig.THDatasetDir)
+	if len(args) == 1 {
+		dir = args[0]
+	}
+	head := rawdb.ReadHeaderNumber(db, rawdb.ReadHeadBlockHash(db))
+	if head == nil {
+		utils.Fatalf("No head block found")
+	}
+	dataset, err := thdataset.New(dir, th.DatasetLen)
+	if err != nil {
+		utils.Fatalf("Failed to open header dataset: %v", err)
+	}
+	defer dataset.Close()
+
+	if err := dataset.Generate(db, *head); err != nil {
+		utils.Fatalf("Failed to generate header dataset: %v", err)
+	}
+	fmt.Printf("Generated header dataset of %d blocks (%d words) in %s\n", dataset.Blocks(), len(dataset.Words()), dir)
+	return nil
+}
+
 func version(ctx *cli.Context) error {
 	fmt.Println(strings.Title(clientIdentifier))
 	fmt.Println("Version:", params.VersionWithMeta)
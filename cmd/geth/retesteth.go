@@ -230,13 +230,17 @@ func (e *NoRewardEngine) accumulateRewards(config *params.ChainConfig, state *st
 }
 
 func (e *NoRewardEngine) Finalize(chain consensus.ChainReader, header *types.Header, statedb *state.StateDB, txs []*types.Transaction,
-	uncles []*types.Header, trieNonces []uint64) {
+	uncles []*types.Header, trieNonces []uint64, storageNonces []types.StorageTrieNonces) error {
 	if e.rewardsOn {
-		e.inner.Finalize(chain, header, statedb, txs, uncles, trieNonces)
-	} else {
-		e.accumulateRewards(chain.Config(), statedb, header, uncles)
-		header.Root = statedb.IntermediateRootByNonce(chain.Config().IsEIP158(header.Number), trieNonces, header.Number.Uint64())
+		return e.inner.Finalize(chain, header, statedb, txs, uncles, trieNonces, storageNonces)
+	}
+	e.accumulateRewards(chain.Config(), statedb, header, uncles)
+	root, err := misc.TrieHashimotoRoot(chain.Config(), header, statedb, trieNonces, storageNonces)
+	if err != nil {
+		return err
 	}
+	header.Root = root
+	return nil
 }
 
 func (e *NoRewardEngine) FinalizeAndAssemble(chain consensus.ChainReader, header *types.Header, statedb *state.StateDB, txs []*types.Transaction,
@@ -252,8 +256,8 @@ func (e *NoRewardEngine) FinalizeAndAssemble(chain consensus.ChainReader, header
 	}
 }
 
-func (e *NoRewardEngine) Seal(chain consensus.ChainReader, block *types.Block, state *state.StateDB, results chan<- *types.Block, stop <-chan struct{}, impt bool) error {
-	return e.inner.Seal(chain, block, state, results, stop, impt)
+func (e *NoRewardEngine) Seal(chain consensus.ChainReader, block *types.Block, state *state.StateDB, results chan<- *types.Block, stop <-chan struct{}) error {
+	return e.inner.Seal(chain, block, state, results, stop)
 }
 
 func (e *NoRewardEngine) SealHash(header *types.Header) common.Hash {
@@ -0,0 +1,184 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/cmd/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/eth"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/trie"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	thCommand = cli.Command{
+		Name:      "th",
+		Usage:     "Manage Trie-Hashimoto state",
+		ArgsUsage: "",
+		Category:  "BLOCKCHAIN COMMANDS",
+		Description: `
+The th commands maintain the Trie-Hashimoto state trie nodes of the local chain.`,
+		Subcommands: []cli.Command{
+			{
+				Name:      "prune",
+				Usage:     "Delete stale trie nodes by block age",
+				ArgsUsage: " ",
+				Action:    utils.MigrateFlags(pruneTrieNodes),
+				Category:  "BLOCKCHAIN COMMANDS",
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.AncientFlag,
+					utils.CacheFlag,
+					utils.KeepRecentFlag,
+				},
+				Description: `
+    geth th prune --keep-recent 128
+
+deletes the trie nodes mined before the most recent states that none of them
+reaches, keeping the given number of recent states intact. The node age index
+is iterated from the oldest block on, so only old trie nodes are visited. Nodes
+flushed before the index was kept are not pruned.`,
+			},
+			{
+				Name:      "reshard",
+				Usage:     "Move the trie nodes into the shards of a new sharding policy",
+				ArgsUsage: " ",
+				Action:    utils.MigrateFlags(reshardTrieNodes),
+				Category:  "BLOCKCHAIN COMMANDS",
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.AncientFlag,
+					utils.CacheFlag,
+					utils.THShardsFlag,
+				},
+				Description: `
+    geth th reshard --th.shards prefix:4
+
+moves every trie node of the local chain into its shard of the given sharding
+policy, which the node then has to be run with. Nodes are copied before they
+are deleted, so an interrupted run may just be restarted.`,
+			},
+		},
+	}
+)
+
+// pruneTrieNodes deletes the trie nodes of the local chain that are no longer
+// reachable from its recent states.
+func pruneTrieNodes(ctx *cli.Context) error {
+	if len(ctx.Args()) > 0 {
+		utils.Fatalf("This command doesn't take any arguments")
+	}
+	keep := ctx.Uint64(utils.KeepRecentFlag.Name)
+	if keep == 0 {
+		utils.Fatalf("At least one recent state must be kept")
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	db := utils.MakeChainDatabase(ctx, stack)
+	defer db.Close()
+
+	config := rawdb.ReadChainConfig(db, rawdb.ReadCanonicalHash(db, 0))
+	if config == nil {
+		utils.Fatalf("No chain config found, is the database initialised?")
+	}
+	if config.TrieHashimoto == nil {
+		utils.Fatalf("Chain doesn't index trie nodes by block number")
+	}
+	head := rawdb.ReadHeaderNumber(db, rawdb.ReadHeadBlockHash(db))
+	if head == nil {
+		utils.Fatalf("No head block found")
+	}
+	if *head < keep {
+		fmt.Printf("Chain has %d blocks only, nothing to prune\n", *head+1)
+		return nil
+	}
+	roots := make([]common.Hash, 0, keep)
+	for number := *head - keep + 1; number <= *head; number++ {
+		header := rawdb.ReadHeader(db, rawdb.ReadCanonicalHash(db, number), number)
+		if header == nil {
+			utils.Fatalf("Missing header of block %d", number)
+		}
+		roots = append(roots, header.Root)
+	}
+	start := time.Now()
+	pruned, err := state.Prune(state.NewDatabase(db), roots, *head-keep+1)
+	if err != nil {
+		utils.Fatalf("Failed to prune trie nodes: %v", err)
+	}
+	fmt.Printf("Pruned %d trie nodes mined before block %d in %v\n", pruned, *head-keep+1, common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// reshardTrieNodes moves the trie nodes of the local chain into the shards of
+// a new sharding policy.
+func reshardTrieNodes(ctx *cli.Context) error {
+	if len(ctx.Args()) > 0 {
+		utils.Fatalf("This command doesn't take any arguments")
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	db := utils.MakeChainDatabase(ctx, stack)
+	defer db.Close()
+
+	var prefixLength int
+	if config := rawdb.ReadChainConfig(db, rawdb.ReadCanonicalHash(db, 0)); config != nil && config.TrieHashimoto != nil {
+		prefixLength = config.TrieHashimoto.PrefixLength
+	}
+	sharder, err := trie.ParseTrieNodeSharder(ctx.GlobalString(utils.THShardsFlag.Name), prefixLength)
+	if err != nil {
+		utils.Fatalf("%v", err)
+	}
+	policy := rawdb.ReadTrieShardPolicy(db)
+	if policy == "" {
+		policy = trie.ShardNone
+	}
+	old, err := trie.ParseTrieNodeSharder(policy, prefixLength)
+	if err != nil {
+		utils.Fatalf("Invalid stored trie node sharding policy: %v", err)
+	}
+	// Open the shards of both policies, the nodes move from the old ones to the new
+	shards := sharder.Shards()
+	if old.Shards() > shards {
+		shards = old.Shards()
+	}
+	stores, err := rawdb.OpenTrieNodeShards(stack.ResolvePath(eth.THShardsDir), shards, 0, 0, "")
+	if err != nil {
+		utils.Fatalf("Failed to open trie node shards: %v", err)
+	}
+	defer func() {
+		for _, store := range stores {
+			store.Close()
+		}
+	}()
+	start := time.Now()
+	moved, err := trie.Reshard(append([]ethdb.KeyValueStore{db}, stores...), sharder)
+	if err != nil {
+		utils.Fatalf("Failed to reshard trie nodes: %v", err)
+	}
+	rawdb.WriteTrieShardPolicy(db, sharder.String())
+
+	fmt.Printf("Moved %d trie nodes from %q to %q shards in %v\n", moved, policy, sharder, common.PrettyDuration(time.Since(start)))
+	return nil
+}
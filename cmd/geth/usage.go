@@ -108,6 +108,15 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.EthashDatasetsOnDiskFlag,
 		},
 	},
+	{
+		Name: "TRIE-HASHIMOTO",
+		Flags: []cli.Flag{
+			utils.THVerifierFlag,
+			utils.THKeepRecentFlag,
+			utils.THColdAfterFlag,
+			utils.THShardsFlag,
+		},
+	},
 	//{
 	//	Name: "DASHBOARD",
 	//	Flags: []cli.Flag{
@@ -210,8 +219,6 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.MinerExtraDataFlag,
 			utils.MinerRecommitIntervalFlag,
 			utils.MinerNoVerfiyFlag,
-			utils.MinerIMPTFlag,
-			utils.MinerFakeIMPTFlag,
 		},
 	},
 	{
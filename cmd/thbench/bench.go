@@ -0,0 +1,377 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	crand "crypto/rand"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"math/rand"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/eth"
+	"github.com/ethereum/go-ethereum/eth/downloader"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/params"
+	"gopkg.in/urfave/cli.v1"
+)
+
+const (
+	engineTH     = "th"     // Trie-Hashimoto mining of the dirty trie nodes, then the block proof-of-work
+	engineEthash = "ethash" // Block proof-of-work only
+
+	receiversIncremental = "incremental" // Receivers are the addresses 1, 2, 3...
+	receiversRandom      = "random"      // Receivers are picked at random
+
+	// minTHTxs is the number of transactions the sealer waits for before mining
+	// the trie nodes of a block.
+	minTHTxs = 200
+)
+
+var errInterrupted = errors.New("interrupted")
+
+// bench is a benchmark run on an in-process node, sending transfers from a set
+// of funded accounts and mining them into blocks one by one.
+type bench struct {
+	stack    *node.Node
+	ethereum *eth.Ethereum
+	signer   types.Signer
+
+	senders []*ecdsa.PrivateKey
+	nonces  []uint64
+	sent    uint64 // Number of transfers sent, also the last incremental receiver
+
+	txs         int
+	incremental bool
+	maxReceiver uint64
+	amounts     bool
+
+	interrupt chan os.Signal
+}
+
+// benchmark is the thbench action, running the benchmark and writing its report.
+func benchmark(ctx *cli.Context) error {
+	setupLogging(ctx)
+
+	config := reportConfig{
+		Engine:      ctx.String(engineFlag.Name),
+		PowMode:     ctx.String(powModeFlag.Name),
+		Senders:     ctx.Int(sendersFlag.Name),
+		TxsPerBlock: ctx.Int(txsFlag.Name),
+		Receivers:   ctx.String(receiversFlag.Name),
+		MaxReceiver: ctx.Uint64(maxReceiverFlag.Name),
+		Incremental: ctx.Bool(amountFlag.Name),
+	}
+	threads, err := parseThreads(ctx.String(threadsFlag.Name))
+	if err != nil {
+		return err
+	}
+	blocks := ctx.Int(blocksFlag.Name)
+	if blocks <= 0 {
+		return fmt.Errorf("invalid block count %d", blocks)
+	}
+	if config.Senders <= 0 || config.TxsPerBlock <= 0 {
+		return fmt.Errorf("invalid sender count %d or transfers per block %d", config.Senders, config.TxsPerBlock)
+	}
+	if config.Receivers != receiversIncremental && config.Receivers != receiversRandom {
+		return fmt.Errorf("invalid receivers %q, want %q or %q", config.Receivers, receiversIncremental, receiversRandom)
+	}
+	var powMode ethash.Mode
+	switch config.PowMode {
+	case "normal":
+		powMode = ethash.ModeNormal
+	case "test":
+		powMode = ethash.ModeTest
+	case "fake":
+		powMode = ethash.ModeFake
+	default:
+		return fmt.Errorf("invalid ethash mode %q, want \"normal\", \"test\" or \"fake\"", config.PowMode)
+	}
+	// Assemble the chain configuration of the benchmarked scheme
+	chainConfig := *params.AllEthashProtocolChanges
+	switch config.Engine {
+	case engineTH:
+		if config.TxsPerBlock < minTHTxs {
+			return fmt.Errorf("trie-hashimoto blocks need at least %d transfers, have %d", minTHTxs, config.TxsPerBlock)
+		}
+		config.PrefixLength = ctx.Int(prefixLengthFlag.Name)
+		config.ReadHeader = ctx.BoolT(readHeaderFlag.Name)
+		config.Fake = ctx.Bool(fakeFlag.Name)
+
+		th := *params.DefaultTrieHashimotoConfig
+		th.PrefixLength = config.PrefixLength
+		th.ReadHeader = config.ReadHeader
+		th.Fake = config.Fake
+		if difficulty := ctx.Uint64(trieDifficultyFlag.Name); difficulty > 0 {
+			th.InitialDifficulty = new(big.Int).SetUint64(difficulty)
+		}
+		chainConfig.TrieHashimoto = &th
+	case engineEthash:
+	default:
+		return fmt.Errorf("invalid engine %q, want %q or %q", config.Engine, engineTH, engineEthash)
+	}
+	// Create the node in the requested or a temporary data directory
+	datadir := ctx.String(dataDirFlag.Name)
+	if datadir == "" {
+		if datadir, err = ioutil.TempDir("", "thbench"); err != nil {
+			return err
+		}
+		defer os.RemoveAll(datadir)
+	}
+	b, err := newBench(datadir, &chainConfig, powMode, config)
+	if err != nil {
+		return err
+	}
+	defer b.stack.Close()
+
+	// Mine the blocks with every thread count, writing whatever was mined
+	report := &report{Config: config}
+	for _, n := range threads {
+		log.Info("Benchmarking mining", "engine", config.Engine, "threads", n, "blocks", blocks, "txs", config.TxsPerBlock)
+		for i := 0; i < blocks; i++ {
+			result, err := b.mineBlock(n)
+			if err == errInterrupted {
+				log.Warn("Benchmark interrupted, writing partial report")
+				return report.write(ctx.String(reportFlag.Name))
+			}
+			if err != nil {
+				return err
+			}
+			report.Blocks = append(report.Blocks, result)
+			fmt.Printf("block %d: threads %d, txs %d, trie nodes %d, trie mining %v, block time %v, db size %s\n",
+				result.Number, result.Threads, result.Txs, result.TrieNodes+result.StorageNodes,
+				secondsDuration(result.TrieMiningTime), secondsDuration(result.BlockTime), common.StorageSize(result.DBSize).TerminalString())
+		}
+	}
+	return report.write(ctx.String(reportFlag.Name))
+}
+
+// parseThreads parses the comma separated mining thread counts.
+func parseThreads(spec string) ([]int, error) {
+	var threads []int
+	for _, field := range strings.Split(spec, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid mining thread count %q", field)
+		}
+		threads = append(threads, n)
+	}
+	return threads, nil
+}
+
+// newBench starts an isolated node on a fresh chain funding the senders.
+func newBench(datadir string, chainConfig *params.ChainConfig, powMode ethash.Mode, config reportConfig) (*bench, error) {
+	senders := make([]*ecdsa.PrivateKey, config.Senders)
+	alloc := make(core.GenesisAlloc)
+	for i := range senders {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			return nil, err
+		}
+		senders[i] = key
+		alloc[crypto.PubkeyToAddress(key.PublicKey)] = core.GenesisAccount{
+			Balance: new(big.Int).Exp(big.NewInt(2), big.NewInt(128), nil),
+		}
+	}
+	// Raise the gas limit enough for all transfers of a block to fit in
+	gasLimit := params.GenesisGasLimit
+	if need := uint64(config.TxsPerBlock) * params.TxGas; need > gasLimit {
+		gasLimit = need
+	}
+	genesis := &core.Genesis{
+		Config:     chainConfig,
+		Difficulty: params.MinimumDifficulty,
+		GasLimit:   gasLimit,
+		Alloc:      alloc,
+	}
+	stack, err := node.New(&node.Config{
+		Name:    "thbench",
+		Version: params.Version,
+		DataDir: datadir,
+		P2P: p2p.Config{
+			NoDiscovery: true,
+			MaxPeers:    0,
+		},
+		NoUSB: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ethConfig := eth.DefaultConfig
+	ethConfig.Genesis = genesis
+	ethConfig.NetworkId = chainConfig.ChainID.Uint64()
+	ethConfig.SyncMode = downloader.FullSync
+	ethConfig.Ethash.PowMode = powMode
+	ethConfig.Miner.Etherbase = crypto.PubkeyToAddress(senders[0].PublicKey)
+	ethConfig.Miner.GasFloor = gasLimit
+	ethConfig.Miner.GasCeil = gasLimit
+
+	if err := stack.Register(func(ctx *node.ServiceContext) (node.Service, error) {
+		return eth.New(ctx, &ethConfig)
+	}); err != nil {
+		return nil, err
+	}
+	if err := stack.Start(); err != nil {
+		return nil, err
+	}
+	var ethereum *eth.Ethereum
+	if err := stack.Service(&ethereum); err != nil {
+		stack.Close()
+		return nil, err
+	}
+	b := &bench{
+		stack:       stack,
+		ethereum:    ethereum,
+		signer:      types.NewEIP155Signer(chainConfig.ChainID),
+		senders:     senders,
+		nonces:      make([]uint64, len(senders)),
+		txs:         config.TxsPerBlock,
+		incremental: config.Receivers == receiversIncremental,
+		maxReceiver: config.MaxReceiver,
+		amounts:     config.Incremental,
+		interrupt:   make(chan os.Signal, 1),
+	}
+	signal.Notify(b.interrupt, syscall.SIGINT, syscall.SIGTERM)
+	return b, nil
+}
+
+// receiver returns the receiver of the next transfer.
+func (b *bench) receiver() common.Address {
+	switch {
+	case b.incremental:
+		return common.BigToAddress(new(big.Int).SetUint64(b.sent))
+	case b.maxReceiver > 0:
+		return common.BigToAddress(new(big.Int).SetUint64(1 + uint64(rand.Int63n(int64(b.maxReceiver)))))
+	default:
+		var addr common.Address
+		crand.Read(addr[:])
+		return addr
+	}
+}
+
+// sendTransfers signs the transfers of a block and adds them into the pool.
+func (b *bench) sendTransfers() error {
+	txs := make([]*types.Transaction, b.txs)
+	for i := range txs {
+		b.sent++
+
+		amount := big.NewInt(1)
+		if b.amounts {
+			amount.SetUint64(b.sent)
+		}
+		sender := int(b.sent % uint64(len(b.senders)))
+		tx := types.NewTransaction(b.nonces[sender], b.receiver(), amount, params.TxGas, big.NewInt(params.GWei), nil)
+		signed, err := types.SignTx(tx, b.signer, b.senders[sender])
+		if err != nil {
+			return err
+		}
+		b.nonces[sender]++
+		txs[i] = signed
+	}
+	for i, err := range b.ethereum.TxPool().AddLocals(txs) {
+		if err != nil {
+			return fmt.Errorf("failed to add transfer %x: %v", txs[i].Hash(), err)
+		}
+	}
+	return nil
+}
+
+// mineBlock sends the transfers of a block and mines it with the given number
+// of threads, measuring the mining time and the database growth.
+func (b *bench) mineBlock(threads int) (*blockResult, error) {
+	chain := b.ethereum.BlockChain()
+	heads := make(chan core.ChainHeadEvent, 16)
+	sub := chain.SubscribeChainHeadEvent(heads)
+	defer sub.Unsubscribe()
+
+	if err := b.sendTransfers(); err != nil {
+		return nil, err
+	}
+	parent := chain.CurrentBlock().NumberU64()
+	start := time.Now()
+	if err := b.ethereum.StartMining(threads); err != nil {
+		return nil, err
+	}
+	defer b.ethereum.StopMining()
+
+	for {
+		select {
+		case head := <-heads:
+			block := head.Block
+			if block.NumberU64() <= parent {
+				continue
+			}
+			result := &blockResult{
+				Number:    block.NumberU64(),
+				Threads:   threads,
+				Txs:       len(block.Transactions()),
+				TrieNodes: len(block.TrieNonces()),
+				BlockTime: time.Since(start).Seconds(),
+			}
+			for _, storage := range block.StorageNonces() {
+				result.StorageNodes += len(storage.Nonces)
+			}
+			if engine, ok := b.ethereum.Engine().(*ethash.Ethash); ok {
+				if mined := engine.LastTrieMining(); mined.Number == result.Number {
+					result.TrieMiningTime = mined.Elapsed.Seconds()
+				}
+			}
+			result.DBSize = dirSize(b.stack.ResolvePath("chaindata")) + dirSize(b.stack.ResolvePath(eth.THShardsDir))
+			result.DatasetSize = dirSize(b.stack.ResolvePath(eth.DefaultConfig.THDatasetDir))
+			return result, nil
+
+		case err := <-sub.Err():
+			return nil, err
+
+		case <-b.interrupt:
+			return nil, errInterrupted
+		}
+	}
+}
+
+// dirSize returns the total size of the files in a directory, zero if missing.
+func dirSize(dir string) uint64 {
+	var size uint64
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += uint64(info.Size())
+		}
+		return nil
+	})
+	return size
+}
+
+// secondsDuration converts a number of seconds into a rounded duration.
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second)).Round(time.Millisecond)
+}
@@ -0,0 +1,149 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+// thbench is a benchmark of Trie-Hashimoto and Ethash mining, run on an
+// in-process node.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ethereum/go-ethereum/cmd/utils"
+	"github.com/ethereum/go-ethereum/log"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// Git SHA1 commit hash of the release (set via linker flags)
+var gitCommit = ""
+var gitDate = ""
+
+var app *cli.App
+
+func init() {
+	app = utils.NewApp(gitCommit, gitDate, "a Trie-Hashimoto mining benchmark")
+	app.Flags = []cli.Flag{
+		dataDirFlag,
+		engineFlag,
+		powModeFlag,
+		sendersFlag,
+		txsFlag,
+		blocksFlag,
+		threadsFlag,
+		receiversFlag,
+		maxReceiverFlag,
+		amountFlag,
+		prefixLengthFlag,
+		readHeaderFlag,
+		fakeFlag,
+		trieDifficultyFlag,
+		reportFlag,
+		verbosityFlag,
+	}
+	app.Action = benchmark
+}
+
+// Commonly used command line flags.
+var (
+	dataDirFlag = cli.StringFlag{
+		Name:  "datadir",
+		Usage: "data directory of the benchmarked node (default = temporary, removed afterwards)",
+	}
+	engineFlag = cli.StringFlag{
+		Name:  "engine",
+		Usage: `mining scheme to benchmark, "th" or "ethash"`,
+		Value: engineTH,
+	}
+	powModeFlag = cli.StringFlag{
+		Name:  "ethash.mode",
+		Usage: `block proof-of-work mode, "normal", "test" or "fake"`,
+		Value: "test",
+	}
+	sendersFlag = cli.IntFlag{
+		Name:  "senders",
+		Usage: "number of funded accounts sending the transfers",
+		Value: 200,
+	}
+	txsFlag = cli.IntFlag{
+		Name:  "txs",
+		Usage: "number of transfers per block",
+		Value: 200,
+	}
+	blocksFlag = cli.IntFlag{
+		Name:  "blocks",
+		Usage: "number of blocks to mine per mining thread count",
+		Value: 10,
+	}
+	threadsFlag = cli.StringFlag{
+		Name:  "threads",
+		Usage: "comma separated mining thread counts to benchmark in turn",
+		Value: "1",
+	}
+	receiversFlag = cli.StringFlag{
+		Name:  "receivers",
+		Usage: `transfer receiver addresses, "incremental" or "random"`,
+		Value: receiversRandom,
+	}
+	maxReceiverFlag = cli.Uint64Flag{
+		Name:  "receivers.max",
+		Usage: "upper bound of the random receiver addresses (0 = any address)",
+		Value: 100000000,
+	}
+	amountFlag = cli.BoolFlag{
+		Name:  "amount.incremental",
+		Usage: "transfer incremental amounts instead of 1 wei each",
+	}
+	prefixLengthFlag = cli.IntFlag{
+		Name:  "th.prefix",
+		Usage: "number of trie node hash bytes carrying the block number",
+		Value: 2,
+	}
+	readHeaderFlag = cli.BoolTFlag{
+		Name:  "th.readheader",
+		Usage: "mix the header dataset into trie node hashes",
+	}
+	fakeFlag = cli.BoolFlag{
+		Name:  "th.fake",
+		Usage: "prefix trie node hashes with the block number without mining",
+	}
+	trieDifficultyFlag = cli.Uint64Flag{
+		Name:  "th.difficulty",
+		Usage: "trie difficulty of the genesis block (0 = 1)",
+	}
+	reportFlag = cli.StringFlag{
+		Name:  "report",
+		Usage: "report file, written as CSV if it ends in .csv and as JSON otherwise",
+		Value: "thbench.json",
+	}
+	verbosityFlag = cli.IntFlag{
+		Name:  "verbosity",
+		Usage: "log level of the benchmarked node: 0=silent, 1=error, 2=warn, 3=info, 4=debug, 5=detail",
+		Value: 2,
+	}
+)
+
+func main() {
+	if err := app.Run(os.Args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// setupLogging sets the log level of the benchmarked node.
+func setupLogging(ctx *cli.Context) {
+	handler := log.StreamHandler(os.Stderr, log.TerminalFormat(true))
+	log.Root().SetHandler(log.LvlFilterHandler(log.Lvl(ctx.Int(verbosityFlag.Name)), handler))
+}
@@ -0,0 +1,111 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// reportConfig is the benchmark setup recorded in the report.
+type reportConfig struct {
+	Engine       string `json:"engine"`
+	PowMode      string `json:"powMode"`
+	Senders      int    `json:"senders"`
+	TxsPerBlock  int    `json:"txsPerBlock"`
+	Receivers    string `json:"receivers"`
+	MaxReceiver  uint64 `json:"maxReceiver"`
+	Incremental  bool   `json:"incrementalAmounts"`
+	PrefixLength int    `json:"prefixLength,omitempty"`
+	ReadHeader   bool   `json:"readHeader,omitempty"`
+	Fake         bool   `json:"fake,omitempty"`
+}
+
+// blockResult is the measurement of a mined block. Times are in seconds and
+// sizes in bytes.
+type blockResult struct {
+	Number         uint64  `json:"number"`
+	Threads        int     `json:"threads"`
+	Txs            int     `json:"txs"`
+	TrieNodes      int     `json:"trieNodes"`      // Account trie nodes mined
+	StorageNodes   int     `json:"storageNodes"`   // Storage trie nodes mined
+	TrieMiningTime float64 `json:"trieMiningTime"` // Time taken to mine the trie nodes
+	BlockTime      float64 `json:"blockTime"`      // Time from the start of mining to the block import
+	DBSize         uint64  `json:"dbSize"`         // Size of the chain database, trie node shards included
+	DatasetSize    uint64  `json:"datasetSize"`    // Size of the header dataset
+}
+
+// report is the outcome of a benchmark run.
+type report struct {
+	Config reportConfig   `json:"config"`
+	Blocks []*blockResult `json:"blocks"`
+}
+
+// write writes the report into a file, as CSV rows of the blocks if its name
+// ends in .csv and as JSON otherwise.
+func (r *report) write(path string) error {
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return r.writeCSV(path)
+	}
+	blob, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, blob, 0644); err != nil {
+		return err
+	}
+	log.Info("Wrote benchmark report", "path", path, "blocks", len(r.Blocks))
+	return nil
+}
+
+// writeCSV writes the block measurements into a CSV file.
+func (r *report) writeCSV(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	w.Write([]string{"number", "threads", "txs", "trieNodes", "storageNodes", "trieMiningTime", "blockTime", "dbSize", "datasetSize"})
+	for _, b := range r.Blocks {
+		w.Write([]string{
+			strconv.FormatUint(b.Number, 10),
+			strconv.Itoa(b.Threads),
+			strconv.Itoa(b.Txs),
+			strconv.Itoa(b.TrieNodes),
+			strconv.Itoa(b.StorageNodes),
+			strconv.FormatFloat(b.TrieMiningTime, 'f', -1, 64),
+			strconv.FormatFloat(b.BlockTime, 'f', -1, 64),
+			strconv.FormatUint(b.DBSize, 10),
+			strconv.FormatUint(b.DatasetSize, 10),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	log.Info("Wrote benchmark report", "path", path, "blocks", len(r.Blocks))
+	return nil
+}
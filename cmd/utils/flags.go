@@ -214,6 +214,11 @@ var (
 		Name:  "nocode",
 		Usage: "Exclude contract code (save db lookups)",
 	}
+	KeepRecentFlag = cli.Uint64Flag{
+		Name:  "keep-recent",
+		Usage: "Number of recent states to keep when pruning stale trie nodes",
+		Value: 128,
+	}
 	defaultSyncMode = eth.DefaultConfig.SyncMode
 	SyncModeFlag    = TextMarshalerFlag{
 		Name:  "syncmode",
@@ -303,6 +308,27 @@ var (
 		Usage: "Number of recent ethash mining DAGs to keep on disk (1+GB each)",
 		Value: eth.DefaultConfig.Ethash.DatasetsOnDisk,
 	}
+	// Trie-Hashimoto settings
+	THVerifierFlag = cli.StringFlag{
+		Name:  "th.verifier",
+		Usage: `Trie node nonce verifier ("full" keeps the header dataset in memory, "light" reads it from the database)`,
+		Value: eth.DefaultConfig.THVerifier,
+	}
+	THKeepRecentFlag = cli.Uint64Flag{
+		Name:  "th.keeprecent",
+		Usage: "Number of recent states to keep when pruning stale trie nodes online (0 = no pruning)",
+		Value: eth.DefaultConfig.THKeepRecent,
+	}
+	THColdAfterFlag = cli.Uint64Flag{
+		Name:  "th.coldafter",
+		Usage: "Number of blocks after which trie nodes move into the cold store (0 = keep all nodes hot)",
+		Value: eth.DefaultConfig.THColdAfter,
+	}
+	THShardsFlag = cli.StringFlag{
+		Name:  "th.shards",
+		Usage: `Trie node sharding policy ("none", "hex", "prefix:<shards>" or "epoch:<shards>:<blocks>")`,
+		Value: eth.DefaultConfig.THShards,
+	}
 	// Transaction pool settings
 	TxPoolLocalsFlag = cli.StringFlag{
 		Name:  "txpool.locals",
@@ -453,14 +479,6 @@ var (
 		Name:  "miner.noverify",
 		Usage: "Disable remote sealing verification",
 	}
-	MinerIMPTFlag = cli.BoolFlag{
-		Name:  "impt",
-		Usage: "Do IMPT mining",
-	}
-	MinerFakeIMPTFlag = cli.BoolFlag{
-		Name:  "fakeimpt",
-		Usage: "Do IMPT mining with modifyHash()",
-	}
 	// Account settings
 	UnlockedAccountFlag = cli.StringFlag{
 		Name:  "unlock",
@@ -1287,6 +1305,21 @@ func setEthash(ctx *cli.Context, cfg *eth.Config) {
 	}
 }
 
+func setTrieHashimoto(ctx *cli.Context, cfg *eth.Config) {
+	if ctx.GlobalIsSet(THVerifierFlag.Name) {
+		cfg.THVerifier = ctx.GlobalString(THVerifierFlag.Name)
+	}
+	if ctx.GlobalIsSet(THKeepRecentFlag.Name) {
+		cfg.THKeepRecent = ctx.GlobalUint64(THKeepRecentFlag.Name)
+	}
+	if ctx.GlobalIsSet(THColdAfterFlag.Name) {
+		cfg.THColdAfter = ctx.GlobalUint64(THColdAfterFlag.Name)
+	}
+	if ctx.GlobalIsSet(THShardsFlag.Name) {
+		cfg.THShards = ctx.GlobalString(THShardsFlag.Name)
+	}
+}
+
 func setMiner(ctx *cli.Context, cfg *miner.Config) {
 	if ctx.GlobalIsSet(MinerNotifyFlag.Name) {
 		cfg.Notify = strings.Split(ctx.GlobalString(MinerNotifyFlag.Name), ",")
@@ -1318,12 +1351,6 @@ func setMiner(ctx *cli.Context, cfg *miner.Config) {
 	if ctx.GlobalIsSet(MinerNoVerfiyFlag.Name) {
 		cfg.Noverify = ctx.Bool(MinerNoVerfiyFlag.Name)
 	}
-	if ctx.GlobalIsSet(MinerIMPTFlag.Name) {
-		cfg.IMPT = ctx.GlobalBool(MinerIMPTFlag.Name)
-	}
-	if ctx.GlobalIsSet(MinerFakeIMPTFlag.Name) {
-		cfg.FakeIMPT = ctx.GlobalBool(MinerFakeIMPTFlag.Name)
-	}
 }
 
 func setWhitelist(ctx *cli.Context, cfg *eth.Config) {
@@ -1418,6 +1445,7 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *eth.Config) {
 	setGPO(ctx, &cfg.GPO)
 	setTxPool(ctx, &cfg.TxPool)
 	setEthash(ctx, cfg)
+	setTrieHashimoto(ctx, cfg)
 	setMiner(ctx, &cfg.Miner)
 	setWhitelist(ctx, cfg)
 
@@ -1712,6 +1740,7 @@ func MakeChain(ctx *cli.Context, stack *node.Node) (chain *core.BlockChain, chai
 		TrieDirtyLimit:      eth.DefaultConfig.TrieDirtyCache,
 		TrieDirtyDisabled:   ctx.GlobalString(GCModeFlag.Name) == "archive",
 		TrieTimeLimit:       eth.DefaultConfig.TrieTimeout,
+		THDatasetDir:        stack.ResolvePath(eth.DefaultConfig.THDatasetDir),
 	}
 	if ctx.GlobalIsSet(CacheFlag.Name) || ctx.GlobalIsSet(CacheTrieFlag.Name) {
 		cache.TrieCleanLimit = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheTrieFlag.Name) / 100
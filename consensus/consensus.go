@@ -83,9 +83,11 @@ type Engine interface {
 	// but does not assemble the block.
 	//
 	// Note: The block header and state database might be updated to reflect any
-	// consensus rules that happen at finalization (e.g. block rewards).
+	// consensus rules that happen at finalization (e.g. block rewards). An error
+	// is returned if the account and storage trie nonces do not reproduce the
+	// block's state changes.
 	Finalize(chain ChainReader, header *types.Header, state *state.StateDB, txs []*types.Transaction,
-		uncles []*types.Header, trieNonces []uint64)
+		uncles []*types.Header, trieNonces []uint64, storageNonces []types.StorageTrieNonces) error
 
 	// FinalizeAndAssemble runs any post-transaction state modifications (e.g. block
 	// rewards) and assembles the final block.
@@ -100,7 +102,7 @@ type Engine interface {
 	//
 	// Note, the method returns immediately and will send the result async. More
 	// than one result may also be returned depending on the consensus algorithm.
-	Seal(chain ChainReader, block *types.Block, state *state.StateDB, results chan<- *types.Block, stop <-chan struct{}, impt bool) error
+	Seal(chain ChainReader, block *types.Block, state *state.StateDB, results chan<- *types.Block, stop <-chan struct{}) error
 
 	// SealHash returns the hash of a block prior to it being sealed.
 	SealHash(header *types.Header) common.Hash
@@ -122,4 +124,8 @@ type PoW interface {
 
 	// Hashrate returns the current mining hashrate of a PoW consensus engine.
 	Hashrate() float64
+
+	// TrieHashrate returns the current Trie-Hashimoto trie node mining hashrate
+	// of a PoW consensus engine, reported separately from the block hashrate.
+	TrieHashrate() float64
 }
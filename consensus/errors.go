@@ -34,4 +34,8 @@ var (
 	// ErrInvalidNumber is returned if a block's number doesn't equal it's parent's
 	// plus one.
 	ErrInvalidNumber = errors.New("invalid block number")
+
+	// ErrInvalidTrieNonce is returned if the trie nonces in a block body are not
+	// a valid Trie-Hashimoto mining result for the block's state changes.
+	ErrInvalidTrieNonce = errors.New("invalid trie nonce")
 )
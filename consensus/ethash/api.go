@@ -22,6 +22,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/trie"
 )
 
 var errEthashStopped = errors.New("ethash stopped")
@@ -116,3 +117,98 @@ func (api *API) SubmitHashRate(rate hexutil.Uint64, id common.Hash) bool {
 func (api *API) GetHashrate() uint64 {
 	return uint64(api.ethash.Hashrate())
 }
+
+// TrieAPI exposes Trie-Hashimoto mining methods for the RPC interface, letting
+// external miners search the nonces of the dirty trie nodes of a pending block.
+type TrieAPI struct {
+	ethash *Ethash
+}
+
+// TrieNodeWork is a dirty trie node pending its nonce, as handed out to remote
+// miners. The node hash is the Keccak256 hash of the encoding with the nonce in
+// its last 8 little endian bytes, followed by the digest of the header dataset
+// of the block if readHeader is set, followed by the 8 byte big endian prefix
+// epoch (number >> 8*prefixLength) if it's not zero. It must be prefixed with
+// the block number and, past the prefix, must not exceed the target of the
+// block's trie difficulty.
+type TrieNodeWork struct {
+	Number       hexutil.Uint64 `json:"number"`
+	OriginalHash common.Hash    `json:"originalHash"`
+	Encoding     hexutil.Bytes  `json:"encoding"`
+	PrefixLength int            `json:"prefixLength"`
+	ReadHeader   bool           `json:"readHeader"`
+	LoopAccesses int            `json:"loopAccesses"`
+	DatasetSize  hexutil.Uint64 `json:"datasetSize"`
+	Target       common.Hash    `json:"target"`
+}
+
+// GetTrieWork returns the dirty trie nodes that are being mined and wait for
+// their nonces. The original hash of a node identifies it on submission, while
+// the dataset size, in 32 bit words of the headers before the block, pins the
+// header dataset epoch the nonces are searched over.
+func (api *TrieAPI) GetTrieWork() ([]TrieNodeWork, error) {
+	if api.ethash.config.PowMode != ModeNormal && api.ethash.config.PowMode != ModeTest {
+		return nil, errors.New("not supported")
+	}
+
+	var (
+		workCh = make(chan []*trie.NodeWork, 1)
+		errc   = make(chan error, 1)
+	)
+
+	select {
+	case api.ethash.fetchTrieWorkCh <- &trieWork{errc: errc, res: workCh}:
+	case <-api.ethash.exitCh:
+		return nil, errEthashStopped
+	}
+
+	select {
+	case works := <-workCh:
+		nodes := make([]TrieNodeWork, len(works))
+		for i, work := range works {
+			nodes[i] = TrieNodeWork{
+				Number:       hexutil.Uint64(work.Number),
+				OriginalHash: work.OriginalHash,
+				Encoding:     work.Encoding,
+				PrefixLength: work.Config.PrefixLength,
+				ReadHeader:   work.Config.ReadHeader,
+				LoopAccesses: work.Config.LoopAccesses,
+				DatasetSize:  hexutil.Uint64(work.DatasetSize),
+				Target:       work.Target,
+			}
+		}
+		return nodes, nil
+	case err := <-errc:
+		return nil, err
+	}
+}
+
+// SubmitTrieNonces can be used by external miners to submit the nonces of the
+// pending trie nodes with the given original hashes. Valid nonces are passed on
+// to the block being assembled. It returns false if any of the nonces was
+// invalid or stale, or if the node wasn't pending.
+func (api *TrieAPI) SubmitTrieNonces(hashes []common.Hash, nonces []hexutil.Uint64) bool {
+	if api.ethash.config.PowMode != ModeNormal && api.ethash.config.PowMode != ModeTest {
+		return false
+	}
+	if len(hashes) != len(nonces) {
+		return false
+	}
+	result := &trieResult{
+		hashes: hashes,
+		nonces: make([]uint64, len(nonces)),
+		errc:   make(chan error, 1),
+	}
+	for i, nonce := range nonces {
+		result.nonces[i] = uint64(nonce)
+	}
+
+	select {
+	case api.ethash.submitTrieNoncesCh <- result:
+	case <-api.ethash.exitCh:
+		return false
+	}
+
+	err := <-result.errc
+	return err == nil
+}
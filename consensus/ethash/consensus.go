@@ -249,6 +249,9 @@ func (ethash *Ethash) verifyHeader(chain consensus.ChainReader, header, parent *
 		if header.Time > uint64(time.Now().Add(allowedFutureBlockTime).Unix()) {
 			return consensus.ErrFutureBlock
 		}
+		if header.TrieTime > uint64(time.Now().Add(allowedFutureBlockTime).Unix()) {
+			return consensus.ErrFutureBlock
+		}
 	}
 	if header.Time <= parent.Time {
 		return errZeroBlockTime
@@ -296,6 +299,15 @@ func (ethash *Ethash) verifyHeader(chain consensus.ChainReader, header, parent *
 	if err := misc.VerifyForkHashes(chain.Config(), header, uncle); err != nil {
 		return err
 	}
+	if err := misc.VerifyTrieNoncesHash(chain.Config(), header); err != nil {
+		return err
+	}
+	if err := misc.VerifyTrieDifficulty(chain.Config(), header, parent); err != nil {
+		return err
+	}
+	if err := misc.VerifyTrieTime(chain.Config(), header, parent); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -561,16 +573,22 @@ func (ethash *Ethash) Prepare(chain consensus.ChainReader, header *types.Header)
 		return consensus.ErrUnknownAncestor
 	}
 	header.Difficulty = ethash.CalcDifficulty(chain, header.Time, parent)
+	header.TrieDifficulty = misc.CalcTrieDifficulty(chain.Config(), parent)
 	return nil
 }
 
 // Finalize implements consensus.Engine, accumulating the block and uncle rewards,
 // setting the final state on the header
 // IntermediateRootByNonce calls HashByNonce to make indexed MPT
-func (ethash *Ethash) Finalize(chain consensus.ChainReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header, trieNonces []uint64) {
+func (ethash *Ethash) Finalize(chain consensus.ChainReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header, trieNonces []uint64, storageNonces []types.StorageTrieNonces) error {
 	// Accumulate any block and uncle rewards and commit the final state root
 	accumulateRewards(chain.Config(), state, header, uncles)
-	header.Root = state.IntermediateRootByNonce(chain.Config().IsEIP158(header.Number), trieNonces, header.Number.Uint64())
+	root, err := misc.TrieHashimotoRoot(chain.Config(), header, state, trieNonces, storageNonces)
+	if err != nil {
+		return err
+	}
+	header.Root = root
+	return nil
 }
 
 // FinalizeAndAssemble implements consensus.Engine, accumulating the block and
@@ -584,16 +602,17 @@ func (ethash *Ethash) FinalizeAndAssemble(chain consensus.ChainReader, header *t
 	return types.NewBlock(header, txs, uncles, receipts), nil
 }
 
-// SealHash returns the hash of a block prior to it being sealed.
-// Removed Root field, because state trie root is changed due to IMPT mining
+// SealHash returns the hash of a block prior to it being sealed. On
+// Trie-Hashimoto blocks the trie nonce commitment and trie difficulty are sealed
+// as well, binding the mined state root and its nonces into the proof-of-work.
 func (ethash *Ethash) SealHash(header *types.Header) (hash common.Hash) {
 	hasher := sha3.NewLegacyKeccak256()
 
-	rlp.Encode(hasher, []interface{}{
+	enc := []interface{}{
 		header.ParentHash,
 		header.UncleHash,
 		header.Coinbase,
-		// header.Root,
+		header.Root,
 		header.TxHash,
 		header.ReceiptHash,
 		header.Bloom,
@@ -603,7 +622,14 @@ func (ethash *Ethash) SealHash(header *types.Header) (hash common.Hash) {
 		header.GasUsed,
 		header.Time,
 		header.Extra,
-	})
+	}
+	if header.TrieNoncesHash != (common.Hash{}) {
+		enc = append(enc, header.TrieNoncesHash, header.TrieDifficulty)
+		if header.TrieTime != 0 {
+			enc = append(enc, header.TrieTime)
+		}
+	}
+	rlp.Encode(hasher, enc)
 	hasher.Sum(hash[:0])
 	return hash
 }
@@ -24,6 +24,7 @@ import (
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/consensus/misc"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/params"
 )
@@ -84,3 +85,102 @@ func TestCalcDifficulty(t *testing.T) {
 		}
 	}
 }
+
+// Tests that the trie difficulty is retargeted on the trie mining time the parent
+// records, not on the timestamp delta the block difficulty is retargeted on: a
+// child timestamped later gets the same trie difficulty.
+func TestTrieDifficultyTrieTime(t *testing.T) {
+	config := &params.ChainConfig{TrieHashimoto: &params.TrieHashimotoConfig{
+		Block:      big.NewInt(0),
+		TargetTime: 15,
+	}}
+	fast := &types.Header{Number: big.NewInt(10), Time: 1000, TrieTime: 1010, TrieDifficulty: big.NewInt(1600)}
+	slow := &types.Header{Number: big.NewInt(10), Time: 1000, TrieTime: 1045, TrieDifficulty: big.NewInt(1600)}
+
+	if have := misc.CalcTrieDifficulty(config, fast); have.Cmp(big.NewInt(1700)) != 0 {
+		t.Fatalf("fast trie mining: trie difficulty mismatch: have %v, want 1700", have)
+	}
+	if have := misc.CalcTrieDifficulty(config, slow); have.Cmp(big.NewInt(1400)) != 0 {
+		t.Fatalf("slow trie mining: trie difficulty mismatch: have %v, want 1400", have)
+	}
+	for _, time := range []uint64{1045, 1100, 5000} {
+		header := &types.Header{Number: big.NewInt(11), Time: time, TrieDifficulty: big.NewInt(1400)}
+		if err := misc.VerifyTrieDifficulty(config, header, slow); err != nil {
+			t.Errorf("time %d: failed to verify trie difficulty: %v", time, err)
+		}
+	}
+}
+
+func TestCalcTrieDifficulty(t *testing.T) {
+	config := &params.ChainConfig{TrieHashimoto: &params.TrieHashimotoConfig{
+		Block:             big.NewInt(10),
+		InitialDifficulty: big.NewInt(100),
+		TargetTime:        15,
+	}}
+	tests := []struct {
+		number     int64
+		parentDiff *big.Int
+		trieTime   uint64
+		want       *big.Int
+	}{
+		{8, nil, 10, nil},                           // before the fork
+		{9, nil, 10, big.NewInt(100)},               // first TH block
+		{10, big.NewInt(100), 10, big.NewInt(106)},  // faster than target
+		{10, big.NewInt(100), 20, big.NewInt(100)},  // within twice the target
+		{10, big.NewInt(100), 45, big.NewInt(88)},   // slower than twice the target
+		{10, big.NewInt(8), 10, big.NewInt(9)},      // at least one step
+		{10, big.NewInt(8), 3000, big.NewInt(1)},    // never below the minimum
+		{10, big.NewInt(1000), 3000, big.NewInt(1)}, // steps capped at -99
+		{10, big.NewInt(100), 0, big.NewInt(100)},   // no trie time recorded
+	}
+	for i, tt := range tests {
+		parent := &types.Header{Number: big.NewInt(tt.number), Time: 1000, TrieDifficulty: tt.parentDiff}
+		if tt.trieTime != 0 {
+			parent.TrieTime = parent.Time + tt.trieTime
+		}
+		have := misc.CalcTrieDifficulty(config, parent)
+		if (have == nil) != (tt.want == nil) || (have != nil && have.Cmp(tt.want) != 0) {
+			t.Errorf("test %d: trie difficulty mismatch: have %v, want %v", i, have, tt.want)
+		}
+		header := &types.Header{Number: big.NewInt(tt.number + 1), Time: parent.Time + 3000, TrieDifficulty: tt.want}
+		if err := misc.VerifyTrieDifficulty(config, header, parent); err != nil {
+			t.Errorf("test %d: failed to verify trie difficulty: %v", i, err)
+		}
+	}
+	// Trie difficulties differing from the retargeted one must be rejected
+	parent := &types.Header{Number: big.NewInt(10), Time: 1000, TrieTime: 1010, TrieDifficulty: big.NewInt(100)}
+	header := &types.Header{Number: big.NewInt(11), Time: 1010, TrieDifficulty: big.NewInt(100)}
+	if err := misc.VerifyTrieDifficulty(config, header, parent); err == nil {
+		t.Errorf("stale trie difficulty accepted")
+	}
+	header = &types.Header{Number: big.NewInt(5), Time: 1010, TrieDifficulty: big.NewInt(1)}
+	if err := misc.VerifyTrieDifficulty(config, header, &types.Header{Number: big.NewInt(4)}); err != misc.ErrUnexpectedTrieDifficulty {
+		t.Errorf("pre-fork trie difficulty: have error %v, want %v", err, misc.ErrUnexpectedTrieDifficulty)
+	}
+}
+
+func TestVerifyTrieTime(t *testing.T) {
+	config := &params.ChainConfig{TrieHashimoto: &params.TrieHashimotoConfig{Block: big.NewInt(10)}}
+	tests := []struct {
+		number   int64
+		time     uint64
+		trieTime uint64
+		parent   uint64 // trie time of the parent
+		want     error
+	}{
+		{11, 1010, 1020, 1000, nil},                     // mined after the timestamp
+		{11, 1010, 1010, 1010, nil},                     // mined within the second
+		{11, 1010, 0, 0, nil},                           // no trie time recorded
+		{11, 1010, 1005, 0, misc.ErrInvalidTrieTime},    // mined before the timestamp
+		{11, 1010, 1020, 1015, misc.ErrInvalidTrieTime}, // timestamped before the parent's trie mining
+		{9, 1010, 1020, 0, misc.ErrUnexpectedTrieTime},  // before the fork
+		{10, 1010, 0, 1015, misc.ErrInvalidTrieTime},    // timestamped before the parent's trie mining
+	}
+	for i, tt := range tests {
+		parent := &types.Header{Number: big.NewInt(tt.number - 1), Time: 1000, TrieTime: tt.parent}
+		header := &types.Header{Number: big.NewInt(tt.number), Time: tt.time, TrieTime: tt.trieTime}
+		if err := misc.VerifyTrieTime(config, header, parent); err != tt.want {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.want)
+		}
+	}
+}
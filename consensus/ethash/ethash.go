@@ -40,6 +40,7 @@ import (
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
 	"github.com/hashicorp/golang-lru/simplelru"
 )
 
@@ -435,6 +436,20 @@ type sealWork struct {
 	res  chan [4]string
 }
 
+// trieWork wraps a request of a remote miner for the pending trie nodes.
+type trieWork struct {
+	errc chan error
+	res  chan []*trie.NodeWork
+}
+
+// trieResult wraps the nonces of pending trie nodes found by a remote miner.
+type trieResult struct {
+	hashes []common.Hash
+	nonces []uint64
+
+	errc chan error
+}
+
 // Ethash is a consensus engine based on proof-of-work implementing the ethash
 // algorithm.
 type Ethash struct {
@@ -449,6 +464,10 @@ type Ethash struct {
 	update   chan struct{} // Notification channel to update mining parameters
 	hashrate metrics.Meter // Meter tracking the average hashrate
 
+	trieHashrate metrics.Meter   // Meter tracking the average trie node hashrate
+	trieRound    *trieRound      // Trie mining round in flight, nil if none
+	trieMined    TrieMiningStats // Outcome of the latest trie mining round
+
 	// Remote sealer related fields
 	workCh       chan *sealTask   // Notification channel to push new work and relative result channel to remote sealer
 	fetchWorkCh  chan *sealWork   // Channel used for remote sealer to fetch mining work
@@ -456,6 +475,11 @@ type Ethash struct {
 	fetchRateCh  chan chan uint64 // Channel used to gather submitted hash rate for local or remote sealer.
 	submitRateCh chan *hashrate   // Channel used for remote sealer to submit their mining hashrate
 
+	// Remote trie node miner related fields
+	trieWorkCh         chan *trie.NodeWork // Notification channel to push dirty trie nodes to remote miners
+	fetchTrieWorkCh    chan *trieWork      // Channel used for remote miners to fetch pending trie nodes
+	submitTrieNoncesCh chan *trieResult    // Channel used for remote miners to submit trie node nonces
+
 	// The fields below are hooks for testing
 	shared    *Ethash       // Shared PoW verifier to avoid cache regeneration
 	fakeFail  uint64        // Block number which fails PoW check even in fake mode
@@ -486,12 +510,17 @@ func New(config Config, notify []string, noverify bool) *Ethash {
 		datasets:     newlru("dataset", config.DatasetsInMem, newDataset),
 		update:       make(chan struct{}),
 		hashrate:     metrics.NewMeterForced(),
+		trieHashrate: metrics.NewMeterForced(),
 		workCh:       make(chan *sealTask),
 		fetchWorkCh:  make(chan *sealWork),
 		submitWorkCh: make(chan *mineResult),
 		fetchRateCh:  make(chan chan uint64),
 		submitRateCh: make(chan *hashrate),
 		exitCh:       make(chan chan error),
+
+		trieWorkCh:         make(chan *trie.NodeWork),
+		fetchTrieWorkCh:    make(chan *trieWork),
+		submitTrieNoncesCh: make(chan *trieResult),
 	}
 	go ethash.remote(notify, noverify)
 	return ethash
@@ -506,12 +535,17 @@ func NewTester(notify []string, noverify bool) *Ethash {
 		datasets:     newlru("dataset", 1, newDataset),
 		update:       make(chan struct{}),
 		hashrate:     metrics.NewMeterForced(),
+		trieHashrate: metrics.NewMeterForced(),
 		workCh:       make(chan *sealTask),
 		fetchWorkCh:  make(chan *sealWork),
 		submitWorkCh: make(chan *mineResult),
 		fetchRateCh:  make(chan chan uint64),
 		submitRateCh: make(chan *hashrate),
 		exitCh:       make(chan chan error),
+
+		trieWorkCh:         make(chan *trie.NodeWork),
+		fetchTrieWorkCh:    make(chan *trieWork),
+		submitTrieNoncesCh: make(chan *trieResult),
 	}
 	go ethash.remote(notify, noverify)
 	return ethash
@@ -707,7 +741,82 @@ func (ethash *Ethash) APIs(chain consensus.ChainReader) []rpc.API {
 			Service:   &API{ethash},
 			Public:    true,
 		},
+		{
+			Namespace: "th",
+			Version:   "1.0",
+			Service:   &TrieAPI{ethash},
+			Public:    true,
+		},
+	}
+}
+
+// TrieHashrate implements PoW, returning the measured rate of the trie node nonce
+// search invocations per second over the last minute. Only the local threads
+// are counted, remote miners don't report their trie node hashrate.
+func (ethash *Ethash) TrieHashrate() float64 {
+	// Fake and shared engines don't mine trie nodes with a meter
+	if ethash.trieHashrate == nil {
+		return 0
 	}
+	return ethash.trieHashrate.Rate1()
+}
+
+// TrieMiningStats is the outcome of a Trie-Hashimoto mining round of a block.
+type TrieMiningStats struct {
+	Number  uint64        // Number of the block whose dirty trie nodes were mined
+	Nodes   int           // Number of trie nodes mined
+	Threads int           // Number of local threads mining them
+	Elapsed time.Duration // Time taken to mine all the nodes of the block
+}
+
+// trieRound is a Trie-Hashimoto mining round in flight.
+type trieRound struct {
+	number     uint64          // Number of the block whose dirty trie nodes are mined
+	difficulty *big.Int        // Trie difficulty of the block
+	threads    int             // Number of local threads mining them
+	start      time.Time       // Time the round started
+	mined      metrics.Counter // Number of trie nodes mined so far
+}
+
+// TrieMiningStatus is the progress of the Trie-Hashimoto mining round in flight.
+type TrieMiningStatus struct {
+	Mining     bool          // Whether the trie nodes of a block are being mined
+	Number     uint64        // Number of the block whose dirty trie nodes are mined
+	Difficulty *big.Int      // Trie difficulty of the block
+	Threads    int           // Number of local threads mining them
+	Mined      int64         // Number of trie nodes mined so far
+	Elapsed    time.Duration // Time since the round started
+}
+
+// TrieMiningStatus returns the progress of the trie mining round in flight. The
+// number of dirty nodes left isn't known until the trie is hashed, so only the
+// nodes mined so far are counted.
+func (ethash *Ethash) TrieMiningStatus() TrieMiningStatus {
+	ethash.lock.Lock()
+	defer ethash.lock.Unlock()
+
+	round := ethash.trieRound
+	if round == nil {
+		return TrieMiningStatus{}
+	}
+	return TrieMiningStatus{
+		Mining:     true,
+		Number:     round.number,
+		Difficulty: round.difficulty,
+		Threads:    round.threads,
+		Mined:      round.mined.Count(),
+		Elapsed:    time.Since(round.start),
+	}
+}
+
+// LastTrieMining returns the outcome of the latest completed trie mining round,
+// which is zero if no trie nodes were mined yet. The block it was mined for may
+// not make it into the chain if it loses the race for its number.
+func (ethash *Ethash) LastTrieMining() TrieMiningStats {
+	ethash.lock.Lock()
+	defer ethash.lock.Unlock()
+
+	return ethash.trieMined
 }
 
 // SeedHash is the seed to use for generating a verification cache and the mining
@@ -17,6 +17,8 @@
 package ethash
 
 import (
+	"encoding/binary"
+	"fmt"
 	"io/ioutil"
 	"math/big"
 	"math/rand"
@@ -27,7 +29,12 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/trie"
 )
 
 // Tests that ethash works correctly in test mode.
@@ -38,7 +45,7 @@ func TestTestMode(t *testing.T) {
 	defer ethash.Close()
 
 	results := make(chan *types.Block)
-	err := ethash.Seal(nil, types.NewBlockWithHeader(header), results, nil)
+	err := ethash.Seal(nil, types.NewBlockWithHeader(header), nil, results, nil)
 	if err != nil {
 		t.Fatalf("failed to seal block: %v", err)
 	}
@@ -104,7 +111,7 @@ func TestRemoteSealer(t *testing.T) {
 
 	// Push new work.
 	results := make(chan *types.Block)
-	ethash.Seal(nil, block, results, nil)
+	ethash.Seal(nil, block, nil, results, nil)
 
 	var (
 		work [4]string
@@ -121,13 +128,127 @@ func TestRemoteSealer(t *testing.T) {
 	header = &types.Header{Number: big.NewInt(1), Difficulty: big.NewInt(1000)}
 	block = types.NewBlockWithHeader(header)
 	sealhash = ethash.SealHash(header)
-	ethash.Seal(nil, block, results, nil)
+	ethash.Seal(nil, block, nil, results, nil)
 
 	if work, err = api.GetWork(); err != nil || work[0] != sealhash.Hex() {
 		t.Error("expect to return the latest pushed work")
 	}
 }
 
+// Tests that remote miners can search the nonces of dirty trie nodes through
+// the th RPC methods, and that invalid nonces are rejected even if submitted
+// work isn't verified.
+func TestRemoteTrieMiner(t *testing.T)         { testRemoteTrieMiner(t, false) }
+func TestRemoteTrieMinerNoVerify(t *testing.T) { testRemoteTrieMiner(t, true) }
+
+func testRemoteTrieMiner(t *testing.T, noverify bool) {
+	const number = 5
+	config := &params.TrieHashimotoConfig{PrefixLength: 1, LoopAccesses: 1}
+
+	ethash := NewTester(nil, noverify)
+	defer ethash.Close()
+
+	api := &TrieAPI{ethash}
+	if _, err := api.GetTrieWork(); err != errNoTrieWork {
+		t.Error("expect to return an error indicate there is no trie mining work")
+	}
+	newTrie := func() *trie.Trie {
+		tr, _ := trie.New(common.Hash{}, trie.NewDatabase(rawdb.NewMemoryDatabase()))
+		for i := 0; i < 8; i++ {
+			tr.Update([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%d", i)))
+		}
+		return tr
+	}
+	// Mine the trie without local threads, leaving every node to the remote miner
+	type result struct {
+		root   common.Hash
+		nonces []uint64
+	}
+	results := make(chan result)
+	go func() {
+		root, nonces, _ := newTrie().HashWithNonce(config, number, nil, &trie.Miner{Remote: ethash.trieWorkCh})
+		results <- result{root, nonces}
+	}()
+	for {
+		select {
+		case res := <-results:
+			have, err := newTrie().HashByNonce(config, res.nonces, number, nil, nil)
+			if err != nil {
+				t.Fatalf("failed to replay remotely mined nonces: %v", err)
+			}
+			if have != res.root {
+				t.Fatalf("root mismatch: have %x, want %x", have, res.root)
+			}
+			return
+		default:
+		}
+		works, err := api.GetTrieWork()
+		if err != nil {
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		for _, work := range works {
+			if work.Number != number || work.PrefixLength != config.PrefixLength {
+				t.Fatalf("trie work mismatch: have number %d, prefix %d", work.Number, work.PrefixLength)
+			}
+			// Search the nonce the way an external miner would
+			enc := common.CopyBytes(work.Encoding)
+			valid := func(nonce uint64) bool {
+				binary.LittleEndian.PutUint64(enc[len(enc)-8:], nonce)
+				return crypto.Keccak256(enc)[0] == number
+			}
+			nonce, invalid := uint64(0), uint64(0)
+			for !valid(nonce) {
+				nonce++
+			}
+			for valid(invalid) {
+				invalid++
+			}
+			if api.SubmitTrieNonces([]common.Hash{work.OriginalHash}, []hexutil.Uint64{hexutil.Uint64(invalid)}) {
+				t.Error("expect to return false when submit an invalid trie node nonce")
+			}
+			if !api.SubmitTrieNonces([]common.Hash{work.OriginalHash}, []hexutil.Uint64{hexutil.Uint64(nonce)}) {
+				t.Error("expect to return true when submit a valid trie node nonce")
+			}
+		}
+	}
+}
+
+// Tests that trie mining stops once the sealing task is stopped.
+func TestTrieMiningAbort(t *testing.T) {
+	// Nonces with an 8 byte prefix can't be found, so mining only ends on abort
+	config := &params.TrieHashimotoConfig{PrefixLength: 8, LoopAccesses: 1}
+
+	ethash := NewTester(nil, false)
+	defer ethash.Close()
+	ethash.SetThreads(1)
+
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()))
+	statedb.SetState(common.Address{0x01}, common.Hash{0x01}, common.Hash{0x01})
+	statedb.IntermediateRoot(false)
+
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(5)})
+	stop := make(chan struct{})
+	time.AfterFunc(100*time.Millisecond, func() { close(stop) })
+
+	errc := make(chan error)
+	go func() {
+		_, err := ethash.mineTrie(block, statedb, config, stop)
+		errc <- err
+	}()
+	select {
+	case err := <-errc:
+		if aborted, ok := err.(*trie.MiningAbortedError); !ok || aborted.Number != 5 {
+			t.Fatalf("error mismatch: have %v, want trie mining abort for block 5", err)
+		}
+		if ethash.trieHashrate.Count() == 0 {
+			t.Fatalf("trie node hash attempts not metered")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("trie mining not aborted")
+	}
+}
+
 func TestHashRate(t *testing.T) {
 	var (
 		hashrate = []hexutil.Uint64{100, 200, 300}
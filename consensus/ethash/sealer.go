@@ -30,15 +30,16 @@ import (
 	"time"
 	impt_log "log"
 	"os"
-	"fmt"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/consensus"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/core/state"
-	
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/trie"
 )
 
 const (
@@ -48,14 +49,24 @@ const (
 	isLogging = false
 )
 
+var (
+	// Trie-Hashimoto stats of the blocks sealed locally
+	thBlockTimer          = metrics.NewRegisteredTimer("ethash/th/block/time", nil)
+	thBlockNodesHistogram = metrics.NewRegisteredHistogram("ethash/th/block/nodes", nil, metrics.NewExpDecaySample(1028, 0.015))
+	thBlockAbortMeter     = metrics.NewRegisteredMeter("ethash/th/block/aborts", nil)
+	sealTimer             = metrics.NewRegisteredTimer("ethash/seal/time", nil)
+)
+
 var (
 	errNoMiningWork      = errors.New("no mining work available yet")
 	errInvalidSealResult = errors.New("invalid or stale proof-of-work solution")
+	errNoTrieWork        = errors.New("no trie mining work available yet")
+	errInvalidTrieResult = errors.New("invalid or stale trie node nonce")
 )
 
 // Seal implements consensus.Engine, attempting to find a nonce that satisfies
 // the block's difficulty requirements.
-func (ethash *Ethash) Seal(chain consensus.ChainReader, block *types.Block, state *state.StateDB, results chan<- *types.Block, stop <-chan struct{}, impt bool) error {
+func (ethash *Ethash) Seal(chain consensus.ChainReader, block *types.Block, state *state.StateDB, results chan<- *types.Block, stop <-chan struct{}) error {
 
 	// no tx, no mining (jmlee)
 	if len(block.Transactions()) == 0 {
@@ -63,7 +74,12 @@ func (ethash *Ethash) Seal(chain consensus.ChainReader, block *types.Block, stat
 		return nil
 	}
 
-	if impt {
+	// Trie-Hashimoto mining is enabled by the chain config, not by the miner
+	var th *params.TrieHashimotoConfig
+	if chain != nil && chain.Config().IsTrieHashimoto(block.Number()) {
+		th = chain.Config().TrieHashimoto
+	}
+	if th != nil {
 		// in impt, require at least 200 txs
 		if len(block.Transactions()) < 200 {
 			log.Info("Sealing paused, waiting for transactions")
@@ -71,6 +87,127 @@ func (ethash *Ethash) Seal(chain consensus.ChainReader, block *types.Block, stat
 		}
 	}
 
+	// Mine the dirty state trie nodes first: the resulting root and trie nonce
+	// commitment are part of the seal hash the block proof-of-work runs over.
+	if th != nil {
+		var err error
+		if block, err = ethash.mineTrie(block, state, th, stop); err != nil {
+			return err
+		}
+	}
+	return ethash.seal(chain, block, state, results, stop)
+}
+
+// mineTrie runs Trie-Hashimoto mining over the dirty nodes of the storage tries
+// and then of the account trie holding their roots, and returns a new block
+// carrying the mined state root and trie nonces. Mining restarts if the thread
+// count is updated, and fails with a *trie.MiningAbortedError once stop is
+// closed.
+func (ethash *Ethash) mineTrie(block *types.Block, state *state.StateDB, th *params.TrieHashimotoConfig, stop <-chan struct{}) (*types.Block, error) {
+	for {
+		var (
+			abort   = make(chan struct{})
+			done    = make(chan struct{})
+			updated = make(chan struct{})
+		)
+		go func() {
+			select {
+			case <-stop:
+				// Outside abort, stop trie mining
+			case <-ethash.update:
+				// Thread count was changed on user request, restart
+				close(updated)
+			case <-done:
+				return
+			}
+			close(abort)
+		}()
+		mined, err := ethash.tryMineTrie(block, state, th, abort)
+		close(done)
+
+		if _, ok := err.(*trie.MiningAbortedError); ok {
+			select {
+			case <-updated:
+				log.Debug("Restarting trie mining after update", "number", block.NumberU64(), "err", err)
+				continue
+			default:
+			}
+		}
+		return mined, err
+	}
+}
+
+// tryMineTrie makes a single attempt at mining the dirty trie nodes of the
+// block, until done or aborted.
+func (ethash *Ethash) tryMineTrie(block *types.Block, state *state.StateDB, th *params.TrieHashimotoConfig, abort <-chan struct{}) (*types.Block, error) {
+	ethash.lock.Lock()
+	threads := ethash.threads
+	ethash.lock.Unlock()
+	if threads == 0 {
+		threads = runtime.NumCPU()
+	}
+	if threads < 0 {
+		threads = 0 // Leaves trie mining to remote miners only
+	}
+	// Hand out every dirty node to remote miners too, if any can fetch them
+	progress := metrics.NewCounterForced()
+	miner := &trie.Miner{Threads: threads, Remote: ethash.trieWorkCh, Abort: abort, Hashrate: ethash.trieHashrate, Progress: progress}
+
+	// Do IMPT mining for state trie nodes (sjkim)
+	stateTrie := state.Trie()
+	number, difficulty := block.NumberU64(), block.TrieDifficulty()
+	start := time.Now()
+
+	ethash.lock.Lock()
+	ethash.trieRound = &trieRound{number: number, difficulty: difficulty, threads: threads, start: start, mined: progress}
+	ethash.lock.Unlock()
+	defer func() {
+		ethash.lock.Lock()
+		ethash.trieRound = nil
+		ethash.lock.Unlock()
+	}()
+	storageNonces, err := state.MineStorageTries(th, number, difficulty, miner)
+	if err != nil {
+		return nil, err
+	}
+	trieHash, trieNonces, err := (*stateTrie).HashWithNonce(th, number, difficulty, miner)
+	if aborted, ok := err.(*trie.MiningAbortedError); ok {
+		// Count the storage trie nodes mined already too
+		for _, sn := range storageNonces {
+			aborted.Mined += len(sn.Nonces)
+		}
+		thBlockAbortMeter.Mark(1)
+		return nil, aborted
+	}
+	if err != nil {
+		return nil, err
+	}
+	nodes := len(trieNonces)
+	for _, sn := range storageNonces {
+		nodes += len(sn.Nonces)
+	}
+	elapsed := time.Since(start)
+
+	ethash.lock.Lock()
+	ethash.trieMined = TrieMiningStats{Number: number, Nodes: nodes, Threads: threads, Elapsed: elapsed}
+	ethash.lock.Unlock()
+
+	thBlockTimer.Update(elapsed)
+	thBlockNodesHistogram.Update(int64(nodes))
+	log.Info("Mined trie nodes", "number", number, "difficulty", difficulty, "nodes", nodes, "threads", threads, "elapsed", common.PrettyDuration(elapsed))
+
+	// Update block header's stateRoot and trie nonce commitment after IMPT mining,
+	// recording when it finished for the trie difficulty of the next block
+	trieTime := uint64(time.Now().Unix())
+	if trieTime < block.Time() {
+		trieTime = block.Time()
+	}
+	return block.WithTrieNonces(trieHash, trieNonces, storageNonces, trieTime), nil
+}
+
+// seal searches for a block nonce satisfying the block's difficulty, after any
+// trie mining has already been done.
+func (ethash *Ethash) seal(chain consensus.ChainReader, block *types.Block, state *state.StateDB, results chan<- *types.Block, stop <-chan struct{}) error {
 	// If we're running a fake PoW, simply return a 0 nonce immediately
 	if ethash.config.PowMode == ModeFake || ethash.config.PowMode == ModeFullFake {
 		header := block.Header()
@@ -84,7 +221,7 @@ func (ethash *Ethash) Seal(chain consensus.ChainReader, block *types.Block, stat
 	}
 	// If we're running a shared PoW, delegate sealing to it
 	if ethash.shared != nil {
-		return ethash.shared.Seal(chain, block, state, results, stop, impt)
+		return ethash.shared.seal(chain, block, state, results, stop)
 	}
 	// Create a runner and the multiple search threads it directs
 	abort := make(chan struct{})
@@ -111,36 +248,6 @@ func (ethash *Ethash) Seal(chain consensus.ChainReader, block *types.Block, stat
 		ethash.workCh <- &sealTask{block: block, results: results}
 	}
 
-	if impt {
-		// Do IMPT mining for state trie nodes (sjkim)
-		trie := state.Trie()
-		number := block.Header().Number.Uint64()
-		common.MiningTimes = []int64{}
-		thMiningStartTime := time.Now()
-		trieHash, trieNonces := (*trie).HashWithNonce(number, threads)
-		thMiningTime := time.Since(thMiningStartTime)
-		fmt.Println("threads num: ", threads)
-		fmt.Println("\nelapsed time to find nonce for", len(trieNonces), "trie nodes:", 
-			"(", int64(thMiningTime/time.Nanosecond), "ns", 
-			"/", int64(thMiningTime/time.Microsecond), "us",
-			"/", int64(thMiningTime/time.Millisecond), "ms)")
-		if len(common.MiningTimes) != 0 {
-			sumOfMiningTimes := int64(0)
-			for _, time := range common.MiningTimes {
-				sumOfMiningTimes += time
-			}
-			fmt.Println("average mining time for single trie node:", sumOfMiningTimes/int64(len(common.MiningTimes))/1000000, "ms (", sumOfMiningTimes/int64(len(common.MiningTimes))/1000, "us )")
-		}
-
-		// runtime.GOMAXPROCS(runtime.NumCPU()) // use all CPU cores for goroutine
-		// fmt.Println("rumtime.GOMAXPROCS:", runtime.GOMAXPROCS(0)) // check # of cores to use
-
-		// Update block header's stateRoot field after IMPT mining
-		block.ModifyRoot(trieHash)
-		// Update block body's trieNonces field after IMPT mining
-		block.ModifyBody(trieNonces)
-	}
-	
 	miningStartTime := time.Now()
 	var (
 		pend   sync.WaitGroup
@@ -162,25 +269,8 @@ func (ethash *Ethash) Seal(chain consensus.ChainReader, block *types.Block, stat
 			close(abort)
 		case result = <-locals:
 			// One of the threads found a block, abort all others
-			miningTime := time.Since(miningStartTime)
-			fmt.Println("elapsed time to find nonce for a block:", 
-				"(", int64(miningTime/time.Nanosecond), "ns", 
-				"/", int64(miningTime/time.Microsecond), "us",
-				"/", int64(miningTime/time.Millisecond), "ms)\n")
-			common.BlockMiningTimes[int64(threads)] = append(common.BlockMiningTimes[int64(threads)], int64(miningTime/time.Microsecond))
-			
-			if len(common.BlockMiningTimes[int64(threads)]) % 100 == 0 {
-				fmt.Println("print block mining times with", threads, "threads")
-				blockMiningTimeSum := int64(0)
-				for _, time := range common.BlockMiningTimes[int64(threads)] {
-					// fmt.Print(time, " ")
-					blockMiningTimeSum += time
-				}
-				fmt.Println("")
-				fmt.Println("avg:", blockMiningTimeSum/int64(len(common.BlockMiningTimes[int64(threads)]))/1000, "ms (", blockMiningTimeSum/int64(len(common.BlockMiningTimes[int64(threads)])), "us ) for", len(common.BlockMiningTimes[int64(threads)]), "blocks\n")
+			sealTimer.UpdateSince(miningStartTime)
 
-			}
-			
 			select {
 			case results <- result:
 			default:
@@ -190,7 +280,7 @@ func (ethash *Ethash) Seal(chain consensus.ChainReader, block *types.Block, stat
 		case <-ethash.update:
 			// Thread count was changed on user request, restart
 			close(abort)
-			if err := ethash.Seal(chain, block, state, results, stop, impt); err != nil {
+			if err := ethash.seal(chain, block, state, results, stop); err != nil {
 				log.Error("Failed to restart sealing after update", "err", err)
 			}
 		}
@@ -248,7 +338,7 @@ search:
 				// Seal and return a block (if still needed)
 				select {
 				// Include IMPT mining result in the sealed block body
-				case found <- block.WithSeal(header).WithBody(block.Transactions(), block.Uncles(), block.TrieNonces()):
+				case found <- block.WithSeal(header):
 					if isLogging && (header.Number.Uint64() % loggingPeriod == 0) {
 						fpLog, err := os.OpenFile("./experiment/impt_sealer.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 						if err != nil {
@@ -276,8 +366,9 @@ search:
 // remote is a standalone goroutine to handle remote mining related stuff.
 func (ethash *Ethash) remote(notify []string, noverify bool) {
 	var (
-		works = make(map[common.Hash]*types.Block)
-		rates = make(map[common.Hash]hashrate)
+		works     = make(map[common.Hash]*types.Block)
+		rates     = make(map[common.Hash]hashrate)
+		trieWorks = make(map[common.Hash]*trie.NodeWork)
 
 		results      chan<- *types.Block
 		currentBlock *types.Block
@@ -387,6 +478,45 @@ func (ethash *Ethash) remote(notify []string, noverify bool) {
 		return false
 	}
 
+	// pendingTrieWorks drops the trie nodes mined already and returns the rest.
+	pendingTrieWorks := func() []*trie.NodeWork {
+		pending := make([]*trie.NodeWork, 0, len(trieWorks))
+		for hash, work := range trieWorks {
+			select {
+			case <-work.Done():
+				delete(trieWorks, hash)
+			default:
+				pending = append(pending, work)
+			}
+		}
+		return pending
+	}
+	// submitTrieNonce verifies the nonce submitted for a pending trie node and
+	// passes it on to the local trie miner, returning whether it was accepted.
+	submitTrieNonce := func(hash common.Hash, nonce uint64) bool {
+		work := trieWorks[hash]
+		if work == nil {
+			log.Warn("Trie node nonce submitted but none pending", "hash", hash)
+			return false
+		}
+		start := time.Now()
+		// Trie nonces are verified even with noverify, it's only one hash and a bad
+		// one would fail the local trie miner
+		if !work.Verify(nonce) {
+			log.Warn("Invalid trie node nonce submitted", "hash", hash, "number", work.Number, "nonce", nonce, "elapsed", common.PrettyDuration(time.Since(start)))
+			return false
+		}
+		if !work.Submit(nonce) {
+			log.Warn("Trie node nonce submitted is stale", "hash", hash, "number", work.Number, "nonce", nonce)
+			return false
+		}
+		log.Trace("Verified correct trie node nonce", "hash", hash, "number", work.Number, "nonce", nonce, "elapsed", common.PrettyDuration(time.Since(start)))
+
+		// The node is mined, stop handing it out
+		delete(trieWorks, hash)
+		return true
+	}
+
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
 
@@ -418,6 +548,28 @@ func (ethash *Ethash) remote(notify []string, noverify bool) {
 				result.errc <- errInvalidSealResult
 			}
 
+		case work := <-ethash.trieWorkCh:
+			// Track the dirty trie node until it's mined either locally or remotely
+			trieWorks[work.OriginalHash] = work
+
+		case req := <-ethash.fetchTrieWorkCh:
+			// Return the trie nodes still pending to remote miner.
+			if pending := pendingTrieWorks(); len(pending) == 0 {
+				req.errc <- errNoTrieWork
+			} else {
+				req.res <- pending
+			}
+
+		case result := <-ethash.submitTrieNoncesCh:
+			// Verify submitted trie node nonces, failing if any of them is rejected.
+			var err error
+			for i, hash := range result.hashes {
+				if !submitTrieNonce(hash, result.nonces[i]) {
+					err = errInvalidTrieResult
+				}
+			}
+			result.errc <- err
+
 		case result := <-ethash.submitRateCh:
 			// Trace remote sealer's hash rate by submitted value.
 			rates[result.id] = hashrate{rate: result.rate, ping: time.Now()}
@@ -439,6 +591,9 @@ func (ethash *Ethash) remote(notify []string, noverify bool) {
 					delete(rates, id)
 				}
 			}
+			// Clear trie nodes mined already
+			pendingTrieWorks()
+
 			// Clear stale pending blocks
 			if currentBlock != nil {
 				for hash, block := range works {
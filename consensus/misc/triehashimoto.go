@@ -0,0 +1,183 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package misc
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+var (
+	// ErrMissingTrieNoncesHash is returned if a header of a Trie-Hashimoto block
+	// doesn't commit to the trie nonces of its body.
+	ErrMissingTrieNoncesHash = errors.New("missing trie nonces hash")
+
+	// ErrUnexpectedTrieNoncesHash is returned if a header before the
+	// Trie-Hashimoto fork commits to trie nonces.
+	ErrUnexpectedTrieNoncesHash = errors.New("unexpected trie nonces hash")
+
+	// ErrUnexpectedTrieDifficulty is returned if a header before the
+	// Trie-Hashimoto fork carries a trie difficulty.
+	ErrUnexpectedTrieDifficulty = errors.New("unexpected trie difficulty")
+
+	// ErrUnexpectedTrieTime is returned if a header before the Trie-Hashimoto
+	// fork records a trie mining time.
+	ErrUnexpectedTrieTime = errors.New("unexpected trie time")
+
+	// ErrInvalidTrieTime is returned if a header records its trie nodes as mined
+	// before its own timestamp, or is timestamped before the trie nodes of its
+	// parent were mined.
+	ErrInvalidTrieTime = errors.New("invalid trie time")
+)
+
+// VerifyTrieNoncesHash validates that the header commits to a trie nonce list
+// if and only if Trie-Hashimoto is active at its number. Matching the list
+// itself against the commitment is left to body validation.
+func VerifyTrieNoncesHash(config *params.ChainConfig, header *types.Header) error {
+	committed := header.TrieNoncesHash != (common.Hash{})
+	switch th := config.IsTrieHashimoto(header.Number); {
+	case th && !committed:
+		return ErrMissingTrieNoncesHash
+	case !th && committed:
+		return ErrUnexpectedTrieNoncesHash
+	}
+	return nil
+}
+
+// bigMinus99 is the lowest trie difficulty adjustment factor, as in Homestead.
+var bigMinus99 = big.NewInt(-99)
+
+// VerifyTrieDifficulty validates that the trie difficulty of the header is the
+// one retargeted from its parent, and that it's only set if Trie-Hashimoto is
+// active at its number.
+func VerifyTrieDifficulty(config *params.ChainConfig, header, parent *types.Header) error {
+	expected := CalcTrieDifficulty(config, parent)
+	switch {
+	case expected == nil && header.TrieDifficulty != nil:
+		return ErrUnexpectedTrieDifficulty
+	case expected != nil && (header.TrieDifficulty == nil || expected.Cmp(header.TrieDifficulty) != 0):
+		return fmt.Errorf("invalid trie difficulty: have %v, want %v", header.TrieDifficulty, expected)
+	}
+	return nil
+}
+
+// VerifyTrieTime validates the trie mining time recorded in the header. It may
+// only be set if Trie-Hashimoto is active at the header's number and can't
+// precede the header's timestamp, while the header itself can't be timestamped
+// before the trie nodes of its parent were mined. Checking it against the local
+// clock is left to the engine, like for the timestamp.
+func VerifyTrieTime(config *params.ChainConfig, header, parent *types.Header) error {
+	if header.TrieTime != 0 {
+		if !config.IsTrieHashimoto(header.Number) {
+			return ErrUnexpectedTrieTime
+		}
+		if header.TrieTime < header.Time {
+			return ErrInvalidTrieTime
+		}
+	}
+	if header.Time < parent.TrieTime {
+		return ErrInvalidTrieTime
+	}
+	return nil
+}
+
+// CalcTrieDifficulty is the trie difficulty adjustment algorithm. It returns the
+// trie difficulty a child of the parent block should have given the parent's
+// trie difficulty and the time its trie nodes took to mine, nil if
+// Trie-Hashimoto isn't active at the child. The first TH block starts at the
+// configured initial difficulty.
+//
+// Much like the Homestead difficulty adjustment, the trie difficulty is raised
+// if the parent's trie mining time is below the TH target time and lowered if
+// it's at least twice as long:
+//
+//	diff = parent_diff + max(parent_diff // 16, 1) *
+//	       max(1 - (parent_trie_time - parent_timestamp) // target_time, -99)
+//
+// The trie mining time spans from the parent's timestamp, taken when its work
+// was prepared, to its trie time, taken when its trie nodes were mined. Both are
+// sealed by the block PoW and bounded by VerifyTrieTime, so the block PoW and
+// propagation delays are left to the block difficulty alone. Parents without a
+// trie time keep their trie difficulty.
+//
+// A zero target time keeps the trie difficulty fixed.
+func CalcTrieDifficulty(config *params.ChainConfig, parent *types.Header) *big.Int {
+	next := new(big.Int).Add(parent.Number, common.Big1)
+	if !config.IsTrieHashimoto(next) {
+		return nil
+	}
+	th := config.TrieHashimoto
+	if parent.TrieDifficulty == nil || !config.IsTrieHashimoto(parent.Number) {
+		if th.InitialDifficulty == nil || th.InitialDifficulty.Cmp(params.MinimumTrieDifficulty) < 0 {
+			return new(big.Int).Set(params.MinimumTrieDifficulty)
+		}
+		return new(big.Int).Set(th.InitialDifficulty)
+	}
+	if th.TargetTime == 0 || parent.TrieTime < parent.Time {
+		return new(big.Int).Set(parent.TrieDifficulty)
+	}
+	// 1 - (parent_trie_time - parent_timestamp) // target_time
+	x := new(big.Int).SetUint64((parent.TrieTime - parent.Time) / th.TargetTime)
+	x.Sub(common.Big1, x)
+
+	// max(1 - (parent_trie_time - parent_timestamp) // target_time, -99)
+	if x.Cmp(bigMinus99) < 0 {
+		x.Set(bigMinus99)
+	}
+	// max(parent_diff // 16, 1) * the adjustment above
+	step := new(big.Int).Div(parent.TrieDifficulty, params.TrieDifficultyBoundDivisor)
+	if step.Cmp(common.Big1) < 0 {
+		step.Set(common.Big1)
+	}
+	x.Mul(x, step)
+	x.Add(parent.TrieDifficulty, x)
+
+	// minimum trie difficulty can ever be
+	if x.Cmp(params.MinimumTrieDifficulty) < 0 {
+		x.Set(params.MinimumTrieDifficulty)
+	}
+	return x
+}
+
+// TrieHashimotoRoot computes the state root of a block being finalized. If
+// Trie-Hashimoto is active at the block's number, the account and storage trie
+// nonces carried in the block body are replayed over the dirty state trie nodes
+// against the block's trie difficulty,
+// otherwise the plain root is returned and the block must not carry any trie
+// nonces.
+func TrieHashimotoRoot(config *params.ChainConfig, header *types.Header, statedb *state.StateDB, trieNonces []uint64, storageNonces []types.StorageTrieNonces) (common.Hash, error) {
+	if !config.IsTrieHashimoto(header.Number) {
+		if len(trieNonces) != 0 || len(storageNonces) != 0 {
+			return common.Hash{}, consensus.ErrInvalidTrieNonce
+		}
+		return statedb.IntermediateRoot(config.IsEIP158(header.Number)), nil
+	}
+	root, err := statedb.IntermediateRootByNonce(config.IsEIP158(header.Number), config.TrieHashimoto, trieNonces, storageNonces, header.Number.Uint64(), header.TrieDifficulty)
+	if err != nil {
+		log.Debug("Rejected trie nonces", "number", header.Number, "nonces", len(trieNonces), "storage", len(storageNonces), "err", err)
+		return common.Hash{}, consensus.ErrInvalidTrieNonce
+	}
+	return root, nil
+}
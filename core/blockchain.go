@@ -37,6 +37,7 @@ import (
 	"github.com/ethereum/go-ethereum/consensus"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/thdataset"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/ethdb"
@@ -83,6 +84,7 @@ const (
 	maxTimeFutureBlocks = 30
 	badBlockLimit       = 10
 	TriesInMemory       = 128
+	coldMigrateInterval = 128 // Number of blocks between two cold trie node migrations
 
 	// BlockChainVersion ensures that an incompatible database forces a resync from scratch.
 	//
@@ -115,6 +117,11 @@ type CacheConfig struct {
 	TrieDirtyLimit      int           // Memory limit (MB) at which to start flushing dirty trie nodes to disk
 	TrieDirtyDisabled   bool          // Whether to disable trie write caching and GC altogether (archive node)
 	TrieTimeLimit       time.Duration // Time limit after which to flush the current in-memory trie to disk
+
+	THDatasetDir    string // Directory of the Trie-Hashimoto header dataset, kept in memory if empty
+	THLightVerifier bool   // Whether to verify trie nonces reading header dataset rows from the database
+	THKeepRecent    uint64 // Number of recent states the online trie node pruner keeps, 0 to disable it
+	THColdAfter     uint64 // Number of blocks after which trie nodes move into the cold store, 0 to keep them hot
 }
 
 // BlockChain represents the canonical chain given a database with a genesis
@@ -176,27 +183,31 @@ type BlockChain struct {
 	badBlocks       *lru.Cache                     // Bad block cache
 	shouldPreserve  func(*types.Block) bool        // Function used to determine whether should preserve the given block.
 	terminateInsert func(common.Hash, uint64) bool // Testing hook used to terminate ancient receipt chain insertion.
+
+	thDataset   headerDataset // Trie-Hashimoto header dataset, nil if headers aren't mixed into trie mining
+	thPruning   int32         // Whether the online trie node pruner is running (atomic)
+	thMigrating int32         // Whether the cold trie node migrator is running (atomic)
+	thMaintain  sync.Mutex    // Lock serializing the trie node pruner and migrator
 }
 
-// convert bytes to uint32s (jmlee)
-func bytesToUint32s(bytes []byte) []uint32 {
-	uint32s := make([]uint32, len(bytes)/4)
-	offset := uint32(0)
-	for i := uint32(0); i < uint32(len(bytes)/4); i++ {
-		r := uint32(0)
-		for j := uint32(0); j < 4; j++ {
-			r |= uint32(bytes[offset+j]) << (8 * j)
-		}
-		offset += 4
-		uint32s[i] = r
-	}
-	return uint32s
+// headerDataset is the Trie-Hashimoto header dataset maintained along the
+// canonical chain, either a full one for mining or a light one for verifying.
+type headerDataset interface {
+	Blocks() uint64
+	Append(number uint64, hash common.Hash, header []byte) error
+	Truncate(blocks uint64, last common.Hash) error
+	Generate(db ethdb.Reader, head uint64) error
+	Ancestry(db ethdb.Reader, parent common.Hash, number uint64) (*thdataset.Ancestry, error)
+	Close() error
 }
 
 // NewBlockChain returns a fully initialised block chain using information
 // available in the database. It initialises the default Ethereum Validator and
 // Processor.
 func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, chainConfig *params.ChainConfig, engine consensus.Engine, vmConfig vm.Config, shouldPreserve func(block *types.Block) bool) (*BlockChain, error) {
+	if err := chainConfig.TrieHashimoto.CheckConfig(); err != nil {
+		return nil, err
+	}
 	if cacheConfig == nil {
 		cacheConfig = &CacheConfig{
 			TrieCleanLimit: 256,
@@ -299,36 +310,28 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, chainConfig *par
 			}
 		}
 	}
+	// Open the header dataset for impt mining and catch it up with the chain (jmlee)
+	if th := bc.chainConfig.TrieHashimoto; th != nil && !th.Fake && th.ReadHeader {
+		var dataset headerDataset
+		if cacheConfig.THLightVerifier {
+			dataset = thdataset.NewLight(bc.db, th.DatasetLen)
+		} else if dataset, err = thdataset.New(cacheConfig.THDatasetDir, th.DatasetLen); err != nil {
+			return nil, err
+		}
+		if err := dataset.Generate(bc.db, bc.CurrentBlock().NumberU64()); err != nil {
+			dataset.Close()
+			return nil, err
+		}
+		bc.thDataset = dataset
+		bc.publishHeaderDataset()
+	}
+
 	// Take ownership of this particular state
 	go bc.update()
 
 	// set NextBlockNumber to prefixing impt trie nodes hash (jmlee)
 	common.NextBlockNumber = bc.CurrentBlock().Header().Number.Uint64() + 1
 
-	// collect all block headers in memory for impt mining (jmlee)
-	if !trie.FakeIMPT && trie.DoReadHeader {
-		fmt.Println("collecting block headers from disk for trie-hashimoto mining")
-		totalHeaderSize := 0
-		for i := uint64(0); i < common.NextBlockNumber; i++ {
-			blockHash := rawdb.ReadCanonicalHash(common.GlobalDB, i)						// get block header's hash
-			rlpedBlockHeader := rawdb.ReadHeaderRLP(common.GlobalDB, blockHash, i)			// get RLPed block header with block hash and block number
-			
-			// common.RLPedBlockHeaders = append(common.RLPedBlockHeaders, rlpedBlockHeader)	// add to memory
-			// totalHeaderSize += len(rlpedBlockHeader)
-
-			common.RLPedBlockHeadersUint32s = append(common.RLPedBlockHeadersUint32s, bytesToUint32s(rlpedBlockHeader)...)
-		}
-
-		// for evaluation: fix dataset size for fair comparison with Ethash vs TH
-		if uint32(len(common.RLPedBlockHeadersUint32s)) >  trie.EthashDatasetLen {
-			fmt.Println("header dataset adjusted:", len(common.RLPedBlockHeadersUint32s), "->", trie.EthashDatasetLen)
-			common.RLPedBlockHeadersUint32s = common.RLPedBlockHeadersUint32s[:trie.EthashDatasetLen]
-		}
-
-		fmt.Println("  => common.NextBlockNumber -> total headers size:", totalHeaderSize, "bytes (", totalHeaderSize/1000000, "MB )")
-		fmt.Println("  => common.RLPedBlockHeadersUint32s -> len:", len(common.RLPedBlockHeadersUint32s), "(", len(common.RLPedBlockHeadersUint32s)*4/1000000, "MB )")
-	}
-
 	return bc, nil
 }
 
@@ -489,7 +492,45 @@ func (bc *BlockChain) SetHead(head uint64) error {
 	bc.blockCache.Purge()
 	bc.futureBlocks.Purge()
 
-	return bc.loadLastState()
+	if err := bc.loadLastState(); err != nil {
+		return err
+	}
+	bc.truncateHeaderDataset(bc.CurrentBlock().NumberU64() + 1)
+	return nil
+}
+
+// truncateHeaderDataset drops the headers of all blocks from the given number
+// on from the header dataset and rewinds the next block number used for trie
+// node prefixes, so that mining and verification only ever see the canonical
+// chain (jmlee).
+func (bc *BlockChain) truncateHeaderDataset(number uint64) {
+	if common.NextBlockNumber > number {
+		common.NextBlockNumber = number
+	}
+	if bc.thDataset == nil {
+		return
+	}
+	var last common.Hash
+	if number > 0 {
+		last = rawdb.ReadCanonicalHash(bc.db, number-1)
+	}
+	if err := bc.thDataset.Truncate(number, last); err != nil {
+		log.Error("Failed to rewind header dataset", "number", number, "err", err)
+	}
+	bc.publishHeaderDataset()
+}
+
+// publishHeaderDataset hands the current header dataset to trie node mining and
+// verification (jmlee). Verification always goes through the dataset source, so
+// that nodes of past blocks are checked against the headers before their block.
+func (bc *BlockChain) publishHeaderDataset() {
+	switch dataset := bc.thDataset.(type) {
+	case *thdataset.Dataset:
+		common.RLPedBlockHeadersUint32s = dataset.Words()
+		trie.SetHeaderDataset(dataset)
+	case *thdataset.Light:
+		trie.SetHeaderDataset(dataset)
+	}
 }
 
 // FastSyncCommitHead sets the current head block to the one defined by the hash
@@ -713,22 +754,22 @@ func (bc *BlockChain) insert(block *types.Block) {
 	// fmt.Println(logData)
 	// common.LogToFile("impt_data_log.txt", logData)*/
 
-	// increase NextBlockNumber (to prefixing impt trie node hash) (jmlee)
-	common.NextBlockNumber++
-
-	// add new block header to memory for impt mining (jmlee)
-	if !trie.FakeIMPT && trie.DoReadHeader {
-		rlpedBlockHeader := rawdb.ReadHeaderRLP(common.GlobalDB, block.Hash(), block.NumberU64())
-		// common.RLPedBlockHeaders = append(common.RLPedBlockHeaders, rlpedBlockHeader)
-
-		common.RLPedBlockHeadersUint32s = append(common.RLPedBlockHeadersUint32s, bytesToUint32s(rlpedBlockHeader)...)
-		fmt.Println("increased header dataset len:", len(common.RLPedBlockHeadersUint32s))
-
-		if uint32(len(common.RLPedBlockHeadersUint32s)) >  trie.EthashDatasetLen {
-			common.RLPedBlockHeadersUint32s = common.RLPedBlockHeadersUint32s[:trie.EthashDatasetLen]
-			fmt.Println("header dataset adjusted:", len(common.RLPedBlockHeadersUint32s))
+	// add new block header to the header dataset for impt mining, dropping
+	// the headers of any chain it replaces (jmlee)
+	bc.truncateHeaderDataset(block.NumberU64())
+	if bc.thDataset != nil {
+		if blocks := bc.thDataset.Blocks(); blocks < block.NumberU64() {
+			if err := bc.thDataset.Generate(bc.db, block.NumberU64()-1); err != nil {
+				log.Error("Failed to fill header dataset gap", "from", blocks, "to", block.NumberU64()-1, "err", err)
+			}
+		}
+		if err := bc.thDataset.Append(block.NumberU64(), block.Hash(), rawdb.ReadHeaderRLP(bc.db, block.Hash(), block.NumberU64())); err != nil {
+			log.Error("Failed to extend header dataset", "number", block.Number(), "hash", block.Hash(), "err", err)
 		}
+		bc.publishHeaderDataset()
 	}
+	// increase NextBlockNumber (to prefixing impt trie node hash) (jmlee)
+	common.NextBlockNumber = block.NumberU64() + 1
 
 	// inspect leveldb stats
 	/*logData = "leveldbInfo\n" // lists with -> level,tables,size(MB),time(sec),read(MB),write(MB)
@@ -795,21 +836,17 @@ func (bc *BlockChain) insert(block *types.Block) {
 	// [TH] hardcoded to set fast sync boundary (jmlee)
 	if block.Number().Uint64() >= common.SyncBoundary {	
 		log.Info("Sync Finished")
-		fmt.Println("TotalPrefixingOverhead:", trie.TotalPrefixingOverhead, "ns -> (", trie.TotalPrefixingOverhead/1000000000, " sec)")
-
-		// check data type (GETH or TH)
-		prefixLen := trie.PrefixLength*2 + 2 // = blockNumberLen + "0x"
-		subRune1 := []rune(block.Header().Root.Hex())
-		subStr1 := string(subRune1[2:prefixLen])
-		fmt.Println(subStr1)
-
-		hashNum, _ := strconv.ParseUint(subStr1, 16, 64)
-		hashNum = uint64(hashNum)
 
-		fmt.Println(block.Number().Uint64(), " / ", hashNum)
+		// check data type (GETH or TH), the root prefix only holds the low bytes
+		// of the block number, so compare it past the prefix epochs
+		prefixLength := params.DefaultTrieHashimotoConfig.PrefixLength
+		if th := bc.chainConfig.TrieHashimoto; th != nil {
+			prefixLength = th.PrefixLength
+		}
+		log.Debug("Checking state root prefix", "number", block.NumberU64(), "prefix", trie.HashPrefix(block.Root(), prefixLength))
 		fileName := ""
 		dbLogFileName := ""
-		if block.Number().Uint64() == hashNum {
+		if trie.HasBlockPrefix(block.Root(), block.NumberU64(), prefixLength) {
 			fmt.Println("this is IMPT data")
 			fileName = "overheads_TH_" + common.SyncMode + "_" + block.Number().String() + ".txt"
 			dbLogFileName = "TH_" + common.SyncMode + "_" + block.Number().String() + ".txt"
@@ -822,8 +859,6 @@ func (bc *BlockChain) insert(block *types.Block) {
 		// save database inspect result
 		dbLogFileDir := "./dbInspectResults/"
 		if _, err := os.Stat(dbLogFileDir + dbLogFileName); os.IsNotExist(err) {
-			startTime := time.Now()
-
 			fmt.Println("File definitely does not exist.")
 			inspectResult := rawdb.InspectDatabaseGetResult(rawdb.GlobalDB) // KiB bytes log
 			f, err := os.OpenFile(dbLogFileDir+dbLogFileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
@@ -832,9 +867,6 @@ func (bc *BlockChain) insert(block *types.Block) {
 			}
 			fmt.Fprintln(f, inspectResult)
 			f.Close()
-
-			elapsedTime := time.Since(startTime)
-			trie.TotalPrefixingOverhead += uint64(elapsedTime.Nanoseconds()) // actually, this is not prefixing overhead, but just for convenience
 		} else {
 			fmt.Println("db inspect result log already exist. skip inspecting")
 		}
@@ -849,13 +881,12 @@ func (bc *BlockChain) insert(block *types.Block) {
 		if err != nil {
 			log.Info("ERR", "err", err)
 		}
-		logData := strconv.FormatUint(trie.TotalPrefixingOverhead/1000000000, 10)
+		logData := "notRolledBack"
 		if common.IsRolledBack {
 			fmt.Println("roll back occured")
-			logData += ",rolledBack"
+			logData = "rolledBack"
 		} else {
 			fmt.Println("roll back not occured")
-			logData += ",notRolledBack"
 		}
 		fmt.Fprintln(f, logData)
 		f.Close()
@@ -1074,6 +1105,12 @@ func (bc *BlockChain) Stop() {
 			log.Error("Dangling trie nodes after full cleanup")
 		}
 	}
+	if bc.thDataset != nil {
+		trie.SetHeaderDataset(nil)
+		if err := bc.thDataset.Close(); err != nil {
+			log.Error("Failed to close header dataset", "err", err)
+		}
+	}
 	log.Info("Blockchain manager stopped")
 }
 
@@ -1137,6 +1174,7 @@ func (bc *BlockChain) Rollback(chain []common.Hash) {
 	if err := bc.truncateAncient(bc.hc.CurrentHeader().Number.Uint64()); err != nil {
 		log.Crit("Truncate ancient store failed", "err", err)
 	}
+	bc.truncateHeaderDataset(bc.CurrentBlock().NumberU64() + 1)
 }
 
 // truncateAncient rewinds the blockchain to the specified header and deletes all
@@ -1533,6 +1571,11 @@ func (bc *BlockChain) writeBlockWithState(block *types.Block, receipts []*types.
 	}
 	triedb := bc.stateCache.TrieDB()
 
+	// Record the block numbers of the TH nodes flushed from now on, resolving
+	// their prefixes against this block
+	if th := bc.chainConfig.TrieHashimoto; th != nil && bc.chainConfig.IsTrieHashimoto(block.Number()) {
+		triedb.IndexNodeAge(th.PrefixLength, block.NumberU64())
+	}
 	// If we're running an archive node, always flush
 	if bc.cacheConfig.TrieDirtyDisabled {
 
@@ -1643,11 +1686,89 @@ func (bc *BlockChain) writeBlockWithState(block *types.Block, receipts []*types.
 	// Set new head.
 	if status == CanonStatTy {
 		bc.insert(block)
+		bc.pruneState(block.NumberU64())
+		bc.migrateColdNodes(block.NumberU64())
 	}
 	bc.futureBlocks.Remove(block.Hash())
 	return status, nil
 }
 
+// pruneState runs the online Trie-Hashimoto state pruner every THKeepRecent
+// blocks. It deletes the trie nodes mined before the kept states that none of
+// them reaches in the background, finding them through the node age index. The
+// states still held in memory are always kept. It never runs concurrently with
+// the cold trie node migrator, which could otherwise move the nodes it deletes.
+func (bc *BlockChain) pruneState(head uint64) {
+	interval := bc.cacheConfig.THKeepRecent
+	if interval == 0 || head%interval != 0 || !bc.chainConfig.IsTrieHashimoto(new(big.Int).SetUint64(head)) {
+		return
+	}
+	keep := interval
+	if keep < TriesInMemory {
+		keep = TriesInMemory
+	}
+	if head < keep {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&bc.thPruning, 0, 1) {
+		log.Debug("Trie node pruning in progress, skipping", "number", head)
+		return
+	}
+	roots := make([]common.Hash, 0, keep)
+	for number := head - keep + 1; number <= head; number++ {
+		header := bc.GetHeaderByNumber(number)
+		if header == nil {
+			log.Warn("Reorg in progress, trie node pruning postponed", "number", number)
+			atomic.StoreInt32(&bc.thPruning, 0)
+			return
+		}
+		roots = append(roots, header.Root)
+	}
+	bc.wg.Add(1)
+	go func() {
+		defer bc.wg.Done()
+		defer atomic.StoreInt32(&bc.thPruning, 0)
+
+		bc.thMaintain.Lock()
+		defer bc.thMaintain.Unlock()
+
+		if _, err := state.Prune(bc.stateCache, roots, head-keep+1); err != nil {
+			log.Error("Failed to prune stale trie nodes", "number", head, "err", err)
+		}
+	}()
+}
+
+// migrateColdNodes runs the cold trie node migrator every coldMigrateInterval
+// blocks. It moves the trie nodes mined more than THColdAfter blocks ago from
+// the key-value store into the cold store in the background, finding them
+// through the node age index.
+func (bc *BlockChain) migrateColdNodes(head uint64) {
+	after := bc.cacheConfig.THColdAfter
+	if after == 0 || head%coldMigrateInterval != 0 || head < after {
+		return
+	}
+	store, ok := bc.db.(trie.ColdNodeStore)
+	if !ok || store.ColdStore() == nil {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&bc.thMigrating, 0, 1) {
+		log.Debug("Cold trie node migration in progress, skipping", "number", head)
+		return
+	}
+	bc.wg.Add(1)
+	go func() {
+		defer bc.wg.Done()
+		defer atomic.StoreInt32(&bc.thMigrating, 0)
+
+		bc.thMaintain.Lock()
+		defer bc.thMaintain.Unlock()
+
+		if _, err := store.ColdStore().Migrate(head - after + 1); err != nil {
+			log.Error("Failed to move trie nodes into cold store", "number", head, "err", err)
+		}
+	}()
+}
+
 // addFutureBlock checks if the block is within the max allowed window to get
 // accepted for future processing, and returns an error if the block is too far
 // ahead and was not added.
@@ -1861,6 +1982,15 @@ func (bc *BlockChain) insertChain(chain types.Blocks, verifySeals bool) (int, []
 		if err != nil {
 			return it.index, events, coalescedLogs, err
 		}
+		// Blocks not extending the head, like those of side chains, were mined over
+		// the headers of their own ancestry, verify their trie nonces against those
+		if bc.thDataset != nil && block.ParentHash() != bc.CurrentBlock().Hash() {
+			dataset, err := bc.thDataset.Ancestry(bc.db, block.ParentHash(), block.NumberU64()-1)
+			if err != nil {
+				return it.index, events, coalescedLogs, err
+			}
+			statedb.SetHeaderDataset(dataset)
+		}
 		// If we have a followup block, run that against the current state to pre-cache
 		// transactions and probabilistically some of the account/storage trie nodes.
 		var followupInterrupt uint32
@@ -2198,6 +2328,9 @@ func (bc *BlockChain) reorg(oldBlock, newBlock *types.Block) error {
 	} else {
 		log.Error("Impossible reorg, please file an issue", "oldnum", oldBlock.Number(), "oldhash", oldBlock.Hash(), "newnum", newBlock.Number(), "newhash", newBlock.Hash())
 	}
+	// Drop the headers of the old chain from the header dataset (jmlee)
+	bc.truncateHeaderDataset(commonBlock.NumberU64() + 1)
+
 	// Insert the new chain(except the head block(reverse order)),
 	// taking care of the proper incremental order.
 	for i := len(newChain) - 1; i >= 1; i-- {
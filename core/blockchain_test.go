@@ -17,11 +17,14 @@
 package core
 
 import (
+	"encoding/binary"
 	"fmt"
 	"io/ioutil"
 	"math/big"
 	"math/rand"
 	"os"
+	"path/filepath"
+	"reflect"
 	"sync"
 	"testing"
 	"time"
@@ -31,11 +34,13 @@ import (
 	"github.com/ethereum/go-ethereum/consensus/ethash"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/thdataset"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/trie"
 )
 
 // So we can deterministically seed different blockchains
@@ -2194,6 +2199,386 @@ func benchmarkLargeNumberOfValueToNonexisting(b *testing.B, numTxs, numBlocks in
 	}
 }
 
+// checkHeaderDataset checks that the header dataset of the chain holds exactly
+// the headers of its canonical chain.
+func checkHeaderDataset(t *testing.T, chain *BlockChain) {
+	t.Helper()
+
+	head := chain.CurrentBlock().NumberU64()
+	want := []uint32{}
+	for number := uint64(0); number <= head; number++ {
+		header := rawdb.ReadHeaderRLP(chain.db, rawdb.ReadCanonicalHash(chain.db, number), number)
+		for i := 0; i+4 <= len(header); i += 4 {
+			want = append(want, binary.LittleEndian.Uint32(header[i:]))
+		}
+	}
+	if blocks := chain.thDataset.Blocks(); blocks != head+1 {
+		t.Fatalf("header dataset block count mismatch: have %d, want %d", blocks, head+1)
+	}
+	if words := chain.thDataset.(*thdataset.Dataset).Words(); !reflect.DeepEqual(words, want) {
+		t.Fatalf("header dataset mismatch at head %d: have %d words, want %d", head, len(words), len(want))
+	}
+	if words := common.RLPedBlockHeadersUint32s; !reflect.DeepEqual(words, want) {
+		t.Fatalf("mining header dataset mismatch at head %d: have %d words, want %d", head, len(words), len(want))
+	}
+	if common.NextBlockNumber != head+1 {
+		t.Fatalf("next block number mismatch: have %d, want %d", common.NextBlockNumber, head+1)
+	}
+}
+
+// Tests that the Trie-Hashimoto header dataset follows the canonical chain
+// through reorgs, rewinds and restarts.
+func TestHeaderDatasetReorg(t *testing.T) {
+	dir, err := ioutil.TempDir("", "thdataset-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Maintain the header dataset without activating trie mining
+	config := *params.TestChainConfig
+	config.TrieHashimoto = &params.TrieHashimotoConfig{PrefixLength: 2, ReadHeader: true, LoopAccesses: 1}
+
+	var (
+		engine  = ethash.NewFaker()
+		db      = rawdb.NewMemoryDatabase()
+		genesis = (&Genesis{Config: &config}).MustCommit(db)
+		cache   = &CacheConfig{TrieCleanLimit: 256, TrieDirtyLimit: 256, TrieTimeLimit: 5 * time.Minute, THDatasetDir: filepath.Join(dir, "thdataset")}
+	)
+	// Generate a canonical chain and a heavier fork of it from an early block
+	longChain, _ := GenerateChain(&config, genesis, engine, db, 32, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{1})
+	})
+	heavyChain, _ := GenerateChain(&config, longChain[3], engine, db, 40, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{2})
+	})
+	// Rolling back requires an ancient store, so use a freezer database
+	diskdb, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), dir, "")
+	if err != nil {
+		t.Fatalf("failed to create temp freezer db: %v", err)
+	}
+	defer diskdb.Close()
+	(&Genesis{Config: &config}).MustCommit(diskdb)
+
+	chain, err := NewBlockChain(diskdb, cache, &config, engine, vm.Config{}, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	checkHeaderDataset(t, chain)
+
+	if n, err := chain.InsertChain(longChain); err != nil {
+		t.Fatalf("block %d: failed to insert long chain: %v", n, err)
+	}
+	checkHeaderDataset(t, chain)
+
+	// Reorg onto the heavy fork, replacing all but the first few headers
+	if n, err := chain.InsertChain(heavyChain); err != nil {
+		t.Fatalf("block %d: failed to insert heavy chain: %v", n, err)
+	}
+	if head := chain.CurrentBlock().Hash(); head != heavyChain[len(heavyChain)-1].Hash() {
+		t.Fatalf("head mismatch after reorg: have %x, want %x", head, heavyChain[len(heavyChain)-1].Hash())
+	}
+	checkHeaderDataset(t, chain)
+
+	// Rewind the chain and extend it again
+	if err := chain.SetHead(20); err != nil {
+		t.Fatalf("failed to rewind chain: %v", err)
+	}
+	checkHeaderDataset(t, chain)
+
+	if n, err := chain.InsertChain(heavyChain[16:]); err != nil {
+		t.Fatalf("block %d: failed to reinsert heavy chain: %v", n, err)
+	}
+	checkHeaderDataset(t, chain)
+
+	// Roll back the last few blocks, as the downloader does on failed syncs
+	chain.Rollback([]common.Hash{heavyChain[len(heavyChain)-2].Hash(), heavyChain[len(heavyChain)-1].Hash()})
+	checkHeaderDataset(t, chain)
+	chain.Stop()
+
+	// Reopen the chain, which should load the dataset from disk as is
+	if chain, err = NewBlockChain(diskdb, cache, &config, engine, vm.Config{}, nil); err != nil {
+		t.Fatalf("failed to reopen tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	checkHeaderDataset(t, chain)
+}
+
+// mineTrieHashimotoBlocks mines n empty blocks with the given coinbase on top of
+// the head of the chain and inserts them. The dirty trie nodes are mined against
+// the header dataset of the chain, so the chain must hold the ancestry wanted.
+func mineTrieHashimotoBlocks(t *testing.T, chain *BlockChain, n int, coinbase common.Address) []*types.Block {
+	t.Helper()
+
+	var blocks []*types.Block
+	for i := 0; i < n; i++ {
+		parent := chain.CurrentBlock()
+		statedb, err := chain.StateAt(parent.Root())
+		if err != nil {
+			t.Fatalf("failed to open state of block %d: %v", parent.NumberU64(), err)
+		}
+		header := &types.Header{
+			ParentHash: parent.Hash(),
+			Coinbase:   coinbase,
+			Number:     new(big.Int).Add(parent.Number(), common.Big1),
+			GasLimit:   parent.GasLimit(),
+			Time:       parent.Time() + 10,
+		}
+		if err := chain.engine.Prepare(chain, header); err != nil {
+			t.Fatalf("failed to prepare block %d: %v", header.Number, err)
+		}
+		block, err := chain.engine.FinalizeAndAssemble(chain, header, statedb, nil, nil, nil, nil)
+		if err != nil {
+			t.Fatalf("failed to assemble block %d: %v", header.Number, err)
+		}
+		var (
+			th         = chain.Config().TrieHashimoto
+			number     = block.NumberU64()
+			difficulty = block.TrieDifficulty()
+		)
+		storage, err := statedb.MineStorageTries(th, number, difficulty, &trie.Miner{Threads: 1})
+		if err != nil {
+			t.Fatalf("failed to mine storage tries of block %d: %v", number, err)
+		}
+		root, nonces, err := (*statedb.Trie()).HashWithNonce(th, number, difficulty, &trie.Miner{Threads: 1})
+		if err != nil {
+			t.Fatalf("failed to mine account trie of block %d: %v", number, err)
+		}
+		block = block.WithTrieNonces(root, nonces, storage, block.Time())
+		if _, err := chain.InsertChain(types.Blocks{block}); err != nil {
+			t.Fatalf("failed to insert mined block %d: %v", number, err)
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+// newTrieHashimotoTestChain creates a chain mining trie nodes over the header
+// dataset from block 1 on, verifying them with the full or the light dataset.
+func newTrieHashimotoTestChain(t *testing.T, light bool) *BlockChain {
+	t.Helper()
+
+	config := *params.TestChainConfig
+	config.TrieHashimoto = &params.TrieHashimotoConfig{Block: common.Big1, PrefixLength: 1, ReadHeader: true, LoopAccesses: 8}
+
+	db := rawdb.NewMemoryDatabase()
+	(&Genesis{Config: &config}).MustCommit(db)
+
+	cache := &CacheConfig{TrieCleanLimit: 256, TrieDirtyLimit: 256, TrieTimeLimit: 5 * time.Minute, THLightVerifier: light}
+	chain, err := NewBlockChain(db, cache, &config, ethash.NewFaker(), vm.Config{}, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	return chain
+}
+
+// Tests that a block whose body is missing a trie nonce is rejected with the error
+// the fetcher drops the propagating peer on, and that it isn't imported.
+func TestTrieHashimotoInvalidTrieNonces(t *testing.T) {
+	generator := newTrieHashimotoTestChain(t, false)
+	blocks := mineTrieHashimotoBlocks(t, generator, 2, common.Address{1})
+	generator.Stop()
+
+	nonces := blocks[1].TrieNonces()
+	if len(nonces) == 0 {
+		t.Fatalf("block %d mined without trie nonces", blocks[1].NumberU64())
+	}
+	bad := blocks[1].WithTrieNonces(blocks[1].Root(), nonces[:len(nonces)-1], blocks[1].StorageNonces(), blocks[1].TrieTime())
+
+	chain := newTrieHashimotoTestChain(t, false)
+	defer chain.Stop()
+
+	if n, err := chain.InsertChain(blocks[:1]); err != nil {
+		t.Fatalf("block %d: failed to insert chain: %v", n, err)
+	}
+	if _, err := chain.InsertChain(types.Blocks{bad}); err != consensus.ErrInvalidTrieNonce {
+		t.Fatalf("bad block error mismatch: have %v, want %v", err, consensus.ErrInvalidTrieNonce)
+	}
+	if chain.HasBlock(bad.Hash(), bad.NumberU64()) {
+		t.Fatalf("block with invalid trie nonces imported")
+	}
+	if n, err := chain.InsertChain(blocks[1:]); err != nil {
+		t.Fatalf("block %d: failed to insert valid block: %v", n, err)
+	}
+}
+
+// Tests that a block forking off below the head is verified against the headers
+// before it, not against the header dataset of the whole canonical chain.
+func TestTrieHashimotoForkedBlock(t *testing.T) {
+	testTrieHashimotoForkedBlock(t, false)
+}
+func TestTrieHashimotoForkedBlockLight(t *testing.T) {
+	testTrieHashimotoForkedBlock(t, true)
+}
+
+func testTrieHashimotoForkedBlock(t *testing.T, light bool) {
+	// Mine a canonical chain and a block forking off two blocks below its head
+	generator := newTrieHashimotoTestChain(t, false)
+	canon := mineTrieHashimotoBlocks(t, generator, 4, common.Address{1})
+	if err := generator.SetHead(2); err != nil {
+		t.Fatalf("failed to rewind generator chain: %v", err)
+	}
+	fork := mineTrieHashimotoBlocks(t, generator, 1, common.Address{2})
+	generator.Stop()
+
+	// Import the canonical chain, then the forked block as a side block
+	chain := newTrieHashimotoTestChain(t, light)
+	defer chain.Stop()
+
+	if n, err := chain.InsertChain(canon); err != nil {
+		t.Fatalf("block %d: failed to insert canonical chain: %v", n, err)
+	}
+	if n, err := chain.InsertChain(fork); err != nil {
+		t.Fatalf("block %d: failed to insert forked block: %v", n, err)
+	}
+	if head := chain.CurrentBlock().Hash(); head != canon[len(canon)-1].Hash() {
+		t.Fatalf("head mismatch: have %x, want %x", head, canon[len(canon)-1].Hash())
+	}
+	if !chain.HasBlockAndState(fork[0].Hash(), fork[0].NumberU64()) {
+		t.Fatalf("forked block not imported with its state")
+	}
+}
+
+// Tests that the blocks of a lighter side chain are verified against the headers
+// of their own ancestry before any reorg, and that the chain reorgs onto them
+// once the side chain becomes heavier.
+func TestTrieHashimotoSideChain(t *testing.T) {
+	testTrieHashimotoSideChain(t, false)
+}
+func TestTrieHashimotoSideChainLight(t *testing.T) {
+	testTrieHashimotoSideChain(t, true)
+}
+
+func testTrieHashimotoSideChain(t *testing.T, light bool) {
+	// Mine a canonical chain and a longer fork of it from an early block
+	generator := newTrieHashimotoTestChain(t, false)
+	canon := mineTrieHashimotoBlocks(t, generator, 6, common.Address{1})
+	if err := generator.SetHead(2); err != nil {
+		t.Fatalf("failed to rewind generator chain: %v", err)
+	}
+	side := mineTrieHashimotoBlocks(t, generator, 5, common.Address{2})
+	generator.Stop()
+
+	chain := newTrieHashimotoTestChain(t, light)
+	defer chain.Stop()
+
+	if n, err := chain.InsertChain(canon); err != nil {
+		t.Fatalf("block %d: failed to insert canonical chain: %v", n, err)
+	}
+	// Import the fork while it's still lighter than the canonical chain
+	if n, err := chain.InsertChain(side[:3]); err != nil {
+		t.Fatalf("block %d: failed to insert side chain: %v", n, err)
+	}
+	if head := chain.CurrentBlock().Hash(); head != canon[len(canon)-1].Hash() {
+		t.Fatalf("head mismatch after side chain import: have %x, want %x", head, canon[len(canon)-1].Hash())
+	}
+	for _, block := range side[:3] {
+		if !chain.HasBlockAndState(block.Hash(), block.NumberU64()) {
+			t.Fatalf("side chain block %d not imported with its state", block.NumberU64())
+		}
+	}
+	// Extend the fork past the canonical chain, reorging onto it
+	if n, err := chain.InsertChain(side[3:]); err != nil {
+		t.Fatalf("block %d: failed to extend side chain: %v", n, err)
+	}
+	if head := chain.CurrentBlock().Hash(); head != side[len(side)-1].Hash() {
+		t.Fatalf("head mismatch after reorg: have %x, want %x", head, side[len(side)-1].Hash())
+	}
+	if !light {
+		checkHeaderDataset(t, chain)
+	}
+}
+
+// coldTestDB is a database with a cold trie node store on the side.
+type coldTestDB struct {
+	ethdb.Database
+	cold *trie.ColdStore
+}
+
+func (db *coldTestDB) ColdStore() *trie.ColdStore { return db.cold }
+
+// Tests that the online trie node pruner and the cold trie node migrator, both
+// due at the same blocks and started concurrently, leave the kept states whole.
+func TestTrieHashimotoPruneMigrateConcurrently(t *testing.T) {
+	dir, err := ioutil.TempDir("", "th-cold-")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := *params.TestChainConfig
+	config.TrieHashimoto = &params.TrieHashimotoConfig{Block: common.Big1, PrefixLength: 2, Fake: true}
+
+	// Mine the blocks on a chain keeping every trie node. The account of the first
+	// coinbase stays untouched after block 1, so its node is old but kept.
+	gendb := rawdb.NewMemoryDatabase()
+	(&Genesis{Config: &config}).MustCommit(gendb)
+	generator, _ := NewBlockChain(gendb, nil, &config, ethash.NewFaker(), vm.Config{}, nil)
+	blocks := mineTrieHashimotoBlocks(t, generator, 1, common.Address{1})
+	blocks = append(blocks, mineTrieHashimotoBlocks(t, generator, 2*TriesInMemory-1, common.Address{2})...)
+	generator.Stop()
+
+	// Import them into a chain pruning and migrating every TriesInMemory blocks
+	memdb := rawdb.NewMemoryDatabase()
+	cold, err := trie.NewColdStore(dir, memdb)
+	if err != nil {
+		t.Fatalf("failed to create cold store: %v", err)
+	}
+	defer cold.Close()
+
+	db := &coldTestDB{Database: memdb, cold: cold}
+	(&Genesis{Config: &config}).MustCommit(db)
+
+	cache := &CacheConfig{TrieCleanLimit: 256, TrieDirtyDisabled: true, THKeepRecent: TriesInMemory, THColdAfter: TriesInMemory}
+	chain, err := NewBlockChain(db, cache, &config, ethash.NewFaker(), vm.Config{}, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("block %d: failed to insert chain: %v", n, err)
+	}
+	// Keep starting both at the head while they run
+	head := chain.CurrentBlock().NumberU64()
+
+	var pend sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		pend.Add(2)
+		go func() {
+			defer pend.Done()
+			chain.pruneState(head)
+		}()
+		go func() {
+			defer pend.Done()
+			chain.migrateColdNodes(head)
+		}()
+	}
+	pend.Wait()
+	chain.wg.Wait()
+
+	if cold.Size() == 0 {
+		t.Fatalf("no trie nodes moved into the cold store")
+	}
+	triedb := trie.NewDatabase(db)
+	if _, err := trie.New(blocks[1].Root(), triedb); err == nil {
+		t.Fatalf("stale state of block %d not pruned", blocks[1].NumberU64())
+	}
+	for _, block := range blocks[len(blocks)-TriesInMemory:] {
+		tr, err := trie.New(block.Root(), triedb)
+		if err != nil {
+			t.Fatalf("failed to open state of block %d: %v", block.NumberU64(), err)
+		}
+		it := tr.NodeIterator(nil)
+		for it.Next(true) {
+		}
+		if err := it.Error(); err != nil {
+			t.Fatalf("state of block %d incomplete: %v", block.NumberU64(), err)
+		}
+	}
+}
+
 func BenchmarkBlockChain_1x1000ValueTransferToNonexisting(b *testing.B) {
 	var (
 		numTxs    = 1000
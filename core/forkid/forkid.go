@@ -213,6 +213,9 @@ func gatherForks(config *params.ChainConfig) []uint64 {
 			forks = append(forks, rule.Uint64())
 		}
 	}
+	if config.TrieHashimoto != nil && config.TrieHashimoto.Block != nil {
+		forks = append(forks, config.TrieHashimoto.Block.Uint64())
+	}
 	// Sort the fork block numbers to permit chronologival XOR
 	for i := 0; i < len(forks); i++ {
 		for j := i + 1; j < len(forks); j++ {
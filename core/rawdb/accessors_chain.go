@@ -476,7 +476,7 @@ func ReadBlock(db ethdb.Reader, hash common.Hash, number uint64) *types.Block {
 	if body == nil {
 		return nil
 	}
-	return types.NewBlockWithHeader(header).WithBody(body.Transactions, body.Uncles, body.TrieNonces)
+	return types.NewBlockWithHeader(header).WithBody(body.Transactions, body.Uncles, body.TrieNonces, body.StorageNonces)
 }
 
 // WriteBlock serializes a block into the database, header and body separately.
@@ -96,3 +96,18 @@ func WritePreimages(db ethdb.KeyValueWriter, preimages map[common.Hash][]byte) {
 	preimageCounter.Inc(int64(len(preimages)))
 	preimageHitCounter.Inc(int64(len(preimages)))
 }
+
+// ReadTrieShardPolicy retrieves the spec of the policy the trie nodes are split
+// across shards by, or an empty string if they were never sharded.
+func ReadTrieShardPolicy(db ethdb.KeyValueReader) string {
+	data, _ := db.Get(trieShardPolicyKey)
+	return string(data)
+}
+
+// WriteTrieShardPolicy stores the spec of the policy the trie nodes are split
+// across shards by.
+func WriteTrieShardPolicy(db ethdb.KeyValueWriter, policy string) {
+	if err := db.Put(trieShardPolicyKey, []byte(policy)); err != nil {
+		log.Crit("Failed to store trie shard policy", "err", err)
+	}
+}
@@ -21,7 +21,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"strconv"
+	"path/filepath"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -42,6 +42,36 @@ type freezerdb struct {
 	ethdb.AncientStore
 }
 
+// coldTrieNodesDir is the directory in the freezer holding the cold trie nodes.
+const coldTrieNodesDir = "thnodes"
+
+// tieredDB is a database wrapper that enables cold trie node retrievals.
+type tieredDB struct {
+	ethdb.Database
+	cold *trie.ColdStore
+}
+
+// ColdStore implements trie.ColdNodeStore, returning the cold trie node store.
+func (db *tieredDB) ColdStore() *trie.ColdStore {
+	return db.cold
+}
+
+// Close implements io.Closer, closing the cold trie node store as well as the
+// wrapped database.
+func (db *tieredDB) Close() error {
+	var errs []error
+	if err := db.cold.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := db.Database.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("%v", errs)
+	}
+	return nil
+}
+
 // Close implements io.Closer, closing both the fast key-value store as well as
 // the slow ancient tables.
 func (frdb *freezerdb) Close() error {
@@ -221,26 +251,19 @@ func NewLevelDBDatabaseWithFreezer(file string, cache int, handles int, freezer
 		kvdb.Close()
 		return nil, err
 	}
-	GlobalDB = frdb // set globaldb (jmlee)
-	common.GlobalDB = frdb // set globaldb (jmlee)
-	fmt.Println("GlobalDB is set")
-
-	// open additional leveldb for indexed trie nodes (jmlee)
-	for i := 0; i < len(trie.GlobalTrieNodeDB); i++ {
-		t_namespace := "eth/db/indexedNode/" + strconv.Itoa(i)
-		t_kvdb, err := leveldb.New(file+"/../indexedNodes/index"+strconv.Itoa(i), cache, handles, t_namespace)
-		if err != nil {
-			return nil, err
-		}
-		t_frdb, err := NewDatabaseWithFreezer(t_kvdb, freezer+"/../../indexedNodes/index"+strconv.Itoa(i)+"/ancient", t_namespace)
-		if err != nil {
-			t_kvdb.Close()
-			return nil, err
-		}
-		trie.GlobalTrieNodeDB[i] = t_frdb
+	// Old trie nodes are moved next to the ancient chain segments
+	cold, err := trie.NewColdStore(filepath.Join(freezer, coldTrieNodesDir), kvdb)
+	if err != nil {
+		frdb.Close()
+		return nil, err
 	}
+	db := &tieredDB{Database: frdb, cold: cold}
+
+	GlobalDB = db // set globaldb (jmlee)
+	common.GlobalDB = db // set globaldb (jmlee)
+	fmt.Println("GlobalDB is set")
 
-	return frdb, nil
+	return db, nil
 }
 
 // InspectDatabase traverses the entire database and checks the size
@@ -265,6 +288,8 @@ func InspectDatabase(db ethdb.Database) error {
 		trieSize        common.StorageSize
 		txlookupSize    common.StorageSize
 		preimageSize    common.StorageSize
+		trieAgeSize     common.StorageSize
+		coldIndexSize   common.StorageSize
 		bloomBitsSize   common.StorageSize
 		cliqueSnapsSize common.StorageSize
 
@@ -274,6 +299,7 @@ func InspectDatabase(db ethdb.Database) error {
 		ancientReceipts common.StorageSize
 		ancientHashes   common.StorageSize
 		ancientTds      common.StorageSize
+		coldTrieNodes   common.StorageSize
 
 		// Les statistic
 		chtTrieNodes   common.StorageSize
@@ -307,6 +333,10 @@ func InspectDatabase(db ethdb.Database) error {
 			txlookupSize += size
 		case bytes.HasPrefix(key, preimagePrefix) && len(key) == (len(preimagePrefix)+common.HashLength):
 			preimageSize += size
+		case trie.IsNodeAgeKey(key):
+			trieAgeSize += size
+		case trie.IsColdIndexKey(key):
+			coldIndexSize += size
 		case bytes.HasPrefix(key, bloomBitsPrefix) && len(key) == (len(bloomBitsPrefix)+10+common.HashLength):
 			bloomBitsSize += size
 		case bytes.HasPrefix(key, []byte("clique-")) && len(key) == 7+common.HashLength:
@@ -319,7 +349,7 @@ func InspectDatabase(db ethdb.Database) error {
 			trieSize += size
 		default:
 			var accounted bool
-			for _, meta := range [][]byte{databaseVerisionKey, headHeaderKey, headBlockKey, headFastBlockKey, fastTrieProgressKey} {
+			for _, meta := range [][]byte{databaseVerisionKey, headHeaderKey, headBlockKey, headFastBlockKey, fastTrieProgressKey, trieShardPolicyKey} {
 				if bytes.Equal(key, meta) {
 					metadata += size
 					accounted = true
@@ -344,6 +374,10 @@ func InspectDatabase(db ethdb.Database) error {
 			total += common.StorageSize(size)
 		}
 	}
+	if store, ok := db.(trie.ColdNodeStore); ok && store.ColdStore() != nil {
+		coldTrieNodes = store.ColdStore().Size()
+		total += coldTrieNodes
+	}
 	// Display the database statistic.
 	stats := [][]string{
 		{"Key-Value store", "Headers", headerSize.String()},
@@ -356,6 +390,8 @@ func InspectDatabase(db ethdb.Database) error {
 		{"Key-Value store", "Bloombit index", bloomBitsSize.String()},
 		{"Key-Value store", "Trie nodes", trieSize.String()},
 		{"Key-Value store", "Trie preimages", preimageSize.String()},
+		{"Key-Value store", "Trie node ages", trieAgeSize.String()},
+		{"Key-Value store", "Cold trie node index", coldIndexSize.String()},
 		{"Key-Value store", "Clique snapshots", cliqueSnapsSize.String()},
 		{"Key-Value store", "Singleton metadata", metadata.String()},
 		{"Ancient store", "Headers", ancientHeaders.String()},
@@ -363,6 +399,7 @@ func InspectDatabase(db ethdb.Database) error {
 		{"Ancient store", "Receipts", ancientReceipts.String()},
 		{"Ancient store", "Difficulties", ancientTds.String()},
 		{"Ancient store", "Block number->hash", ancientHashes.String()},
+		{"Ancient store", "Cold trie nodes", coldTrieNodes.String()},
 		{"Light client", "CHT trie nodes", chtTrieNodes.String()},
 		{"Light client", "Bloom trie nodes", bloomTrieNodes.String()},
 	}
@@ -400,6 +437,8 @@ func InspectDatabaseGetResult(db ethdb.Database) string {
 		trieSize        common.StorageSize
 		txlookupSize    common.StorageSize
 		preimageSize    common.StorageSize
+		trieAgeSize     common.StorageSize
+		coldIndexSize   common.StorageSize
 		bloomBitsSize   common.StorageSize
 		cliqueSnapsSize common.StorageSize
 
@@ -409,6 +448,7 @@ func InspectDatabaseGetResult(db ethdb.Database) string {
 		ancientReceipts common.StorageSize
 		ancientHashes   common.StorageSize
 		ancientTds      common.StorageSize
+		coldTrieNodes   common.StorageSize
 
 		// Les statistic
 		chtTrieNodes   common.StorageSize
@@ -442,6 +482,10 @@ func InspectDatabaseGetResult(db ethdb.Database) string {
 			txlookupSize += size
 		case bytes.HasPrefix(key, preimagePrefix) && len(key) == (len(preimagePrefix)+common.HashLength):
 			preimageSize += size
+		case trie.IsNodeAgeKey(key):
+			trieAgeSize += size
+		case trie.IsColdIndexKey(key):
+			coldIndexSize += size
 		case bytes.HasPrefix(key, bloomBitsPrefix) && len(key) == (len(bloomBitsPrefix)+10+common.HashLength):
 			bloomBitsSize += size
 		case bytes.HasPrefix(key, []byte("clique-")) && len(key) == 7+common.HashLength:
@@ -454,7 +498,7 @@ func InspectDatabaseGetResult(db ethdb.Database) string {
 			trieSize += size
 		default:
 			var accounted bool
-			for _, meta := range [][]byte{databaseVerisionKey, headHeaderKey, headBlockKey, headFastBlockKey, fastTrieProgressKey} {
+			for _, meta := range [][]byte{databaseVerisionKey, headHeaderKey, headBlockKey, headFastBlockKey, fastTrieProgressKey, trieShardPolicyKey} {
 				if bytes.Equal(key, meta) {
 					metadata += size
 					accounted = true
@@ -479,6 +523,10 @@ func InspectDatabaseGetResult(db ethdb.Database) string {
 			total += common.StorageSize(size)
 		}
 	}
+	if store, ok := db.(trie.ColdNodeStore); ok && store.ColdStore() != nil {
+		coldTrieNodes = store.ColdStore().Size()
+		total += coldTrieNodes
+	}
 	// Display the database statistic.
 	stats := [][]string{
 		{"Key-Value store", "Headers", headerSize.String()},
@@ -491,6 +539,8 @@ func InspectDatabaseGetResult(db ethdb.Database) string {
 		{"Key-Value store", "Bloombit index", bloomBitsSize.String()},
 		{"Key-Value store", "Trie nodes", trieSize.String()},
 		{"Key-Value store", "Trie preimages", preimageSize.String()},
+		{"Key-Value store", "Trie node ages", trieAgeSize.String()},
+		{"Key-Value store", "Cold trie node index", coldIndexSize.String()},
 		{"Key-Value store", "Clique snapshots", cliqueSnapsSize.String()},
 		{"Key-Value store", "Singleton metadata", metadata.String()},
 		{"Ancient store", "Headers", ancientHeaders.String()},
@@ -498,6 +548,7 @@ func InspectDatabaseGetResult(db ethdb.Database) string {
 		{"Ancient store", "Receipts", ancientReceipts.String()},
 		{"Ancient store", "Difficulties", ancientTds.String()},
 		{"Ancient store", "Block number->hash", ancientHashes.String()},
+		{"Ancient store", "Cold trie nodes", coldTrieNodes.String()},
 		{"Light client", "CHT trie nodes", chtTrieNodes.String()},
 		{"Light client", "Bloom trie nodes", bloomTrieNodes.String()},
 	}
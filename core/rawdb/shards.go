@@ -0,0 +1,123 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/ethdb/leveldb"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// shardedDB is a database wrapper that splits the trie nodes across shards.
+type shardedDB struct {
+	ethdb.Database
+	shards *trie.NodeShards
+	stores []ethdb.KeyValueStore // Shard stores besides the main key-value store
+}
+
+// NodeShards implements trie.ShardedNodeStore, returning the trie node shards.
+func (db *shardedDB) NodeShards() *trie.NodeShards {
+	return db.shards
+}
+
+// ColdStore implements trie.ColdNodeStore, returning the cold trie node store
+// of the wrapped database, if any.
+func (db *shardedDB) ColdStore() *trie.ColdStore {
+	if store, ok := db.Database.(trie.ColdNodeStore); ok {
+		return store.ColdStore()
+	}
+	return nil
+}
+
+// Close implements io.Closer, closing the shard stores as well as the wrapped
+// database.
+func (db *shardedDB) Close() error {
+	var errs []error
+	for _, store := range db.stores {
+		if err := store.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := db.Database.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("%v", errs)
+	}
+	return nil
+}
+
+// OpenTrieNodeShards opens the key-value stores of the trie node shards in the
+// given directory, but the first one, which is the main key-value store.
+func OpenTrieNodeShards(dir string, shards int, cache int, handles int, namespace string) ([]ethdb.KeyValueStore, error) {
+	stores := make([]ethdb.KeyValueStore, 0, shards-1)
+	for i := 1; i < shards; i++ {
+		store, err := leveldb.New(filepath.Join(dir, strconv.Itoa(i)), cache, handles, namespace+"shard/"+strconv.Itoa(i)+"/")
+		if err != nil {
+			for _, store := range stores {
+				store.Close()
+			}
+			return nil, err
+		}
+		stores = append(stores, store)
+	}
+	return stores, nil
+}
+
+// NewShardedDatabase splits the trie nodes of the database across the shards of
+// the given policy, opening the shard stores in the given directory. The policy
+// must match the one the database was sharded by, which is recorded as soon as
+// the database holds more than the genesis state. Changing it takes moving the
+// existing nodes with `geth th reshard`.
+func NewShardedDatabase(db ethdb.Database, dir string, sharder trie.TrieNodeSharder, cache int, handles int, namespace string) (ethdb.Database, error) {
+	policy := ReadTrieShardPolicy(db)
+	if policy == "" {
+		// Genesis nodes written unsharded are still found in the main store
+		if number := ReadHeaderNumber(db, ReadHeadHeaderHash(db)); number == nil || *number == 0 {
+			WriteTrieShardPolicy(db, sharder.String())
+			policy = sharder.String()
+		} else {
+			policy = trie.ShardNone
+		}
+	}
+	if policy != sharder.String() {
+		return nil, fmt.Errorf("trie node sharding policy changed from %q to %q, run geth th reshard", policy, sharder)
+	}
+	if sharder.Shards() == 1 {
+		return db, nil
+	}
+	stores, err := OpenTrieNodeShards(dir, sharder.Shards(), cache, handles, namespace)
+	if err != nil {
+		return nil, err
+	}
+	shards, err := trie.NewNodeShards(sharder, append([]ethdb.KeyValueStore{db}, stores...))
+	if err != nil {
+		for _, store := range stores {
+			store.Close()
+		}
+		return nil, err
+	}
+	sharded := &shardedDB{Database: db, shards: shards, stores: stores}
+	if cold := sharded.ColdStore(); cold != nil {
+		cold.SetNodeShards(shards)
+	}
+	return sharded, nil
+}
@@ -18,9 +18,11 @@ package state
 
 import (
 	"fmt"
+	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/trie"
 	lru "github.com/hashicorp/golang-lru"
 )
@@ -80,11 +82,15 @@ type Trie interface {
 
 	// HashWithNonce returns the root hash of the trie with the mining work result. 
 	// It does not write to the database and can be used even if the trie doesn't have one.
-	HashWithNonce(uint64, int) (common.Hash, []uint64)
+	// Node hashes must meet the target of the trie difficulty past the block number.
+	// A nil miner searches the nonces on all CPUs locally. Aborting the miner fails
+	// with a *trie.MiningAbortedError, leaving the trie unchanged.
+	HashWithNonce(config *params.TrieHashimotoConfig, blockNum uint64, difficulty *big.Int, miner *trie.Miner) (common.Hash, []uint64, error)
 	
 	// HashByNonce returns the root hash of the trie updated by previously mined work.
 	// It does not write to the database and can be used even if the trie doesn't have one.
-	HashByNonce(trieNonces []uint64, blockNum uint64) common.Hash
+	// The nonces are verified against the given header dataset, nil for the default one.
+	HashByNonce(config *params.TrieHashimotoConfig, trieNonces []uint64, blockNum uint64, difficulty *big.Int, dataset trie.HeaderDataset) (common.Hash, error)
 
 	// Commit writes all nodes to the trie's memory database, tracking the internal
 	// and external (for account tries) references.
@@ -0,0 +1,37 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Prune deletes the Trie-Hashimoto state trie nodes mined before the given
+// block that are not reachable from any of the given state roots, including
+// those of the account storage tries. It returns the number of nodes deleted,
+// see trie.Database.Prune.
+func Prune(db Database, roots []common.Hash, before uint64) (int, error) {
+	return db.TrieDB().Prune(roots, before, func(leaf []byte) []common.Hash {
+		// Storage trie leaves don't decode into accounts
+		var account Account
+		if err := rlp.DecodeBytes(leaf, &account); err != nil || account.Root == emptyRoot {
+			return nil
+		}
+		return []common.Hash{account.Root}
+	})
+}
@@ -26,7 +26,9 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
 )
 
 var emptyCodeHash = crypto.Keccak256(nil)
@@ -277,6 +279,36 @@ func (s *stateObject) updateRoot(db Database) {
 	s.data.Root = s.trie.Hash()
 }
 
+// mineRoot runs Trie-Hashimoto mining over the dirty nodes of the storage trie,
+// sets the mined storage root and returns the trie nonces found.
+func (s *stateObject) mineRoot(config *params.TrieHashimotoConfig, blockNum uint64, difficulty *big.Int, miner *trie.Miner) ([]uint64, error) {
+	// Track the amount of time wasted on hashing the storge trie
+	if metrics.EnabledExpensive {
+		defer func(start time.Time) { s.db.StorageHashes += time.Since(start) }(time.Now())
+	}
+	root, nonces, err := s.trie.HashWithNonce(config, blockNum, difficulty, miner)
+	if err != nil {
+		return nil, err
+	}
+	s.data.Root = root
+	return nonces, nil
+}
+
+// updateRootByNonce replays the trie nonces mined for the storage trie and sets
+// the resulting storage root.
+func (s *stateObject) updateRootByNonce(config *params.TrieHashimotoConfig, trieNonces []uint64, blockNum uint64, difficulty *big.Int) error {
+	// Track the amount of time wasted on hashing the storge trie
+	if metrics.EnabledExpensive {
+		defer func(start time.Time) { s.db.StorageHashes += time.Since(start) }(time.Now())
+	}
+	root, err := s.trie.HashByNonce(config, trieNonces, blockNum, difficulty, s.db.headerDataset)
+	if err != nil {
+		return err
+	}
+	s.data.Root = root
+	return nil
+}
+
 // CommitTrie the storage trie of the object to db.
 // This updates the trie root.
 func (s *stateObject) CommitTrie(db Database) error {
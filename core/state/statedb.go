@@ -18,6 +18,7 @@
 package state
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"math/big"
@@ -29,6 +30,7 @@ import (
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/trie"
 )
@@ -44,6 +46,18 @@ var (
 
 	// emptyCode is the known hash of the empty EVM bytecode.
 	emptyCode = crypto.Keccak256Hash(nil)
+
+	// errUnsortedStorageNonces is returned if the storage trie nonces of a block
+	// are not listed in strictly ascending address order.
+	errUnsortedStorageNonces = errors.New("storage trie nonces not sorted by address")
+
+	// errEmptyStorageNonces is returned if an account is listed without any
+	// storage trie nonces.
+	errEmptyStorageNonces = errors.New("empty storage trie nonces")
+
+	// errUnusedStorageNonces is returned if storage trie nonces are listed for an
+	// account without a dirty storage trie.
+	errUnusedStorageNonces = errors.New("unused storage trie nonces")
 )
 
 type proofList [][]byte
@@ -57,6 +71,17 @@ func (n *proofList) Delete(key []byte) error {
 	panic("not supported")
 }
 
+// hashedProofList is a proofList also keeping the hashes of the proof nodes.
+type hashedProofList struct {
+	proofList
+	hashes []common.Hash
+}
+
+func (n *hashedProofList) Put(key []byte, value []byte) error {
+	n.hashes = append(n.hashes, common.BytesToHash(key))
+	return n.proofList.Put(key, value)
+}
+
 // StateDBs within the ethereum protocol are used to store anything
 // within the merkle trie. StateDBs take care of caching and storing
 // nested states. It's the general query interface to retrieve:
@@ -87,6 +112,9 @@ type StateDB struct {
 
 	preimages map[common.Hash][]byte
 
+	// Header dataset to verify trie nonces against, nil for the default one
+	headerDataset trie.HeaderDataset
+
 	// Journal of state modifications. This is the backbone of
 	// Snapshot and RevertToSnapshot.
 	journal        *journal
@@ -305,6 +333,26 @@ func (self *StateDB) GetStorageProof(a common.Address, key common.Hash) ([][]byt
 	return [][]byte(proof), err
 }
 
+// GetProofNodes returns the Merkle proof for a given account along with the
+// hashes its nodes are referenced by, which are mined under Trie-Hashimoto.
+func (self *StateDB) GetProofNodes(a common.Address) ([]common.Hash, [][]byte, error) {
+	var proof hashedProofList
+	err := self.trie.Prove(crypto.Keccak256(a.Bytes()), 0, &proof)
+	return proof.hashes, [][]byte(proof.proofList), err
+}
+
+// GetStorageProofNodes returns the StorageProof for given key along with the
+// hashes its nodes are referenced by.
+func (self *StateDB) GetStorageProofNodes(a common.Address, key common.Hash) ([]common.Hash, [][]byte, error) {
+	var proof hashedProofList
+	trie := self.StorageTrie(a)
+	if trie == nil {
+		return nil, nil, errors.New("storage trie for requested address does not exist")
+	}
+	err := trie.Prove(crypto.Keccak256(key.Bytes()), 0, &proof)
+	return proof.hashes, [][]byte(proof.proofList), err
+}
+
 // GetCommittedState retrieves a value from the given account's committed storage trie.
 func (self *StateDB) GetCommittedState(addr common.Address, hash common.Hash) common.Hash {
 	stateObject := self.getStateObject(addr)
@@ -557,6 +605,7 @@ func (self *StateDB) Copy() *StateDB {
 		logs:              make(map[common.Hash][]*types.Log, len(self.logs)),
 		logSize:           self.logSize,
 		preimages:         make(map[common.Hash][]byte, len(self.preimages)),
+		headerDataset:     self.headerDataset,
 		journal:           newJournal(),
 	}
 	// Copy the dirty states, logs, and preimages
@@ -662,21 +711,110 @@ func (s *StateDB) IntermediateRoot(deleteEmptyObjects bool) common.Hash {
 	return s.trie.Hash()
 }
 
-// IntermediateRoot computes the current root hash of the state trie.
-// It is called in between transactions to get the root hash that
-// goes into transaction receipts.
-func (s *StateDB) IntermediateRootByNonce(deleteEmptyObjects bool, trieNonces []uint64, blockNum uint64) common.Hash {
+// SetHeaderDataset sets the header dataset the trie nonces replayed by
+// IntermediateRootByNonce are verified against. It must hold the headers before
+// the block, which is only needed for blocks not extending the canonical head.
+func (s *StateDB) SetHeaderDataset(dataset trie.HeaderDataset) {
+	s.headerDataset = dataset
+}
+
+// IntermediateRootByNonce computes the Trie-Hashimoto indexed root hash of the
+// state trie, replaying the nonces mined for the block with the given TH config
+// and trie difficulty.
+// The storage tries are replayed first, in the order of MineStorageTries, then
+// the account trie holding their roots.
+// An error is returned if the nonces are not a valid mining result for the block.
+func (s *StateDB) IntermediateRootByNonce(deleteEmptyObjects bool, config *params.TrieHashimotoConfig, trieNonces []uint64, storageNonces []types.StorageTrieNonces, blockNum uint64, difficulty *big.Int) (common.Hash, error) {
 	s.Finalise(deleteEmptyObjects)
 
+	if err := s.updateStorageRootsByNonce(config, storageNonces, blockNum, difficulty); err != nil {
+		return common.Hash{}, err
+	}
 	// Track the amount of time wasted on hashing the account trie
 	if metrics.EnabledExpensive {
 		defer func(start time.Time) { s.AccountHashes += time.Since(start) }(time.Now())
 	}
-	if len(trieNonces) == 0 {
-		return s.trie.Hash()
-	} else {
-		return s.trie.HashByNonce(trieNonces, blockNum)
+	return s.trie.HashByNonce(config, trieNonces, blockNum, difficulty, s.headerDataset)
+}
+
+// MineStorageTries runs Trie-Hashimoto mining over the dirty storage tries and
+// updates the storage roots of their accounts. It must be called after the state
+// is finalised and before mining the account trie. The nonces are returned per
+// account in ascending address order, leaving out tries without dirty nodes.
+// If the miner is aborted, the returned *trie.MiningAbortedError counts the
+// nodes mined over all storage tries.
+func (s *StateDB) MineStorageTries(config *params.TrieHashimotoConfig, blockNum uint64, difficulty *big.Int, miner *trie.Miner) ([]types.StorageTrieNonces, error) {
+	var (
+		storageNonces []types.StorageTrieNonces
+		mined         int
+	)
+	for _, obj := range s.storageTrieObjects() {
+		nonces, err := obj.mineRoot(config, blockNum, difficulty, miner)
+		if aborted, ok := err.(*trie.MiningAbortedError); ok {
+			return nil, &trie.MiningAbortedError{Number: aborted.Number, Mined: mined + aborted.Mined}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(nonces) > 0 {
+			storageNonces = append(storageNonces, types.StorageTrieNonces{Address: obj.address, Nonces: nonces})
+			mined += len(nonces)
+		}
+		s.updateStateObject(obj)
+	}
+	return storageNonces, nil
+}
+
+// updateStorageRootsByNonce replays the storage trie nonces of a block over the
+// dirty storage tries in the order of MineStorageTries. Tries without nonces
+// listed must not have any dirty nodes.
+func (s *StateDB) updateStorageRootsByNonce(config *params.TrieHashimotoConfig, storageNonces []types.StorageTrieNonces, blockNum uint64, difficulty *big.Int) error {
+	listed := make(map[common.Address][]uint64, len(storageNonces))
+	for i, sn := range storageNonces {
+		if i > 0 && bytes.Compare(storageNonces[i-1].Address[:], sn.Address[:]) >= 0 {
+			return errUnsortedStorageNonces
+		}
+		if len(sn.Nonces) == 0 {
+			return errEmptyStorageNonces
+		}
+		listed[sn.Address] = sn.Nonces
+	}
+	for _, obj := range s.storageTrieObjects() {
+		nonces, ok := listed[obj.address]
+		if !ok {
+			nonces = []uint64{}
+		}
+		delete(listed, obj.address)
+
+		if err := obj.updateRootByNonce(config, nonces, blockNum, difficulty); err != nil {
+			return fmt.Errorf("storage trie of %x: %v", obj.address, err)
+		}
+		s.updateStateObject(obj)
+	}
+	if len(listed) != 0 {
+		return errUnusedStorageNonces
+	}
+	return nil
+}
+
+// storageTrieObjects returns the dirty, live state objects with an open storage
+// trie in ascending address order, the order storage tries are mined and
+// replayed in.
+func (s *StateDB) storageTrieObjects() []*stateObject {
+	addrs := make([]common.Address, 0, len(s.stateObjectsDirty))
+	for addr := range s.stateObjectsDirty {
+		if obj := s.stateObjects[addr]; obj != nil && !obj.deleted && obj.trie != nil {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return bytes.Compare(addrs[i][:], addrs[j][:]) < 0
+	})
+	objs := make([]*stateObject, len(addrs))
+	for i, addr := range addrs {
+		objs[i] = s.stateObjects[addr]
 	}
+	return objs
 }
 
 // Prepare sets the current transaction hash and index and block hash which is
@@ -24,6 +24,7 @@ import (
 	"math/big"
 	"math/rand"
 	"reflect"
+	"runtime"
 	"strings"
 	"testing"
 	"testing/quick"
@@ -33,6 +34,8 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/trie"
 )
 
 // Tests that updating a state trie does not leak any database writes prior to
@@ -449,3 +452,100 @@ func TestCopyOfCopy(t *testing.T) {
 		t.Fatalf("2nd copy fail, expected 42, got %v", got)
 	}
 }
+
+// Tests that the storage trie nonces mined for a block are replayed by the
+// verifier in ascending address order, and that tampered lists are rejected.
+func TestStorageTrieNonces(t *testing.T) {
+	var (
+		config = &params.TrieHashimotoConfig{PrefixLength: 2, LoopAccesses: 1}
+		addrA  = common.BytesToAddress([]byte{0x01})
+		addrB  = common.BytesToAddress([]byte{0x02})
+		addrC  = common.BytesToAddress([]byte{0x03})
+	)
+	// newState creates a state with the same changes applied in the given order
+	newState := func(reverse bool) *StateDB {
+		state, _ := New(common.Hash{}, NewDatabase(rawdb.NewMemoryDatabase()))
+		updates := []func(){
+			func() { state.SetState(addrA, common.Hash{0x0a}, common.Hash{0x01}) },
+			func() { state.SetState(addrB, common.Hash{0x0b}, common.Hash{0x02}) },
+			func() { state.AddBalance(addrC, big.NewInt(3)) },
+		}
+		for i := range updates {
+			if reverse {
+				i = len(updates) - 1 - i
+			}
+			updates[i]()
+		}
+		return state
+	}
+	miner := newState(false)
+	miner.IntermediateRoot(false)
+	storageNonces, _ := miner.MineStorageTries(config, 1, nil, &trie.Miner{Threads: 1})
+	root, trieNonces, _ := miner.trie.HashWithNonce(config, 1, nil, &trie.Miner{Threads: 1})
+
+	if len(storageNonces) != 2 || storageNonces[0].Address != addrA || storageNonces[1].Address != addrB {
+		t.Fatalf("storage trie nonces mismatch: have %v, want nonces for %x and %x", storageNonces, addrA, addrB)
+	}
+	have, err := newState(true).IntermediateRootByNonce(false, config, trieNonces, storageNonces, 1, nil)
+	if err != nil {
+		t.Fatalf("failed to replay storage trie nonces: %v", err)
+	}
+	if have != root {
+		t.Fatalf("state root mismatch: have %x, want %x", have, root)
+	}
+	invalid := map[string][]types.StorageTrieNonces{
+		"unsorted": {storageNonces[1], storageNonces[0]},
+		"missing":  storageNonces[:1],
+		"unused":   append(append([]types.StorageTrieNonces{}, storageNonces...), types.StorageTrieNonces{Address: addrC, Nonces: []uint64{0}}),
+		"empty":    {storageNonces[0], {Address: addrB}},
+	}
+	for name, list := range invalid {
+		if _, err := newState(false).IntermediateRootByNonce(false, config, trieNonces, list, 1, nil); err == nil {
+			t.Errorf("%s: tampered storage trie nonces accepted", name)
+		}
+	}
+}
+
+// Benchmarks Trie-Hashimoto mining of the account and storage tries of a state,
+// one node at a time and with independent subtrees mined concurrently.
+func BenchmarkMineState(b *testing.B) {
+	threads := []int{1}
+	if cpus := runtime.NumCPU(); cpus > 1 {
+		threads = append(threads, cpus)
+	}
+	for _, threads := range threads {
+		b.Run(fmt.Sprintf("sequential/threads-%d", threads), func(b *testing.B) {
+			benchmarkMineState(b, &trie.Miner{Threads: threads, Sequential: true})
+		})
+		b.Run(fmt.Sprintf("concurrent/threads-%d", threads), func(b *testing.B) {
+			benchmarkMineState(b, &trie.Miner{Threads: threads})
+		})
+	}
+}
+
+func benchmarkMineState(b *testing.B, miner *trie.Miner) {
+	config := &params.TrieHashimotoConfig{PrefixLength: 1, LoopAccesses: 1}
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		state, _ := New(common.Hash{}, NewDatabase(rawdb.NewMemoryDatabase()))
+		for j := 0; j < 1000; j++ {
+			addr := common.BigToAddress(big.NewInt(int64(j)))
+			state.AddBalance(addr, big.NewInt(int64(j+1)))
+			if j%10 == 0 {
+				for k := 0; k < 10; k++ {
+					state.SetState(addr, common.BigToHash(big.NewInt(int64(k))), common.Hash{0x01})
+				}
+			}
+		}
+		state.IntermediateRoot(false)
+		b.StartTimer()
+
+		if _, err := state.MineStorageTries(config, 1, nil, miner); err != nil {
+			b.Fatalf("failed to mine storage tries: %v", err)
+		}
+		if _, _, err := state.trie.HashWithNonce(config, 1, nil, miner); err != nil {
+			b.Fatalf("failed to mine account trie: %v", err)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package thdataset
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// Ancestry is a read-only header dataset along the ancestry of a block which may
+// not extend the canonical chain, so that the trie node nonces of side chain
+// blocks are verified against the headers they were mined with. The headers
+// shared with the canonical chain are read from its dataset, those of the side
+// chain are read from the database once and kept in memory.
+type Ancestry struct {
+	base  trie.HeaderDataset // Header dataset of the canonical chain
+	fork  uint64             // Number of the first side chain header
+	start uint64             // Number of words of the headers shared with the canonical chain
+	ends  []uint64           // End of every side chain header in words
+	words []uint32           // Words of the side chain headers
+}
+
+// newAncestry creates the header dataset along the ancestry of the block with the
+// given parent, over the dataset of the canonical chain holding the given number
+// of headers and word limit. Side chain headers are read from the database.
+func newAncestry(base trie.HeaderDataset, blocks uint64, limit uint32, db ethdb.Reader, parent common.Hash, number uint64) (*Ancestry, error) {
+	// Collect the side chain headers down to the canonical chain
+	var headers [][]byte
+	for number >= blocks || rawdb.ReadCanonicalHash(db, number) != parent {
+		blob := rawdb.ReadHeaderRLP(db, parent, number)
+		if len(blob) == 0 {
+			return nil, fmt.Errorf("missing header #%d [%x]", number, parent[:4])
+		}
+		if number == 0 {
+			return nil, fmt.Errorf("unknown genesis [%x]", parent[:4])
+		}
+		header := new(types.Header)
+		if err := rlp.DecodeBytes(blob, header); err != nil {
+			return nil, fmt.Errorf("invalid header #%d [%x]: %v", number, parent[:4], err)
+		}
+		headers = append(headers, blob)
+		parent, number = header.ParentHash, number-1
+	}
+	a := &Ancestry{base: base, fork: number + 1}
+	a.start = base.Size(a.fork)
+
+	// Lay out the side chain headers after the shared ones, up to the word limit
+	end := a.start
+	for i := len(headers) - 1; i >= 0; i-- {
+		words := uint64(len(headers[i]) / 4)
+		if limit != 0 && end+words > uint64(limit) {
+			words = 0
+			if end < uint64(limit) {
+				words = uint64(limit) - end
+			}
+		}
+		for j := uint64(0); j < words; j++ {
+			a.words = append(a.words, binary.LittleEndian.Uint32(headers[i][4*j:]))
+		}
+		end += words
+		a.ends = append(a.ends, end)
+	}
+	return a, nil
+}
+
+// end returns the number of words in the dataset.
+func (a *Ancestry) end() uint64 {
+	if len(a.ends) == 0 {
+		return a.start
+	}
+	return a.ends[len(a.ends)-1]
+}
+
+// Size returns the number of words in the dataset the trie nodes of the given
+// block were mined with, i.e. those of the headers of all its ancestors.
+func (a *Ancestry) Size(number uint64) uint64 {
+	switch {
+	case number <= a.fork:
+		return a.base.Size(number)
+	case number-a.fork > uint64(len(a.ends)):
+		return a.end()
+	default:
+		return a.ends[number-a.fork-1]
+	}
+}
+
+// Lookup returns the 16 words of the dataset row at the given index. Words past
+// the dataset are zero.
+func (a *Ancestry) Lookup(index uint32) []uint32 {
+	offset := uint64(index) * rowWords
+	if offset+rowWords <= a.start {
+		return a.base.Lookup(index)
+	}
+	row := make([]uint32, rowWords)
+	if offset < a.start {
+		copy(row, a.base.Lookup(index)[:a.start-offset])
+	}
+	for i := range row {
+		if pos := offset + uint64(i); pos >= a.start && pos < a.end() {
+			row[i] = a.words[pos-a.start]
+		}
+	}
+	return row
+}
@@ -0,0 +1,101 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package thdataset
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// writeTestSideChain stores n dummy headers forking off the canonical header of
+// the given number, without making them canonical.
+func writeTestSideChain(db ethdb.Database, number uint64, n int) []*types.Header {
+	parent := rawdb.ReadCanonicalHash(db, number)
+	headers := make([]*types.Header, n)
+	for i := range headers {
+		headers[i] = &types.Header{ParentHash: parent, Number: new(big.Int).SetUint64(number + uint64(i) + 1), Difficulty: big.NewInt(131071), Extra: []byte{0x03}}
+		rawdb.WriteHeader(db, headers[i])
+		parent = headers[i].Hash()
+	}
+	return headers
+}
+
+// Tests that the header dataset along a side chain is laid out as if the side
+// chain was canonical.
+func TestAncestry(t *testing.T) {
+	for _, limit := range []uint32{0, 300, 5300} {
+		db := rawdb.NewMemoryDatabase()
+		writeTestChain(db, 64, 0x01)
+		side := writeTestSideChain(db, 40, 10)
+
+		// Build the dataset the side chain would have if it was canonical
+		want, _ := New("", limit)
+		for number, header := range readHeaders(db, 41) {
+			want.Append(uint64(number), crypto.Keccak256Hash(header), header)
+		}
+		for _, header := range side {
+			want.Append(header.Number.Uint64(), header.Hash(), rawdb.ReadHeaderRLP(db, header.Hash(), header.Number.Uint64()))
+		}
+		full, _ := New("", limit)
+		if err := full.Generate(db, 63); err != nil {
+			t.Fatalf("limit %d: failed to generate full dataset: %v", limit, err)
+		}
+		light := NewLight(db, limit)
+		if err := light.Generate(db, 63); err != nil {
+			t.Fatalf("limit %d: failed to generate light dataset: %v", limit, err)
+		}
+		head := side[len(side)-1]
+		for _, base := range []interface {
+			trie.HeaderDataset
+			Ancestry(ethdb.Reader, common.Hash, uint64) (*Ancestry, error)
+		}{full, light} {
+			ancestry, err := base.Ancestry(db, head.Hash(), head.Number.Uint64())
+			if err != nil {
+				t.Fatalf("limit %d: failed to create side chain dataset: %v", limit, err)
+			}
+			for number := uint64(0); number <= want.Blocks()+1; number++ {
+				if have, want := ancestry.Size(number), want.Size(number); have != want {
+					t.Fatalf("limit %d: block %d size mismatch: have %d, want %d", limit, number, have, want)
+				}
+			}
+			for index := uint32(0); uint64(index)*rowWords <= uint64(len(want.Words()))+rowWords; index++ {
+				if have, want := ancestry.Lookup(index), want.Lookup(index); !reflect.DeepEqual(have, want) {
+					t.Fatalf("limit %d: row %d mismatch: have %x, want %x", limit, index, have, want)
+				}
+			}
+			// A canonical parent needs no side chain headers
+			ancestry, err = base.Ancestry(db, rawdb.ReadCanonicalHash(db, 63), 63)
+			if err != nil {
+				t.Fatalf("limit %d: failed to create canonical dataset: %v", limit, err)
+			}
+			if have, want := ancestry.Size(64), base.Size(64); have != want {
+				t.Fatalf("limit %d: canonical size mismatch: have %d, want %d", limit, have, want)
+			}
+			if _, err := base.Ancestry(db, common.Hash{1}, 50); err == nil {
+				t.Fatalf("limit %d: dataset created along an unknown ancestry", limit)
+			}
+		}
+	}
+}
@@ -0,0 +1,621 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package thdataset implements the Trie-Hashimoto header dataset, the RLP
+// encodings of all canonical block headers concatenated as little endian uint32
+// words, which trie node mining reads from for memory hardness.
+//
+// The dataset is kept in a memory mapped file next to the chain database, much
+// like the ethash DAGs, and is extended as blocks are inserted instead of being
+// rebuilt from the database at every start. A per block index file records the
+// end of every header in the dataset together with a running CRC32 checksum of
+// the data, which is verified when the dataset is opened, and which allows the
+// dataset to be rewound to any earlier block on chain reorganisations. The index
+// starts with the hashes of the genesis and the last block in the dataset, so a
+// dataset left over from another chain is regenerated instead of reused.
+//
+// Verifiers may use a Light dataset instead, which only tracks the header
+// boundaries and reads the rows a nonce accesses from the database.
+package thdataset
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+	"unsafe"
+
+	mmap "github.com/edsrzf/mmap-go"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+const (
+	// datasetRevision is the data structure version used for file naming.
+	datasetRevision = 2
+
+	// headerWords is the number of uint32 words preceding the data in the file:
+	// the two magic words, the word limit and the revision.
+	headerWords = 4
+
+	// indexHeaderSize is the size of the index file header preceding the per
+	// block entries: the genesis hash and the hash of the last block.
+	indexHeaderSize = 2 * common.HashLength
+
+	// indexEntrySize is the size of a per block index entry: the end of the block
+	// header in the dataset as uint64 words and the running checksum as uint32,
+	// padded to 16 bytes.
+	indexEntrySize = 16
+
+	// minGrowth is the minimum number of words the dataset file grows by.
+	minGrowth = 1 << 20
+
+	// verifyChunk is the number of words checksummed at once on opening.
+	verifyChunk = 1 << 14
+)
+
+var (
+	// dumpMagic is a dataset dump header to sanity check a data dump.
+	dumpMagic = []uint32{0xbaddcafe, 0x7e1ec0de}
+
+	// crcTable is the checksum table of the running dataset checksum.
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+	// ErrNonContiguous is returned if a header is appended out of order.
+	ErrNonContiguous = errors.New("non-contiguous header dataset append")
+
+	// errCorruptDataset is returned if the dataset files on disk are inconsistent.
+	errCorruptDataset = errors.New("corrupt header dataset")
+)
+
+// Dataset is the Trie-Hashimoto header dataset of a chain. It is safe for
+// concurrent use.
+type Dataset struct {
+	path  string // Path of the data file, empty for an in-memory dataset
+	limit uint32 // Maximum number of words in the dataset, 0 for unbounded
+
+	lock   sync.RWMutex
+	dump   *os.File    // Data file, nil for an in-memory dataset
+	index  *os.File    // Per block index file, nil for an in-memory dataset
+	memidx []byte      // Per block index of an in-memory dataset
+	mmaps  []mmap.MMap // Data file mappings, older ones stay valid for readers until closed
+	buffer []uint32    // Writable view of the whole data region
+	words  uint64      // Number of words in use
+	blocks uint64      // Number of headers appended
+	crc    uint32      // Running checksum of the words in use
+
+	genesis common.Hash // Hash of the genesis block, zero if the dataset is empty
+	last    common.Hash // Hash of the last block in the dataset, zero if empty
+}
+
+// New opens the header dataset stored in dir, creating it if it doesn't exist
+// yet. A dataset failing its checksum is discarded and started over. If dir is
+// empty, the dataset is kept in memory only.
+func New(dir string, limit uint32) (*Dataset, error) {
+	d := &Dataset{limit: limit}
+	if dir == "" {
+		return d, nil
+	}
+	if !isLittleEndian() {
+		return nil, errors.New("header dataset files require a little endian system")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	// Drop the files of earlier revisions, they can't be tied to a chain
+	for revision := 1; revision < datasetRevision; revision++ {
+		path := filepath.Join(dir, fmt.Sprintf("headers-R%d", revision))
+		os.Remove(path)
+		os.Remove(path + ".index")
+	}
+	d.path = filepath.Join(dir, fmt.Sprintf("headers-R%d", datasetRevision))
+
+	err := d.open()
+	switch {
+	case err == nil:
+		log.Info("Opened Trie-Hashimoto header dataset", "path", d.path, "blocks", d.blocks, "words", d.words)
+		return d, nil
+	case !os.IsNotExist(err):
+		log.Warn("Discarding Trie-Hashimoto header dataset", "path", d.path, "err", err)
+		d.closeFiles()
+	}
+	if err := d.create(); err != nil {
+		d.closeFiles()
+		return nil, err
+	}
+	return d, nil
+}
+
+// open memory maps an existing dataset and verifies its checksum.
+func (d *Dataset) open() error {
+	dump, err := os.OpenFile(d.path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	d.dump = dump
+	if d.index, err = os.OpenFile(d.path+".index", os.O_RDWR, 0644); err != nil {
+		if os.IsNotExist(err) {
+			return errCorruptDataset
+		}
+		return err
+	}
+	stat, err := d.dump.Stat()
+	if err != nil {
+		return err
+	}
+	if stat.Size() < headerWords*4 || stat.Size()%4 != 0 {
+		return errCorruptDataset
+	}
+	if err := d.remap(); err != nil {
+		return err
+	}
+	header := d.buffer[:headerWords]
+	if header[0] != dumpMagic[0] || header[1] != dumpMagic[1] {
+		return errCorruptDataset
+	}
+	if header[2] != d.limit || header[3] != datasetRevision {
+		return fmt.Errorf("dataset parameters changed: limit %d, revision %d", header[2], header[3])
+	}
+	d.buffer = d.buffer[headerWords:]
+
+	// Restore the chain the dataset was taken from, its size and checksum from
+	// the index
+	if stat, err = d.index.Stat(); err != nil {
+		return err
+	}
+	if stat.Size() < indexHeaderSize || (stat.Size()-indexHeaderSize)%indexEntrySize != 0 {
+		return errCorruptDataset
+	}
+	var anchors [indexHeaderSize]byte
+	if _, err := d.index.ReadAt(anchors[:], 0); err != nil {
+		return err
+	}
+	d.genesis = common.BytesToHash(anchors[:common.HashLength])
+	d.last = common.BytesToHash(anchors[common.HashLength:])
+
+	if d.blocks = uint64((stat.Size() - indexHeaderSize) / indexEntrySize); d.blocks > 0 {
+		if d.words, d.crc, err = d.readIndex(d.blocks - 1); err != nil {
+			return err
+		}
+	}
+	if d.words > uint64(len(d.buffer)) {
+		return errCorruptDataset
+	}
+	if crc := checksum(0, d.buffer[:d.words]); crc != d.crc {
+		return fmt.Errorf("checksum mismatch: have %08x, want %08x", crc, d.crc)
+	}
+	return nil
+}
+
+// create starts a new, empty dataset file and index.
+func (d *Dataset) create() error {
+	dump, err := os.Create(d.path)
+	if err != nil {
+		return err
+	}
+	d.dump = dump
+	if d.index, err = os.Create(d.path + ".index"); err != nil {
+		return err
+	}
+	if err := d.index.Truncate(indexHeaderSize); err != nil {
+		return err
+	}
+	if err := d.dump.Truncate(int64(headerWords+minGrowth) * 4); err != nil {
+		return err
+	}
+	if err := d.remap(); err != nil {
+		return err
+	}
+	copy(d.buffer, dumpMagic)
+	d.buffer[2], d.buffer[3] = d.limit, datasetRevision
+	d.buffer = d.buffer[headerWords:]
+	d.words, d.blocks, d.crc = 0, 0, 0
+	d.genesis, d.last = common.Hash{}, common.Hash{}
+
+	log.Info("Created Trie-Hashimoto header dataset", "path", d.path)
+	return nil
+}
+
+// remap memory maps the whole data file, keeping any earlier mapping alive so
+// views handed out before stay valid.
+func (d *Dataset) remap() error {
+	mem, err := mmap.Map(d.dump, mmap.RDWR, 0)
+	if err != nil {
+		return err
+	}
+	d.mmaps = append(d.mmaps, mem)
+
+	var buffer []uint32
+	header := (*reflect.SliceHeader)(unsafe.Pointer(&buffer))
+	header.Data = uintptr(unsafe.Pointer(&mem[0]))
+	header.Len = len(mem) / 4
+	header.Cap = len(mem) / 4
+
+	d.buffer = buffer
+	return nil
+}
+
+// grow extends the data region to hold at least the given number of words.
+func (d *Dataset) grow(words uint64) error {
+	size := 2 * uint64(len(d.buffer))
+	if size < words {
+		size = words
+	}
+	if size < minGrowth {
+		size = minGrowth
+	}
+	if d.dump == nil {
+		buffer := make([]uint32, size)
+		copy(buffer, d.buffer[:d.words])
+		d.buffer = buffer
+		return nil
+	}
+	if err := d.dump.Truncate(int64(headerWords+size) * 4); err != nil {
+		return err
+	}
+	if err := d.remap(); err != nil {
+		return err
+	}
+	d.buffer = d.buffer[headerWords:]
+	return nil
+}
+
+// Blocks returns the number of headers in the dataset, i.e. the number of the
+// next block to append.
+func (d *Dataset) Blocks() uint64 {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	return d.blocks
+}
+
+// Words returns a view of the dataset. The view is not affected by later
+// appends and stays valid until the dataset is closed, but its contents change
+// if the dataset is truncated below its length and extended again.
+func (d *Dataset) Words() []uint32 {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	return d.buffer[:d.words:d.words]
+}
+
+// Size returns the number of words in the dataset the trie nodes of the given
+// block were mined with, i.e. those of the headers of all blocks before it.
+func (d *Dataset) Size(number uint64) uint64 {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	switch {
+	case number == 0 || d.blocks == 0:
+		return 0
+	case number >= d.blocks:
+		return d.words
+	}
+	words, _, err := d.readIndex(number - 1)
+	if err != nil {
+		log.Error("Header dataset index unavailable", "number", number-1, "err", err)
+		return 0
+	}
+	return words
+}
+
+// Lookup returns the 16 words of the dataset row at the given index. Words past
+// the dataset are zero.
+func (d *Dataset) Lookup(index uint32) []uint32 {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	row := make([]uint32, rowWords)
+	if offset := uint64(index) * rowWords; offset < d.words {
+		copy(row, d.buffer[offset:d.words])
+	}
+	return row
+}
+
+// anchors returns the hashes of the genesis and the last block in the dataset,
+// tying it to the chain its headers were taken from.
+func (d *Dataset) anchors() (common.Hash, common.Hash) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	return d.genesis, d.last
+}
+
+// Ancestry returns the header dataset along the ancestry of the block with the
+// given parent, which may have left the canonical chain.
+func (d *Dataset) Ancestry(db ethdb.Reader, parent common.Hash, number uint64) (*Ancestry, error) {
+	return newAncestry(d, d.Blocks(), d.limit, db, parent, number)
+}
+
+// full returns whether the dataset reached its word limit.
+func (d *Dataset) full() bool {
+	return d.limit != 0 && d.words >= uint64(d.limit)
+}
+
+// Append adds the RLP encoding of the header of the next block, with the given
+// hash, to the dataset. Trailing bytes not filling a whole word are dropped, as
+// is anything past the word limit.
+func (d *Dataset) Append(number uint64, hash common.Hash, header []byte) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if number != d.blocks {
+		return fmt.Errorf("%v: have block %d, want %d", ErrNonContiguous, number, d.blocks)
+	}
+	words := uint64(len(header) / 4)
+	if d.limit != 0 && d.words+words > uint64(d.limit) {
+		words = 0
+		if !d.full() {
+			words = uint64(d.limit) - d.words
+		}
+	}
+	if d.words+words > uint64(len(d.buffer)) {
+		if err := d.grow(d.words + words); err != nil {
+			return err
+		}
+	}
+	for i := uint64(0); i < words; i++ {
+		d.buffer[d.words+i] = binary.LittleEndian.Uint32(header[4*i:])
+	}
+	d.crc = crc32.Update(d.crc, crcTable, header[:4*words])
+	d.words += words
+	d.blocks++
+
+	if err := d.writeIndex(d.blocks-1, d.words, d.crc); err != nil {
+		return err
+	}
+	genesis := d.genesis
+	if number == 0 {
+		genesis = hash
+	}
+	return d.writeAnchors(genesis, hash)
+}
+
+// Generate extends the dataset with the canonical headers stored in the
+// database up to and including head. A dataset ahead of the database is
+// truncated to head first, one taken from another chain is started over.
+func (d *Dataset) Generate(db ethdb.Reader, head uint64) error {
+	return generate(d, db, head)
+}
+
+// filled returns whether the dataset reached its word limit.
+func (d *Dataset) filled() bool {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	return d.full()
+}
+
+// appender is a header dataset extended block by block.
+type appender interface {
+	Blocks() uint64
+	Append(number uint64, hash common.Hash, header []byte) error
+	Truncate(blocks uint64, last common.Hash) error
+	filled() bool
+}
+
+// anchored is a header dataset kept across restarts, which records the chain
+// its headers were taken from.
+type anchored interface {
+	anchors() (genesis common.Hash, last common.Hash)
+}
+
+// generate extends a dataset with the canonical headers stored in the database
+// up to and including head, truncating it to head first if it's ahead. A kept
+// dataset whose headers weren't taken from the canonical chain is started over.
+func generate(d appender, db ethdb.Reader, head uint64) error {
+	if a, ok := d.(anchored); ok && d.Blocks() > 0 {
+		if genesis, last := a.anchors(); !onChain(db, genesis, last, d.Blocks()-1, head) {
+			log.Warn("Header dataset not on canonical chain, regenerating", "blocks", d.Blocks(), "last", last)
+			if err := d.Truncate(0, common.Hash{}); err != nil {
+				return err
+			}
+		}
+	}
+	if blocks := d.Blocks(); blocks > head+1 {
+		log.Warn("Header dataset ahead of chain, truncating", "blocks", blocks, "head", head)
+		if err := d.Truncate(head+1, rawdb.ReadCanonicalHash(db, head)); err != nil {
+			return err
+		}
+	}
+	var (
+		start  = time.Now()
+		logged = time.Now()
+		first  = d.Blocks()
+	)
+	for number := first; number <= head; number++ {
+		hash := rawdb.ReadCanonicalHash(db, number)
+		if hash == (common.Hash{}) {
+			return fmt.Errorf("missing canonical header #%d", number)
+		}
+		// Headers past the word limit only need to be counted
+		var header []byte
+		if !d.filled() {
+			if header = rawdb.ReadHeaderRLP(db, hash, number); len(header) == 0 {
+				return fmt.Errorf("missing canonical header #%d", number)
+			}
+		}
+		if err := d.Append(number, hash, header); err != nil {
+			return err
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Generating Trie-Hashimoto header dataset", "number", number, "head", head, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if head+1 > first {
+		log.Info("Generated Trie-Hashimoto header dataset", "blocks", head+1-first, "elapsed", common.PrettyDuration(time.Since(start)))
+	}
+	return nil
+}
+
+// onChain reports whether a dataset of the headers up to the given last block
+// was taken from the canonical chain in the database. A dataset ahead of the
+// head is followed back to it by parent hashes.
+func onChain(db ethdb.Reader, genesis, last common.Hash, number, head uint64) bool {
+	if genesis != rawdb.ReadCanonicalHash(db, 0) {
+		return false
+	}
+	for ; number > head; number-- {
+		header := rawdb.ReadHeader(db, last, number)
+		if header == nil {
+			return false
+		}
+		last = header.ParentHash
+	}
+	return last == rawdb.ReadCanonicalHash(db, number)
+}
+
+// Truncate drops the headers of all blocks from the given number on, so the
+// dataset can be extended with the headers of a different chain. The hash is
+// the one of the block before, the last one left in the dataset.
+func (d *Dataset) Truncate(blocks uint64, last common.Hash) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if blocks >= d.blocks {
+		return nil
+	}
+	var (
+		words uint64
+		crc   uint32
+		err   error
+	)
+	if blocks > 0 {
+		if words, crc, err = d.readIndex(blocks - 1); err != nil {
+			return err
+		}
+	}
+	if d.index != nil {
+		if err := d.index.Truncate(indexHeaderSize + int64(blocks*indexEntrySize)); err != nil {
+			return err
+		}
+	} else {
+		d.memidx = d.memidx[:blocks*indexEntrySize]
+	}
+	d.words, d.blocks, d.crc = words, blocks, crc
+
+	if blocks == 0 {
+		return d.writeAnchors(common.Hash{}, common.Hash{})
+	}
+	return d.writeAnchors(d.genesis, last)
+}
+
+// readIndex retrieves the dataset size and checksum after the given block.
+func (d *Dataset) readIndex(number uint64) (uint64, uint32, error) {
+	var entry [indexEntrySize]byte
+	if d.index == nil {
+		copy(entry[:], d.memidx[number*indexEntrySize:])
+	} else if _, err := d.index.ReadAt(entry[:], indexHeaderSize+int64(number*indexEntrySize)); err != nil {
+		return 0, 0, err
+	}
+	return binary.LittleEndian.Uint64(entry[:8]), binary.LittleEndian.Uint32(entry[8:12]), nil
+}
+
+// writeIndex stores the dataset size and checksum after the given block.
+func (d *Dataset) writeIndex(number uint64, words uint64, crc uint32) error {
+	var entry [indexEntrySize]byte
+	binary.LittleEndian.PutUint64(entry[:8], words)
+	binary.LittleEndian.PutUint32(entry[8:12], crc)
+
+	if d.index == nil {
+		d.memidx = append(d.memidx[:number*indexEntrySize], entry[:]...)
+		return nil
+	}
+	_, err := d.index.WriteAt(entry[:], indexHeaderSize+int64(number*indexEntrySize))
+	return err
+}
+
+// writeAnchors stores the hashes of the genesis and the last block in the
+// dataset.
+func (d *Dataset) writeAnchors(genesis, last common.Hash) error {
+	d.genesis, d.last = genesis, last
+	if d.index == nil {
+		return nil
+	}
+	_, err := d.index.WriteAt(append(genesis.Bytes(), last.Bytes()...), 0)
+	return err
+}
+
+// Close flushes the dataset to disk and releases its files. Views returned by
+// Words must not be used afterwards.
+func (d *Dataset) Close() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	var err error
+	if len(d.mmaps) > 0 {
+		err = d.mmaps[len(d.mmaps)-1].Flush()
+	}
+	if cerr := d.closeFiles(); err == nil {
+		err = cerr
+	}
+	d.buffer, d.memidx, d.words, d.blocks, d.crc = nil, nil, 0, 0, 0
+	d.genesis, d.last = common.Hash{}, common.Hash{}
+	return err
+}
+
+// closeFiles unmaps and closes the dataset files, if any.
+func (d *Dataset) closeFiles() error {
+	var err error
+	for _, mem := range d.mmaps {
+		if uerr := mem.Unmap(); err == nil {
+			err = uerr
+		}
+	}
+	d.mmaps = nil
+	for _, file := range []*os.File{d.dump, d.index} {
+		if file == nil {
+			continue
+		}
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	d.dump, d.index = nil, nil
+	return err
+}
+
+// checksum extends a running checksum with the little endian encoding of words.
+func checksum(crc uint32, words []uint32) uint32 {
+	buf := make([]byte, 4*verifyChunk)
+	for len(words) > 0 {
+		n := len(words)
+		if n > verifyChunk {
+			n = verifyChunk
+		}
+		for i, word := range words[:n] {
+			binary.LittleEndian.PutUint32(buf[4*i:], word)
+		}
+		crc = crc32.Update(crc, crcTable, buf[:4*n])
+		words = words[n:]
+	}
+	return crc
+}
+
+// isLittleEndian returns whether the local system is running in little or big
+// endian byte order.
+func isLittleEndian() bool {
+	n := uint32(0x01020304)
+	return *(*byte)(unsafe.Pointer(&n)) == 0x04
+}
@@ -0,0 +1,302 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package thdataset
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// testHeaders creates a chain of n dummy headers and returns their encodings.
+func testHeaders(t *testing.T, n int) [][]byte {
+	headers := make([][]byte, n)
+	for i := range headers {
+		header := &types.Header{Number: big.NewInt(int64(i)), Difficulty: big.NewInt(131072), Extra: make([]byte, i%7)}
+		blob, err := rlp.EncodeToBytes(header)
+		if err != nil {
+			t.Fatalf("failed to encode header %d: %v", i, err)
+		}
+		headers[i] = blob
+	}
+	return headers
+}
+
+// expectedWords concatenates the header encodings into the expected dataset.
+func expectedWords(headers [][]byte, limit uint32) []uint32 {
+	words := []uint32{}
+	for _, header := range headers {
+		for i := 0; i+4 <= len(header); i += 4 {
+			words = append(words, binary.LittleEndian.Uint32(header[i:]))
+		}
+	}
+	if limit != 0 && uint32(len(words)) > limit {
+		words = words[:limit]
+	}
+	return words
+}
+
+func appendHeaders(t *testing.T, d *Dataset, headers [][]byte) {
+	for _, header := range headers {
+		if err := d.Append(d.Blocks(), crypto.Keccak256Hash(header), header); err != nil {
+			t.Fatalf("failed to append header %d: %v", d.Blocks(), err)
+		}
+	}
+}
+
+// Tests that appended headers survive reopening the dataset.
+func TestDatasetPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "thdataset-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	headers := testHeaders(t, 64)
+
+	d, err := New(dir, 0)
+	if err != nil {
+		t.Fatalf("failed to create dataset: %v", err)
+	}
+	appendHeaders(t, d, headers[:32])
+	if err := d.Append(40, crypto.Keccak256Hash(headers[40]), headers[40]); err == nil {
+		t.Fatalf("non-contiguous append succeeded")
+	}
+	if err := d.Close(); err != nil {
+		t.Fatalf("failed to close dataset: %v", err)
+	}
+	if d, err = New(dir, 0); err != nil {
+		t.Fatalf("failed to reopen dataset: %v", err)
+	}
+	if blocks := d.Blocks(); blocks != 32 {
+		t.Fatalf("block count mismatch after reopen: have %d, want %d", blocks, 32)
+	}
+	appendHeaders(t, d, headers[32:])
+	if words := d.Words(); !reflect.DeepEqual(words, expectedWords(headers, 0)) {
+		t.Fatalf("dataset mismatch: have %d words, want %d", len(words), len(expectedWords(headers, 0)))
+	}
+	d.Close()
+}
+
+// Tests that a dataset with corrupted data is discarded on opening.
+func TestDatasetCorruption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "thdataset-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d, err := New(dir, 0)
+	if err != nil {
+		t.Fatalf("failed to create dataset: %v", err)
+	}
+	appendHeaders(t, d, testHeaders(t, 16))
+	path := d.path
+	d.Close()
+
+	// Flip a bit in the first data word
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var word [1]byte
+	if _, err := file.ReadAt(word[:], headerWords*4); err != nil {
+		t.Fatal(err)
+	}
+	word[0] ^= 0x01
+	if _, err := file.WriteAt(word[:], headerWords*4); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	if d, err = New(dir, 0); err != nil {
+		t.Fatalf("failed to reopen dataset: %v", err)
+	}
+	defer d.Close()
+	if blocks, words := d.Blocks(), len(d.Words()); blocks != 0 || words != 0 {
+		t.Fatalf("corrupt dataset not discarded: %d blocks, %d words", blocks, words)
+	}
+}
+
+// Tests that truncated datasets can be extended with the headers of another
+// chain, both in memory and on disk.
+func TestDatasetTruncate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "thdataset-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	testDatasetTruncate(t, "")
+	testDatasetTruncate(t, dir)
+}
+
+func testDatasetTruncate(t *testing.T, dir string) {
+	var (
+		headers = testHeaders(t, 32)
+		fork    = testHeaders(t, 40)
+	)
+	for i := 10; i < len(fork); i++ {
+		fork[i] = append(fork[i], 0xde, 0xad, 0xbe, 0xef)
+	}
+	d, err := New(dir, 0)
+	if err != nil {
+		t.Fatalf("failed to create dataset: %v", err)
+	}
+	appendHeaders(t, d, headers)
+	if err := d.Truncate(10, crypto.Keccak256Hash(headers[9])); err != nil {
+		t.Fatalf("failed to truncate dataset: %v", err)
+	}
+	if words := d.Words(); !reflect.DeepEqual(words, expectedWords(headers[:10], 0)) {
+		t.Fatalf("truncated dataset mismatch: have %d words, want %d", len(words), len(expectedWords(headers[:10], 0)))
+	}
+	appendHeaders(t, d, fork[10:])
+	if words := d.Words(); !reflect.DeepEqual(words, expectedWords(fork, 0)) {
+		t.Fatalf("extended dataset mismatch: have %d words, want %d", len(words), len(expectedWords(fork, 0)))
+	}
+	if dir == "" {
+		return
+	}
+	d.Close()
+	if d, err = New(dir, 0); err != nil {
+		t.Fatalf("failed to reopen dataset: %v", err)
+	}
+	defer d.Close()
+	if blocks := d.Blocks(); blocks != uint64(len(fork)) {
+		t.Fatalf("block count mismatch after reopen: have %d, want %d", blocks, len(fork))
+	}
+	if words := d.Words(); !reflect.DeepEqual(words, expectedWords(fork, 0)) {
+		t.Fatalf("reopened dataset mismatch: have %d words, want %d", len(words), len(expectedWords(fork, 0)))
+	}
+}
+
+// Tests that the dataset stops growing at its word limit but keeps counting
+// blocks.
+func TestDatasetLimit(t *testing.T) {
+	headers := testHeaders(t, 32)
+
+	d, err := New("", 100)
+	if err != nil {
+		t.Fatalf("failed to create dataset: %v", err)
+	}
+	appendHeaders(t, d, headers)
+	if blocks := d.Blocks(); blocks != 32 {
+		t.Fatalf("block count mismatch: have %d, want %d", blocks, 32)
+	}
+	if words := d.Words(); !reflect.DeepEqual(words, expectedWords(headers, 100)) {
+		t.Fatalf("dataset mismatch: have %d words, want %d", len(words), 100)
+	}
+}
+
+// Tests that the dataset is generated from the canonical headers in a database.
+func TestDatasetGenerate(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+
+	headers := make([][]byte, 20)
+	for i := range headers {
+		header := &types.Header{Number: big.NewInt(int64(i)), Difficulty: big.NewInt(131072), Extra: []byte{byte(i)}}
+		rawdb.WriteHeader(db, header)
+		rawdb.WriteCanonicalHash(db, header.Hash(), uint64(i))
+		headers[i] = rawdb.ReadHeaderRLP(db, header.Hash(), uint64(i))
+	}
+	d, err := New("", 0)
+	if err != nil {
+		t.Fatalf("failed to create dataset: %v", err)
+	}
+	if err := d.Generate(db, 9); err != nil {
+		t.Fatalf("failed to generate dataset: %v", err)
+	}
+	if err := d.Generate(db, 19); err != nil {
+		t.Fatalf("failed to extend dataset: %v", err)
+	}
+	if words := d.Words(); !reflect.DeepEqual(words, expectedWords(headers, 0)) {
+		t.Fatalf("dataset mismatch: have %d words, want %d", len(words), len(expectedWords(headers, 0)))
+	}
+	if err := d.Generate(db, 25); err == nil {
+		t.Fatalf("generating past the stored headers succeeded")
+	}
+}
+
+// writeLinkedChain writes a chain of n headers linked by their parent hashes as
+// the canonical one, with the given extra data from block fork on.
+func writeLinkedChain(db ethdb.Database, n int, fork int, extra byte) {
+	var parent common.Hash
+	for i := 0; i < n; i++ {
+		header := &types.Header{ParentHash: parent, Number: big.NewInt(int64(i)), Difficulty: big.NewInt(131072)}
+		if i >= fork {
+			header.Extra = []byte{extra}
+		}
+		rawdb.WriteHeader(db, header)
+		rawdb.WriteCanonicalHash(db, header.Hash(), uint64(i))
+		parent = header.Hash()
+	}
+}
+
+// Tests that a dataset kept on disk is only extended if its headers were taken
+// from the canonical chain in the database, and regenerated otherwise.
+func TestDatasetGenerateChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "thdataset-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	extend := func(db ethdb.Reader, head uint64, headers [][]byte) {
+		d, err := New(dir, 0)
+		if err != nil {
+			t.Fatalf("failed to open dataset: %v", err)
+		}
+		defer d.Close()
+
+		if err := d.Generate(db, head); err != nil {
+			t.Fatalf("head %d: failed to generate dataset: %v", head, err)
+		}
+		if words := d.Words(); !reflect.DeepEqual(words, expectedWords(headers, 0)) {
+			t.Fatalf("head %d: dataset mismatch: have %d words, want %d", head, len(words), len(expectedWords(headers, 0)))
+		}
+	}
+	db := rawdb.NewMemoryDatabase()
+	writeLinkedChain(db, 40, 40, 0)
+	extend(db, 29, readHeaders(db, 30))
+
+	// A dataset ahead of the chain is rewound along its ancestry, without reading
+	// the headers before the head again
+	headers := readHeaders(db, 20)
+	hash := rawdb.ReadCanonicalHash(db, 5)
+	header := rawdb.ReadHeader(db, hash, 5)
+	rawdb.DeleteHeader(db, hash, 5)
+	extend(db, 19, headers)
+	rawdb.WriteHeader(db, header)
+
+	// A dataset of a replaced chain is regenerated, as is one of another genesis
+	writeLinkedChain(db, 40, 10, 0x01)
+	extend(db, 39, readHeaders(db, 40))
+
+	other := rawdb.NewMemoryDatabase()
+	writeLinkedChain(other, 40, 0, 0x02)
+	extend(other, 39, readHeaders(other, 40))
+}
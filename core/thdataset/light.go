@@ -0,0 +1,213 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package thdataset
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const (
+	// rowWords is the number of words in a dataset row read by trie node mining.
+	rowWords = 16
+
+	// headerCacheLimit is the number of decoded headers a light dataset caches.
+	headerCacheLimit = 4096
+)
+
+// Light is a header dataset for verifiers that only tracks where every header
+// starts in the dataset, reading the rows needed to verify a trie node nonce
+// from the database on demand. It is safe for concurrent use.
+type Light struct {
+	db    ethdb.Reader // Database to read canonical headers from
+	limit uint32       // Maximum number of words in the dataset, 0 for unbounded
+
+	lock    sync.RWMutex
+	ends    []uint64   // End of every header in the dataset in words
+	headers *lru.Cache // Dataset words of recently read headers by block number
+}
+
+// NewLight creates an empty light header dataset over the canonical headers in
+// the given database.
+func NewLight(db ethdb.Reader, limit uint32) *Light {
+	headers, _ := lru.New(headerCacheLimit)
+	return &Light{
+		db:      db,
+		limit:   limit,
+		headers: headers,
+	}
+}
+
+// Blocks returns the number of headers in the dataset, i.e. the number of the
+// next block to append.
+func (l *Light) Blocks() uint64 {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	return uint64(len(l.ends))
+}
+
+// words returns the number of words in use.
+func (l *Light) words() uint64 {
+	if len(l.ends) == 0 {
+		return 0
+	}
+	return l.ends[len(l.ends)-1]
+}
+
+// filled returns whether the dataset reached its word limit.
+func (l *Light) filled() bool {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	return l.limit != 0 && l.words() >= uint64(l.limit)
+}
+
+// Append adds the header of the next block to the dataset. Only the length of
+// the header is recorded, its words are read from the database when needed. The
+// light dataset is rebuilt at every start, so the hash isn't recorded.
+func (l *Light) Append(number uint64, hash common.Hash, header []byte) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if number != uint64(len(l.ends)) {
+		return fmt.Errorf("%v: have block %d, want %d", ErrNonContiguous, number, len(l.ends))
+	}
+	end := l.words() + uint64(len(header)/4)
+	if l.limit != 0 && end > uint64(l.limit) {
+		end = uint64(l.limit)
+	}
+	l.ends = append(l.ends, end)
+	l.headers.Remove(number)
+	return nil
+}
+
+// Generate extends the dataset with the canonical headers stored in the
+// database up to and including head. A dataset ahead of the database is
+// truncated to head first.
+func (l *Light) Generate(db ethdb.Reader, head uint64) error {
+	return generate(l, db, head)
+}
+
+// Truncate drops the headers of all blocks from the given number on, so the
+// dataset can be extended with the headers of a different chain. The hash of
+// the last block left isn't recorded either.
+func (l *Light) Truncate(blocks uint64, last common.Hash) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for number := blocks; number < uint64(len(l.ends)); number++ {
+		l.headers.Remove(number)
+	}
+	if blocks < uint64(len(l.ends)) {
+		l.ends = l.ends[:blocks]
+	}
+	return nil
+}
+
+// Close drops the header boundaries and cached headers of the dataset.
+func (l *Light) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.ends = nil
+	l.headers.Purge()
+	return nil
+}
+
+// Size returns the number of words in the dataset the trie nodes of the given
+// block were mined with, i.e. those of the headers of all blocks before it.
+func (l *Light) Size(number uint64) uint64 {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	switch {
+	case number == 0 || len(l.ends) == 0:
+		return 0
+	case number > uint64(len(l.ends)):
+		return l.words()
+	default:
+		return l.ends[number-1]
+	}
+}
+
+// Lookup returns the 16 words of the dataset row at the given index, reading
+// the headers it spans from the database. Words past the dataset are zero.
+func (l *Light) Lookup(index uint32) []uint32 {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	var (
+		row    = make([]uint32, rowWords)
+		offset = uint64(index) * rowWords
+	)
+	for filled := 0; filled < rowWords; {
+		// Find the header containing the next word of the row
+		number := sort.Search(len(l.ends), func(i int) bool { return l.ends[i] > offset })
+		if number == len(l.ends) {
+			break
+		}
+		start := uint64(0)
+		if number > 0 {
+			start = l.ends[number-1]
+		}
+		words := l.header(uint64(number))
+		if uint64(len(words)) < l.ends[number]-start {
+			log.Error("Header dataset row unavailable", "number", number, "index", index)
+			break
+		}
+		n := copy(row[filled:], words[offset-start:l.ends[number]-start])
+		filled += n
+		offset += uint64(n)
+	}
+	return row
+}
+
+// Ancestry returns the header dataset along the ancestry of the block with the
+// given parent, which may have left the canonical chain.
+func (l *Light) Ancestry(db ethdb.Reader, parent common.Hash, number uint64) (*Ancestry, error) {
+	l.lock.RLock()
+	blocks, limit := uint64(len(l.ends)), l.limit
+	l.lock.RUnlock()
+
+	return newAncestry(l, blocks, limit, db, parent, number)
+}
+
+// header retrieves the dataset words of the canonical header of a block.
+func (l *Light) header(number uint64) []uint32 {
+	if words, ok := l.headers.Get(number); ok {
+		return words.([]uint32)
+	}
+	blob := rawdb.ReadHeaderRLP(l.db, rawdb.ReadCanonicalHash(l.db, number), number)
+	if len(blob) == 0 {
+		return nil
+	}
+	words := make([]uint32, len(blob)/4)
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint32(blob[4*i:])
+	}
+	l.headers.Add(number, words)
+	return words
+}
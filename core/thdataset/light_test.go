@@ -0,0 +1,113 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package thdataset
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// writeTestChain stores a canonical chain of n dummy headers in the database.
+func writeTestChain(db ethdb.Database, n int, extra byte) {
+	for i := 0; i < n; i++ {
+		header := &types.Header{Number: big.NewInt(int64(i)), Difficulty: big.NewInt(131072), Extra: make([]byte, i%11)}
+		for j := range header.Extra {
+			header.Extra[j] = extra
+		}
+		rawdb.WriteHeader(db, header)
+		rawdb.WriteCanonicalHash(db, header.Hash(), uint64(i))
+	}
+}
+
+// checkLight checks that the rows and block sizes of a light dataset match those
+// of the full dataset.
+func checkLight(t *testing.T, light *Light, full *Dataset) {
+	words := full.Words()
+	if blocks := light.Blocks(); blocks != full.Blocks() {
+		t.Fatalf("block count mismatch: have %d, want %d", blocks, full.Blocks())
+	}
+	if size := light.Size(light.Blocks()); size != uint64(len(words)) {
+		t.Fatalf("size mismatch: have %d, want %d", size, len(words))
+	}
+	for index := uint32(0); uint64(index+1)*rowWords <= uint64(len(words)); index++ {
+		want := words[index*rowWords : (index+1)*rowWords]
+		if have := light.Lookup(index); !reflect.DeepEqual(have, want) {
+			t.Fatalf("row %d mismatch: have %x, want %x", index, have, want)
+		}
+		if have := full.Lookup(index); !reflect.DeepEqual(have, want) {
+			t.Fatalf("full row %d mismatch: have %x, want %x", index, have, want)
+		}
+	}
+	past := uint32(uint64(len(words))/rowWords + 1)
+	if have, want := full.Lookup(past), light.Lookup(past); !reflect.DeepEqual(have, want) {
+		t.Fatalf("row %d past the end mismatch: have %x, want %x", past, have, want)
+	}
+	for number := uint64(0); number <= light.Blocks()+1; number++ {
+		if have, want := full.Size(number), light.Size(number); have != want {
+			t.Fatalf("block %d size mismatch: have %d, want %d", number, have, want)
+		}
+	}
+}
+
+// Tests that light datasets read the same rows from the database as full ones
+// hold in memory.
+func TestLightDataset(t *testing.T) {
+	for _, limit := range []uint32{0, 300} {
+		db := rawdb.NewMemoryDatabase()
+		writeTestChain(db, 64, 0x01)
+
+		full, _ := New("", limit)
+		if err := full.Generate(db, 63); err != nil {
+			t.Fatalf("limit %d: failed to generate full dataset: %v", limit, err)
+		}
+		light := NewLight(db, limit)
+		if err := light.Generate(db, 63); err != nil {
+			t.Fatalf("limit %d: failed to generate light dataset: %v", limit, err)
+		}
+		checkLight(t, light, full)
+
+		// Trie nodes of a block are mined over the headers before it
+		if size, want := light.Size(10), uint64(len(expectedWords(readHeaders(db, 10), limit))); size != want {
+			t.Fatalf("limit %d: block size mismatch: have %d, want %d", limit, size, want)
+		}
+		// Replace the chain from block 20 on and extend both datasets again
+		writeTestChain(db, 80, 0x02)
+		for _, d := range []appender{full, light} {
+			if err := d.Truncate(20, rawdb.ReadCanonicalHash(db, 19)); err != nil {
+				t.Fatalf("limit %d: failed to truncate dataset: %v", limit, err)
+			}
+			if err := generate(d, db, 79); err != nil {
+				t.Fatalf("limit %d: failed to extend dataset: %v", limit, err)
+			}
+		}
+		checkLight(t, light, full)
+	}
+}
+
+// readHeaders returns the encodings of the first n canonical headers.
+func readHeaders(db ethdb.Reader, n uint64) [][]byte {
+	headers := make([][]byte, n)
+	for i := range headers {
+		headers[i] = rawdb.ReadHeaderRLP(db, rawdb.ReadCanonicalHash(db, uint64(i)), uint64(i))
+	}
+	return headers
+}
@@ -19,6 +19,7 @@ package types
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"math/big"
@@ -84,17 +85,67 @@ type Header struct {
 	Extra       []byte         `json:"extraData"        gencodec:"required"`
 	MixDigest   common.Hash    `json:"mixHash"`
 	Nonce       BlockNonce     `json:"nonce"`
+
+	// TrieNoncesHash commits to the trie nonce list carried in the block body.
+	// It is only set on Trie-Hashimoto blocks and is left out of the RLP
+	// encoding otherwise, keeping legacy header hashes unchanged.
+	TrieNoncesHash common.Hash `json:"trieNoncesHash" rlp:"-"`
+
+	// TrieDifficulty scales the work of mining every trie node of the block on
+	// top of its block number prefix. It is encoded after the trie nonce
+	// commitment and only set on Trie-Hashimoto blocks.
+	TrieDifficulty *big.Int `json:"trieDifficulty" rlp:"-"`
+
+	// TrieTime is the time the trie nodes of the block were mined at, sealed by
+	// the block PoW so that the trie difficulty of its children is retargeted on
+	// the trie mining time. It is encoded after the trie difficulty if set, which
+	// it isn't on headers mined before it was recorded.
+	TrieTime uint64 `json:"trieTime" rlp:"-"`
+}
+
+// legacyHeaderFields is the number of RLP list elements of a header without
+// a trie nonce commitment.
+const legacyHeaderFields = 15
+
+// headerFields is Header without its RLP methods, used to encode and decode
+// the legacy field list.
+type headerFields Header
+
+// extheader is the RLP encoding of a Trie-Hashimoto header, which appends the
+// trie nonce commitment, the trie difficulty and the optional trie time to the
+// legacy field list.
+type extheader struct {
+	ParentHash     common.Hash
+	UncleHash      common.Hash
+	Coinbase       common.Address
+	Root           common.Hash
+	TxHash         common.Hash
+	ReceiptHash    common.Hash
+	Bloom          Bloom
+	Difficulty     *big.Int
+	Number         *big.Int
+	GasLimit       uint64
+	GasUsed        uint64
+	Time           uint64
+	Extra          []byte
+	MixDigest      common.Hash
+	Nonce          BlockNonce
+	TrieNoncesHash common.Hash
+	TrieDifficulty *big.Int
+	TrieTime       []uint64 `rlp:"tail"` // Empty or the single trie time
 }
 
 // field type overrides for gencodec
 type headerMarshaling struct {
-	Difficulty *hexutil.Big
-	Number     *hexutil.Big
-	GasLimit   hexutil.Uint64
-	GasUsed    hexutil.Uint64
-	Time       hexutil.Uint64
-	Extra      hexutil.Bytes
-	Hash       common.Hash `json:"hash"` // adds call to Hash() in MarshalJSON
+	Difficulty     *hexutil.Big
+	Number         *hexutil.Big
+	GasLimit       hexutil.Uint64
+	GasUsed        hexutil.Uint64
+	Time           hexutil.Uint64
+	Extra          hexutil.Bytes
+	TrieDifficulty *hexutil.Big
+	TrieTime       hexutil.Uint64
+	Hash           common.Hash `json:"hash"` // adds call to Hash() in MarshalJSON
 }
 
 // Hash returns the block hash of the header, which is simply the keccak256 hash of its
@@ -103,12 +154,107 @@ func (h *Header) Hash() common.Hash {
 	return rlpHash(h)
 }
 
+// EncodeRLP serializes h into the Ethereum RLP header format. The trie nonce
+// commitment and trie difficulty are only appended if the commitment is set,
+// followed by the trie time if that is set too.
+func (h *Header) EncodeRLP(w io.Writer) error {
+	if h.TrieNoncesHash == (common.Hash{}) {
+		return rlp.Encode(w, (*headerFields)(h))
+	}
+	var trieTime []uint64
+	if h.TrieTime != 0 {
+		trieTime = []uint64{h.TrieTime}
+	}
+	return rlp.Encode(w, &extheader{
+		ParentHash:     h.ParentHash,
+		UncleHash:      h.UncleHash,
+		Coinbase:       h.Coinbase,
+		Root:           h.Root,
+		TxHash:         h.TxHash,
+		ReceiptHash:    h.ReceiptHash,
+		Bloom:          h.Bloom,
+		Difficulty:     h.Difficulty,
+		Number:         h.Number,
+		GasLimit:       h.GasLimit,
+		GasUsed:        h.GasUsed,
+		Time:           h.Time,
+		Extra:          h.Extra,
+		MixDigest:      h.MixDigest,
+		Nonce:          h.Nonce,
+		TrieNoncesHash: h.TrieNoncesHash,
+		TrieDifficulty: h.TrieDifficulty,
+		TrieTime:       trieTime,
+	})
+}
+
+// DecodeRLP decodes a header in either the legacy or the Trie-Hashimoto format.
+func (h *Header) DecodeRLP(s *rlp.Stream) error {
+	raw, err := s.Raw()
+	if err != nil {
+		return err
+	}
+	content, _, err := rlp.SplitList(raw)
+	if err != nil {
+		return err
+	}
+	fields, err := rlp.CountValues(content)
+	if err != nil {
+		return err
+	}
+	if fields == legacyHeaderFields {
+		h.TrieNoncesHash, h.TrieDifficulty, h.TrieTime = common.Hash{}, nil, 0
+		return rlp.DecodeBytes(raw, (*headerFields)(h))
+	}
+	var eh extheader
+	if err := rlp.DecodeBytes(raw, &eh); err != nil {
+		return err
+	}
+	if eh.TrieNoncesHash == (common.Hash{}) {
+		return errors.New("rlp: empty trie nonces hash in extended header")
+	}
+	var trieTime uint64
+	switch len(eh.TrieTime) {
+	case 0:
+	case 1:
+		if trieTime = eh.TrieTime[0]; trieTime == 0 {
+			return errors.New("rlp: zero trie time in extended header")
+		}
+	default:
+		return errors.New("rlp: too many fields in extended header")
+	}
+	*h = Header{
+		ParentHash:     eh.ParentHash,
+		UncleHash:      eh.UncleHash,
+		Coinbase:       eh.Coinbase,
+		Root:           eh.Root,
+		TxHash:         eh.TxHash,
+		ReceiptHash:    eh.ReceiptHash,
+		Bloom:          eh.Bloom,
+		Difficulty:     eh.Difficulty,
+		Number:         eh.Number,
+		GasLimit:       eh.GasLimit,
+		GasUsed:        eh.GasUsed,
+		Time:           eh.Time,
+		Extra:          eh.Extra,
+		MixDigest:      eh.MixDigest,
+		Nonce:          eh.Nonce,
+		TrieNoncesHash: eh.TrieNoncesHash,
+		TrieDifficulty: eh.TrieDifficulty,
+		TrieTime:       trieTime,
+	}
+	return nil
+}
+
 var headerSize = common.StorageSize(reflect.TypeOf(Header{}).Size())
 
 // Size returns the approximate memory used by all internal contents. It is used
 // to approximate and limit the memory consumption of various caches.
 func (h *Header) Size() common.StorageSize {
-	return headerSize + common.StorageSize(len(h.Extra)+(h.Difficulty.BitLen()+h.Number.BitLen())/8)
+	size := len(h.Extra) + (h.Difficulty.BitLen()+h.Number.BitLen())/8
+	if h.TrieDifficulty != nil {
+		size += h.TrieDifficulty.BitLen() / 8
+	}
+	return headerSize + common.StorageSize(size)
 }
 
 // SanityCheck checks a few basic things -- these checks are way beyond what
@@ -124,6 +270,11 @@ func (h *Header) SanityCheck() error {
 			return fmt.Errorf("too large block difficulty: bitlen %d", diffLen)
 		}
 	}
+	if h.TrieDifficulty != nil {
+		if diffLen := h.TrieDifficulty.BitLen(); diffLen > 80 {
+			return fmt.Errorf("too large block trie difficulty: bitlen %d", diffLen)
+		}
+	}
 	if eLen := len(h.Extra); eLen > 100*1024 {
 		return fmt.Errorf("too large block extradata: size %d", eLen)
 	}
@@ -140,9 +291,50 @@ func rlpHash(x interface{}) (h common.Hash) {
 // Body is a simple (mutable, non-safe) data container for storing and moving
 // a block's data contents (transactions and uncles) together.
 type Body struct {
-	Transactions []*Transaction
-	Uncles       []*Header
-	TrieNonces	 []uint64
+	Transactions  []*Transaction
+	Uncles        []*Header
+	TrieNonces    []uint64
+	StorageNonces []StorageTrieNonces
+}
+
+// DecodeRLP decodes a block body, also accepting the bodies stored before the
+// trie nonce lists were added, which simply end early and carry no nonces.
+func (b *Body) DecodeRLP(s *rlp.Stream) error {
+	if _, err := s.List(); err != nil {
+		return err
+	}
+	if err := s.Decode(&b.Transactions); err != nil {
+		return err
+	}
+	if err := s.Decode(&b.Uncles); err != nil {
+		return err
+	}
+	b.TrieNonces, b.StorageNonces = nil, nil
+	if err := decodeTrieNonces(s, &b.TrieNonces, &b.StorageNonces); err != nil {
+		return err
+	}
+	return s.ListEnd()
+}
+
+// decodeTrieNonces decodes the optional trailing trie nonce lists of a block or
+// a block body, leaving the lists missing in legacy encodings empty.
+func decodeTrieNonces(s *rlp.Stream, trieNonces *[]uint64, storageNonces *[]StorageTrieNonces) error {
+	if err := s.Decode(trieNonces); err == rlp.EOL {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := s.Decode(storageNonces); err != nil && err != rlp.EOL {
+		return err
+	}
+	return nil
+}
+
+// StorageTrieNonces holds the trie nonces mined for the storage trie of a single
+// account. Blocks list them in ascending address order.
+type StorageTrieNonces struct {
+	Address common.Address `json:"address"`
+	Nonces  []uint64       `json:"nonces"`
 }
 
 // Block represents an entire block in the Ethereum blockchain.
@@ -151,6 +343,7 @@ type Block struct {
 	uncles       []*Header
 	transactions Transactions
 	trieNonces	 []uint64 // (sjkim)
+	storageNonces []StorageTrieNonces
 
 	// caches
 	hash atomic.Value
@@ -185,6 +378,29 @@ type extblock struct {
 	Txs    []*Transaction
 	Uncles []*Header
 	TrieNonces []uint64
+	StorageNonces []StorageTrieNonces
+}
+
+// DecodeRLP decodes a block, also accepting the blocks encoded before the trie
+// nonce lists were added, see Body.DecodeRLP.
+func (eb *extblock) DecodeRLP(s *rlp.Stream) error {
+	if _, err := s.List(); err != nil {
+		return err
+	}
+	if err := s.Decode(&eb.Header); err != nil {
+		return err
+	}
+	if err := s.Decode(&eb.Txs); err != nil {
+		return err
+	}
+	if err := s.Decode(&eb.Uncles); err != nil {
+		return err
+	}
+	eb.TrieNonces, eb.StorageNonces = nil, nil
+	if err := decodeTrieNonces(s, &eb.TrieNonces, &eb.StorageNonces); err != nil {
+		return err
+	}
+	return s.ListEnd()
 }
 
 // [deprecated by eth/63]
@@ -256,6 +472,9 @@ func CopyHeader(h *Header) *Header {
 		cpy.Extra = make([]byte, len(h.Extra))
 		copy(cpy.Extra, h.Extra)
 	}
+	if h.TrieDifficulty != nil {
+		cpy.TrieDifficulty = new(big.Int).Set(h.TrieDifficulty)
+	}
 	return &cpy
 }
 
@@ -266,7 +485,7 @@ func (b *Block) DecodeRLP(s *rlp.Stream) error {
 	if err := s.Decode(&eb); err != nil {
 		return err
 	}
-	b.header, b.uncles, b.transactions, b.trieNonces = eb.Header, eb.Uncles, eb.Txs, eb.TrieNonces
+	b.header, b.uncles, b.transactions, b.trieNonces, b.storageNonces = eb.Header, eb.Uncles, eb.Txs, eb.TrieNonces, eb.StorageNonces
 	b.size.Store(common.StorageSize(rlp.ListSize(size)))
 	return nil
 }
@@ -278,6 +497,7 @@ func (b *Block) EncodeRLP(w io.Writer) error {
 		Txs:    b.transactions,
 		Uncles: b.uncles,
 		TrieNonces: b.trieNonces,
+		StorageNonces: b.storageNonces,
 	})
 }
 
@@ -296,6 +516,7 @@ func (b *StorageBlock) DecodeRLP(s *rlp.Stream) error {
 func (b *Block) Uncles() []*Header          { return b.uncles }
 func (b *Block) Transactions() Transactions { return b.transactions }
 func (b *Block) TrieNonces() []uint64 { return b.trieNonces }
+func (b *Block) StorageNonces() []StorageTrieNonces { return b.storageNonces }
 
 func (b *Block) Transaction(hash common.Hash) *Transaction {
 	for _, transaction := range b.transactions {
@@ -312,6 +533,19 @@ func (b *Block) GasUsed() uint64      { return b.header.GasUsed }
 func (b *Block) Difficulty() *big.Int { return new(big.Int).Set(b.header.Difficulty) }
 func (b *Block) Time() uint64         { return b.header.Time }
 
+// TrieDifficulty returns the trie difficulty of the block, nil if Trie-Hashimoto
+// isn't active at it.
+func (b *Block) TrieDifficulty() *big.Int {
+	if b.header.TrieDifficulty == nil {
+		return nil
+	}
+	return new(big.Int).Set(b.header.TrieDifficulty)
+}
+
+// TrieTime returns the time the trie nodes of the block were mined at, zero if
+// it isn't recorded.
+func (b *Block) TrieTime() uint64 { return b.header.TrieTime }
+
 func (b *Block) NumberU64() uint64        { return b.header.Number.Uint64() }
 func (b *Block) MixDigest() common.Hash   { return b.header.MixDigest }
 func (b *Block) Nonce() uint64            { return binary.BigEndian.Uint64(b.header.Nonce[:]) }
@@ -327,7 +561,7 @@ func (b *Block) Extra() []byte            { return common.CopyBytes(b.header.Ext
 func (b *Block) Header() *Header { return CopyHeader(b.header) }
 
 // Body returns the non-header content of the block.
-func (b *Block) Body() *Body { return &Body{b.transactions, b.uncles, b.trieNonces} }
+func (b *Block) Body() *Body { return &Body{b.transactions, b.uncles, b.trieNonces, b.storageNonces} }
 
 // Size returns the true RLP encoded storage size of the block, either by encoding
 // and returning it, or returning a previsouly cached value.
@@ -361,6 +595,22 @@ func CalcUncleHash(uncles []*Header) common.Hash {
 	return rlpHash(uncles)
 }
 
+// CalcTrieNoncesHash returns the header commitment to the account and storage
+// trie nonces of a block. The hash of empty lists is non-zero, so every
+// Trie-Hashimoto block commits.
+func CalcTrieNoncesHash(trieNonces []uint64, storageNonces []StorageTrieNonces) common.Hash {
+	return rlpHash([]interface{}{trieNonces, storageNonces})
+}
+
+// TrieNoncesMatch reports whether the trie nonces match the commitment in the
+// header. Headers without a commitment must come without trie nonces.
+func TrieNoncesMatch(header *Header, trieNonces []uint64, storageNonces []StorageTrieNonces) bool {
+	if header.TrieNoncesHash == (common.Hash{}) {
+		return len(trieNonces) == 0 && len(storageNonces) == 0
+	}
+	return CalcTrieNoncesHash(trieNonces, storageNonces) == header.TrieNoncesHash
+}
+
 // WithSeal returns a new block with the data from b but the header replaced with
 // the sealed one.
 func (b *Block) WithSeal(header *Header) *Block {
@@ -371,16 +621,18 @@ func (b *Block) WithSeal(header *Header) *Block {
 		transactions: b.transactions,
 		uncles:       b.uncles,
 		trieNonces:	  b.trieNonces,
+		storageNonces: b.storageNonces,
 	}
 }
 
 // WithBody returns a new block with the given transaction and uncle contents.
-func (b *Block) WithBody(transactions []*Transaction, uncles []*Header, trieNonces []uint64) *Block {
+func (b *Block) WithBody(transactions []*Transaction, uncles []*Header, trieNonces []uint64, storageNonces []StorageTrieNonces) *Block {
 	block := &Block{
-		header:       CopyHeader(b.header),
-		transactions: make([]*Transaction, len(transactions)),
-		uncles:       make([]*Header, len(uncles)),
-		trieNonces:   make([]uint64, len(trieNonces)),
+		header:        CopyHeader(b.header),
+		transactions:  make([]*Transaction, len(transactions)),
+		uncles:        make([]*Header, len(uncles)),
+		trieNonces:    make([]uint64, len(trieNonces)),
+		storageNonces: copyStorageNonces(storageNonces),
 	}
 	copy(block.transactions, transactions)
 	copy(block.trieNonces, trieNonces)
@@ -401,17 +653,35 @@ func (b *Block) Hash() common.Hash {
 	return v
 }
 
-// ModifyRoot is called after stateRoot is found after IMPT mining
-// Updates stateRoot field
-func (b *Block) ModifyRoot(hash common.Hash) {
-	b.header.Root = hash
+// WithTrieNonces returns a new block with the state root and the account and
+// storage trie nonces found by Trie-Hashimoto mining, committing to the nonces
+// and the time they were mined at in the header.
+func (b *Block) WithTrieNonces(root common.Hash, trieNonces []uint64, storageNonces []StorageTrieNonces, trieTime uint64) *Block {
+	block := &Block{
+		header:        CopyHeader(b.header),
+		transactions:  b.transactions,
+		uncles:        b.uncles,
+		trieNonces:    make([]uint64, len(trieNonces)),
+		storageNonces: copyStorageNonces(storageNonces),
+	}
+	copy(block.trieNonces, trieNonces)
+	block.header.Root = root
+	block.header.TrieNoncesHash = CalcTrieNoncesHash(trieNonces, storageNonces)
+	block.header.TrieTime = trieTime
+	return block
 }
 
-// ModifyBody is called after stateRoot is found after IMPT mining
-// Updates trieNonces field
-func (b *Block) ModifyBody(trieNonces []uint64) {
-	b.trieNonces = make([]uint64, len(trieNonces))
-	copy(b.trieNonces, trieNonces)
+// copyStorageNonces creates a deep copy of a list of storage trie nonces.
+func copyStorageNonces(storageNonces []StorageTrieNonces) []StorageTrieNonces {
+	if len(storageNonces) == 0 {
+		return nil
+	}
+	cpy := make([]StorageTrieNonces, len(storageNonces))
+	for i, sn := range storageNonces {
+		cpy[i] = StorageTrieNonces{Address: sn.Address, Nonces: make([]uint64, len(sn.Nonces))}
+		copy(cpy[i].Nonces, sn.Nonces)
+	}
+	return cpy
 }
 
 type Blocks []*Block
@@ -64,6 +64,183 @@ func TestBlockEncoding(t *testing.T) {
 	}
 }
 
+func TestHeaderTrieNoncesEncoding(t *testing.T) {
+	legacy := &Header{
+		ParentHash: common.HexToHash("0x01"),
+		Root:       common.HexToHash("0x02"),
+		Difficulty: big.NewInt(131072),
+		Number:     big.NewInt(1),
+		GasLimit:   3141592,
+		Time:       1426516743,
+		Extra:      []byte("test"),
+	}
+	enc, err := rlp.EncodeToBytes(legacy)
+	if err != nil {
+		t.Fatal("encode error: ", err)
+	}
+	content, _, err := rlp.SplitList(enc)
+	if err != nil {
+		t.Fatal("split error: ", err)
+	}
+	if fields, _ := rlp.CountValues(content); fields != legacyHeaderFields {
+		t.Errorf("legacy header field count mismatch: have %d, want %d", fields, legacyHeaderFields)
+	}
+	th := CopyHeader(legacy)
+	th.TrieNoncesHash = CalcTrieNoncesHash([]uint64{1, 2, 3}, nil)
+	th.TrieDifficulty = big.NewInt(42)
+	if th.Hash() == legacy.Hash() {
+		t.Errorf("trie nonce commitment not included in header hash")
+	}
+	timed := CopyHeader(th)
+	timed.TrieTime = 1426516750
+	if timed.Hash() == th.Hash() {
+		t.Errorf("trie time not included in header hash")
+	}
+	for _, want := range []*Header{legacy, th, timed} {
+		enc, err := rlp.EncodeToBytes(want)
+		if err != nil {
+			t.Fatal("encode error: ", err)
+		}
+		var have Header
+		if err := rlp.DecodeBytes(enc, &have); err != nil {
+			t.Fatal("decode error: ", err)
+		}
+		if have.Hash() != want.Hash() {
+			t.Errorf("header hash mismatch after decoding: have %x, want %x", have.Hash(), want.Hash())
+		}
+		if have.TrieNoncesHash != want.TrieNoncesHash {
+			t.Errorf("trie nonces hash mismatch: have %x, want %x", have.TrieNoncesHash, want.TrieNoncesHash)
+		}
+		if (have.TrieDifficulty == nil) != (want.TrieDifficulty == nil) || (have.TrieDifficulty != nil && have.TrieDifficulty.Cmp(want.TrieDifficulty) != 0) {
+			t.Errorf("trie difficulty mismatch: have %v, want %v", have.TrieDifficulty, want.TrieDifficulty)
+		}
+		if have.TrieTime != want.TrieTime {
+			t.Errorf("trie time mismatch: have %d, want %d", have.TrieTime, want.TrieTime)
+		}
+	}
+	// Zero or repeated trie times must be rejected
+	for _, trieTime := range [][]uint64{{0}, {1426516750, 1426516751}} {
+		eh := &extheader{
+			Difficulty:     th.Difficulty,
+			Number:         th.Number,
+			TrieNoncesHash: th.TrieNoncesHash,
+			TrieDifficulty: th.TrieDifficulty,
+			TrieTime:       trieTime,
+		}
+		enc, err := rlp.EncodeToBytes(eh)
+		if err != nil {
+			t.Fatal("encode error: ", err)
+		}
+		var have Header
+		if err := rlp.DecodeBytes(enc, &have); err == nil {
+			t.Errorf("trie time %v: decoded invalid extended header", trieTime)
+		}
+	}
+}
+
+func TestTrieNoncesMatch(t *testing.T) {
+	nonces := []uint64{7, 8}
+	storage := []StorageTrieNonces{{Address: common.Address{0x01}, Nonces: []uint64{9}}}
+	header := &Header{Difficulty: big.NewInt(1), Number: big.NewInt(1)}
+	if !TrieNoncesMatch(header, nil, nil) {
+		t.Errorf("legacy header rejected empty trie nonces")
+	}
+	if TrieNoncesMatch(header, nonces, nil) {
+		t.Errorf("legacy header accepted trie nonces")
+	}
+	if TrieNoncesMatch(header, nil, storage) {
+		t.Errorf("legacy header accepted storage trie nonces")
+	}
+	block := NewBlockWithHeader(header).WithTrieNonces(common.HexToHash("0x03"), nonces, storage, 10)
+	if !TrieNoncesMatch(block.Header(), block.TrieNonces(), block.StorageNonces()) {
+		t.Errorf("trie nonces don't match their own commitment")
+	}
+	if TrieNoncesMatch(block.Header(), []uint64{8, 7}, storage) {
+		t.Errorf("reordered trie nonces matched the commitment")
+	}
+	if TrieNoncesMatch(block.Header(), nil, storage) {
+		t.Errorf("missing trie nonces matched the commitment")
+	}
+	if TrieNoncesMatch(block.Header(), nonces, nil) {
+		t.Errorf("missing storage trie nonces matched the commitment")
+	}
+}
+
+// Tests that bodies and blocks encoded before the storage trie nonces, or before
+// any trie nonces, still decode, and that current ones round trip.
+func TestLegacyBodyDecoding(t *testing.T) {
+	var (
+		header  = &Header{Difficulty: big.NewInt(1), Number: big.NewInt(1)}
+		uncles  = []*Header{{Difficulty: big.NewInt(2), Number: big.NewInt(0)}}
+		nonces  = []uint64{7, 8}
+		storage = []StorageTrieNonces{{Address: common.Address{0x01}, Nonces: []uint64{9}}}
+	)
+	tests := []struct {
+		body        interface{}
+		block       interface{}
+		wantNonces  []uint64
+		wantStorage []StorageTrieNonces
+	}{
+		{
+			body:  []interface{}{Transactions{}, uncles},
+			block: []interface{}{header, Transactions{}, uncles},
+		},
+		{
+			body:       []interface{}{Transactions{}, uncles, nonces},
+			block:      []interface{}{header, Transactions{}, uncles, nonces},
+			wantNonces: nonces,
+		},
+		{
+			body:        &Body{Transactions: Transactions{}, Uncles: uncles, TrieNonces: nonces, StorageNonces: storage},
+			block:       []interface{}{header, Transactions{}, uncles, nonces, storage},
+			wantNonces:  nonces,
+			wantStorage: storage,
+		},
+	}
+	for i, tt := range tests {
+		enc, _ := rlp.EncodeToBytes(tt.body)
+		var body Body
+		if err := rlp.DecodeBytes(enc, &body); err != nil {
+			t.Fatalf("test %d: failed to decode body: %v", i, err)
+		}
+		if len(body.Uncles) != 1 || body.Uncles[0].Hash() != uncles[0].Hash() {
+			t.Errorf("test %d: body uncles mismatch: have %v", i, body.Uncles)
+		}
+		if !reflect.DeepEqual(body.TrieNonces, tt.wantNonces) || !reflect.DeepEqual(body.StorageNonces, tt.wantStorage) {
+			t.Errorf("test %d: body nonces mismatch: have %v %v, want %v %v", i, body.TrieNonces, body.StorageNonces, tt.wantNonces, tt.wantStorage)
+		}
+		enc, _ = rlp.EncodeToBytes(tt.block)
+		var block Block
+		if err := rlp.DecodeBytes(enc, &block); err != nil {
+			t.Fatalf("test %d: failed to decode block: %v", i, err)
+		}
+		if block.Hash() != header.Hash() || len(block.Uncles()) != 1 {
+			t.Errorf("test %d: block mismatch: have %x with %d uncles", i, block.Hash(), len(block.Uncles()))
+		}
+		if !reflect.DeepEqual(block.TrieNonces(), tt.wantNonces) || !reflect.DeepEqual(block.StorageNonces(), tt.wantStorage) {
+			t.Errorf("test %d: block nonces mismatch: have %v %v, want %v %v", i, block.TrieNonces(), block.StorageNonces(), tt.wantNonces, tt.wantStorage)
+		}
+	}
+	// Trailing junk must still be rejected
+	enc, _ := rlp.EncodeToBytes([]interface{}{Transactions{}, uncles, nonces, storage, uint(1)})
+	if err := rlp.DecodeBytes(enc, new(Body)); err == nil {
+		t.Errorf("body with trailing junk decoded")
+	}
+}
+
+// Tests that the size of headers without a trie difficulty can be approximated.
+func TestHeaderSize(t *testing.T) {
+	legacy := &Header{Difficulty: big.NewInt(131072), Number: big.NewInt(1), Extra: []byte("legacy")}
+	if have, want := legacy.Size(), headerSize+common.StorageSize(len(legacy.Extra)+2); have != want {
+		t.Errorf("legacy header size mismatch: have %v, want %v", have, want)
+	}
+	th := CopyHeader(legacy)
+	th.TrieDifficulty = big.NewInt(1 << 16)
+	if have, want := th.Size(), legacy.Size()+2; have != want {
+		t.Errorf("trie hashimoto header size mismatch: have %v, want %v", have, want)
+	}
+}
+
 func TestUncleHash(t *testing.T) {
 	uncles := make([]*Header, 0)
 	h := CalcUncleHash(uncles)
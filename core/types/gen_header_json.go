@@ -16,22 +16,25 @@ var _ = (*headerMarshaling)(nil)
 // MarshalJSON marshals as JSON.
 func (h Header) MarshalJSON() ([]byte, error) {
 	type Header struct {
-		ParentHash  common.Hash    `json:"parentHash"       gencodec:"required"`
-		UncleHash   common.Hash    `json:"sha3Uncles"       gencodec:"required"`
-		Coinbase    common.Address `json:"miner"            gencodec:"required"`
-		Root        common.Hash    `json:"stateRoot"        gencodec:"required"`
-		TxHash      common.Hash    `json:"transactionsRoot" gencodec:"required"`
-		ReceiptHash common.Hash    `json:"receiptsRoot"     gencodec:"required"`
-		Bloom       Bloom          `json:"logsBloom"        gencodec:"required"`
-		Difficulty  *hexutil.Big   `json:"difficulty"       gencodec:"required"`
-		Number      *hexutil.Big   `json:"number"           gencodec:"required"`
-		GasLimit    hexutil.Uint64 `json:"gasLimit"         gencodec:"required"`
-		GasUsed     hexutil.Uint64 `json:"gasUsed"          gencodec:"required"`
-		Time        hexutil.Uint64 `json:"timestamp"        gencodec:"required"`
-		Extra       hexutil.Bytes  `json:"extraData"        gencodec:"required"`
-		MixDigest   common.Hash    `json:"mixHash"`
-		Nonce       BlockNonce     `json:"nonce"`
-		Hash        common.Hash    `json:"hash"`
+		ParentHash     common.Hash    `json:"parentHash"       gencodec:"required"`
+		UncleHash      common.Hash    `json:"sha3Uncles"       gencodec:"required"`
+		Coinbase       common.Address `json:"miner"            gencodec:"required"`
+		Root           common.Hash    `json:"stateRoot"        gencodec:"required"`
+		TxHash         common.Hash    `json:"transactionsRoot" gencodec:"required"`
+		ReceiptHash    common.Hash    `json:"receiptsRoot"     gencodec:"required"`
+		Bloom          Bloom          `json:"logsBloom"        gencodec:"required"`
+		Difficulty     *hexutil.Big   `json:"difficulty"       gencodec:"required"`
+		Number         *hexutil.Big   `json:"number"           gencodec:"required"`
+		GasLimit       hexutil.Uint64 `json:"gasLimit"         gencodec:"required"`
+		GasUsed        hexutil.Uint64 `json:"gasUsed"          gencodec:"required"`
+		Time           hexutil.Uint64 `json:"timestamp"        gencodec:"required"`
+		Extra          hexutil.Bytes  `json:"extraData"        gencodec:"required"`
+		MixDigest      common.Hash    `json:"mixHash"`
+		Nonce          BlockNonce     `json:"nonce"`
+		TrieNoncesHash common.Hash    `json:"trieNoncesHash" rlp:"-"`
+		TrieDifficulty *hexutil.Big   `json:"trieDifficulty" rlp:"-"`
+		TrieTime       hexutil.Uint64 `json:"trieTime" rlp:"-"`
+		Hash           common.Hash    `json:"hash"`
 	}
 	var enc Header
 	enc.ParentHash = h.ParentHash
@@ -49,6 +52,9 @@ func (h Header) MarshalJSON() ([]byte, error) {
 	enc.Extra = h.Extra
 	enc.MixDigest = h.MixDigest
 	enc.Nonce = h.Nonce
+	enc.TrieNoncesHash = h.TrieNoncesHash
+	enc.TrieDifficulty = (*hexutil.Big)(h.TrieDifficulty)
+	enc.TrieTime = hexutil.Uint64(h.TrieTime)
 	enc.Hash = h.Hash()
 	return json.Marshal(&enc)
 }
@@ -56,21 +62,24 @@ func (h Header) MarshalJSON() ([]byte, error) {
 // UnmarshalJSON unmarshals from JSON.
 func (h *Header) UnmarshalJSON(input []byte) error {
 	type Header struct {
-		ParentHash  *common.Hash    `json:"parentHash"       gencodec:"required"`
-		UncleHash   *common.Hash    `json:"sha3Uncles"       gencodec:"required"`
-		Coinbase    *common.Address `json:"miner"            gencodec:"required"`
-		Root        *common.Hash    `json:"stateRoot"        gencodec:"required"`
-		TxHash      *common.Hash    `json:"transactionsRoot" gencodec:"required"`
-		ReceiptHash *common.Hash    `json:"receiptsRoot"     gencodec:"required"`
-		Bloom       *Bloom          `json:"logsBloom"        gencodec:"required"`
-		Difficulty  *hexutil.Big    `json:"difficulty"       gencodec:"required"`
-		Number      *hexutil.Big    `json:"number"           gencodec:"required"`
-		GasLimit    *hexutil.Uint64 `json:"gasLimit"         gencodec:"required"`
-		GasUsed     *hexutil.Uint64 `json:"gasUsed"          gencodec:"required"`
-		Time        *hexutil.Uint64 `json:"timestamp"        gencodec:"required"`
-		Extra       *hexutil.Bytes  `json:"extraData"        gencodec:"required"`
-		MixDigest   *common.Hash    `json:"mixHash"`
-		Nonce       *BlockNonce     `json:"nonce"`
+		ParentHash     *common.Hash    `json:"parentHash"       gencodec:"required"`
+		UncleHash      *common.Hash    `json:"sha3Uncles"       gencodec:"required"`
+		Coinbase       *common.Address `json:"miner"            gencodec:"required"`
+		Root           *common.Hash    `json:"stateRoot"        gencodec:"required"`
+		TxHash         *common.Hash    `json:"transactionsRoot" gencodec:"required"`
+		ReceiptHash    *common.Hash    `json:"receiptsRoot"     gencodec:"required"`
+		Bloom          *Bloom          `json:"logsBloom"        gencodec:"required"`
+		Difficulty     *hexutil.Big    `json:"difficulty"       gencodec:"required"`
+		Number         *hexutil.Big    `json:"number"           gencodec:"required"`
+		GasLimit       *hexutil.Uint64 `json:"gasLimit"         gencodec:"required"`
+		GasUsed        *hexutil.Uint64 `json:"gasUsed"          gencodec:"required"`
+		Time           *hexutil.Uint64 `json:"timestamp"        gencodec:"required"`
+		Extra          *hexutil.Bytes  `json:"extraData"        gencodec:"required"`
+		MixDigest      *common.Hash    `json:"mixHash"`
+		Nonce          *BlockNonce     `json:"nonce"`
+		TrieNoncesHash *common.Hash    `json:"trieNoncesHash" rlp:"-"`
+		TrieDifficulty *hexutil.Big    `json:"trieDifficulty" rlp:"-"`
+		TrieTime       *hexutil.Uint64 `json:"trieTime" rlp:"-"`
 	}
 	var dec Header
 	if err := json.Unmarshal(input, &dec); err != nil {
@@ -134,5 +143,14 @@ func (h *Header) UnmarshalJSON(input []byte) error {
 	if dec.Nonce != nil {
 		h.Nonce = *dec.Nonce
 	}
+	if dec.TrieNoncesHash != nil {
+		h.TrieNoncesHash = *dec.TrieNoncesHash
+	}
+	if dec.TrieDifficulty != nil {
+		h.TrieDifficulty = (*big.Int)(dec.TrieDifficulty)
+	}
+	if dec.TrieTime != nil {
+		h.TrieTime = uint64(*dec.TrieTime)
+	}
 	return nil
 }
@@ -66,6 +66,11 @@ func (api *PublicEthereumAPI) Hashrate() hexutil.Uint64 {
 	return hexutil.Uint64(api.e.Miner().HashRate())
 }
 
+// TrieHashrate returns the Trie-Hashimoto trie node mining hashrate
+func (api *PublicEthereumAPI) TrieHashrate() hexutil.Uint64 {
+	return hexutil.Uint64(api.e.Miner().TrieHashRate())
+}
+
 // ChainId is the EIP-155 replay-protection chain id for the current ethereum chain config.
 func (api *PublicEthereumAPI) ChainId() hexutil.Uint64 {
 	chainID := new(big.Int)
@@ -154,6 +159,11 @@ func (api *PrivateMinerAPI) GetHashrate() uint64 {
 	return api.e.miner.HashRate()
 }
 
+// GetTrieHashrate returns the current trie node hashrate of the miner.
+func (api *PrivateMinerAPI) GetTrieHashrate() uint64 {
+	return api.e.miner.TrieHashRate()
+}
+
 // PrivateAdminAPI is the collection of Ethereum full node-related APIs
 // exposed over the private admin endpoint.
 type PrivateAdminAPI struct {
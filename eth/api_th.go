@@ -0,0 +1,300 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/impt"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// trieProgressInterval is the interval at which trie mining progress is notified.
+const trieProgressInterval = time.Second
+
+var errTrieMiningUnsupported = errors.New("trie mining not supported by the consensus engine")
+
+// PublicTrieHashimotoAPI provides an API to inspect the trie nodes indexed by
+// Trie-Hashimoto mining.
+type PublicTrieHashimotoAPI struct {
+	eth *Ethereum
+}
+
+// NewPublicTrieHashimotoAPI creates a new Trie-Hashimoto inspection API.
+func NewPublicTrieHashimotoAPI(eth *Ethereum) *PublicTrieHashimotoAPI {
+	return &PublicTrieHashimotoAPI{eth: eth}
+}
+
+// TrieNoncesResult is the trie node nonces of a block along with the hashes of
+// the nodes before and after they were mined.
+type TrieNoncesResult struct {
+	Number        hexutil.Uint64            `json:"number"`
+	Hash          common.Hash               `json:"hash"`
+	TrieNonces    []*impt.TrieNonce         `json:"trieNonces"`
+	StorageNonces []StorageTrieNoncesResult `json:"storageNonces"`
+}
+
+// StorageTrieNoncesResult is the nonces of the storage trie nodes of an account
+// mined in a block.
+type StorageTrieNoncesResult struct {
+	Address common.Address    `json:"address"`
+	Nonces  []*impt.TrieNonce `json:"nonces"`
+}
+
+// GetTrieNonces returns the trie node nonces of a block, each with the hash the
+// node had before it was mined and the hash it was mined into. The nodes are
+// looked up in the state of the block, which must still be available.
+func (api *PublicTrieHashimotoAPI) GetTrieNonces(blockNr rpc.BlockNumber) (*TrieNoncesResult, error) {
+	block, err := api.blockByNumber(blockNr)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		config = api.eth.blockchain.Config().TrieHashimoto
+		triedb = api.eth.blockchain.StateCache().TrieDB()
+		number = block.NumberU64()
+	)
+	nodes, err := triedb.MinedNodes(config, block.Root(), number)
+	if err != nil {
+		return nil, err
+	}
+	result := &TrieNoncesResult{
+		Number:        hexutil.Uint64(number),
+		Hash:          block.Hash(),
+		StorageNonces: make([]StorageTrieNoncesResult, 0, len(block.StorageNonces())),
+	}
+	if result.TrieNonces, err = matchTrieNonces(nodes, block.TrieNonces()); err != nil {
+		return nil, fmt.Errorf("account trie: %v", err)
+	}
+	if len(block.StorageNonces()) == 0 {
+		return result, nil
+	}
+	accounts, err := api.eth.blockchain.StateCache().OpenTrie(block.Root())
+	if err != nil {
+		return nil, err
+	}
+	for _, storage := range block.StorageNonces() {
+		enc, err := accounts.TryGet(storage.Address[:])
+		if err != nil {
+			return nil, err
+		}
+		var account state.Account
+		if err := rlp.DecodeBytes(enc, &account); err != nil {
+			return nil, fmt.Errorf("account %x: %v", storage.Address, err)
+		}
+		nodes, err := triedb.MinedNodes(config, account.Root, number)
+		if err != nil {
+			return nil, err
+		}
+		nonces, err := matchTrieNonces(nodes, storage.Nonces)
+		if err != nil {
+			return nil, fmt.Errorf("storage trie of %x: %v", storage.Address, err)
+		}
+		result.StorageNonces = append(result.StorageNonces, StorageTrieNoncesResult{Address: storage.Address, Nonces: nonces})
+	}
+	return result, nil
+}
+
+// matchTrieNonces pairs the nonces listed in a block with the nodes of a trie
+// mined in it, which must match them one to one.
+func matchTrieNonces(nodes []trie.MinedNode, nonces []uint64) ([]*impt.TrieNonce, error) {
+	if len(nodes) != len(nonces) {
+		return nil, fmt.Errorf("%d trie nodes mined, %d nonces listed", len(nodes), len(nonces))
+	}
+	result := make([]*impt.TrieNonce, len(nonces))
+	for i, node := range nodes {
+		if node.Nonce != nonces[i] {
+			return nil, fmt.Errorf("trie node %x nonce %d mismatch, listed %d", node.Hash, node.Nonce, nonces[i])
+		}
+		result[i] = impt.NewTrieNonce(node.OriginalHash, node.Hash, node.Nonce)
+	}
+	return result, nil
+}
+
+// TrieNodeResult is a decoded trie node. Child references are either hashes or
+// the encodings of the children embedded in the node, empty if missing.
+type TrieNodeResult struct {
+	Hash         common.Hash     `json:"hash"`
+	OriginalHash common.Hash     `json:"originalHash"`
+	Type         string          `json:"type"`
+	Key          hexutil.Bytes   `json:"key,omitempty"`
+	Value        hexutil.Bytes   `json:"value,omitempty"`
+	Children     []hexutil.Bytes `json:"children,omitempty"`
+	Nonce        hexutil.Uint64  `json:"nonce"`
+	PrefixBlock  *hexutil.Uint64 `json:"prefixBlock"`
+	Valid        bool            `json:"valid"`
+	Encoding     hexutil.Bytes   `json:"encoding"`
+}
+
+// GetNode returns the trie node with the given hash, decoded, along with its
+// nonce and the block its hash prefix refers to. The key of a short node is
+// given in nibbles, with a trailing 0x10 if it's a leaf. The prefix block is the
+// one the node age index records, or else the latest block up to the head with
+// the prefix of the hash, and is null if no block since Trie-Hashimoto
+// activation has it. Nodes hashed without a prefix, like those of the genesis
+// state, still get the block their hash happens to start with. The node is valid
+// if its encoding hashes to the given hash under the Trie-Hashimoto rules.
+func (api *PublicTrieHashimotoAPI) GetNode(hash common.Hash) (*TrieNodeResult, error) {
+	blob, err := api.eth.blockchain.StateCache().TrieDB().Node(hash)
+	if err != nil {
+		return nil, fmt.Errorf("trie node %x not found", hash)
+	}
+	info, err := trie.DecodeNodeInfo(blob)
+	if err != nil {
+		return nil, fmt.Errorf("trie node %x: %v", hash, err)
+	}
+	result := &TrieNodeResult{
+		Hash:         hash,
+		OriginalHash: trie.OriginalNodeHash(blob),
+		Type:         "short",
+		Key:          info.Key,
+		Value:        info.Value,
+		Nonce:        hexutil.Uint64(info.Nonce),
+		Encoding:     blob,
+	}
+	if info.Full {
+		result.Type = "full"
+	}
+	for _, child := range info.Children {
+		result.Children = append(result.Children, child)
+	}
+	var (
+		config = api.eth.blockchain.Config().TrieHashimoto
+		head   = api.eth.blockchain.CurrentBlock().NumberU64()
+	)
+	if number, ok := trie.NodeAge(api.eth.chainDb, config, hash, head); ok {
+		result.PrefixBlock = (*hexutil.Uint64)(&number)
+	}
+	result.Valid = trie.VerifyNodeHash(config, hash, blob, head, nil) == nil
+	return result, nil
+}
+
+// TrieMiningStatus is the progress of the trie node mining in flight.
+type TrieMiningStatus struct {
+	Mining         bool           `json:"mining"`
+	Number         hexutil.Uint64 `json:"number"`
+	TrieDifficulty *hexutil.Big   `json:"trieDifficulty"`
+	Threads        int            `json:"threads"`
+	NodesMined     hexutil.Uint64 `json:"nodesMined"`
+	Elapsed        float64        `json:"elapsed"`
+	TrieHashrate   hexutil.Uint64 `json:"trieHashrate"`
+	LastNumber     hexutil.Uint64 `json:"lastNumber"`
+	LastNodes      hexutil.Uint64 `json:"lastNodes"`
+	LastElapsed    float64        `json:"lastElapsed"`
+}
+
+// MiningStatus returns the progress of the trie node mining in flight: the block
+// whose dirty nodes are mined, the nodes mined so far and the time elapsed in
+// seconds, along with the outcome of the latest completed round.
+func (api *PublicTrieHashimotoAPI) MiningStatus() (*TrieMiningStatus, error) {
+	engine, ok := api.eth.engine.(*ethash.Ethash)
+	if !ok {
+		return nil, errTrieMiningUnsupported
+	}
+	return trieMiningStatus(engine), nil
+}
+
+// MiningProgress creates a subscription that is notified of the trie mining
+// status every second while the trie nodes of a block are being mined, and once
+// more when the round completes.
+func (api *PublicTrieHashimotoAPI) MiningProgress(ctx context.Context) (*rpc.Subscription, error) {
+	engine, ok := api.eth.engine.(*ethash.Ethash)
+	if !ok {
+		return nil, errTrieMiningUnsupported
+	}
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	rpcSub := notifier.CreateSubscription()
+
+	go func() {
+		ticker := time.NewTicker(trieProgressInterval)
+		defer ticker.Stop()
+
+		prev := trieMiningStatus(engine)
+		for {
+			select {
+			case <-ticker.C:
+				// Rounds shorter than the interval are only seen completed
+				status := trieMiningStatus(engine)
+				completed := status.LastNumber != prev.LastNumber || status.LastElapsed != prev.LastElapsed
+				if status.Mining || prev.Mining || completed {
+					notifier.Notify(rpcSub.ID, status)
+				}
+				prev = status
+
+			case <-rpcSub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+	return rpcSub, nil
+}
+
+// trieMiningStatus assembles the trie mining status of the engine.
+func trieMiningStatus(engine *ethash.Ethash) *TrieMiningStatus {
+	var (
+		status = engine.TrieMiningStatus()
+		last   = engine.LastTrieMining()
+	)
+	result := &TrieMiningStatus{
+		Mining:       status.Mining,
+		Number:       hexutil.Uint64(status.Number),
+		Threads:      status.Threads,
+		NodesMined:   hexutil.Uint64(status.Mined),
+		Elapsed:      status.Elapsed.Seconds(),
+		TrieHashrate: hexutil.Uint64(engine.TrieHashrate()),
+		LastNumber:   hexutil.Uint64(last.Number),
+		LastNodes:    hexutil.Uint64(last.Nodes),
+		LastElapsed:  last.Elapsed.Seconds(),
+	}
+	if status.Difficulty != nil {
+		result.TrieDifficulty = (*hexutil.Big)(status.Difficulty)
+	}
+	return result
+}
+
+// blockByNumber returns the block with the given number, the latest one for
+// rpc.LatestBlockNumber. Pending blocks aren't mined yet so have no trie nonces.
+func (api *PublicTrieHashimotoAPI) blockByNumber(blockNr rpc.BlockNumber) (*types.Block, error) {
+	var block *types.Block
+	switch blockNr {
+	case rpc.PendingBlockNumber:
+		return nil, errors.New("pending block not mined yet")
+	case rpc.LatestBlockNumber:
+		block = api.eth.blockchain.CurrentBlock()
+	default:
+		block = api.eth.blockchain.GetBlockByNumber(uint64(blockNr))
+	}
+	if block == nil {
+		return nil, fmt.Errorf("block #%d not found", blockNr)
+	}
+	return block, nil
+}
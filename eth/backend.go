@@ -51,6 +51,7 @@ import (
 	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
 )
 
 type LesServer interface {
@@ -143,6 +144,19 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 	}
 	log.Info("Initialised chain configuration", "config", chainConfig)
 
+	var prefixLength int
+	if chainConfig.TrieHashimoto != nil {
+		prefixLength = chainConfig.TrieHashimoto.PrefixLength
+	}
+	sharder, err := trie.ParseTrieNodeSharder(config.THShards, prefixLength)
+	if err != nil {
+		return nil, err
+	}
+	shards := sharder.Shards()
+	if chainDb, err = rawdb.NewShardedDatabase(chainDb, ctx.ResolvePath(THShardsDir), sharder, config.DatabaseCache/shards, config.DatabaseHandles/shards, "eth/db/chaindata/"); err != nil {
+		return nil, err
+	}
+
 	eth := &Ethereum{
 		config:         config,
 		chainDb:        chainDb,
@@ -157,6 +171,11 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 		bloomIndexer:   NewBloomIndexer(chainDb, params.BloomBitsBlocks, params.BloomConfirms),
 	}
 
+	switch config.THVerifier {
+	case "", THVerifierFull, THVerifierLight:
+	default:
+		return nil, fmt.Errorf("invalid trie-hashimoto verifier %q, want %q or %q", config.THVerifier, THVerifierFull, THVerifierLight)
+	}
 	bcVersion := rawdb.ReadDatabaseVersion(chainDb)
 	var dbVer = "<nil>"
 	if bcVersion != nil {
@@ -184,6 +203,10 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 			TrieDirtyLimit:      config.TrieDirtyCache,
 			TrieDirtyDisabled:   config.NoPruning,
 			TrieTimeLimit:       config.TrieTimeout,
+			THDatasetDir:        ctx.ResolvePath(config.THDatasetDir),
+			THLightVerifier:     config.THVerifier == THVerifierLight,
+			THKeepRecent:        config.THKeepRecent,
+			THColdAfter:         config.THColdAfter,
 		}
 	)
 	eth.blockchain, err = core.NewBlockChain(chainDb, cacheConfig, chainConfig, eth.engine, vmConfig, eth.shouldPreserve)
@@ -312,6 +335,11 @@ func (s *Ethereum) APIs() []rpc.API {
 			Version:   "1.0",
 			Service:   NewPrivateMinerAPI(s),
 			Public:    false,
+		}, {
+			Namespace: "th",
+			Version:   "1.0",
+			Service:   NewPublicTrieHashimotoAPI(s),
+			Public:    true,
 		}, {
 			Namespace: "eth",
 			Version:   "1.0",
@@ -452,6 +480,11 @@ func (s *Ethereum) StartMining(threads int) error {
 		s.lock.RUnlock()
 		s.txPool.SetGasPrice(price)
 
+		// Trie node mining needs the whole header dataset in memory
+		if th := s.blockchain.Config().TrieHashimoto; th != nil && !th.Fake && th.ReadHeader && s.config.THVerifier == THVerifierLight {
+			log.Error("Cannot mine trie nodes with the light verifier", "verifier", s.config.THVerifier)
+			return fmt.Errorf("mining requires --th.verifier=%s", THVerifierFull)
+		}
 		// Configure the local mining address
 		eb, err := s.Etherbase()
 		if err != nil {
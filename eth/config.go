@@ -33,6 +33,16 @@ import (
 	"github.com/ethereum/go-ethereum/params"
 )
 
+// Trie-Hashimoto trie node nonce verifiers.
+const (
+	THVerifierFull  = "full"  // Verify against the header dataset kept in memory, the default
+	THVerifierLight = "light" // Verify reading the accessed header dataset rows from the database
+)
+
+// THShardsDir is the directory of the trie node shard stores, next to the chain
+// database.
+const THShardsDir = "thshards"
+
 // DefaultConfig contains default settings for use on the Ethereum main net.
 var DefaultConfig = Config{
 	SyncMode: downloader.FastSync,
@@ -49,6 +59,8 @@ var DefaultConfig = Config{
 	TrieCleanCache: 256,
 	TrieDirtyCache: 256,
 	TrieTimeout:    60 * time.Minute,
+	THDatasetDir:   "thdataset",
+	THVerifier:     THVerifierFull,
 	Miner: miner.Config{
 		GasFloor: 8000000,
 		GasCeil:  8000000,
@@ -120,6 +132,13 @@ type Config struct {
 	TrieDirtyCache int
 	TrieTimeout    time.Duration
 
+	// Trie-Hashimoto options
+	THDatasetDir string // Header dataset directory
+	THVerifier   string // Trie node nonce verifier, THVerifierFull or THVerifierLight
+	THKeepRecent uint64 // Number of recent states the online trie node pruner keeps, 0 to disable it
+	THColdAfter  uint64 // Number of blocks after which trie nodes move into the cold store, 0 to keep them hot
+	THShards     string // Trie node sharding policy, see trie.ParseTrieNodeSharder
+
 	// Mining options
 	Miner miner.Config
 
@@ -90,6 +90,7 @@ var (
 	errCancelContentProcessing = errors.New("content processing canceled (requested)")
 	errCanceled                = errors.New("syncing canceled (requested)")
 	errNoSyncActive            = errors.New("no sync active")
+	errNoRangeFetch            = errors.New("no prefix range fetch active")
 	errTooOld                  = errors.New("peer doesn't speak recent enough protocol version (need version >= 62)")
 )
 
@@ -143,6 +144,7 @@ type Downloader struct {
 	stateSyncStart chan *stateSync
 	trackStateReq  chan *stateReq
 	stateCh        chan dataPack // [eth/63] Channel receiving inbound node state data
+	rangeCh        chan dataPack // Channel receiving inbound prefix range node data
 
 	// Cancellation and termination
 	cancelPeer string         // Identifier of the peer currently being used as the master (cancel on drop)
@@ -208,6 +210,9 @@ type BlockChain interface {
 
 	// InsertReceiptChain inserts a batch of receipts into the local chain.
 	InsertReceiptChain(types.Blocks, []types.Receipts, uint64) (int, error)
+
+	// Config retrieves the chain configuration, telling how state nodes are hashed.
+	Config() *params.ChainConfig
 }
 
 // New creates a new downloader to fetch hashes and blocks from remote peers.
@@ -235,6 +240,7 @@ func New(checkpoint uint64, stateDb ethdb.Database, stateBloom *trie.SyncBloom,
 		headerProcCh:   make(chan []*types.Header, 1),
 		quitCh:         make(chan struct{}),
 		stateCh:        make(chan dataPack),
+		rangeCh:        make(chan dataPack),
 		stateSyncStart: make(chan *stateSync),
 		syncStatsState: stateSyncStats{
 			processed: rawdb.ReadFastTrieProgress(stateDb),
@@ -1122,7 +1128,7 @@ func (d *Downloader) fetchBodies(from uint64) error {
 	var (
 		deliver = func(packet dataPack) (int, error) {
 			pack := packet.(*bodyPack)
-			return d.queue.DeliverBodies(pack.peerID, pack.transactions, pack.uncles, pack.trieNonces)
+			return d.queue.DeliverBodies(pack.peerID, pack.transactions, pack.uncles, pack.trieNonces, pack.storageNonces)
 		}
 		expire   = func() map[string]int { return d.queue.ExpireBodies(d.requestTTL()) }
 		fetch    = func(p *peerConnection, req *fetchRequest) error { return p.FetchBodies(req) }
@@ -1567,7 +1573,7 @@ func (d *Downloader) importBlockResults(results []*fetchResult) error {
 	)
 	blocks := make([]*types.Block, len(results))
 	for i, result := range results {
-		blocks[i] = types.NewBlockWithHeader(result.Header).WithBody(result.Transactions, result.Uncles, result.TrieNonces)
+		blocks[i] = types.NewBlockWithHeader(result.Header).WithBody(result.Transactions, result.Uncles, result.TrieNonces, result.StorageNonces)
 	}
 	if index, err := d.blockchain.InsertChain(blocks); err != nil {
 		if index < len(results) {
@@ -1588,8 +1594,17 @@ func (d *Downloader) importBlockResults(results []*fetchResult) error {
 // database. It also controls the synchronisation of state nodes of the pivot block.
 func (d *Downloader) processFastSyncContent(latest *types.Header) error {
 	// Start syncing state of the reported head block. This should get us most of
-	// the state of the pivot block.
-	stateSync := d.syncState(latest.Root)
+	// the state of the pivot block. Nodes mixing in the header dataset can't be
+	// verified before the headers up to their block are downloaded though, so
+	// their state download waits for the pivot.
+	var stateSync *stateSync
+	if th := d.blockchain.Config().TrieHashimoto; th != nil && th.ReadHeader && !th.Fake {
+		stateSync = newStateSync(d, latest.Root)
+		stateSync.err = errCancelStateFetch
+		close(stateSync.done)
+	} else {
+		stateSync = d.syncState(latest, nil)
+	}
 	defer stateSync.Cancel()
 	go func() {
 		if err := stateSync.Wait(); err != nil && err != errCancelStateFetch && err != errCanceled {
@@ -1613,9 +1628,14 @@ func (d *Downloader) processFastSyncContent(latest *types.Header) error {
 		// block became stale, move the goalpost
 		results := d.queue.Results(oldPivot == nil) // Block if we're not monitoring pivot staleness
 		if len(results) == 0 {
-			// If pivot sync is done, stop
+			// If pivot sync is done, stop. Without a pivot block, on chains shorter
+			// than fsMinFullBlocks, all blocks were fully imported and the initial
+			// state download of the head is just dropped.
 			if oldPivot == nil {
-				return stateSync.Cancel()
+				if err := stateSync.Cancel(); err != errCancelStateFetch {
+					return err
+				}
+				return nil
 			}
 			// If sync failed, stop
 			select {
@@ -1633,12 +1653,11 @@ func (d *Downloader) processFastSyncContent(latest *types.Header) error {
 		}
 		// Split around the pivot block and process the two sides via fast/full sync
 		if atomic.LoadInt32(&d.committed) == 0 {
-			// deprecate underlines to limit fast sync boundary (jmlee)
-			// latest = results[len(results)-1].Header
-			// if height := latest.Number.Uint64(); height > pivot+2*uint64(fsMinFullBlocks) {
-			// 	log.Warn("Pivot became stale, moving", "old", pivot, "new", height-uint64(fsMinFullBlocks))
-			// 	pivot = height - uint64(fsMinFullBlocks)
-			// }
+			latest = results[len(results)-1].Header
+			if height := latest.Number.Uint64(); height > pivot+2*uint64(fsMinFullBlocks) {
+				log.Warn("Pivot became stale, moving", "old", pivot, "new", height-uint64(fsMinFullBlocks))
+				pivot = height - uint64(fsMinFullBlocks)
+			}
 		}
 		P, beforeP, afterP := splitAroundPivot(pivot, results)
 		if err := d.commitFastSyncData(beforeP, stateSync); err != nil {
@@ -1649,7 +1668,12 @@ func (d *Downloader) processFastSyncContent(latest *types.Header) error {
 			if oldPivot != P {
 				stateSync.Cancel()
 
-				stateSync = d.syncState(P.Header.Root)
+				// Catch up on the nodes mined since a stale pivot by block range
+				var stale *types.Header
+				if oldPivot != nil {
+					stale = oldPivot.Header
+				}
+				stateSync = d.syncState(P.Header, stale)
 				defer stateSync.Cancel()
 				go func() {
 					if err := stateSync.Wait(); err != nil && err != errCancelStateFetch && err != errCanceled {
@@ -1719,7 +1743,7 @@ func (d *Downloader) commitFastSyncData(results []*fetchResult, stateSync *state
 	blocks := make([]*types.Block, len(results))
 	receipts := make([]types.Receipts, len(results))
 	for i, result := range results {
-		blocks[i] = types.NewBlockWithHeader(result.Header).WithBody(result.Transactions, result.Uncles, result.TrieNonces)
+		blocks[i] = types.NewBlockWithHeader(result.Header).WithBody(result.Transactions, result.Uncles, result.TrieNonces, result.StorageNonces)
 		receipts[i] = result.Receipts
 	}
 	if index, err := d.blockchain.InsertReceiptChain(blocks, receipts, d.ancientLimit); err != nil {
@@ -1730,7 +1754,7 @@ func (d *Downloader) commitFastSyncData(results []*fetchResult, stateSync *state
 }
 
 func (d *Downloader) commitPivotBlock(result *fetchResult) error {
-	block := types.NewBlockWithHeader(result.Header).WithBody(result.Transactions, result.Uncles, result.TrieNonces)
+	block := types.NewBlockWithHeader(result.Header).WithBody(result.Transactions, result.Uncles, result.TrieNonces, result.StorageNonces)
 	log.Debug("Committing fast sync pivot as new head", "number", block.Number(), "hash", block.Hash())
 
 	// Commit the pivot block as the new head, will require full sync from here on
@@ -1760,8 +1784,8 @@ func (d *Downloader) DeliverHeaders(id string, headers []*types.Header) (err err
 }
 
 // DeliverBodies injects a new batch of block bodies received from a remote node.
-func (d *Downloader) DeliverBodies(id string, transactions [][]*types.Transaction, uncles [][]*types.Header, trieNonces [][]uint64) (err error) {
-	return d.deliver(id, d.bodyCh, &bodyPack{id, transactions, uncles, trieNonces}, bodyInMeter, bodyDropMeter)
+func (d *Downloader) DeliverBodies(id string, transactions [][]*types.Transaction, uncles [][]*types.Header, trieNonces [][]uint64, storageNonces [][]types.StorageTrieNonces) (err error) {
+	return d.deliver(id, d.bodyCh, &bodyPack{id, transactions, uncles, trieNonces, storageNonces}, bodyInMeter, bodyDropMeter)
 }
 
 // DeliverReceipts injects a new batch of receipts received from a remote node.
@@ -1774,6 +1798,20 @@ func (d *Downloader) DeliverNodeData(id string, data [][]byte) (err error) {
 	return d.deliver(id, d.stateCh, &statePack{id, data}, stateInMeter, stateDropMeter)
 }
 
+// DeliverNodesByPrefixRange injects a batch of trie nodes mined in a block range
+// received from a remote node. Unlike the other deliveries, it's only accepted
+// while a range fetch is waiting for it, late replies are dropped.
+func (d *Downloader) DeliverNodesByPrefixRange(id string, nodes []trie.AgedNode) error {
+	rangeInMeter.Mark(int64(len(nodes)))
+	select {
+	case d.rangeCh <- &rangePack{id, nodes}:
+		return nil
+	default:
+		rangeDropMeter.Mark(int64(len(nodes)))
+		return errNoRangeFetch
+	}
+}
+
 // deliver injects a new batch of data received from a remote node.
 func (d *Downloader) deliver(id string, destCh chan dataPack, packet dataPack, inMeter, dropMeter metrics.Meter) (err error) {
 	// Update the delivery metrics for both good and failed deliveries
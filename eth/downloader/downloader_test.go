@@ -17,6 +17,7 @@
 package downloader
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"math/big"
@@ -28,10 +29,15 @@ import (
 
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
 	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/trie"
 )
 
@@ -62,6 +68,9 @@ type downloadTester struct {
 	ancientReceipts map[common.Hash]types.Receipts // Ancient receipts belonging to the tester
 	ancientChainTd  map[common.Hash]*big.Int       // Ancient total difficulties of the blocks in the local chain
 
+	invalidTrieNonces common.Hash         // Block whose trie nonces fail verification on import
+	config            *params.ChainConfig // Chain configuration, params.TestChainConfig if nil
+
 	lock sync.RWMutex
 }
 
@@ -238,13 +247,24 @@ func (dl *downloadTester) GetTd(hash common.Hash, number uint64) *big.Int {
 	return dl.ownChainTd[hash]
 }
 
+// hasHeaderLocked checks if a header is present in the active or the ancient
+// chain, the parent of a header batch may have been migrated to the ancients
+// by a concurrent receipt import. The lock must be held by the caller.
+func (dl *downloadTester) hasHeaderLocked(hash common.Hash) bool {
+	if _, ok := dl.ownHeaders[hash]; ok {
+		return true
+	}
+	_, ok := dl.ancientHeaders[hash]
+	return ok
+}
+
 // InsertHeaderChain injects a new batch of headers into the simulated chain.
 func (dl *downloadTester) InsertHeaderChain(headers []*types.Header, checkFreq int) (i int, err error) {
 	dl.lock.Lock()
 	defer dl.lock.Unlock()
 
 	// Do a quick check, as the blockchain.InsertHeaderChain doesn't insert anything in case of errors
-	if _, ok := dl.ownHeaders[headers[0].ParentHash]; !ok {
+	if !dl.hasHeaderLocked(headers[0].ParentHash) {
 		return 0, errors.New("unknown parent")
 	}
 	for i := 1; i < len(headers); i++ {
@@ -254,15 +274,19 @@ func (dl *downloadTester) InsertHeaderChain(headers []*types.Header, checkFreq i
 	}
 	// Do a full insert if pre-checks passed
 	for i, header := range headers {
-		if _, ok := dl.ownHeaders[header.Hash()]; ok {
+		if dl.hasHeaderLocked(header.Hash()) {
 			continue
 		}
-		if _, ok := dl.ownHeaders[header.ParentHash]; !ok {
+		if !dl.hasHeaderLocked(header.ParentHash) {
 			return i, errors.New("unknown parent")
 		}
+		parentTd := dl.ownChainTd[header.ParentHash]
+		if parentTd == nil {
+			parentTd = dl.ancientChainTd[header.ParentHash]
+		}
 		dl.ownHashes = append(dl.ownHashes, header.Hash())
 		dl.ownHeaders[header.Hash()] = header
-		dl.ownChainTd[header.Hash()] = new(big.Int).Add(dl.ownChainTd[header.ParentHash], header.Difficulty)
+		dl.ownChainTd[header.Hash()] = new(big.Int).Add(parentTd, header.Difficulty)
 	}
 	return len(headers), nil
 }
@@ -278,6 +302,9 @@ func (dl *downloadTester) InsertChain(blocks types.Blocks) (i int, err error) {
 		} else if _, err := dl.stateDb.Get(parent.Root().Bytes()); err != nil {
 			return i, fmt.Errorf("unknown parent state %x: %v", parent.Root(), err)
 		}
+		if block.Hash() == dl.invalidTrieNonces {
+			return i, consensus.ErrInvalidTrieNonce
+		}
 		if _, ok := dl.ownHeaders[block.Hash()]; !ok {
 			dl.ownHashes = append(dl.ownHashes, block.Hash())
 			dl.ownHeaders[block.Hash()] = block.Header()
@@ -322,6 +349,14 @@ func (dl *downloadTester) InsertReceiptChain(blocks types.Blocks, receipts []typ
 	return len(blocks), nil
 }
 
+// Config retrieves the configuration the simulated chain was generated with.
+func (dl *downloadTester) Config() *params.ChainConfig {
+	if dl.config != nil {
+		return dl.config
+	}
+	return params.TestChainConfig
+}
+
 // Rollback removes some recently added elements from the chain.
 func (dl *downloadTester) Rollback(hashes []common.Hash) {
 	dl.lock.Lock()
@@ -407,8 +442,8 @@ func (dlp *downloadTesterPeer) RequestHeadersByNumber(origin uint64, amount int,
 // peer in the download tester. The returned function can be used to retrieve
 // batches of block bodies from the particularly requested peer.
 func (dlp *downloadTesterPeer) RequestBodies(hashes []common.Hash) error {
-	txs, uncles := dlp.chain.bodies(hashes)
-	go dlp.dl.downloader.DeliverBodies(dlp.id, txs, uncles)
+	txs, uncles, trieNonces, storageNonces := dlp.chain.bodies(hashes)
+	go dlp.dl.downloader.DeliverBodies(dlp.id, txs, uncles, trieNonces, storageNonces)
 	return nil
 }
 
@@ -732,7 +767,7 @@ func TestInactiveDownloader62(t *testing.T) {
 	if err := tester.downloader.DeliverHeaders("bad peer", []*types.Header{}); err != errNoSyncActive {
 		t.Errorf("error mismatch: have %v, want %v", err, errNoSyncActive)
 	}
-	if err := tester.downloader.DeliverBodies("bad peer", [][]*types.Transaction{}, [][]*types.Header{}); err != errNoSyncActive {
+	if err := tester.downloader.DeliverBodies("bad peer", [][]*types.Transaction{}, [][]*types.Header{}, [][]uint64{}, [][]types.StorageTrieNonces{}); err != errNoSyncActive {
 		t.Errorf("error mismatch: have %v, want  %v", err, errNoSyncActive)
 	}
 }
@@ -749,7 +784,7 @@ func TestInactiveDownloader63(t *testing.T) {
 	if err := tester.downloader.DeliverHeaders("bad peer", []*types.Header{}); err != errNoSyncActive {
 		t.Errorf("error mismatch: have %v, want %v", err, errNoSyncActive)
 	}
-	if err := tester.downloader.DeliverBodies("bad peer", [][]*types.Transaction{}, [][]*types.Header{}); err != errNoSyncActive {
+	if err := tester.downloader.DeliverBodies("bad peer", [][]*types.Transaction{}, [][]*types.Header{}, [][]uint64{}, [][]types.StorageTrieNonces{}); err != errNoSyncActive {
 		t.Errorf("error mismatch: have %v, want %v", err, errNoSyncActive)
 	}
 	if err := tester.downloader.DeliverReceipts("bad peer", [][]*types.Receipt{}); err != errNoSyncActive {
@@ -1142,6 +1177,33 @@ func testBlockHeaderAttackerDropping(t *testing.T, protocol int) {
 	}
 }
 
+// Tests that the sync peer is dropped if a block of its chain fails to import on
+// invalid trie nonces. Bodies are only accepted if they match the trie nonces
+// commitment of their header, so it's the chain of the sync peer that's bogus.
+func TestInvalidTrieNoncesDropping62(t *testing.T) { testInvalidTrieNoncesDropping(t, 62) }
+func TestInvalidTrieNoncesDropping63(t *testing.T) { testInvalidTrieNoncesDropping(t, 63) }
+func TestInvalidTrieNoncesDropping64(t *testing.T) { testInvalidTrieNoncesDropping(t, 64) }
+
+func testInvalidTrieNoncesDropping(t *testing.T, protocol int) {
+	t.Parallel()
+
+	tester := newTester()
+	defer tester.terminate()
+
+	chain := testChainBase.shorten(blockCacheItems - 15)
+	tester.invalidTrieNonces = chain.chain[chain.len()/2]
+	tester.newPeer("peer", protocol, chain)
+
+	head := chain.headBlock().Hash()
+	if err := tester.downloader.Synchronise("peer", head, chain.td(head), FullSync); err != errInvalidChain {
+		t.Fatalf("sync error mismatch: have %v, want %v", err, errInvalidChain)
+	}
+	if _, ok := tester.peers["peer"]; ok {
+		t.Fatalf("peer with invalid trie nonces not dropped")
+	}
+	assertOwnChain(t, tester, chain.len()/2)
+}
+
 // Tests that synchronisation progress (origin block number, current block number
 // and highest block number) is tracked and updated correctly.
 func TestSyncProgress62(t *testing.T)      { testSyncProgress(t, 62, FullSync) }
@@ -1656,3 +1718,320 @@ func testCheckpointEnforcement(t *testing.T, protocol int, mode SyncMode) {
 		assertOwnChain(t, tester, chain.len())
 	}
 }
+
+// Tests that state nodes mined with Trie-Hashimoto are matched to the items they
+// were requested for in request order, skipping items the peer didn't deliver,
+// and that a junk blob stops matching the rest of the response by mined hash
+// instead of verifying every following blob against every pending item.
+func TestTrieHashimotoStateDelivery(t *testing.T) {
+	t.Parallel()
+
+	// Mine an account trie past the first prefix epoch, so that the hashes of its
+	// nodes don't match their plain hashes
+	var (
+		config       = &params.TrieHashimotoConfig{PrefixLength: 1, LoopAccesses: 1}
+		number       = uint64(259)
+		srcDb, roots = newTrieHashimotoTestState(t, config, number)
+		root         = roots[0]
+	)
+	node := func(hash common.Hash) []byte {
+		blob, err := srcDb.Node(hash)
+		if err != nil {
+			t.Fatalf("missing node %x: %v", hash, err)
+		}
+		return blob
+	}
+	tester := newTester()
+	defer tester.terminate()
+	tester.newPeer("peer", 63, testChainBase.shorten(1))
+
+	s := newStateSync(tester.downloader, root)
+	s.sched.SetTrieHashimoto(config, number, nil)
+	s.th = config
+
+	deliver := func(items []common.Hash, response [][]byte) int {
+		req := &stateReq{items: items, tasks: make(map[common.Hash]*stateTask), peer: &peerConnection{id: "peer"}, response: response}
+		for _, hash := range items {
+			req.tasks[hash] = &stateTask{attempts: make(map[string]struct{})}
+			delete(s.tasks, hash)
+		}
+		successful, err := s.process(req)
+		if err != nil {
+			t.Fatalf("failed to process response: %v", err)
+		}
+		return successful
+	}
+	if n := deliver([]common.Hash{root}, [][]byte{node(root)}); n != 1 {
+		t.Fatalf("root delivery: have %d nodes, want 1", n)
+	}
+	children := s.sched.Missing(0)
+	if len(children) < 4 {
+		t.Fatalf("root node too small: %d children", len(children))
+	}
+	// A junk blob ahead of valid nodes must make the rest of the response unmatched
+	response := [][]byte{[]byte("junk")}
+	for _, hash := range children {
+		response = append(response, node(hash))
+	}
+	if n := deliver(children, response); n != 0 {
+		t.Fatalf("junk delivery: have %d nodes, want 0", n)
+	}
+	if len(s.tasks) != len(children) {
+		t.Fatalf("junk delivery: have %d tasks re-queued, want %d", len(s.tasks), len(children))
+	}
+	// Nodes delivered in order must be matched, even if the peer skipped some
+	response = response[:0]
+	for i := 1; i < len(children); i += 2 {
+		response = append(response, node(children[i]))
+	}
+	if n := deliver(children, response); n != len(response) {
+		t.Fatalf("partial delivery: have %d nodes, want %d", n, len(response))
+	}
+	if want := len(children) - len(response); len(s.tasks) != want {
+		t.Fatalf("partial delivery: have %d tasks re-queued, want %d", len(s.tasks), want)
+	}
+}
+
+// newTrieHashimotoTestState mines an account trie with enough accounts for a full
+// root node in each of the given blocks, updating a few accounts in every block
+// after the first, and flushes them along with the node age index. It returns
+// the node database and the state roots of the blocks.
+func newTrieHashimotoTestState(t *testing.T, config *params.TrieHashimotoConfig, numbers ...uint64) (*trie.Database, []common.Hash) {
+	t.Helper()
+
+	triedb := trie.NewDatabase(rawdb.NewMemoryDatabase())
+	accounts, _ := trie.New(common.Hash{}, triedb)
+	update := func(i int, balance int64) {
+		blob, _ := rlp.EncodeToBytes(&state.Account{
+			Nonce:    uint64(i),
+			Balance:  big.NewInt(balance),
+			Root:     types.EmptyRootHash,
+			CodeHash: crypto.Keccak256(nil),
+		})
+		accounts.Update(crypto.Keccak256([]byte{byte(i)}), blob)
+	}
+	for i := 0; i < 64; i++ {
+		update(i, int64(i+1))
+	}
+	var roots []common.Hash
+	for n, number := range numbers {
+		for i := 0; n > 0 && i < 4; i++ {
+			update((4*n+i)%64, int64(number))
+		}
+		root, _, err := accounts.HashWithNonce(config, number, nil, &trie.Miner{Threads: 1})
+		if err != nil {
+			t.Fatalf("block %d: failed to mine account trie: %v", number, err)
+		}
+		if _, err := accounts.Commit(nil); err != nil {
+			t.Fatalf("block %d: failed to commit account trie: %v", number, err)
+		}
+		triedb.IndexNodeAge(config.PrefixLength, number)
+		if err := triedb.Commit(root, false); err != nil {
+			t.Fatalf("block %d: failed to flush account trie: %v", number, err)
+		}
+		roots = append(roots, root)
+	}
+	return triedb, roots
+}
+
+// rangeTestPeer is a download tester peer which also serves the trie nodes mined
+// in a block range, a few at a time, optionally after a late reply of a peer
+// tried before.
+type rangeTestPeer struct {
+	Peer
+	tester *downloadTester
+	id     string
+	triedb *trie.Database
+	late   string // Peer to deliver a late reply of before the first range
+}
+
+func (rtp *rangeTestPeer) RequestNodesByPrefixRange(from uint64, origin common.Hash, to uint64) error {
+	if rtp.late != "" {
+		rtp.deliver(rtp.late, []trie.AgedNode{})
+		rtp.late = ""
+	}
+	rtp.deliver(rtp.id, rtp.triedb.NodesByAge(from, origin, to, 4, 1<<20))
+	return nil
+}
+
+// deliver hands the nodes to the downloader as soon as a range fetch waits for
+// them, giving up after a while.
+func (rtp *rangeTestPeer) deliver(id string, nodes []trie.AgedNode) {
+	for i := 0; i < 1000; i++ {
+		if rtp.tester.downloader.DeliverNodesByPrefixRange(id, nodes) == nil {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Tests that the state mined since a stale pivot is caught up by block range,
+// ignoring late replies of other peers, and that the caught up nodes are fed
+// into the state sync of the new pivot without requesting them.
+func TestTrieHashimotoCatchUp(t *testing.T) {
+	t.Parallel()
+
+	config := &params.TrieHashimotoConfig{PrefixLength: 1, LoopAccesses: 1}
+	triedb, roots := newTrieHashimotoTestState(t, config, 257, 258, 259)
+
+	tester := newTester()
+	defer tester.terminate()
+	tester.newPeer("peer", 63, testChainBase.shorten(1))
+	tester.downloader.peers.peers["peer"].peer = &rangeTestPeer{
+		Peer:   tester.downloader.peers.peers["peer"].peer,
+		tester: tester,
+		id:     "peer",
+		triedb: triedb,
+		late:   "timed out peer",
+	}
+	s := newStateSync(tester.downloader, roots[2])
+	s.sched.SetTrieHashimoto(config, 259, nil)
+	s.th = config
+	s.pivot, s.stale = &types.Header{Number: big.NewInt(259)}, &types.Header{Number: big.NewInt(257)}
+
+	if err := s.catchUp(); err != nil {
+		t.Fatalf("failed to catch up: %v", err)
+	}
+	mined := make(map[common.Hash]bool)
+	for _, node := range triedb.NodesByAge(258, common.Hash{}, 260, 1000, 1<<20) {
+		mined[node.Hash] = true
+		if _, ok := s.prefetched[node.Hash]; !ok {
+			t.Errorf("node %x mined in block %d not caught up", node.Hash, node.Number)
+		}
+	}
+	if len(s.prefetched) != len(mined) {
+		t.Fatalf("caught up nodes mismatch: have %d, want %d", len(s.prefetched), len(mined))
+	}
+	// The caught up nodes of the new pivot state must leave only older ones missing
+	if err := s.processPrefetched(); err != nil {
+		t.Fatalf("failed to process caught up nodes: %v", err)
+	}
+	if s.numUncommitted == 0 {
+		t.Fatalf("no caught up nodes processed")
+	}
+	var missing []common.Hash
+	for hash := range s.tasks {
+		if mined[hash] {
+			t.Errorf("node %x caught up but still missing", hash)
+		}
+		missing = append(missing, hash)
+	}
+	if len(missing) == 0 || len(s.sched.Missing(0)) != 0 {
+		t.Fatalf("missing nodes not queued as tasks")
+	}
+	// Syncing the rest must complete the state of the new pivot
+	for len(missing) > 0 {
+		for _, hash := range missing {
+			blob, err := triedb.Node(hash)
+			if err != nil {
+				t.Fatalf("missing node %x: %v", hash, err)
+			}
+			if _, _, err := s.sched.Process([]trie.SyncResult{{Hash: hash, Data: blob}}); err != nil {
+				t.Fatalf("failed to process node %x: %v", hash, err)
+			}
+		}
+		missing = s.sched.Missing(0)
+	}
+	if err := s.commit(true); err != nil {
+		t.Fatalf("failed to commit state: %v", err)
+	}
+	synced, err := trie.New(roots[2], trie.NewDatabase(tester.stateDb))
+	if err != nil {
+		t.Fatalf("failed to open synced state: %v", err)
+	}
+	accounts := 0
+	for it := trie.NewIterator(synced.NodeIterator(nil)); it.Next(); {
+		accounts++
+	}
+	if accounts != 64 {
+		t.Fatalf("synced state accounts mismatch: have %d, want 64", accounts)
+	}
+}
+
+// nodeTestPeer is a download tester peer serving the state trie nodes from its
+// own node database.
+type nodeTestPeer struct {
+	Peer
+	tester *downloadTester
+	id     string
+	triedb *trie.Database
+}
+
+func (ntp *nodeTestPeer) RequestNodeData(hashes []common.Hash) error {
+	results := make([][]byte, 0, len(hashes))
+	for _, hash := range hashes {
+		if blob, err := ntp.triedb.Node(hash); err == nil {
+			results = append(results, blob)
+		}
+	}
+	go ntp.tester.downloader.DeliverNodeData(ntp.id, results)
+	return nil
+}
+
+// Tests that the pivot state of a chain whose trie node nonces read the header
+// dataset is verified against the downloaded canonical headers, even though the
+// local header dataset never grows during fast sync, and that the state sync
+// fails instead if the headers before the pivot are missing.
+func TestTrieHashimotoReadHeaderStateSync(t *testing.T) {
+	// Not parallel, the nodes are mined with the global in-memory header dataset
+	var (
+		config = &params.TrieHashimotoConfig{PrefixLength: 1, ReadHeader: true, LoopAccesses: 4}
+		chain  = testChainBase.shorten(65)
+		pivot  = chain.headersByNumber(64, 1, 0)[0]
+		words  []uint32
+	)
+	for _, header := range chain.headersByNumber(0, 64, 0) {
+		blob, _ := rlp.EncodeToBytes(header)
+		for i := 0; i+4 <= len(blob); i += 4 {
+			words = append(words, binary.LittleEndian.Uint32(blob[i:]))
+		}
+	}
+	dataset := common.RLPedBlockHeadersUint32s
+	common.RLPedBlockHeadersUint32s = words
+	triedb, roots := newTrieHashimotoTestState(t, config, pivot.Number.Uint64())
+	common.RLPedBlockHeadersUint32s = dataset
+
+	pivot = types.CopyHeader(pivot)
+	pivot.Root = roots[0]
+
+	chainConfig := *params.TestChainConfig
+	chainConfig.TrieHashimoto = config
+
+	tester := newTester()
+	defer tester.terminate()
+	tester.config = &chainConfig
+	tester.newPeer("peer", 63, chain)
+	tester.downloader.peers.peers["peer"].peer = &nodeTestPeer{
+		Peer:   tester.downloader.peers.peers["peer"].peer,
+		tester: tester,
+		id:     "peer",
+		triedb: triedb,
+	}
+	// Node data is only accepted while a sync is running
+	tester.downloader.cancelLock.Lock()
+	tester.downloader.cancelCh = make(chan struct{})
+	tester.downloader.cancelLock.Unlock()
+
+	// Without the headers before the pivot the nodes can't be verified
+	if err := tester.downloader.syncState(pivot, nil).Wait(); err == nil {
+		t.Fatalf("state synced without the headers before the pivot")
+	}
+	for _, header := range chain.headersByNumber(0, 64, 0) {
+		rawdb.WriteHeader(tester.stateDb, header)
+		rawdb.WriteCanonicalHash(tester.stateDb, header.Hash(), header.Number.Uint64())
+	}
+	if err := tester.downloader.syncState(pivot, nil).Wait(); err != nil {
+		t.Fatalf("failed to sync state: %v", err)
+	}
+	synced, err := trie.New(pivot.Root, trie.NewDatabase(tester.stateDb))
+	if err != nil {
+		t.Fatalf("failed to open synced state: %v", err)
+	}
+	accounts := 0
+	for it := trie.NewIterator(synced.NodeIterator(nil)); it.Next(); {
+		accounts++
+	}
+	if accounts != 64 {
+		t.Fatalf("synced state accounts mismatch: have %d, want 64", accounts)
+	}
+}
@@ -127,6 +127,7 @@ func (p *FakePeer) RequestBodies(hashes []common.Hash) error {
 		txs    [][]*types.Transaction
 		uncles [][]*types.Header
 		trieNonces [][]uint64
+		storageNonces [][]types.StorageTrieNonces
 	)
 	for _, hash := range hashes {
 		block := rawdb.ReadBlock(p.db, hash, *p.hc.GetBlockNumber(hash))
@@ -134,8 +135,9 @@ func (p *FakePeer) RequestBodies(hashes []common.Hash) error {
 		txs = append(txs, block.Transactions())
 		uncles = append(uncles, block.Uncles())
 		trieNonces = append(trieNonces, block.TrieNonces())
+		storageNonces = append(storageNonces, block.StorageNonces())
 	}
-	p.dl.DeliverBodies(p.id, txs, uncles, trieNonces)
+	p.dl.DeliverBodies(p.id, txs, uncles, trieNonces, storageNonces)
 	return nil
 }
 
@@ -90,6 +90,13 @@ type Peer interface {
 	RequestNodeData([]common.Hash) error
 }
 
+// RangePeer is a full peer which can also serve the trie nodes mined in a range
+// of blocks.
+type RangePeer interface {
+	Peer
+	RequestNodesByPrefixRange(from uint64, origin common.Hash, to uint64) error
+}
+
 // lightPeerWrapper wraps a LightPeer struct, stubbing out the Peer-only methods.
 type lightPeerWrapper struct {
 	peer LightPeer
@@ -222,6 +229,18 @@ func (p *peerConnection) FetchNodeData(hashes []common.Hash) error {
 	return nil
 }
 
+// FetchNodesByPrefixRange sends a request for the trie nodes mined in a block
+// range to the remote peer, returning false if the peer can't serve it.
+func (p *peerConnection) FetchNodesByPrefixRange(from uint64, origin common.Hash, to uint64) bool {
+	peer, ok := p.peer.(RangePeer)
+	if !ok {
+		return false
+	}
+	go peer.RequestNodesByPrefixRange(from, origin, to)
+
+	return true
+}
+
 // SetHeadersIdle sets the peer to idle, allowing it to execute new header retrieval
 // requests. Its estimated header retrieval throughput is updated with that measured
 // just now.
@@ -63,6 +63,7 @@ type fetchResult struct {
 	Transactions types.Transactions
 	Receipts     types.Receipts
 	TrieNonces	 []uint64
+	StorageNonces []types.StorageTrieNonces
 }
 
 // queue represents hashes that are either need fetching or are being fetched
@@ -765,7 +766,7 @@ func (q *queue) DeliverHeaders(id string, headers []*types.Header, headerProcCh
 // DeliverBodies injects a block body retrieval response into the results queue.
 // The method returns the number of blocks bodies accepted from the delivery and
 // also wakes any threads waiting for data delivery.
-func (q *queue) DeliverBodies(id string, txLists [][]*types.Transaction, uncleLists [][]*types.Header, trieNonceLists [][]uint64) (int, error) {
+func (q *queue) DeliverBodies(id string, txLists [][]*types.Transaction, uncleLists [][]*types.Header, trieNonceLists [][]uint64, storageNonceLists [][]types.StorageTrieNonces) (int, error) {
 	q.lock.Lock()
 	defer q.lock.Unlock()
 
@@ -773,9 +774,13 @@ func (q *queue) DeliverBodies(id string, txLists [][]*types.Transaction, uncleLi
 		if types.DeriveSha(types.Transactions(txLists[index])) != header.TxHash || types.CalcUncleHash(uncleLists[index]) != header.UncleHash {
 			return errInvalidBody
 		}
+		if !types.TrieNoncesMatch(header, trieNonceLists[index], storageNonceLists[index]) {
+			return errInvalidBody
+		}
 		result.Transactions = txLists[index]
 		result.Uncles = uncleLists[index]
 		result.TrieNonces = trieNonceLists[index]
+		result.StorageNonces = storageNonceLists[index]
 		return nil
 	}
 	return q.deliver(id, q.blockTaskPool, q.blockTaskQueue, q.blockPendPool, q.blockDonePool, bodyReqTimer, len(txLists), reconstruct)
@@ -17,6 +17,7 @@
 package downloader
 
 import (
+	"bytes"
 	"fmt"
 	"hash"
 	"sync"
@@ -25,8 +26,11 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/thdataset"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/trie"
 	"golang.org/x/crypto/sha3"
 )
@@ -57,10 +61,35 @@ type stateSyncStats struct {
 	pending    uint64 // Number of still pending state entries
 }
 
-// syncState starts downloading state with the given root hash.
-func (d *Downloader) syncState(root common.Hash) *stateSync {
+// syncState starts downloading the state of the given pivot block. If the pivot
+// replaces a stale one, the trie nodes mined since are first fetched by block
+// range from the peers serving them. Nodes mixing in the header dataset are
+// verified against a light dataset over the downloaded canonical headers, which
+// must thus reach the pivot.
+func (d *Downloader) syncState(pivot *types.Header, stale *types.Header) *stateSync {
 	// Create the state sync
-	s := newStateSync(d, root)
+	s := newStateSync(d, pivot.Root)
+	if th := d.blockchain.Config().TrieHashimoto; th != nil {
+		var dataset trie.HeaderDataset
+		if th.ReadHeader && !th.Fake {
+			light := thdataset.NewLight(d.stateDB, th.DatasetLen)
+			if number := pivot.Number.Uint64(); number > 0 {
+				if err := light.Generate(d.stateDB, number-1); err != nil {
+					log.Warn("Failed to generate header dataset for state sync", "pivot", number, "err", err)
+					s.err = err
+					close(s.done)
+					return s
+				}
+			}
+			dataset = light
+		}
+		s.sched.SetTrieHashimoto(th, pivot.Number.Uint64(), dataset)
+		s.th, s.dataset = th, dataset
+
+		if stale != nil && stale.Number.Cmp(pivot.Number) < 0 {
+			s.pivot, s.stale = pivot, stale
+		}
+	}
 	select {
 	case d.stateSyncStart <- s:
 	case <-d.quitCh:
@@ -97,11 +126,15 @@ func (d *Downloader) runStateSync(s *stateSync) *stateSync {
 	)
 	defer func() {
 		// Cancel active request timers on exit. Also set peers to idle so they're
-		// available for the next sync.
+		// available for the next sync, including those whose responses were never
+		// handed to this one.
 		for _, req := range active {
 			req.timer.Stop()
 			req.peer.SetNodeDataIdle(len(req.items))
 		}
+		for _, req := range finished {
+			req.peer.SetNodeDataIdle(len(req.items))
+		}
 	}()
 	// Run the state sync.
 	go s.run()
@@ -219,6 +252,12 @@ type stateSync struct {
 	keccak hash.Hash                  // Keccak256 hasher to verify deliveries with
 	tasks  map[common.Hash]*stateTask // Set of tasks currently queued for retrieval
 
+	th         *params.TrieHashimotoConfig // Trie-Hashimoto config the state nodes are mined with, if any
+	dataset    trie.HeaderDataset          // Header dataset up to the pivot the node nonces are verified against
+	pivot      *types.Header               // Pivot block to catch up to by block range
+	stale      *types.Header               // Stale pivot block to catch up from, if any
+	prefetched map[common.Hash][]byte      // Nodes caught up by block range, not yet scheduled
+
 	numUncommitted   int
 	bytesUncommitted int
 
@@ -239,13 +278,14 @@ type stateTask struct {
 // yet start the sync. The user needs to call run to initiate.
 func newStateSync(d *Downloader, root common.Hash) *stateSync {
 	return &stateSync{
-		d:       d,
-		sched:   state.NewStateSync(root, d.stateDB, d.stateBloom),
-		keccak:  sha3.NewLegacyKeccak256(),
-		tasks:   make(map[common.Hash]*stateTask),
-		deliver: make(chan *stateReq),
-		cancel:  make(chan struct{}),
-		done:    make(chan struct{}),
+		d:          d,
+		sched:      state.NewStateSync(root, d.stateDB, d.stateBloom),
+		keccak:     sha3.NewLegacyKeccak256(),
+		tasks:      make(map[common.Hash]*stateTask),
+		prefetched: make(map[common.Hash][]byte),
+		deliver:    make(chan *stateReq),
+		cancel:     make(chan struct{}),
+		done:       make(chan struct{}),
 	}
 }
 
@@ -287,8 +327,16 @@ func (s *stateSync) loop() (err error) {
 		}
 	}()
 
+	if s.stale != nil {
+		if err = s.catchUp(); err != nil {
+			return err
+		}
+	}
 	// Keep assigning new tasks until the sync completes or aborts
 	for s.sched.Pending() > 0 {
+		if err = s.processPrefetched(); err != nil {
+			return err
+		}
 		if err = s.commit(false); err != nil {
 			return err
 		}
@@ -341,6 +389,113 @@ func (s *stateSync) loop() (err error) {
 	return nil
 }
 
+// catchUp fetches the trie nodes mined after the stale pivot up to the new one
+// by block range, so that most of the new pivot's state is at hand before the
+// trie is walked from its root. Peers not serving ranges or failing to deliver
+// valid nodes are skipped, whatever isn't caught up is left to the walk.
+func (s *stateSync) catchUp() error {
+	var (
+		from   = s.stale.Number.Uint64() + 1
+		to     = s.pivot.Number.Uint64() + 1
+		origin common.Hash
+		tried  = make(map[string]struct{})
+	)
+	for from < to {
+		// Find a peer serving block ranges not tried yet
+		var peer *peerConnection
+		for _, p := range s.d.peers.AllPeers() {
+			if _, ok := tried[p.id]; !ok && p.FetchNodesByPrefixRange(from, origin, to) {
+				peer = p
+				break
+			}
+		}
+		if peer == nil {
+			return nil
+		}
+		// Wait for the reply, dropping late ones of peers tried before
+		var (
+			nodes     []trie.AgedNode
+			delivered bool
+			timeout   = time.NewTimer(s.d.requestTTL())
+		)
+	wait:
+		for !delivered {
+			select {
+			case pack := <-s.d.rangeCh:
+				if pack.PeerId() == peer.id {
+					nodes, delivered = pack.(*rangePack).nodes, true
+				}
+			case <-timeout.C:
+				break wait
+			case <-s.cancel:
+				timeout.Stop()
+				return errCancelStateFetch
+			case <-s.d.cancelCh:
+				timeout.Stop()
+				return errCanceled
+			}
+		}
+		timeout.Stop()
+
+		if !delivered {
+			tried[peer.id] = struct{}{}
+			continue
+		}
+		if len(nodes) == 0 {
+			break
+		}
+		// Keep the nodes verified against the pivot, each past the last one
+		for _, node := range nodes {
+			next := node.Number > from || (node.Number == from && bytes.Compare(node.Hash[:], origin[:]) > 0)
+			if !next || node.Number >= to || !trie.HasBlockPrefix(node.Hash, node.Number, s.th.PrefixLength) {
+				tried[peer.id] = struct{}{}
+				break
+			}
+			if err := trie.VerifyNodeHash(s.th, node.Hash, node.Blob, s.pivot.Number.Uint64(), s.dataset); err != nil {
+				tried[peer.id] = struct{}{}
+				break
+			}
+			s.prefetched[node.Hash] = node.Blob
+			from, origin = node.Number, node.Hash
+		}
+	}
+	log.Debug("Caught up state by block range", "from", s.stale.Number, "to", s.pivot.Number, "nodes", len(s.prefetched))
+	return nil
+}
+
+// processPrefetched injects the scheduled nodes that were caught up by block
+// range into the sync, as long as their children are found among them too.
+func (s *stateSync) processPrefetched() error {
+	for len(s.prefetched) > 0 {
+		for _, hash := range s.sched.Missing(0) {
+			s.tasks[hash] = &stateTask{make(map[string]struct{})}
+		}
+		processed := 0
+		for hash := range s.tasks {
+			blob, ok := s.prefetched[hash]
+			if !ok {
+				continue
+			}
+			delete(s.prefetched, hash)
+			delete(s.tasks, hash)
+
+			if _, _, err := s.sched.Process([]trie.SyncResult{{Hash: hash, Data: blob}}); err != nil {
+				return fmt.Errorf("invalid state node %s: %v", hash.TerminalString(), err)
+			}
+			s.numUncommitted++
+			s.bytesUncommitted += len(blob)
+			processed++
+		}
+		if processed == 0 {
+			break
+		}
+		if err := s.commit(false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s *stateSync) commit(force bool) error {
 	if !force && s.bytesUncommitted < ethdb.IdealBatchSize {
 		return nil
@@ -427,9 +582,25 @@ func (s *stateSync) process(req *stateReq) (int, error) {
 		}
 	}(time.Now())
 
-	// Iterate over all the delivered data and inject one-by-one into the trie
+	// Iterate over all the delivered data and inject one-by-one into the trie. The
+	// data is delivered in request order, so mined nodes are only matched against
+	// the items following the last match. A blob matching none of them is junk or
+	// out of order, after which the rest of the response isn't matched by mined
+	// hash at all, keeping the verifications linear in the number of items.
+	pending := req.items
 	for _, blob := range req.response {
-		_, hash, err := s.processNodeData(blob)
+		_, hash, err := s.processNodeData(blob, req.tasks, pending)
+
+		matched := false
+		for i, item := range pending {
+			if item == hash {
+				pending, matched = pending[i+1:], true
+				break
+			}
+		}
+		if !matched {
+			pending = nil
+		}
 		switch err {
 		case nil:
 			s.numUncommitted++
@@ -465,25 +636,28 @@ func (s *stateSync) process(req *stateReq) (int, error) {
 }
 
 // processNodeData tries to inject a trie node data blob delivered from a remote
-// peer into the state trie, returning whether anything useful was written or any
-// error occurred.
-var cnt uint64 = 0
-func (s *stateSync) processNodeData(blob []byte) (bool, common.Hash, error) {
+// peer into the state trie, returning whether anything useful was written, the
+// hash the blob was accepted for and any error that occurred. The hash of a mined
+// node carries its nonce and block and can't be derived from the blob alone, so
+// blobs not hashing to a pending task are tried against the candidate items of
+// the request, which the sync scheduler verifies the blob against.
+func (s *stateSync) processNodeData(blob []byte, tasks map[common.Hash]*stateTask, candidates []common.Hash) (bool, common.Hash, error) {
 	res := trie.SyncResult{Data: blob}
 	s.keccak.Reset()
 	s.keccak.Write(blob)
 	s.keccak.Sum(res.Hash[:0])
 
-	// forcely change trie node hash value for syncing with fake TH data (this enables fast-sync with fake TH data) (jmlee)
-	startTime := time.Now()
-	s.sched.PrefixingTrieNodeHash(&res.Hash)
-	elapsedTime := time.Since(startTime)
-	trie.TotalPrefixingOverhead += uint64(elapsedTime.Nanoseconds())
-	cnt++
-	if cnt%10000 == 0 {
-		fmt.Println("\nelapsed time for hash changing:", int(elapsedTime.Nanoseconds()), "nanoseconds --> total", trie.TotalPrefixingOverhead/1000000000, "seconds\n")
+	if _, ok := tasks[res.Hash]; s.th != nil && !ok {
+		for _, hash := range candidates {
+			if _, ok := tasks[hash]; !ok {
+				continue
+			}
+			committed, _, err := s.sched.Process([]trie.SyncResult{{Hash: hash, Data: blob}})
+			if err != trie.ErrNodeHashMismatch && err != trie.ErrInvalidNodePrefix {
+				return committed, hash, err
+			}
+		}
 	}
-
 	committed, _, err := s.sched.Process([]trie.SyncResult{res})
 	return committed, res.Hash, err
 }
@@ -199,16 +199,20 @@ func (tc *testChain) receipts(hashes []common.Hash) [][]*types.Receipt {
 }
 
 // bodies returns the block bodies of the given block hashes.
-func (tc *testChain) bodies(hashes []common.Hash) ([][]*types.Transaction, [][]*types.Header) {
+func (tc *testChain) bodies(hashes []common.Hash) ([][]*types.Transaction, [][]*types.Header, [][]uint64, [][]types.StorageTrieNonces) {
 	transactions := make([][]*types.Transaction, 0, len(hashes))
 	uncles := make([][]*types.Header, 0, len(hashes))
+	trieNonces := make([][]uint64, 0, len(hashes))
+	storageNonces := make([][]types.StorageTrieNonces, 0, len(hashes))
 	for _, hash := range hashes {
 		if block, ok := tc.blockm[hash]; ok {
 			transactions = append(transactions, block.Transactions())
 			uncles = append(uncles, block.Uncles())
+			trieNonces = append(trieNonces, block.TrieNonces())
+			storageNonces = append(storageNonces, block.StorageNonces())
 		}
 	}
-	return transactions, uncles
+	return transactions, uncles, trieNonces, storageNonces
 }
 
 func (tc *testChain) hashToNumber(target common.Hash) (uint64, bool) {
@@ -20,7 +20,7 @@ import (
 	"fmt"
 
 	"github.com/ethereum/go-ethereum/core/types"
-	
+	"github.com/ethereum/go-ethereum/trie"
 )
 
 // peerDropFn is a callback type for dropping a peer detected as malicious.
@@ -49,6 +49,7 @@ type bodyPack struct {
 	transactions [][]*types.Transaction
 	uncles       [][]*types.Header
 	trieNonces	 [][]uint64
+	storageNonces [][]types.StorageTrieNonces
 }
 
 func (p *bodyPack) PeerId() string { return p.peerID }
@@ -79,3 +80,13 @@ type statePack struct {
 func (p *statePack) PeerId() string { return p.peerID }
 func (p *statePack) Items() int     { return len(p.states) }
 func (p *statePack) Stats() string  { return fmt.Sprintf("%d", len(p.states)) }
+
+// rangePack is a batch of trie nodes mined in a block range returned by a peer.
+type rangePack struct {
+	peerID string
+	nodes  []trie.AgedNode
+}
+
+func (p *rangePack) PeerId() string { return p.peerID }
+func (p *rangePack) Items() int     { return len(p.nodes) }
+func (p *rangePack) Stats() string  { return fmt.Sprintf("%d", len(p.nodes)) }
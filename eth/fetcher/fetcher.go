@@ -96,6 +96,7 @@ type bodyFilterTask struct {
 	transactions [][]*types.Transaction // Collection of transactions per block bodies
 	uncles       [][]*types.Header      // Collection of uncles per block bodies
 	trieNonces	 [][]uint64
+	storageNonces [][]types.StorageTrieNonces // Collection of storage trie nonces per block bodies
 	time         time.Time              // Arrival time of the blocks' contents
 }
 
@@ -248,7 +249,7 @@ func (f *Fetcher) FilterHeaders(peer string, headers []*types.Header, time time.
 
 // FilterBodies extracts all the block bodies that were explicitly requested by
 // the fetcher, returning those that should be handled differently.
-func (f *Fetcher) FilterBodies(peer string, transactions [][]*types.Transaction, uncles [][]*types.Header, trieNonces [][]uint64, time time.Time) ([][]*types.Transaction, [][]*types.Header, [][]uint64) {
+func (f *Fetcher) FilterBodies(peer string, transactions [][]*types.Transaction, uncles [][]*types.Header, trieNonces [][]uint64, storageNonces [][]types.StorageTrieNonces, time time.Time) ([][]*types.Transaction, [][]*types.Header, [][]uint64, [][]types.StorageTrieNonces) {
 	log.Trace("Filtering bodies", "peer", peer, "txs", len(transactions), "uncles", len(uncles))
 
 	// Send the filter channel to the fetcher
@@ -257,20 +258,20 @@ func (f *Fetcher) FilterBodies(peer string, transactions [][]*types.Transaction,
 	select {
 	case f.bodyFilter <- filter:
 	case <-f.quit:
-		return nil, nil, nil
+		return nil, nil, nil, nil
 	}
 	// Request the filtering of the body list
 	select {
-	case filter <- &bodyFilterTask{peer: peer, transactions: transactions, uncles: uncles, trieNonces: trieNonces, time: time}:
+	case filter <- &bodyFilterTask{peer: peer, transactions: transactions, uncles: uncles, trieNonces: trieNonces, storageNonces: storageNonces, time: time}:
 	case <-f.quit:
-		return nil, nil, nil
+		return nil, nil, nil, nil
 	}
 	// Retrieve the bodies remaining after filtering
 	select {
 	case task := <-filter:
-		return task.transactions, task.uncles, task.trieNonces
+		return task.transactions, task.uncles, task.trieNonces, task.storageNonces
 	case <-f.quit:
-		return nil, nil, nil
+		return nil, nil, nil, nil
 	}
 }
 
@@ -525,12 +526,12 @@ func (f *Fetcher) loop() {
 						txnHash := types.DeriveSha(types.Transactions(task.transactions[i]))
 						uncleHash := types.CalcUncleHash(task.uncles[i])
 
-						if txnHash == announce.header.TxHash && uncleHash == announce.header.UncleHash && announce.origin == task.peer {
+						if txnHash == announce.header.TxHash && uncleHash == announce.header.UncleHash && types.TrieNoncesMatch(announce.header, task.trieNonces[i], task.storageNonces[i]) && announce.origin == task.peer {
 							// Mark the body matched, reassemble if still unknown
 							matched = true
 
 							if f.getBlock(hash) == nil {
-								block := types.NewBlockWithHeader(announce.header).WithBody(task.transactions[i], task.uncles[i], task.trieNonces[i])
+								block := types.NewBlockWithHeader(announce.header).WithBody(task.transactions[i], task.uncles[i], task.trieNonces[i], task.storageNonces[i])
 								block.ReceivedAt = task.time
 
 								blocks = append(blocks, block)
@@ -543,6 +544,8 @@ func (f *Fetcher) loop() {
 				if matched {
 					task.transactions = append(task.transactions[:i], task.transactions[i+1:]...)
 					task.uncles = append(task.uncles[:i], task.uncles[i+1:]...)
+					task.trieNonces = append(task.trieNonces[:i], task.trieNonces[i+1:]...)
+					task.storageNonces = append(task.storageNonces[:i], task.storageNonces[i+1:]...)
 					i--
 					continue
 				}
@@ -668,6 +671,12 @@ func (f *Fetcher) insert(peer string, block *types.Block) {
 		// Run the actual import and log any issues
 		if _, err := f.insertChain(types.Blocks{block}); err != nil {
 			log.Debug("Propagated block import failed", "peer", peer, "number", block.Number(), "hash", hash, "err", err)
+			// Bogus trie nonces can only come from a misbehaving peer, drop it. Synced
+			// blocks are covered by the downloader dropping the sync peer on an invalid
+			// chain, their bodies having to match the trie nonces hash of the headers.
+			if err == consensus.ErrInvalidTrieNonce {
+				f.dropPeer(peer)
+			}
 			return
 		}
 		// If import succeeded, broadcast the block
@@ -25,6 +25,7 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
 	"github.com/ethereum/go-ethereum/consensus/ethash"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/rawdb"
@@ -82,6 +83,8 @@ type fetcherTester struct {
 	blocks map[common.Hash]*types.Block // Blocks belonging to the tester
 	drops  map[string]bool              // Map of peers dropped by the fetcher
 
+	invalidTrieNonces common.Hash // Block whose trie nonces fail verification on import
+
 	lock sync.RWMutex
 }
 
@@ -133,6 +136,9 @@ func (f *fetcherTester) insertChain(blocks types.Blocks) (int, error) {
 		if _, ok := f.blocks[block.ParentHash()]; !ok {
 			return i, errors.New("unknown parent")
 		}
+		if block.Hash() == f.invalidTrieNonces {
+			return i, consensus.ErrInvalidTrieNonce
+		}
 		// Discard any new blocks if the same height already exists
 		if block.NumberU64() <= f.blocks[f.hashes[len(f.hashes)-1]].NumberU64() {
 			return i, nil
@@ -184,15 +190,19 @@ func (f *fetcherTester) makeBodyFetcher(peer string, blocks map[common.Hash]*typ
 		// Gather the block bodies to return
 		transactions := make([][]*types.Transaction, 0, len(hashes))
 		uncles := make([][]*types.Header, 0, len(hashes))
+		trieNonces := make([][]uint64, 0, len(hashes))
+		storageNonces := make([][]types.StorageTrieNonces, 0, len(hashes))
 
 		for _, hash := range hashes {
 			if block, ok := closure[hash]; ok {
 				transactions = append(transactions, block.Transactions())
 				uncles = append(uncles, block.Uncles())
+				trieNonces = append(trieNonces, block.TrieNonces())
+				storageNonces = append(storageNonces, block.StorageNonces())
 			}
 		}
 		// Return on a new thread
-		go f.fetcher.FilterBodies(peer, transactions, uncles, time.Now().Add(drift))
+		go f.fetcher.FilterBodies(peer, transactions, uncles, trieNonces, storageNonces, time.Now().Add(drift))
 
 		return nil
 	}
@@ -644,6 +654,46 @@ func testInvalidNumberAnnouncement(t *testing.T, protocol int) {
 	verifyImportDone(t, imported)
 }
 
+// Tests that a peer propagating a block whose trie nonces fail verification on
+// import gets dropped, while one propagating a valid block doesn't.
+func TestInvalidTrieNoncesPropagation(t *testing.T) {
+	// Create a single block to import and fail it on the first try
+	hashes, blocks := makeChain(1, 0, genesis)
+
+	tester := newTester()
+	tester.lock.Lock()
+	tester.invalidTrieNonces = hashes[0]
+	tester.lock.Unlock()
+
+	imported := make(chan *types.Block)
+	tester.fetcher.importedHook = func(block *types.Block) { imported <- block }
+
+	// Propagate the block with the bad trie nonces, check for the drop
+	tester.fetcher.Enqueue("bad", blocks[hashes[0]])
+	verifyImportEvent(t, imported, false)
+
+	tester.lock.Lock()
+	dropped := tester.drops["bad"]
+	tester.invalidTrieNonces = common.Hash{}
+	tester.lock.Unlock()
+
+	if !dropped {
+		t.Fatalf("peer with invalid trie nonces not dropped")
+	}
+	// Make sure a valid block passes without a drop
+	tester.fetcher.Enqueue("good", blocks[hashes[0]])
+	verifyImportEvent(t, imported, true)
+
+	tester.lock.RLock()
+	dropped = tester.drops["good"]
+	tester.lock.RUnlock()
+
+	if dropped {
+		t.Fatalf("peer with valid trie nonces dropped")
+	}
+	verifyImportDone(t, imported)
+}
+
 // Tests that if a block is empty (i.e. header only), no body request should be
 // made, and instead the header should be assembled into a whole block in itself.
 func TestEmptyBlockShortCircuit62(t *testing.T) { testEmptyBlockShortCircuit(t, 62) }
@@ -37,6 +37,11 @@ func (c Config) MarshalTOML() (interface{}, error) {
 		TrieCleanCache          int
 		TrieDirtyCache          int
 		TrieTimeout             time.Duration
+		THDatasetDir            string
+		THVerifier              string
+		THKeepRecent            uint64
+		THColdAfter             uint64
+		THShards                string
 		Miner                   miner.Config
 		Ethash                  ethash.Config
 		TxPool                  core.TxPoolConfig
@@ -70,6 +75,11 @@ func (c Config) MarshalTOML() (interface{}, error) {
 	enc.TrieCleanCache = c.TrieCleanCache
 	enc.TrieDirtyCache = c.TrieDirtyCache
 	enc.TrieTimeout = c.TrieTimeout
+	enc.THDatasetDir = c.THDatasetDir
+	enc.THVerifier = c.THVerifier
+	enc.THKeepRecent = c.THKeepRecent
+	enc.THColdAfter = c.THColdAfter
+	enc.THShards = c.THShards
 	enc.Miner = c.Miner
 	enc.Ethash = c.Ethash
 	enc.TxPool = c.TxPool
@@ -106,6 +116,11 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 		TrieCleanCache          *int
 		TrieDirtyCache          *int
 		TrieTimeout             *time.Duration
+		THDatasetDir            *string
+		THVerifier              *string
+		THKeepRecent            *uint64
+		THColdAfter             *uint64
+		THShards                *string
 		Miner                   *miner.Config
 		Ethash                  *ethash.Config
 		TxPool                  *core.TxPoolConfig
@@ -179,6 +194,21 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.TrieTimeout != nil {
 		c.TrieTimeout = *dec.TrieTimeout
 	}
+	if dec.THDatasetDir != nil {
+		c.THDatasetDir = *dec.THDatasetDir
+	}
+	if dec.THVerifier != nil {
+		c.THVerifier = *dec.THVerifier
+	}
+	if dec.THKeepRecent != nil {
+		c.THKeepRecent = *dec.THKeepRecent
+	}
+	if dec.THColdAfter != nil {
+		c.THColdAfter = *dec.THColdAfter
+	}
+	if dec.THShards != nil {
+		c.THShards = *dec.THShards
+	}
 	if dec.Miner != nil {
 		c.Miner = *dec.Miner
 	}
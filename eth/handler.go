@@ -330,7 +330,15 @@ func (pm *ProtocolManager) handle(p *peer) error {
 	defer pm.removePeer(p.id)
 
 	// Register the peer in the downloader. If the downloader considers it banned, we disconnect
-	if err := pm.downloader.RegisterPeer(p.id, p.version, p); err != nil {
+	var (
+		dlpeer  downloader.Peer = p
+		version                 = p.version
+	)
+	if p.version == th63 {
+		// Downloads run as with eth/63, with the block range node fetches on top
+		dlpeer, version = &rangePeer{p}, eth63
+	}
+	if err := pm.downloader.RegisterPeer(p.id, version, dlpeer); err != nil {
 		return err
 	}
 	// Propagate existing transactions. new transactions appearing
@@ -568,19 +576,21 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 		transactions := make([][]*types.Transaction, len(request))
 		uncles := make([][]*types.Header, len(request))
 		trieNonces := make([][]uint64, len(request))
+		storageNonces := make([][]types.StorageTrieNonces, len(request))
 
 		for i, body := range request {
 			transactions[i] = body.Transactions
 			uncles[i] = body.Uncles
 			trieNonces[i] = body.TrieNonces
+			storageNonces[i] = body.StorageNonces
 		}
 		// Filter out any explicitly requested bodies, deliver the rest to the downloader
 		filter := len(transactions) > 0 || len(uncles) > 0
 		if filter {
-			transactions, uncles, trieNonces = pm.fetcher.FilterBodies(p.id, transactions, uncles, trieNonces, time.Now())
+			transactions, uncles, trieNonces, storageNonces = pm.fetcher.FilterBodies(p.id, transactions, uncles, trieNonces, storageNonces, time.Now())
 		}
 		if len(transactions) > 0 || len(uncles) > 0 || !filter {
-			err := pm.downloader.DeliverBodies(p.id, transactions, uncles, trieNonces)
+			err := pm.downloader.DeliverBodies(p.id, transactions, uncles, trieNonces, storageNonces)
 			if err != nil {
 				log.Debug("Failed to deliver bodies", "err", err)
 			}
@@ -624,6 +634,27 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 			log.Debug("Failed to deliver node state data", "err", err)
 		}
 
+	case p.version == th63 && msg.Code == GetNodesByPrefixRangeMsg:
+		// Decode the range query
+		var query getNodesByPrefixRangeData
+		if err := msg.Decode(&query); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		// Gather the nodes from the age index until the fetch or network limits are reached
+		nodes := pm.blockchain.StateCache().TrieDB().NodesByAge(query.From, query.Origin, query.To, downloader.MaxStateFetch, softResponseLimit)
+		return p.SendNodesByPrefixRange(nodes)
+
+	case p.version == th63 && msg.Code == NodesByPrefixRangeMsg:
+		// A batch of trie nodes arrived to one of our previous range requests
+		var nodes nodesByPrefixRangeData
+		if err := msg.Decode(&nodes); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		// Deliver all to the downloader
+		if err := pm.downloader.DeliverNodesByPrefixRange(p.id, nodes); err != nil {
+			log.Debug("Failed to deliver trie nodes by block range", "err", err)
+		}
+
 	case p.version >= eth63 && msg.Code == GetReceiptsMsg:
 		// Decode the retrieval message
 		msgStream := rlp.NewStream(msg.Payload, uint64(msg.Size))
@@ -36,6 +36,7 @@ import (
 	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/trie"
 )
 
 // Tests that block headers can be retrieved from a remote chain based on user queries.
@@ -365,6 +366,55 @@ func testGetNodeData(t *testing.T, protocol int) {
 	}
 }
 
+// Tests that the trie nodes mined in a block range can be retrieved from the node
+// age index, resuming after a given node.
+func TestGetNodesByPrefixRange(t *testing.T) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
+	peer, _ := newTestPeer("peer", th63, pm, true)
+	defer peer.close()
+
+	// Mine a trie in a few blocks into the node database of the chain
+	var (
+		config = &params.TrieHashimotoConfig{PrefixLength: 1, LoopAccesses: 1}
+		triedb = pm.blockchain.StateCache().TrieDB()
+	)
+	tr, _ := trie.New(common.Hash{}, triedb)
+	for number := uint64(1); number <= 3; number++ {
+		for i := 0; i < 16; i++ {
+			tr.Update([]byte{byte(i), 0x01}, []byte{byte(i), byte(number)})
+		}
+		root, _, err := tr.HashWithNonce(config, number, nil, &trie.Miner{Threads: 1})
+		if err != nil {
+			t.Fatalf("block %d: failed to mine trie: %v", number, err)
+		}
+		if _, err := tr.Commit(nil); err != nil {
+			t.Fatalf("block %d: failed to commit trie: %v", number, err)
+		}
+		triedb.IndexNodeAge(config.PrefixLength, number)
+		if err := triedb.Commit(root, false); err != nil {
+			t.Fatalf("block %d: failed to flush trie: %v", number, err)
+		}
+	}
+	nodes := triedb.NodesByAge(2, common.Hash{}, 4, downloader.MaxStateFetch, softResponseLimit)
+	if len(nodes) < 2 {
+		t.Fatalf("too few nodes mined in blocks [2, 4): %d", len(nodes))
+	}
+	for _, node := range nodes {
+		if node.Number < 2 || node.Number >= 4 {
+			t.Fatalf("node %x mined in block %d out of range", node.Hash, node.Number)
+		}
+	}
+	// Request the whole range, then resume after its first node
+	p2p.Send(peer.app, GetNodesByPrefixRangeMsg, &getNodesByPrefixRangeData{From: 2, To: 4})
+	if err := p2p.ExpectMsg(peer.app, NodesByPrefixRangeMsg, nodes); err != nil {
+		t.Fatalf("range mismatch: %v", err)
+	}
+	p2p.Send(peer.app, GetNodesByPrefixRangeMsg, &getNodesByPrefixRangeData{From: nodes[0].Number, Origin: nodes[0].Hash, To: 4})
+	if err := p2p.ExpectMsg(peer.app, NodesByPrefixRangeMsg, nodes[1:]); err != nil {
+		t.Fatalf("resumed range mismatch: %v", err)
+	}
+}
+
 // Tests that the transaction receipts can be retrieved based on hashes.
 func TestGetReceipt63(t *testing.T) { testGetReceipt(t, 63) }
 
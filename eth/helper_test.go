@@ -47,6 +47,14 @@ var (
 	testBank       = crypto.PubkeyToAddress(testBankKey.PublicKey)
 )
 
+func init() {
+	// Peers are handled with the sync boundary of the global database, which only
+	// persistent databases set. Without it, the boundary falls back to the head.
+	if rawdb.GlobalDB == nil {
+		rawdb.GlobalDB = rawdb.NewMemoryDatabase()
+	}
+}
+
 // newTestProtocolManager creates a new protocol manager for testing purposes,
 // with the given number of blocks already known, and potential notification
 // channels for different events.
@@ -94,6 +94,8 @@ func (rw *meteredMsgReadWriter) ReadMsg() (p2p.Msg, error) {
 
 	case rw.version >= eth63 && msg.Code == NodeDataMsg:
 		packets, traffic = reqStateInPacketsMeter, reqStateInTrafficMeter
+	case rw.version == th63 && msg.Code == NodesByPrefixRangeMsg:
+		packets, traffic = reqStateInPacketsMeter, reqStateInTrafficMeter
 	case rw.version >= eth63 && msg.Code == ReceiptsMsg:
 		packets, traffic = reqReceiptInPacketsMeter, reqReceiptInTrafficMeter
 
@@ -121,6 +123,8 @@ func (rw *meteredMsgReadWriter) WriteMsg(msg p2p.Msg) error {
 
 	case rw.version >= eth63 && msg.Code == NodeDataMsg:
 		packets, traffic = reqStateOutPacketsMeter, reqStateOutTrafficMeter
+	case rw.version == th63 && msg.Code == NodesByPrefixRangeMsg:
+		packets, traffic = reqStateOutPacketsMeter, reqStateOutTrafficMeter
 	case rw.version >= eth63 && msg.Code == ReceiptsMsg:
 		packets, traffic = reqReceiptOutPacketsMeter, reqReceiptOutTrafficMeter
 
@@ -28,6 +28,7 @@ import (
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
 )
 
 var (
@@ -304,6 +305,12 @@ func (p *peer) SendNodeData(data [][]byte) error {
 	return p2p.Send(p.rw, NodeDataMsg, data)
 }
 
+// SendNodesByPrefixRange sends a batch of trie nodes mined in a block range,
+// corresponding to the range requested.
+func (p *peer) SendNodesByPrefixRange(nodes []trie.AgedNode) error {
+	return p2p.Send(p.rw, NodesByPrefixRangeMsg, nodesByPrefixRangeData(nodes))
+}
+
 // SendReceiptsRLP sends a batch of transaction receipts, corresponding to the
 // ones requested from an already RLP encoded format.
 func (p *peer) SendReceiptsRLP(receipts []rlp.RawValue) error {
@@ -345,6 +352,19 @@ func (p *peer) RequestNodeData(hashes []common.Hash) error {
 	return p2p.Send(p.rw, GetNodeDataMsg, hashes)
 }
 
+// rangePeer is a peer speaking th63, which the downloader may also request the
+// trie nodes mined in a block range from.
+type rangePeer struct {
+	*peer
+}
+
+// RequestNodesByPrefixRange fetches the trie nodes mined in the blocks from
+// `from` up to `to` from a remote node, resuming after the origin node.
+func (p *rangePeer) RequestNodesByPrefixRange(from uint64, origin common.Hash, to uint64) error {
+	p.Log().Debug("Fetching trie nodes by block range", "from", from, "origin", origin, "to", to)
+	return p2p.Send(p.rw, GetNodesByPrefixRangeMsg, &getNodesByPrefixRangeData{From: from, Origin: origin, To: to})
+}
+
 // RequestReceipts fetches a batch of transaction receipts from a remote node.
 func (p *peer) RequestReceipts(hashes []common.Hash) error {
 	p.Log().Debug("Fetching batch of receipts", "count", len(hashes))
@@ -26,23 +26,28 @@ import (
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/rlp"
-	
+	"github.com/ethereum/go-ethereum/trie"
 )
 
 // Constants to match up protocol versions and messages
 const (
 	eth62 = 62
 	eth63 = 63
+
+	// th63 is eth/63 extended with the prefix range node messages. It's numbered
+	// apart from the official versions, as the next of those (eth/64) stands for
+	// the fork ID handshake, not for these messages.
+	th63 = 1000 + eth63
 )
 
 // protocolName is the official short name of the protocol used during capability negotiation.
 const protocolName = "eth"
 
 // ProtocolVersions are the supported versions of the eth protocol (first is primary).
-var ProtocolVersions = []uint{eth63}
+var ProtocolVersions = []uint{th63, eth63}
 
 // protocolLengths are the number of implemented message corresponding to different protocol versions.
-var protocolLengths = map[uint]uint64{eth63: 17, eth62: 8}
+var protocolLengths = map[uint]uint64{th63: 19, eth63: 17, eth62: 8}
 
 const protocolMaxMsgSize = 10 * 1024 * 1024 // Maximum cap on the size of a protocol message
 
@@ -63,6 +68,10 @@ const (
 	NodeDataMsg    = 0x0e
 	GetReceiptsMsg = 0x0f
 	ReceiptsMsg    = 0x10
+
+	// Protocol messages belonging to th63
+	GetNodesByPrefixRangeMsg = 0x11
+	NodesByPrefixRangeMsg    = 0x12
 )
 
 type errCode int
@@ -168,6 +177,18 @@ func (hn *hashOrNumber) DecodeRLP(s *rlp.Stream) error {
 	return err
 }
 
+// getNodesByPrefixRangeData represents a query for the trie nodes mined in a
+// range of blocks, which the node hash prefixes index.
+type getNodesByPrefixRangeData struct {
+	From   uint64      // First block of the range
+	Origin common.Hash // Node of the first block to resume after (empty = from the start)
+	To     uint64      // Block the range ends before
+}
+
+// nodesByPrefixRangeData is the network packet answering a prefix range query,
+// oldest node first. An empty packet means the range is exhausted.
+type nodesByPrefixRangeData []trie.AgedNode
+
 // newBlockData is the network packet for the block propagation message.
 type newBlockData struct {
 	Block *types.Block
@@ -192,6 +213,7 @@ type blockBody struct {
 	Transactions []*types.Transaction // Transactions contained within a block
 	Uncles       []*types.Header      // Uncles contained within a block
 	TrieNonces	 []uint64
+	StorageNonces []types.StorageTrieNonces // Storage trie nonces per account
 }
 
 // blockBodiesData is the network packet for block content distribution.
@@ -23,11 +23,14 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"time"
 
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/impt"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/rpc"
 )
@@ -93,6 +96,7 @@ type rpcBlock struct {
 	Transactions []rpcTransaction `json:"transactions"`
 	UncleHashes  []common.Hash    `json:"uncles"`
 	TrieNonces	 []uint64 `json:"trieNonces"`
+	StorageNonces []types.StorageTrieNonces `json:"storageNonces"`
 }
 
 func (ec *Client) getBlock(ctx context.Context, method string, args ...interface{}) (*types.Block, error) {
@@ -163,7 +167,7 @@ func (ec *Client) getBlock(ctx context.Context, method string, args ...interface
 		trieNonces[i] = trieNonce
 	}
 
-	return types.NewBlockWithHeader(head).WithBody(txs, uncles, trieNonces), nil
+	return types.NewBlockWithHeader(head).WithBody(txs, uncles, trieNonces, body.StorageNonces), nil
 }
 
 // HeaderByHash returns the block header with the given hash.
@@ -241,6 +245,139 @@ func (ec *Client) MiningTimeByNumber(ctx context.Context, number *big.Int, threa
 	return size, nil
 }
 
+type rpcTrieNonces struct {
+	Number        hexutil.Uint64     `json:"number"`
+	Hash          common.Hash        `json:"hash"`
+	TrieNonces    []*impt.TrieNonce  `json:"trieNonces"`
+	StorageNonces []rpcStorageNonces `json:"storageNonces"`
+}
+
+type rpcStorageNonces struct {
+	Address common.Address    `json:"address"`
+	Nonces  []*impt.TrieNonce `json:"nonces"`
+}
+
+// TrieNoncesByNumber returns the Trie-Hashimoto nonces of the trie nodes mined in
+// the given block, along with the hashes of the nodes before and after they were
+// mined. If number is nil, the latest known block is used. The state of the block
+// must still be available on the server.
+func (ec *Client) TrieNoncesByNumber(ctx context.Context, number *big.Int) (*ethereum.TrieNonces, error) {
+	var raw *rpcTrieNonces
+	if err := ec.c.CallContext(ctx, &raw, "th_getTrieNonces", toBlockNumArg(number)); err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, ethereum.NotFound
+	}
+	nonces := &ethereum.TrieNonces{
+		Number:     uint64(raw.Number),
+		Hash:       raw.Hash,
+		TrieNonces: raw.TrieNonces,
+	}
+	for _, storage := range raw.StorageNonces {
+		nonces.StorageNonces = append(nonces.StorageNonces, ethereum.StorageTrieNonces{Address: storage.Address, Nonces: storage.Nonces})
+	}
+	return nonces, nil
+}
+
+type rpcTrieNode struct {
+	Hash         common.Hash     `json:"hash"`
+	OriginalHash common.Hash     `json:"originalHash"`
+	Type         string          `json:"type"`
+	Key          hexutil.Bytes   `json:"key"`
+	Value        hexutil.Bytes   `json:"value"`
+	Children     []hexutil.Bytes `json:"children"`
+	Nonce        hexutil.Uint64  `json:"nonce"`
+	PrefixBlock  *hexutil.Uint64 `json:"prefixBlock"`
+	Valid        bool            `json:"valid"`
+	Encoding     hexutil.Bytes   `json:"encoding"`
+}
+
+// TrieNodeByHash returns the trie node with the given hash, decoded, along with
+// its Trie-Hashimoto nonce and the block its hash prefix refers to.
+func (ec *Client) TrieNodeByHash(ctx context.Context, hash common.Hash) (*ethereum.TrieNode, error) {
+	var raw *rpcTrieNode
+	if err := ec.c.CallContext(ctx, &raw, "th_getNode", hash); err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, ethereum.NotFound
+	}
+	node := &ethereum.TrieNode{
+		Hash:         raw.Hash,
+		OriginalHash: raw.OriginalHash,
+		Full:         raw.Type == "full",
+		Key:          raw.Key,
+		Value:        raw.Value,
+		Nonce:        uint64(raw.Nonce),
+		PrefixBlock:  (*uint64)(raw.PrefixBlock),
+		Valid:        raw.Valid,
+		Encoding:     raw.Encoding,
+	}
+	for _, child := range raw.Children {
+		node.Children = append(node.Children, child)
+	}
+	return node, nil
+}
+
+type rpcTrieMiningProgress struct {
+	Mining         bool           `json:"mining"`
+	Number         hexutil.Uint64 `json:"number"`
+	TrieDifficulty *hexutil.Big   `json:"trieDifficulty"`
+	Threads        int            `json:"threads"`
+	NodesMined     hexutil.Uint64 `json:"nodesMined"`
+	Elapsed        float64        `json:"elapsed"`
+	TrieHashrate   hexutil.Uint64 `json:"trieHashrate"`
+	LastNumber     hexutil.Uint64 `json:"lastNumber"`
+	LastNodes      hexutil.Uint64 `json:"lastNodes"`
+	LastElapsed    float64        `json:"lastElapsed"`
+}
+
+func (p *rpcTrieMiningProgress) toProgress() *ethereum.TrieMiningProgress {
+	return &ethereum.TrieMiningProgress{
+		Mining:         p.Mining,
+		Number:         uint64(p.Number),
+		TrieDifficulty: (*big.Int)(p.TrieDifficulty),
+		Threads:        p.Threads,
+		NodesMined:     uint64(p.NodesMined),
+		Elapsed:        time.Duration(p.Elapsed * float64(time.Second)),
+		TrieHashrate:   uint64(p.TrieHashrate),
+		LastNumber:     uint64(p.LastNumber),
+		LastNodes:      uint64(p.LastNodes),
+		LastElapsed:    time.Duration(p.LastElapsed * float64(time.Second)),
+	}
+}
+
+// SubscribeTrieMiningProgress subscribes to notifications about the progress of
+// the trie node mining of the server, sent every second while the trie nodes of
+// a block are being mined and once more when the round completes.
+func (ec *Client) SubscribeTrieMiningProgress(ctx context.Context, ch chan<- *ethereum.TrieMiningProgress) (ethereum.Subscription, error) {
+	raw := make(chan *rpcTrieMiningProgress)
+	sub, err := ec.c.Subscribe(ctx, "th", raw, "miningProgress")
+	if err != nil {
+		return nil, err
+	}
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case progress := <-raw:
+				select {
+				case ch <- progress.toProgress():
+				case err := <-sub.Err():
+					return err
+				case <-quit:
+					return nil
+				}
+			case err := <-sub.Err():
+				return err
+			case <-quit:
+				return nil
+			}
+		}
+	}), nil
+}
+
 // TransactionByHash returns the transaction with the given hash.
 func (ec *Client) TransactionByHash(ctx context.Context, hash common.Hash) (tx *types.Transaction, isPending bool, err error) {
 	var json *rpcTransaction
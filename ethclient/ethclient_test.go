@@ -335,3 +335,34 @@ func TestChainID(t *testing.T) {
 		t.Fatalf("ChainID returned wrong number: %+v", id)
 	}
 }
+
+func TestTrieHashimoto(t *testing.T) {
+	backend, chain := newTestBackend(t)
+	client, _ := backend.Attach()
+	defer backend.Stop()
+	defer client.Close()
+	ec := NewClient(client)
+
+	nonces, err := ec.TrieNoncesByNumber(context.Background(), big.NewInt(1))
+	if err != nil {
+		t.Fatalf("TrieNoncesByNumber error: %v", err)
+	}
+	if nonces.Number != 1 || nonces.Hash != chain[1].Hash() || len(nonces.TrieNonces) != 0 {
+		t.Fatalf("TrieNoncesByNumber returned wrong nonces: %+v", nonces)
+	}
+	node, err := ec.TrieNodeByHash(context.Background(), chain[1].Root())
+	if err != nil {
+		t.Fatalf("TrieNodeByHash error: %v", err)
+	}
+	if node.Hash != chain[1].Root() || !node.Valid || node.PrefixBlock != nil || len(node.Encoding) == 0 {
+		t.Fatalf("TrieNodeByHash returned wrong node: %+v", node)
+	}
+	if _, err := ec.TrieNodeByHash(context.Background(), common.Hash{1}); err == nil {
+		t.Fatal("TrieNodeByHash found missing node")
+	}
+	sub, err := ec.SubscribeTrieMiningProgress(context.Background(), make(chan *ethereum.TrieMiningProgress))
+	if err != nil {
+		t.Fatalf("SubscribeTrieMiningProgress error: %v", err)
+	}
+	sub.Unsubscribe()
+}
@@ -20,6 +20,7 @@ package graphql
 import (
 	"context"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/ethereum/go-ethereum"
@@ -33,10 +34,12 @@ import (
 	"github.com/ethereum/go-ethereum/internal/ethapi"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
 )
 
 var OnlyOnMainChainError = errors.New("This operation is only available for blocks on the canonical chain.")
 var BlockInvariantError = errors.New("Block objects must be instantiated with at least one of num or hash.")
+var TrieUnavailableError = errors.New("Trie nodes are not available on this node.")
 
 // Account represents an Ethereum account at a particular block.
 type Account struct {
@@ -91,6 +94,33 @@ func (a *Account) Storage(ctx context.Context, args struct{ Slot common.Hash })
 	return state.GetState(a.address, args.Slot), nil
 }
 
+// StateNodeAge returns the block the account trie node referencing the leaf of
+// the account was mined in, as encoded in the prefix of its hash.
+func (a *Account) StateNodeAge(ctx context.Context) (*hexutil.Uint64, error) {
+	state, header, err := a.backend.StateAndHeaderByNumber(ctx, a.blockNumber)
+	if state == nil || err != nil {
+		return nil, err
+	}
+	triedb := state.Database().TrieDB()
+	if triedb == nil {
+		return nil, TrieUnavailableError
+	}
+	accounts, err := trie.NewSecure(header.Root, triedb)
+	if err != nil {
+		return nil, err
+	}
+	hash, ok, err := accounts.LeafParentHash(a.address[:])
+	if !ok || err != nil {
+		return nil, err
+	}
+	number, ok := trie.NodeAge(a.backend.ChainDb(), a.backend.ChainConfig().TrieHashimoto, hash, header.Number.Uint64())
+	if !ok {
+		return nil, nil
+	}
+	ret := hexutil.Uint64(number)
+	return &ret, nil
+}
+
 // Log represents an individual log message. All arguments are mandatory.
 type Log struct {
 	backend     ethapi.Backend
@@ -346,7 +376,8 @@ type Block struct {
 	header    *types.Header
 	block     *types.Block
 	receipts  []*types.Receipt
-	canonical BlockType // Indicates if this block is on the main chain or not.
+	mined     []trie.MinedNode // Account trie nodes mined in this block
+	canonical BlockType        // Indicates if this block is on the main chain or not.
 }
 
 func (b *Block) onMainChain(ctx context.Context) error {
@@ -428,6 +459,34 @@ func (b *Block) resolveReceipts(ctx context.Context) ([]*types.Receipt, error) {
 	return b.receipts, nil
 }
 
+// resolveMinedNodes returns the account trie nodes mined in this block, in the
+// order of their nonces, fetching them from the state of the block if necessary.
+func (b *Block) resolveMinedNodes(ctx context.Context) ([]trie.MinedNode, error) {
+	if b.mined == nil {
+		block, err := b.resolve(ctx)
+		if err != nil {
+			return nil, err
+		}
+		state, _, err := b.backend.StateAndHeaderByNumber(ctx, rpc.BlockNumber(block.NumberU64()))
+		if state == nil || err != nil {
+			return nil, err
+		}
+		triedb := state.Database().TrieDB()
+		if triedb == nil {
+			return nil, TrieUnavailableError
+		}
+		mined, err := triedb.MinedNodes(b.backend.ChainConfig().TrieHashimoto, block.Root(), block.NumberU64())
+		if err != nil {
+			return nil, err
+		}
+		if len(mined) != len(block.TrieNonces()) {
+			return nil, fmt.Errorf("%d trie nodes mined, %d nonces listed", len(mined), len(block.TrieNonces()))
+		}
+		b.mined = mined
+	}
+	return b.mined, nil
+}
+
 func (b *Block) Number(ctx context.Context) (hexutil.Uint64, error) {
 	if b.num == nil || *b.num == rpc.LatestBlockNumber {
 		header, err := b.resolveHeader(ctx)
@@ -802,6 +861,106 @@ func (b *Block) Account(ctx context.Context, args struct {
 	}, nil
 }
 
+func (b *Block) TrieNonces(ctx context.Context) (*[]*TrieNonce, error) {
+	block, err := b.resolve(ctx)
+	if err != nil || block == nil {
+		return nil, err
+	}
+	ret := make([]*TrieNonce, 0, len(block.TrieNonces()))
+	for i, nonce := range block.TrieNonces() {
+		ret = append(ret, &TrieNonce{
+			block: b,
+			index: i,
+			nonce: nonce,
+		})
+	}
+	return &ret, nil
+}
+
+func (b *Block) THMiningStats(ctx context.Context) (*THMiningStats, error) {
+	block, err := b.resolve(ctx)
+	if err != nil || block == nil {
+		return nil, err
+	}
+	config := b.backend.ChainConfig()
+	if !config.IsTrieHashimoto(block.Number()) {
+		return nil, nil
+	}
+	return &THMiningStats{
+		block:        block,
+		prefixLength: config.TrieHashimoto.PrefixLength,
+	}, nil
+}
+
+// TrieNonce represents the Trie-Hashimoto nonce of an account trie node mined in
+// a block. The node hashes are looked up in the state of the block when needed.
+type TrieNonce struct {
+	block *Block
+	index int
+	nonce uint64
+}
+
+func (t *TrieNonce) Nonce(ctx context.Context) hexutil.Uint64 {
+	return hexutil.Uint64(t.nonce)
+}
+
+func (t *TrieNonce) BeforeHash(ctx context.Context) (*common.Hash, error) {
+	mined, err := t.block.resolveMinedNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &mined[t.index].OriginalHash, nil
+}
+
+func (t *TrieNonce) AfterHash(ctx context.Context) (*common.Hash, error) {
+	mined, err := t.block.resolveMinedNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &mined[t.index].Hash, nil
+}
+
+// THMiningStats represents the Trie-Hashimoto mining done for a block.
+type THMiningStats struct {
+	block        *types.Block
+	prefixLength int
+}
+
+func (s *THMiningStats) TrieDifficulty(ctx context.Context) hexutil.Big {
+	if difficulty := s.block.TrieDifficulty(); difficulty != nil {
+		return hexutil.Big(*difficulty)
+	}
+	return hexutil.Big{}
+}
+
+func (s *THMiningStats) Prefix(ctx context.Context) hexutil.Uint64 {
+	number := s.block.NumberU64()
+	if epochLength := trie.PrefixEpochLength(s.prefixLength); epochLength != 0 {
+		number %= epochLength
+	}
+	return hexutil.Uint64(number)
+}
+
+func (s *THMiningStats) Epoch(ctx context.Context) hexutil.Uint64 {
+	return hexutil.Uint64(trie.PrefixEpoch(s.block.NumberU64(), s.prefixLength))
+}
+
+func (s *THMiningStats) TrieNodes(ctx context.Context) int32 {
+	return int32(len(s.block.TrieNonces()))
+}
+
+func (s *THMiningStats) StorageTries(ctx context.Context) int32 {
+	return int32(len(s.block.StorageNonces()))
+}
+
+func (s *THMiningStats) StorageNodes(ctx context.Context) int32 {
+	var nodes int
+	for _, storage := range s.block.StorageNonces() {
+		nodes += len(storage.Nonces)
+	}
+	return int32(nodes)
+}
+
 // CallData encapsulates arguments to `call` or `estimateGas`.
 // All arguments are optional.
 type CallData struct {
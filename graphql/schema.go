@@ -52,6 +52,11 @@ const schema string = `
         # Storage provides access to the storage of a contract account, indexed
         # by its 32 byte slot identifier.
         storage(slot: Bytes32!): Bytes32!
+        # StateNodeAge is the block number encoded in the hash prefix of the
+        # account trie node referencing the leaf of this account, i.e. the block
+        # it was last mined in. This will be null if the account doesn't exist,
+        # its leaf is the trie root, or the node predates Trie-Hashimoto.
+        stateNodeAge: Long
     }
 
     # Log is an Ethereum event log.
@@ -206,6 +211,43 @@ const schema string = `
         # EstimateGas estimates the amount of gas that will be required for
         # successful execution of a transaction at the current block's state.
         estimateGas(data: CallData!): Long!
+        # TrieNonces is the list of Trie-Hashimoto nonces of the account trie
+        # nodes mined in this block, in mining order. If the block is unavailable,
+        # this field will be null.
+        trieNonces: [TrieNonce!]
+        # THMiningStats summarises the Trie-Hashimoto mining of this block. This
+        # will be null for blocks before Trie-Hashimoto activation.
+        thMiningStats: THMiningStats
+    }
+
+    # TrieNonce is the Trie-Hashimoto nonce of an account trie node mined in a block.
+    type TrieNonce {
+        # Nonce is the nonce the node was mined with.
+        nonce: Long!
+        # BeforeHash is the hash the node had before it was mined, with a zero
+        # nonce. This will be null if the state of the block is unavailable.
+        beforeHash: Bytes32
+        # AfterHash is the hash the node was mined into, prefixed with the block
+        # number. This will be null if the state of the block is unavailable.
+        afterHash: Bytes32
+    }
+
+    # THMiningStats is the Trie-Hashimoto mining done for a block.
+    type THMiningStats {
+        # TrieDifficulty is the difficulty of mining every trie node of the block.
+        trieDifficulty: BigInt!
+        # Prefix is the block number prefix of the hashes of the nodes mined in
+        # the block.
+        prefix: Long!
+        # Epoch is the number of times the block number prefix wrapped around
+        # before the block.
+        epoch: Long!
+        # TrieNodes is the number of account trie nodes mined in the block.
+        trieNodes: Int!
+        # StorageTries is the number of storage tries with nodes mined in the block.
+        storageTries: Int!
+        # StorageNodes is the number of storage trie nodes mined in the block.
+        storageNodes: Int!
     }
 
     # CallData represents the data associated with a local contract call.
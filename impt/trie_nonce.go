@@ -2,6 +2,7 @@ package impt
 
 import (
 	_"container/heap"
+	"encoding/json"
 	_"errors"
 	"io"
 	_"os"
@@ -57,6 +58,21 @@ func (tn *TrieNonce) DecodeRLP(s *rlp.Stream) error {
 	return nil
 }
 
+// MarshalJSON implements json.Marshaler, encoding the fields like TrieNonceRLP.
+func (tn *TrieNonce) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&TrieNonceRLP{Before: tn.before, After: tn.after, Nonce: tn.nonce})
+}
+
+// UnmarshalJSON implements json.Unmarshaler, decoding the fields of a TrieNonceRLP.
+func (tn *TrieNonce) UnmarshalJSON(input []byte) error {
+	var dec TrieNonceRLP
+	if err := json.Unmarshal(input, &dec); err != nil {
+		return err
+	}
+	tn.before, tn.after, tn.nonce = dec.Before, dec.After, dec.Nonce
+	return nil
+}
+
 // Before returns the hash of IMPT node before changed by mining
 func (tn *TrieNonce) Before() common.Hash {
 	return tn.before
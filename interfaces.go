@@ -21,9 +21,11 @@ import (
 	"context"
 	"errors"
 	"math/big"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/impt"
 )
 
 // NotFound is returned by API methods if the requested item does not exist.
@@ -62,10 +64,61 @@ type ChainReader interface {
 	TrieSizeByNumber(ctx context.Context, number *big.Int) (uint64, error)
 	MiningTimeByHash(ctx context.Context, hash common.Hash, threads int) (uint64, error)
 	MiningTimeByNumber(ctx context.Context, number *big.Int, threads int) (uint64, error)
+	TrieNoncesByNumber(ctx context.Context, number *big.Int) (*TrieNonces, error)
+	TrieNodeByHash(ctx context.Context, hash common.Hash) (*TrieNode, error)
 
 	// This method subscribes to notifications about changes of the head block of
 	// the canonical chain.
 	SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (Subscription, error)
+
+	// This method subscribes to notifications about the progress of the trie
+	// node mining of the node.
+	SubscribeTrieMiningProgress(ctx context.Context, ch chan<- *TrieMiningProgress) (Subscription, error)
+}
+
+// TrieNonces is the Trie-Hashimoto nonces of the trie nodes mined in a block,
+// each with the hash of the node before and after it was mined.
+type TrieNonces struct {
+	Number        uint64
+	Hash          common.Hash
+	TrieNonces    []*impt.TrieNonce   // Nonces of the account trie nodes
+	StorageNonces []StorageTrieNonces // Nonces of the storage trie nodes, per account
+}
+
+// StorageTrieNonces is the Trie-Hashimoto nonces of the storage trie nodes of an
+// account mined in a block.
+type StorageTrieNonces struct {
+	Address common.Address
+	Nonces  []*impt.TrieNonce
+}
+
+// TrieNode is a decoded trie node along with its Trie-Hashimoto nonce.
+type TrieNode struct {
+	Hash         common.Hash
+	OriginalHash common.Hash // Hash of the node with a zero nonce
+	Full         bool        // Whether the node is a full node rather than a short node
+	Key          []byte      // Key nibbles of a short node, terminated with 16 for leaves
+	Value        []byte      // Value of a leaf or of the value slot of a full node
+	Children     [][]byte    // Child references, either hashes or embedded node encodings
+	Nonce        uint64
+	PrefixBlock  *uint64 // Block the hash prefix refers to, nil if none
+	Valid        bool    // Whether the node hashes to its hash
+	Encoding     []byte
+}
+
+// TrieMiningProgress is the progress of the Trie-Hashimoto mining of a node.
+type TrieMiningProgress struct {
+	Mining         bool          // Whether the trie nodes of a block are being mined
+	Number         uint64        // Block whose trie nodes are being mined
+	TrieDifficulty *big.Int      // Trie difficulty of the block
+	Threads        int           // Local threads mining the nodes
+	NodesMined     uint64        // Nodes mined so far
+	Elapsed        time.Duration // Time since the round started
+	TrieHashrate   uint64        // Local trie node hashrate
+
+	LastNumber  uint64        // Block of the latest completed round
+	LastNodes   uint64        // Nodes mined in the latest completed round
+	LastElapsed time.Duration // Time taken by the latest completed round
 }
 
 // TransactionReader provides access to past transactions and their receipts.
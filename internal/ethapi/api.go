@@ -19,6 +19,7 @@ package ethapi
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"math/big"
@@ -554,26 +555,46 @@ func (s *PublicBlockChainAPI) GetBalance(ctx context.Context, address common.Add
 
 // Result structs for GetProof
 type AccountResult struct {
-	Address      common.Address  `json:"address"`
-	AccountProof []string        `json:"accountProof"`
-	Balance      *hexutil.Big    `json:"balance"`
-	CodeHash     common.Hash     `json:"codeHash"`
-	Nonce        hexutil.Uint64  `json:"nonce"`
-	StorageHash  common.Hash     `json:"storageHash"`
-	StorageProof []StorageResult `json:"storageProof"`
+	Address           common.Address    `json:"address"`
+	AccountProof      []string          `json:"accountProof"`
+	AccountProofNodes []ProofNodeResult `json:"accountProofNodes,omitempty"`
+	Balance           *hexutil.Big      `json:"balance"`
+	CodeHash          common.Hash       `json:"codeHash"`
+	Nonce             hexutil.Uint64    `json:"nonce"`
+	StorageHash       common.Hash       `json:"storageHash"`
+	StorageProof      []StorageResult   `json:"storageProof"`
 }
 type StorageResult struct {
-	Key   string       `json:"key"`
-	Value *hexutil.Big `json:"value"`
-	Proof []string     `json:"proof"`
+	Key        string            `json:"key"`
+	Value      *hexutil.Big      `json:"value"`
+	Proof      []string          `json:"proof"`
+	ProofNodes []ProofNodeResult `json:"proofNodes,omitempty"`
+}
+
+// ProofNodeResult is the Trie-Hashimoto data of a proof node: the hash it's
+// referenced by, its nonce, the block number prefix of the hash and the block
+// the prefix refers to, null if it precedes Trie-Hashimoto.
+type ProofNodeResult struct {
+	Hash        common.Hash     `json:"hash"`
+	Nonce       hexutil.Uint64  `json:"nonce"`
+	Prefix      hexutil.Uint64  `json:"prefix"`
+	PrefixBlock *hexutil.Uint64 `json:"prefixBlock"`
+}
+
+// ProofOptions are the optional settings of GetProof.
+type ProofOptions struct {
+	TrieHashimoto bool `json:"trieHashimoto"` // Also return the Trie-Hashimoto data of every proof node
 }
 
 // GetProof returns the Merkle-proof for a given account and optionally some storage keys.
-func (s *PublicBlockChainAPI) GetProof(ctx context.Context, address common.Address, storageKeys []string, blockNr rpc.BlockNumber) (*AccountResult, error) {
-	state, _, err := s.b.StateAndHeaderByNumber(ctx, blockNr)
+// With the trieHashimoto option, the nonce and hash prefix of every proof node are
+// returned too, so that the proof can be checked with trie.VerifyProofTH.
+func (s *PublicBlockChainAPI) GetProof(ctx context.Context, address common.Address, storageKeys []string, blockNr rpc.BlockNumber, options *ProofOptions) (*AccountResult, error) {
+	state, header, err := s.b.StateAndHeaderByNumber(ctx, blockNr)
 	if state == nil || err != nil {
 		return nil, err
 	}
+	withNodes := options != nil && options.TrieHashimoto
 
 	storageTrie := state.StorageTrie(address)
 	storageHash := types.EmptyRootHash
@@ -591,23 +612,26 @@ func (s *PublicBlockChainAPI) GetProof(ctx context.Context, address common.Addre
 	// create the proof for the storageKeys
 	for i, key := range storageKeys {
 		if storageTrie != nil {
-			proof, storageError := state.GetStorageProof(address, common.HexToHash(key))
+			hashes, proof, storageError := state.GetStorageProofNodes(address, common.HexToHash(key))
 			if storageError != nil {
 				return nil, storageError
 			}
-			storageProof[i] = StorageResult{key, (*hexutil.Big)(state.GetState(address, common.HexToHash(key)).Big()), common.ToHexArray(proof)}
+			storageProof[i] = StorageResult{key, (*hexutil.Big)(state.GetState(address, common.HexToHash(key)).Big()), common.ToHexArray(proof), nil}
+			if withNodes {
+				storageProof[i].ProofNodes = s.proofNodes(hashes, proof, header.Number.Uint64())
+			}
 		} else {
-			storageProof[i] = StorageResult{key, &hexutil.Big{}, []string{}}
+			storageProof[i] = StorageResult{key, &hexutil.Big{}, []string{}, nil}
 		}
 	}
 
 	// create the accountProof
-	accountProof, proofErr := state.GetProof(address)
+	hashes, accountProof, proofErr := state.GetProofNodes(address)
 	if proofErr != nil {
 		return nil, proofErr
 	}
 
-	return &AccountResult{
+	result := &AccountResult{
 		Address:      address,
 		AccountProof: common.ToHexArray(accountProof),
 		Balance:      (*hexutil.Big)(state.GetBalance(address)),
@@ -615,7 +639,37 @@ func (s *PublicBlockChainAPI) GetProof(ctx context.Context, address common.Addre
 		Nonce:        hexutil.Uint64(state.GetNonce(address)),
 		StorageHash:  storageHash,
 		StorageProof: storageProof,
-	}, state.Error()
+	}
+	if withNodes {
+		result.AccountProofNodes = s.proofNodes(hashes, accountProof, header.Number.Uint64())
+	}
+	return result, state.Error()
+}
+
+// proofNodes returns the Trie-Hashimoto data of the nodes of a proof in the state
+// of the given block.
+func (s *PublicBlockChainAPI) proofNodes(hashes []common.Hash, proof [][]byte, number uint64) []ProofNodeResult {
+	config := s.b.ChainConfig().TrieHashimoto
+
+	nodes := make([]ProofNodeResult, len(proof))
+	for i, blob := range proof {
+		nodes[i].Hash = hashes[i]
+		if len(blob) >= 8 {
+			nodes[i].Nonce = hexutil.Uint64(binary.LittleEndian.Uint64(blob[len(blob)-8:]))
+		}
+		if config == nil {
+			continue
+		}
+		nodes[i].Prefix = hexutil.Uint64(trie.HashPrefix(hashes[i], config.PrefixLength))
+		// Nodes left unmined carry a zero nonce under their plain hash
+		if nodes[i].Nonce == 0 && crypto.Keccak256Hash(blob) == hashes[i] {
+			continue
+		}
+		if age, ok := trie.NodeAge(s.b.ChainDb(), config, hashes[i], number); ok {
+			nodes[i].PrefixBlock = (*hexutil.Uint64)(&age)
+		}
+	}
+	return nodes
 }
 
 // GetBlockByNumber returns the requested block. When blockNr is -1 the chain head is returned. When fullTx is true all
@@ -715,8 +769,12 @@ func (s *PublicBlockChainAPI) GetTrieSizeByHash(ctx context.Context, blockHash c
 
 // GetUncleCountByBlockNumber returns number of uncles in the block for the given block number
 func (s *PublicBlockChainAPI) GetMiningTimeByNumber(ctx context.Context, blockNr rpc.BlockNumber, threads int) uint64 {
+	th := s.b.ChainConfig().TrieHashimoto
+	if th == nil {
+		return 0
+	}
 	if block, _ := s.b.BlockByNumber(ctx, blockNr); block != nil {
-		size := trie.MiningTime(s.b.ChainDb(), (block.Header().Root)[:], block.Header().Number.Uint64(), threads)
+		size := trie.MiningTime(s.b.ChainDb(), (block.Header().Root)[:], block.Header().Number.Uint64(), threads, th)
 		return size
 	}
 	return 0
@@ -724,8 +782,12 @@ func (s *PublicBlockChainAPI) GetMiningTimeByNumber(ctx context.Context, blockNr
 
 // GetUncleCountByBlockNumber returns number of uncles in the block for the given block number
 func (s *PublicBlockChainAPI) GetMiningTimeByHash(ctx context.Context, blockHash common.Hash, threads int) uint64 {
+	th := s.b.ChainConfig().TrieHashimoto
+	if th == nil {
+		return 0
+	}
 	if block, _ := s.b.GetBlock(ctx, blockHash); block != nil {
-		size := trie.MiningTime(s.b.ChainDb(), (block.Header().Root)[:], block.Header().Number.Uint64(), threads)
+		size := trie.MiningTime(s.b.ChainDb(), (block.Header().Root)[:], block.Header().Number.Uint64(), threads, th)
 		return size
 	}
 	return 0
@@ -995,6 +1057,12 @@ func RPCMarshalBlock(b *types.Block, inclTx bool, fullTx bool) (map[string]inter
 		"transactionsRoot": head.TxHash,
 		"receiptsRoot":     head.ReceiptHash,
 		"trieNonces":		b.TrieNonces(),
+		"storageNonces":	b.StorageNonces(),
+	}
+	if head.TrieNoncesHash != (common.Hash{}) {
+		fields["trieNoncesHash"] = head.TrieNoncesHash
+		fields["trieDifficulty"] = (*hexutil.Big)(head.TrieDifficulty)
+		fields["trieTime"] = hexutil.Uint64(head.TrieTime)
 	}
 
 	if inclTx {
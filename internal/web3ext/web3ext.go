@@ -534,6 +534,11 @@ web3._extend({
 				return formatted;
 			}
 		}),
+		new web3._extend.Property({
+			name: 'trieHashrate',
+			getter: 'eth_trieHashrate',
+			outputFormatter: web3._extend.utils.toDecimal
+		}),
 	]
 });
 `
@@ -578,6 +583,10 @@ web3._extend({
 			name: 'getHashrate',
 			call: 'miner_getHashrate'
 		}),
+		new web3._extend.Method({
+			name: 'getTrieHashrate',
+			call: 'miner_getTrieHashrate'
+		}),
 	],
 	properties: []
 });
@@ -131,7 +131,7 @@ func GetBlock(ctx context.Context, odr OdrBackend, hash common.Hash, number uint
 		return nil, err
 	}
 	// Reassemble the block and return
-	return types.NewBlockWithHeader(header).WithBody(body.Transactions, body.Uncles, body.TrieNonces), nil
+	return types.NewBlockWithHeader(header).WithBody(body.Transactions, body.Uncles, body.TrieNonces, body.StorageNonces), nil
 }
 
 // GetBlockReceipts retrieves the receipts generated by the transactions included
@@ -20,14 +20,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/trie"
-	
 )
 
 func NewState(ctx context.Context, head *types.Header, odr OdrBackend) *state.StateDB {
@@ -135,18 +136,18 @@ func (t *odrTrie) Hash() common.Hash {
 }
 
 
-func (t *odrTrie) HashWithNonce(blockNum uint64, threads int) (common.Hash, []uint64) {
+func (t *odrTrie) HashWithNonce(config *params.TrieHashimotoConfig, blockNum uint64, difficulty *big.Int, miner *trie.Miner) (common.Hash, []uint64, error) {
 	if t.trie == nil {
-		return t.id.Root, nil
+		return t.id.Root, nil, nil
 	}
-	return t.trie.HashWithNonce(blockNum, threads)
+	return t.trie.HashWithNonce(config, blockNum, difficulty, miner)
 }
 
-func (t *odrTrie) HashByNonce(trieNonces []uint64, blockNum uint64) common.Hash {
+func (t *odrTrie) HashByNonce(config *params.TrieHashimotoConfig, trieNonces []uint64, blockNum uint64, difficulty *big.Int, dataset trie.HeaderDataset) (common.Hash, error) {
 	if t.trie == nil {
-		return t.id.Root
+		return t.id.Root, nil
 	}
-	return t.trie.HashByNonce(trieNonces, blockNum)
+	return t.trie.HashByNonce(config, trieNonces, blockNum, difficulty, dataset)
 }
 
 func (t *odrTrie) NodeIterator(startkey []byte) trie.NodeIterator {
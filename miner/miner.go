@@ -51,8 +51,6 @@ type Config struct {
 	GasPrice  *big.Int       // Minimum gas price for mining a transaction
 	Recommit  time.Duration  // The time interval for miner to re-create mining work.
 	Noverify  bool           // Disable remote mining solution verification(only useful in ethash).
-	IMPT	  bool			 // Do IMPT mining
-	FakeIMPT  bool			 // Do IMPT mining with modifyHash()
 }
 
 // Miner creates blocks and searches for proof-of-work values.
@@ -153,6 +151,15 @@ func (self *Miner) HashRate() uint64 {
 	return 0
 }
 
+// TrieHashRate returns the Trie-Hashimoto trie node mining hashrate, which is
+// not part of HashRate.
+func (self *Miner) TrieHashRate() uint64 {
+	if pow, ok := self.engine.(consensus.PoW); ok {
+		return uint64(pow.TrieHashrate())
+	}
+	return 0
+}
+
 func (self *Miner) SetExtra(extra []byte) error {
 	if uint64(len(extra)) > params.MaximumExtraDataSize {
 		return fmt.Errorf("Extra exceeds max length. %d > %v", len(extra), params.MaximumExtraDataSize)
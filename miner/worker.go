@@ -35,6 +35,7 @@ import (
 	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/trie"
 )
 
 const (
@@ -505,6 +506,7 @@ func (w *worker) mainLoop() {
 func (w *worker) taskLoop() {
 	var (
 		stopCh chan struct{}
+		sealed chan struct{}
 		prev   common.Hash
 	)
 
@@ -514,6 +516,11 @@ func (w *worker) taskLoop() {
 			close(stopCh)
 			stopCh = nil
 		}
+		// Wait for the engine to give up the task, so sealing work stays in order
+		if sealed != nil {
+			<-sealed
+			sealed = nil
+		}
 	}
 	for {
 		select {
@@ -522,7 +529,7 @@ func (w *worker) taskLoop() {
 				w.newTaskHook(task)
 			}
 			// Reject duplicate sealing work due to resubmitting.
-			sealHash := w.engine.SealHash(task.block.Header())
+			sealHash := w.taskHash(task.block.Header())
 			if sealHash == prev {
 				continue
 			}
@@ -534,12 +541,14 @@ func (w *worker) taskLoop() {
 				continue
 			}
 			w.pendingMu.Lock()
-			w.pendingTasks[w.engine.SealHash(task.block.Header())] = task
+			w.pendingTasks[sealHash] = task
 			w.pendingMu.Unlock()
 
-			if err := w.engine.Seal(w.chain, task.block, task.state, w.resultCh, stopCh, w.config.IMPT); err != nil {
-				log.Warn("Block sealing failed", "err", err)
-			}
+			// Trie-Hashimoto mining may keep the engine busy for long, seal in
+			// the background to stay responsive to new tasks.
+			sealed = make(chan struct{})
+			go w.seal(task, sealHash, stopCh, sealed)
+
 		case <-w.exitCh:
 			interrupt()
 			return
@@ -547,6 +556,35 @@ func (w *worker) taskLoop() {
 	}
 }
 
+// seal pushes a sealing task to the consensus engine, closing sealed once the
+// engine returns. Tasks the engine fails or gives up on are dropped.
+func (w *worker) seal(task *task, sealHash common.Hash, stop <-chan struct{}, sealed chan struct{}) {
+	defer close(sealed)
+
+	err := w.engine.Seal(w.chain, task.block, task.state, w.resultCh, stop)
+	if err == nil {
+		return
+	}
+	if aborted, ok := err.(*trie.MiningAbortedError); ok {
+		log.Debug("Trie mining aborted", "number", aborted.Number, "mined", aborted.Mined)
+	} else {
+		log.Warn("Block sealing failed", "err", err)
+	}
+	w.pendingMu.Lock()
+	delete(w.pendingTasks, sealHash)
+	w.pendingMu.Unlock()
+}
+
+// taskHash returns the hash a sealing task is tracked under. Trie-Hashimoto
+// mining in the engine replaces the state root, trie nonce commitment and trie
+// time of the block, so these are left out to match sealed blocks with their
+// tasks.
+func (w *worker) taskHash(header *types.Header) common.Hash {
+	header = types.CopyHeader(header)
+	header.Root, header.TrieNoncesHash, header.TrieTime = common.Hash{}, common.Hash{}, 0
+	return w.engine.SealHash(header)
+}
+
 // resultLoop is a standalone goroutine to handle sealing result submitting
 // and flush relative data to the database.
 func (w *worker) resultLoop() {
@@ -566,7 +604,7 @@ func (w *worker) resultLoop() {
 				hash     = block.Hash()
 			)
 			w.pendingMu.RLock()
-			task, exist := w.pendingTasks[sealhash]
+			task, exist := w.pendingTasks[w.taskHash(block.Header())]
 			w.pendingMu.RUnlock()
 			if !exist {
 				log.Error("Block found but no relative pending task", "number", block.Number(), "sealhash", sealhash, "hash", hash)
@@ -852,6 +890,10 @@ func (w *worker) commitNewWork(interrupt *int32, noempty bool, timestamp int64)
 	if parent.Time() >= uint64(timestamp) {
 		timestamp = int64(parent.Time() + 1)
 	}
+	// the block can't be timestamped before the trie nodes of its parent were mined
+	if parent.TrieTime() > uint64(timestamp) {
+		timestamp = int64(parent.TrieTime())
+	}
 	// this will ensure we're not going off too far in the future
 	// if now := time.Now().Unix(); timestamp > now+1 {
 	// 	wait := time.Duration(timestamp-now) * time.Second
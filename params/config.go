@@ -209,16 +209,29 @@ var (
 	//
 	// This configuration is intentionally not using keyed fields to force anyone
 	// adding flags to the config to also have to set these fields.
-	AllEthashProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, new(EthashConfig), nil}
+	AllEthashProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, new(EthashConfig), nil}
 
 	// AllCliqueProtocolChanges contains every protocol change (EIPs) introduced
 	// and accepted by the Ethereum core developers into the Clique consensus.
 	//
 	// This configuration is intentionally not using keyed fields to force anyone
 	// adding flags to the config to also have to set these fields.
-	AllCliqueProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, &CliqueConfig{Period: 0, Epoch: 30000}}
-
-	TestChainConfig = &ChainConfig{big.NewInt(1), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, new(EthashConfig), nil}
+	AllCliqueProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, &CliqueConfig{Period: 0, Epoch: 30000}}
+
+	// DefaultTrieHashimotoConfig contains the Trie-Hashimoto parameters the TH
+	// experiments were originally run with (node hashes prefixed without mining,
+	// dataset capped at the size of the ethash dataset at block 8,000,000), as in
+	// the genesis of build/bin.
+	DefaultTrieHashimotoConfig = &TrieHashimotoConfig{
+		Block:        big.NewInt(0),
+		Fake:         true,
+		PrefixLength: 2,
+		ReadHeader:   true,
+		LoopAccesses: 1,
+		DatasetLen:   826277728,
+	}
+
+	TestChainConfig = &ChainConfig{big.NewInt(1), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, new(EthashConfig), nil}
 	TestRules       = TestChainConfig.Rules(new(big.Int))
 )
 
@@ -289,6 +302,9 @@ type ChainConfig struct {
 	PetersburgBlock     *big.Int `json:"petersburgBlock,omitempty"`     // Petersburg switch block (nil = same as Constantinople)
 	EWASMBlock          *big.Int `json:"ewasmBlock,omitempty"`          // EWASM switch block (nil = no fork, 0 = already activated)
 
+	// Trie-Hashimoto state indexing on top of the consensus engine
+	TrieHashimoto *TrieHashimotoConfig `json:"trieHashimoto,omitempty"`
+
 	// Various consensus engines
 	Ethash *EthashConfig `json:"ethash,omitempty"`
 	Clique *CliqueConfig `json:"clique,omitempty"`
@@ -302,6 +318,64 @@ func (c *EthashConfig) String() string {
 	return "ethash"
 }
 
+// TrieHashimotoConfig is the configuration of Trie-Hashimoto (TH) mining, which
+// indexes every dirty state trie node by the number of the block that wrote it.
+type TrieHashimotoConfig struct {
+	Block *big.Int `json:"block,omitempty"` // TH switch block (nil = no fork, 0 = already activated)
+
+	Fake         bool   `json:"fake,omitempty"`       // Prefix node hashes with the block number without mining
+	PrefixLength int    `json:"prefixLength"`         // Number of node hash bytes carrying the block number
+	ReadHeader   bool   `json:"readHeader,omitempty"` // Mix the header dataset into node hashes for memory hardness
+	LoopAccesses int    `json:"loopAccesses"`         // Number of header dataset accesses per mining attempt
+	DatasetLen   uint32 `json:"datasetLen,omitempty"` // Maximum header dataset length in uint32s (0 = unbounded)
+
+	InitialDifficulty *big.Int `json:"initialDifficulty,omitempty"` // Trie difficulty of the TH switch block (nil = 1)
+	TargetTime        uint64   `json:"targetTime,omitempty"`        // Block time in seconds the trie difficulty is retargeted to (0 = fixed)
+}
+
+// String implements the stringer interface, returning the TH mining details.
+func (c *TrieHashimotoConfig) String() string {
+	return fmt.Sprintf("{Block: %v Fake: %v PrefixLength: %v ReadHeader: %v LoopAccesses: %v DatasetLen: %v InitialDifficulty: %v TargetTime: %v}",
+		c.Block,
+		c.Fake,
+		c.PrefixLength,
+		c.ReadHeader,
+		c.LoopAccesses,
+		c.DatasetLen,
+		c.InitialDifficulty,
+		c.TargetTime,
+	)
+}
+
+// CheckConfig checks that the Trie-Hashimoto parameters can be mined and
+// verified with, returning an error about the first one that can't. A nil
+// config is valid, TH is simply not configured.
+func (c *TrieHashimotoConfig) CheckConfig() error {
+	switch {
+	case c == nil:
+		return nil
+	case c.PrefixLength < 1 || c.PrefixLength > 8:
+		return fmt.Errorf("invalid Trie-Hashimoto prefix length %d, want 1-8", c.PrefixLength)
+	case c.ReadHeader && c.LoopAccesses <= 0:
+		return fmt.Errorf("invalid Trie-Hashimoto loop accesses %d, want at least 1 to read the header dataset", c.LoopAccesses)
+	case c.InitialDifficulty != nil && c.InitialDifficulty.Sign() <= 0:
+		return fmt.Errorf("invalid Trie-Hashimoto initial difficulty %v, want at least 1", c.InitialDifficulty)
+	}
+	return nil
+}
+
+// sameRules returns whether the two configs mine and verify trie nodes and trie
+// difficulties alike, regardless of their switch block.
+func (c *TrieHashimotoConfig) sameRules(other *TrieHashimotoConfig) bool {
+	if c == nil || other == nil {
+		return c == other
+	}
+	return c.Fake == other.Fake && c.PrefixLength == other.PrefixLength &&
+		c.ReadHeader == other.ReadHeader && c.LoopAccesses == other.LoopAccesses &&
+		c.DatasetLen == other.DatasetLen && configNumEqual(c.InitialDifficulty, other.InitialDifficulty) &&
+		c.TargetTime == other.TargetTime
+}
+
 // CliqueConfig is the consensus engine configs for proof-of-authority based sealing.
 type CliqueConfig struct {
 	Period uint64 `json:"period"` // Number of seconds between blocks to enforce
@@ -324,7 +398,7 @@ func (c *ChainConfig) String() string {
 	default:
 		engine = "unknown"
 	}
-	return fmt.Sprintf("{ChainID: %v Homestead: %v DAO: %v DAOSupport: %v EIP150: %v EIP155: %v EIP158: %v Byzantium: %v Constantinople: %v  Petersburg: %v Engine: %v}",
+	return fmt.Sprintf("{ChainID: %v Homestead: %v DAO: %v DAOSupport: %v EIP150: %v EIP155: %v EIP158: %v Byzantium: %v Constantinople: %v  Petersburg: %v TrieHashimoto: %v Engine: %v}",
 		c.ChainID,
 		c.HomesteadBlock,
 		c.DAOForkBlock,
@@ -335,6 +409,7 @@ func (c *ChainConfig) String() string {
 		c.ByzantiumBlock,
 		c.ConstantinopleBlock,
 		c.PetersburgBlock,
+		c.TrieHashimoto,
 		engine,
 	)
 }
@@ -386,6 +461,12 @@ func (c *ChainConfig) IsEWASM(num *big.Int) bool {
 	return isForked(c.EWASMBlock, num)
 }
 
+// IsTrieHashimoto returns whether num is either equal to the Trie-Hashimoto fork
+// block or greater.
+func (c *ChainConfig) IsTrieHashimoto(num *big.Int) bool {
+	return c.TrieHashimoto != nil && isForked(c.TrieHashimoto.Block, num)
+}
+
 // GasTable returns the gas table corresponding to the current phase (homestead or homestead reprice).
 //
 // The returned GasTable's fields shouldn't, under any circumstances, be changed.
@@ -457,9 +538,26 @@ func (c *ChainConfig) checkCompatible(newcfg *ChainConfig, head *big.Int) *Confi
 	if isForkIncompatible(c.EWASMBlock, newcfg.EWASMBlock, head) {
 		return newCompatError("ewasm fork block", c.EWASMBlock, newcfg.EWASMBlock)
 	}
+	if isForkIncompatible(c.trieHashimotoBlock(), newcfg.trieHashimotoBlock(), head) {
+		return newCompatError("Trie-Hashimoto fork block", c.trieHashimotoBlock(), newcfg.trieHashimotoBlock())
+	}
+	// Past the TH switch, the stored trie nodes and headers were mined with the
+	// stored parameters, which may thus not change
+	if isForked(c.trieHashimotoBlock(), head) && !c.TrieHashimoto.sameRules(newcfg.TrieHashimoto) {
+		return newCompatError("Trie-Hashimoto parameters", c.trieHashimotoBlock(), newcfg.trieHashimotoBlock())
+	}
 	return nil
 }
 
+// trieHashimotoBlock returns the Trie-Hashimoto switch block, or nil if TH is
+// not configured at all.
+func (c *ChainConfig) trieHashimotoBlock() *big.Int {
+	if c.TrieHashimoto == nil {
+		return nil
+	}
+	return c.TrieHashimoto.Block
+}
+
 // isForkIncompatible returns true if a fork scheduled at s1 cannot be rescheduled to
 // block s2 because head is already past the fork.
 func isForkIncompatible(s1, s2, head *big.Int) bool {
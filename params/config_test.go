@@ -17,7 +17,10 @@
 package params
 
 import (
+	"encoding/json"
+	"io/ioutil"
 	"math/big"
+	"path/filepath"
 	"reflect"
 	"testing"
 )
@@ -70,6 +73,62 @@ func TestCheckCompatible(t *testing.T) {
 				RewindTo:     9,
 			},
 		},
+		{
+			stored:  &ChainConfig{TrieHashimoto: &TrieHashimotoConfig{Block: big.NewInt(10)}},
+			new:     &ChainConfig{TrieHashimoto: &TrieHashimotoConfig{Block: big.NewInt(20)}},
+			head:    9,
+			wantErr: nil,
+		},
+		{
+			stored: &ChainConfig{TrieHashimoto: &TrieHashimotoConfig{Block: big.NewInt(10)}},
+			new:    &ChainConfig{},
+			head:   15,
+			wantErr: &ConfigCompatError{
+				What:         "Trie-Hashimoto fork block",
+				StoredConfig: big.NewInt(10),
+				NewConfig:    nil,
+				RewindTo:     9,
+			},
+		},
+		{
+			stored:  &ChainConfig{TrieHashimoto: &TrieHashimotoConfig{Block: big.NewInt(10), PrefixLength: 1}},
+			new:     &ChainConfig{TrieHashimoto: &TrieHashimotoConfig{Block: big.NewInt(10), PrefixLength: 2}},
+			head:    9,
+			wantErr: nil,
+		},
+		{
+			stored: &ChainConfig{TrieHashimoto: &TrieHashimotoConfig{Block: big.NewInt(10), PrefixLength: 1}},
+			new:    &ChainConfig{TrieHashimoto: &TrieHashimotoConfig{Block: big.NewInt(10), PrefixLength: 2}},
+			head:   10,
+			wantErr: &ConfigCompatError{
+				What:         "Trie-Hashimoto parameters",
+				StoredConfig: big.NewInt(10),
+				NewConfig:    big.NewInt(10),
+				RewindTo:     9,
+			},
+		},
+		{
+			stored: &ChainConfig{TrieHashimoto: &TrieHashimotoConfig{Block: big.NewInt(10), PrefixLength: 1, ReadHeader: true, LoopAccesses: 1}},
+			new:    &ChainConfig{TrieHashimoto: &TrieHashimotoConfig{Block: big.NewInt(10), PrefixLength: 1, ReadHeader: true, LoopAccesses: 2}},
+			head:   15,
+			wantErr: &ConfigCompatError{
+				What:         "Trie-Hashimoto parameters",
+				StoredConfig: big.NewInt(10),
+				NewConfig:    big.NewInt(10),
+				RewindTo:     9,
+			},
+		},
+		{
+			stored: &ChainConfig{TrieHashimoto: &TrieHashimotoConfig{Block: big.NewInt(10), PrefixLength: 1}},
+			new:    &ChainConfig{TrieHashimoto: &TrieHashimotoConfig{Block: big.NewInt(10), PrefixLength: 1, Fake: true}},
+			head:   15,
+			wantErr: &ConfigCompatError{
+				What:         "Trie-Hashimoto parameters",
+				StoredConfig: big.NewInt(10),
+				NewConfig:    big.NewInt(10),
+				RewindTo:     9,
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -79,3 +138,46 @@ func TestCheckCompatible(t *testing.T) {
 		}
 	}
 }
+
+func TestCheckTrieHashimotoConfig(t *testing.T) {
+	tests := []struct {
+		config *TrieHashimotoConfig
+		fail   bool
+	}{
+		{config: nil},
+		{config: DefaultTrieHashimotoConfig},
+		{config: &TrieHashimotoConfig{PrefixLength: 1}},
+		{config: &TrieHashimotoConfig{PrefixLength: 8, ReadHeader: true, LoopAccesses: 1}},
+		{config: &TrieHashimotoConfig{PrefixLength: 0}, fail: true},
+		{config: &TrieHashimotoConfig{PrefixLength: 9}, fail: true},
+		{config: &TrieHashimotoConfig{PrefixLength: 2, ReadHeader: true}, fail: true},
+		{config: &TrieHashimotoConfig{PrefixLength: 2, ReadHeader: true, LoopAccesses: -1}, fail: true},
+		{config: &TrieHashimotoConfig{PrefixLength: 2, InitialDifficulty: big.NewInt(0)}, fail: true},
+	}
+	for i, tt := range tests {
+		if err := tt.config.CheckConfig(); (err != nil) != tt.fail {
+			t.Errorf("test %d: config %v: have error %v, want failure %v", i, tt.config, err, tt.fail)
+		}
+	}
+}
+
+// Tests that the default Trie-Hashimoto parameters are those of the genesis the
+// experiments are run with.
+func TestDefaultTrieHashimotoConfig(t *testing.T) {
+	blob, err := ioutil.ReadFile(filepath.Join("..", "build", "bin", "genesis.json"))
+	if err != nil {
+		t.Fatalf("failed to read genesis: %v", err)
+	}
+	var genesis struct {
+		Config *ChainConfig `json:"config"`
+	}
+	if err := json.Unmarshal(blob, &genesis); err != nil {
+		t.Fatalf("failed to decode genesis: %v", err)
+	}
+	if genesis.Config.TrieHashimoto == nil {
+		t.Fatalf("genesis without Trie-Hashimoto config")
+	}
+	if have, want := DefaultTrieHashimotoConfig.String(), genesis.Config.TrieHashimoto.String(); have != want {
+		t.Fatalf("default config mismatch:\nhave %v\nwant %v", have, want)
+	}
+}
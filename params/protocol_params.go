@@ -92,4 +92,7 @@ var (
 	GenesisDifficulty      = big.NewInt(131072) // Difficulty of the Genesis block.
 	MinimumDifficulty      = big.NewInt(131072) // The minimum that the difficulty may ever be.
 	DurationLimit          = big.NewInt(13)     // The decision boundary on the blocktime duration used to determine whether difficulty should go up or not.
+
+	TrieDifficultyBoundDivisor = big.NewInt(16) // The bound divisor of the trie difficulty, used in the update calculations.
+	MinimumTrieDifficulty      = big.NewInt(1)  // The minimum that the trie difficulty may ever be.
 )
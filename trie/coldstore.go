@@ -0,0 +1,293 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+var (
+	// ColdIndexPrefix is the database key prefix of the cold trie node index:
+	// ColdIndexPrefix + node hash -> offset (uint64 big endian) + length (uint32
+	// big endian) of the node in the cold store data file.
+	ColdIndexPrefix = []byte("th-cold-")
+
+	// coldProgressKey tracks the first block whose nodes were not migrated yet.
+	coldProgressKey = []byte("THColdProgress")
+
+	// errColdStoreClosed is returned when accessing a closed cold store.
+	errColdStoreClosed = errors.New("cold store closed")
+)
+
+const (
+	coldIndexKeyLength   = 8 + common.HashLength // Length of the above prefix + 32 byte hash
+	coldIndexEntryLength = 8 + 4                 // Length of a cold index value
+	coldDataFile         = "nodes.dat"           // Name of the cold store data file
+)
+
+var (
+	coldReadMeter         = metrics.NewRegisteredMeter("trie/cold/read", nil)
+	coldMigrateTimer      = metrics.NewRegisteredResettingTimer("trie/cold/migrate/time", nil)
+	coldMigrateNodesMeter = metrics.NewRegisteredMeter("trie/cold/migrate/nodes", nil)
+	coldMigrateSizeMeter  = metrics.NewRegisteredMeter("trie/cold/migrate/size", nil)
+)
+
+// ColdNodeStore is implemented by disk databases that keep old trie nodes in a
+// cold store. Databases created on top of them read the cold nodes transparently.
+type ColdNodeStore interface {
+	ColdStore() *ColdStore
+}
+
+// ColdStore is the cold tier of the trie node storage. Trie nodes mined long
+// enough ago are moved out of the key-value store, where the recent, hot ones
+// stay, into an append-only data file much like the ancient chain segments are
+// moved into the freezer. The key-value store only keeps a small index entry of
+// every cold node, so the nodes can still be looked up by hash.
+type ColdStore struct {
+	// WARNING: The `size` field is accessed atomically. On 32 bit platforms, only
+	// 64-bit aligned fields can be atomic. The struct is guaranteed to be so aligned,
+	// so take advantage of that (https://golang.org/pkg/sync/atomic/#pkg-note-BUG).
+	size uint64 // Number of bytes in the data file
+
+	db     ethdb.KeyValueStore // Key-value store holding the hot nodes and the cold index
+	shards *NodeShards         // Shards the hot nodes are split across, nil if none
+	file   *os.File            // Append-only data file of the cold nodes
+
+	lock    sync.RWMutex // Protects the data file from being closed while in use
+	migrate sync.Mutex   // Serializes migrations, the only writers of the data file
+}
+
+// NewColdStore opens the cold trie node store in the given directory, creating
+// it if needed, over the key-value store holding the hot trie nodes.
+func NewColdStore(dir string, db ethdb.KeyValueStore) (*ColdStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filepath.Join(dir, coldDataFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	// Nodes appended before a crash but never indexed are left behind as garbage,
+	// the next migration appends them again.
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &ColdStore{db: db, file: file, size: uint64(stat.Size())}, nil
+}
+
+// SetNodeShards makes the cold store migrate the hot nodes out of the given
+// shards instead of the key-value store alone.
+func (s *ColdStore) SetNodeShards(shards *NodeShards) {
+	s.migrate.Lock()
+	defer s.migrate.Unlock()
+
+	s.shards = shards
+}
+
+// coldIndexKey = ColdIndexPrefix + hash
+func coldIndexKey(hash common.Hash) []byte {
+	return append(append(make([]byte, 0, coldIndexKeyLength), ColdIndexPrefix...), hash[:]...)
+}
+
+// IsColdIndexKey returns whether the database key belongs to the cold node index.
+func IsColdIndexKey(key []byte) bool {
+	return len(key) == coldIndexKeyLength && bytes.HasPrefix(key, ColdIndexPrefix)
+}
+
+// Node retrieves a trie node from the cold store.
+func (s *ColdStore) Node(hash common.Hash) ([]byte, error) {
+	entry, err := s.db.Get(coldIndexKey(hash))
+	if err != nil {
+		return nil, err
+	}
+	if len(entry) != coldIndexEntryLength {
+		return nil, errors.New("corrupt cold node index")
+	}
+	var (
+		offset = binary.BigEndian.Uint64(entry)
+		blob   = make([]byte, binary.BigEndian.Uint32(entry[8:]))
+	)
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if s.file == nil {
+		return nil, errColdStoreClosed
+	}
+	if _, err := s.file.ReadAt(blob, int64(offset)); err != nil {
+		return nil, err
+	}
+	coldReadMeter.Mark(int64(len(blob)))
+	return blob, nil
+}
+
+// Size returns the number of bytes in the cold store data file.
+func (s *ColdStore) Size() common.StorageSize {
+	return common.StorageSize(atomic.LoadUint64(&s.size))
+}
+
+// Migrate moves the trie nodes that the node age index records in blocks before
+// the given one from the key-value store into the cold store. It continues
+// where the last migration stopped, so every node is only visited once. Nodes
+// are appended and synced to the data file before they are deleted from the
+// key-value store, so a node is readable from either store all along. It returns
+// the number of nodes moved.
+func (s *ColdStore) Migrate(before uint64) (int, error) {
+	s.migrate.Lock()
+	defer s.migrate.Unlock()
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if s.file == nil {
+		return 0, errColdStoreClosed
+	}
+	var from uint64
+	if blob, _ := s.db.Get(coldProgressKey); len(blob) == 8 {
+		from = binary.BigEndian.Uint64(blob)
+	}
+	if from >= before {
+		return 0, nil
+	}
+	var (
+		start  = time.Now()
+		moved  int
+		size   uint64
+		batch  = s.db.NewBatch()
+		get    = func(hash common.Hash) ([]byte, error) { return s.db.Get(hash[:]) }
+		offset = atomic.LoadUint64(&s.size)
+		writer = bufio.NewWriter(&coldFileWriter{file: s.file, offset: int64(offset)})
+		it     = s.db.NewIteratorWithStart(nodeAgeKey(from, common.Hash{}))
+	)
+	defer it.Release()
+
+	if s.shards != nil {
+		batch, get = s.shards.NewBatch(), s.shards.Get
+	}
+	// flush writes the nodes to the data file ahead of their index entries
+	flush := func() error {
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+		if err := s.file.Sync(); err != nil {
+			return err
+		}
+		if err := batch.Write(); err != nil {
+			return err
+		}
+		batch.Reset()
+		return nil
+	}
+	for it.Next() {
+		key := it.Key()
+		if !bytes.HasPrefix(key, NodeAgePrefix) {
+			break
+		}
+		if !IsNodeAgeKey(key) {
+			continue
+		}
+		number, hash := SplitNodeAgeKey(key)
+		if number >= before {
+			break
+		}
+		// Skip nodes pruned or moved already
+		blob, err := get(hash)
+		if err != nil || len(blob) == 0 {
+			continue
+		}
+		if _, err := writer.Write(blob); err != nil {
+			return moved, err
+		}
+		entry := make([]byte, coldIndexEntryLength)
+		binary.BigEndian.PutUint64(entry, offset)
+		binary.BigEndian.PutUint32(entry[8:], uint32(len(blob)))
+		if err := batch.Put(coldIndexKey(hash), entry); err != nil {
+			return moved, err
+		}
+		if err := batch.Delete(hash[:]); err != nil {
+			return moved, err
+		}
+		offset += uint64(len(blob))
+		size += uint64(len(blob))
+		moved++
+
+		if batch.ValueSize() >= ethdb.IdealBatchSize {
+			if err := flush(); err != nil {
+				return moved, err
+			}
+			atomic.StoreUint64(&s.size, offset)
+		}
+	}
+	if err := it.Error(); err != nil {
+		return moved, err
+	}
+	var progress [8]byte
+	binary.BigEndian.PutUint64(progress[:], before)
+	if err := batch.Put(coldProgressKey, progress[:]); err != nil {
+		return moved, err
+	}
+	if err := flush(); err != nil {
+		return moved, err
+	}
+	atomic.StoreUint64(&s.size, offset)
+
+	coldMigrateTimer.UpdateSince(start)
+	coldMigrateNodesMeter.Mark(int64(moved))
+	coldMigrateSizeMeter.Mark(int64(size))
+
+	log.Debug("Moved trie nodes into cold store", "before", before, "nodes", moved, "size", common.StorageSize(size), "elapsed", common.PrettyDuration(time.Since(start)))
+	return moved, nil
+}
+
+// Close closes the cold store data file.
+func (s *ColdStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// coldFileWriter appends to the cold store data file at the given offset.
+type coldFileWriter struct {
+	file   *os.File
+	offset int64
+}
+
+func (w *coldFileWriter) Write(data []byte) (int, error) {
+	n, err := w.file.WriteAt(data, w.offset)
+	w.offset += int64(n)
+	return n, err
+}
@@ -0,0 +1,133 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+)
+
+// tieredTestDB is a key-value store with a cold trie node store on the side.
+type tieredTestDB struct {
+	*memorydb.Database
+	cold *ColdStore
+}
+
+func (db *tieredTestDB) ColdStore() *ColdStore { return db.cold }
+
+// checkTieredTrieComplete checks that every node of the trie is available from
+// either the hot or the cold store.
+func checkTieredTrieComplete(t *testing.T, diskdb *tieredTestDB, root common.Hash) {
+	trie, err := New(root, NewDatabase(diskdb))
+	if err != nil {
+		t.Fatalf("failed to open trie %x: %v", root, err)
+	}
+	it := trie.NodeIterator(nil)
+	for it.Next(true) {
+	}
+	if err := it.Error(); err != nil {
+		t.Fatalf("trie %x incomplete: %v", root, err)
+	}
+}
+
+// Tests that old trie nodes move into the cold store once, stay readable across
+// restarts and are dropped from it when pruned.
+func TestColdStoreMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coldstore-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	memdb := memorydb.New()
+	triedb := NewDatabase(memdb)
+
+	trie, _ := New(common.Hash{}, triedb)
+	for i := 0; i < 16; i++ {
+		trie.Update([]byte{byte(i), 0x01}, []byte{byte(i)})
+	}
+	oldRoot := commitMinedTrie(t, trie, triedb, 1)
+
+	trie, _ = New(oldRoot, triedb)
+	trie.Update([]byte{0x00, 0x01}, []byte("updated"))
+	newRoot := commitMinedTrie(t, trie, triedb, 2)
+
+	cold, err := NewColdStore(dir, memdb)
+	if err != nil {
+		t.Fatalf("failed to open cold store: %v", err)
+	}
+	diskdb := &tieredTestDB{Database: memdb, cold: cold}
+
+	// Move the nodes of the first block only
+	moved, err := cold.Migrate(2)
+	if err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	if moved == 0 {
+		t.Fatalf("no nodes moved")
+	}
+	if has, _ := memdb.Has(oldRoot[:]); has {
+		t.Errorf("old root %x still hot", oldRoot)
+	}
+	if has, _ := memdb.Has(newRoot[:]); !has {
+		t.Errorf("new root %x not hot", newRoot)
+	}
+	if blob, err := NewDatabase(diskdb).Node(oldRoot); err != nil || len(blob) == 0 {
+		t.Errorf("old root %x not readable: %v", oldRoot, err)
+	}
+	checkTieredTrieComplete(t, diskdb, oldRoot)
+	checkTieredTrieComplete(t, diskdb, newRoot)
+
+	// Migrating again must resume and find nothing else
+	size := cold.Size()
+	if moved, err := cold.Migrate(2); err != nil || moved != 0 {
+		t.Fatalf("second migration: have %d nodes moved (err %v), want 0", moved, err)
+	}
+	if cold.Size() != size {
+		t.Fatalf("cold store size mismatch: have %v, want %v", cold.Size(), size)
+	}
+	// Reopen the store and check the cold nodes are still there
+	if err := cold.Close(); err != nil {
+		t.Fatalf("failed to close cold store: %v", err)
+	}
+	if _, err := cold.Node(oldRoot); err != errColdStoreClosed {
+		t.Fatalf("closed store read: have %v, want %v", err, errColdStoreClosed)
+	}
+	if cold, err = NewColdStore(dir, memdb); err != nil {
+		t.Fatalf("failed to reopen cold store: %v", err)
+	}
+	defer cold.Close()
+
+	if cold.Size() != size {
+		t.Fatalf("reopened cold store size mismatch: have %v, want %v", cold.Size(), size)
+	}
+	diskdb.cold = cold
+	checkTieredTrieComplete(t, diskdb, oldRoot)
+
+	// Pruning the old state must drop its cold nodes too
+	if _, err := NewDatabase(diskdb).Prune([]common.Hash{newRoot}, 2, nil); err != nil {
+		t.Fatalf("failed to prune: %v", err)
+	}
+	if _, err := cold.Node(oldRoot); err == nil {
+		t.Errorf("old root %x still cold", oldRoot)
+	}
+	checkTieredTrieComplete(t, diskdb, newRoot)
+}
@@ -22,7 +22,6 @@ import (
 	"fmt"
 	"io"
 	"reflect"
-	"strconv"
 	"sync"
 	"time"
 
@@ -34,10 +33,6 @@ import (
 	"github.com/ethereum/go-ethereum/rlp"
 )
 
-const GlobalTrieNodeDBLength = 0 // deprecated feature (jmlee)
-
-var GlobalTrieNodeDB [GlobalTrieNodeDBLength]ethdb.Database
-
 var (
 	memcacheCleanHitMeter   = metrics.NewRegisteredMeter("trie/memcache/clean/hit", nil)
 	memcacheCleanMissMeter  = metrics.NewRegisteredMeter("trie/memcache/clean/miss", nil)
@@ -73,6 +68,8 @@ const secureKeyLength = 11 + 32
 // servers even while the trie is executing expensive garbage collection.
 type Database struct {
 	diskdb ethdb.KeyValueStore // Persistent storage for matured trie nodes
+	cold   *ColdStore          // Persistent storage for old trie nodes, nil if none
+	shards *NodeShards         // Shards the trie nodes are split across, nil if none
 
 	cleans  *bigcache.BigCache          // GC friendly memory cache of clean node RLPs
 	dirties map[common.Hash]*cachedNode // Data and references relationships of dirty nodes
@@ -94,6 +91,8 @@ type Database struct {
 	childrenSize  common.StorageSize // Storage size of the external children tracking
 	preimagesSize common.StorageSize // Storage size of the preimages cache
 
+	age *nodeAgeIndex // Age index of the flushed trie nodes, nil if disabled
+
 	lock sync.RWMutex
 }
 
@@ -422,8 +421,20 @@ func NewDatabaseWithCache(diskdb ethdb.KeyValueStore, cache int) *Database {
 			Hasher:             trienodeHasher{},
 		})
 	}
+	var (
+		cold   *ColdStore
+		shards *NodeShards
+	)
+	if store, ok := diskdb.(ColdNodeStore); ok {
+		cold = store.ColdStore()
+	}
+	if store, ok := diskdb.(ShardedNodeStore); ok {
+		shards = store.NodeShards()
+	}
 	return &Database{
 		diskdb: diskdb,
+		cold:   cold,
+		shards: shards,
 		cleans: cleans,
 		dirties: map[common.Hash]*cachedNode{{}: {
 			children: make(map[common.Hash]uint16),
@@ -437,6 +448,65 @@ func (db *Database) DiskDB() ethdb.KeyValueReader {
 	return db.diskdb
 }
 
+// diskNode retrieves an encoded trie node from its shard of the persistent
+// database, or from the cold store if it was moved there.
+func (db *Database) diskNode(hash common.Hash) ([]byte, error) {
+	var (
+		enc []byte
+		err error
+	)
+	if db.shards != nil {
+		enc, err = db.shards.Get(hash)
+	} else {
+		enc, err = db.diskdb.Get(hash[:])
+	}
+	if (err != nil || len(enc) == 0) && db.cold != nil {
+		enc, err = db.cold.Node(hash)
+	}
+	return enc, err
+}
+
+// newBatch creates a write-only batch on the persistent database, routing the
+// trie nodes to their shards.
+func (db *Database) newBatch() ethdb.Batch {
+	if db.shards != nil {
+		return db.shards.NewBatch()
+	}
+	return db.diskdb.NewBatch()
+}
+
+// IndexNodeAge makes the database record the block number of every trie node it
+// flushes from now on in the node age index. The block number prefixes of the
+// node hashes are resolved against the given head, which must be the block the
+// nodes inserted last were mined in, and flushed nodes must be less than a prefix
+// epoch old. A zero prefix length disables the index.
+func (db *Database) IndexNodeAge(prefixLength int, head uint64) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if prefixLength == 0 {
+		db.age = nil
+		return
+	}
+	db.age = &nodeAgeIndex{prefixLength: prefixLength, head: head}
+}
+
+// indexNodeAge adds the node age index entry of a trie node being flushed to
+// the batch. Raw blobs, like contract code, are not indexed.
+func (db *Database) indexNodeAge(batch ethdb.Batch, hash common.Hash, node *cachedNode) error {
+	if db.age == nil {
+		return nil
+	}
+	if _, ok := node.node.(rawNode); ok {
+		return nil
+	}
+	number, ok := db.age.number(hash)
+	if !ok {
+		return nil
+	}
+	return WriteNodeAge(batch, number, hash)
+}
+
 // InsertBlob writes a new reference tracked blob to the memory database if it's
 // yet unknown. This method should only be used for non-trie nodes that require
 // reference counting, since trie nodes are garbage collected directly through
@@ -491,44 +561,6 @@ func (db *Database) insertPreimage(hash common.Hash, preimage []byte) {
 	db.preimagesSize += common.StorageSize(common.HashLength + len(preimage))
 }
 
-// GetProperDBIndex gets proper index of GlobalTrieNodeDB for indexed trie node (jmlee)
-// this can be changed with other db indexing policies
-func GetProperDBIndex(hash common.Hash) int {
-	index := string(hash.Hex()[2]) // ex. 0xea945... -> index = e
-	var dbIndex int64
-
-	// indexing policy 1: 5 DBs - 0 / 1 / 2 / 3 / 4~f
-	// if index < strconv.Itoa(GlobalTrieNodeDBLength) {
-	// 	dbIndex, _ = strconv.Atoi(index)
-	// } else {
-	// 	dbIndex = GlobalTrieNodeDBLength-1
-	// }
-
-	// indexing policy 2: 6 DBs - 0 / 1 / 2~3 / 4~6 / 7~a / b~f
-	// if index == "0" {	// 0
-	// 	dbIndex = 0
-	// } else if index < "2" {	// 1
-	// 	dbIndex = 1
-	// } else if index < "4" {	// 2~3
-	// 	dbIndex = 2
-	// } else if index < "7" {	// 4~6
-	// 	dbIndex = 3
-	// } else if index < "b" {	// 7~a
-	// 	dbIndex = 4
-	// } else {	// b~f
-	// 	dbIndex = 5
-	// }
-
-	// indexing policy 3: 16 DBs - 0/1/2/3/4/5/6/7/8/9/a/b/c/d/e/f
-	// dbIndex, _ = strconv.ParseInt(index, 16, 8)
-
-	// indexing policy 4: 1 DB - 0~f
-	_ = index
-	dbIndex = 0
-
-	return int(dbIndex)
-}
-
 // node retrieves a cached trie node from memory, or returns nil if none can be
 // found in the memory cache.
 func (db *Database) node(hash common.Hash) node {
@@ -549,33 +581,7 @@ func (db *Database) node(hash common.Hash) node {
 		return dirty.obj(hash)
 	}
 	// Content unavailable in memory, attempt to retrieve from disk
-	// enc, err := db.diskdb.Get(hash[:]) // impt: find indexed trie node in proper trie node db (jmlee)
-	dbIndex := GetProperDBIndex(hash)
-	start1 := time.Now()
-	// dont need additional trie db anymore, just set elapsed1 = 0 (jmlee)
-	// enc, err := GlobalTrieNodeDB[dbIndex].Get(hash[:])
-	elapsed1 := time.Since(start1)
-	elapsed1 = 0
-	// if enc == nil {
-	// 	// not found, just logging it as 0
-	// 	elapsed1 = 0
-	// }
-	start2 := time.Now()
-	enc, err := db.diskdb.Get(hash[:])
-	elapsed2 := time.Since(start2)
-	// fmt.Println("	%% compare DB search time -> triedb:", elapsed1, "vs totaldb:", elapsed2, "-> reduced time:", elapsed2-elapsed1)
-	// print trie db index & search time for impt data log
-	logData := ""
-	logData += strconv.Itoa(dbIndex) + ","
-	logData += strconv.Itoa(int(elapsed1.Nanoseconds())) + ","
-	logData += strconv.Itoa(int(elapsed2.Nanoseconds())) + ","
-	logData += strconv.Itoa(int((elapsed2 - elapsed1).Nanoseconds())) + ","
-	// fmt.Println("	logData:", logData)
-	// fmt.Println(logData)
-
-	// append or write logData to file
-	// common.LogToFile("impt_data_log.txt", logData)
-
+	enc, err := db.diskNode(hash)
 	if err != nil || enc == nil {
 		return nil
 	}
@@ -616,34 +622,7 @@ func (db *Database) Node(hash common.Hash) ([]byte, error) {
 		return dirty.rlp(), nil
 	}
 	// Content unavailable in memory, attempt to retrieve from disk
-	// enc, err := db.diskdb.Get(hash[:]) // impt: find indexed trie node in proper trie node db (jmlee) (cf. this Node() function is rarely called)
-	dbIndex := GetProperDBIndex(hash)
-	start1 := time.Now()
-	// dont need additional trie db anymore, just set elapsed1 = 0 (jmlee)
-	// enc, err := GlobalTrieNodeDB[dbIndex].Get(hash[:])
-	elapsed1 := time.Since(start1)
-	elapsed1 = 0
-	// if enc == nil {
-	// 	// not found, just logging it as 0
-	// 	elapsed1 = 0
-	// }
-	start2 := time.Now()
-	enc, err := db.diskdb.Get(hash[:])
-	elapsed2 := time.Since(start2)
-	// fmt.Println("	%%% compare DB search time -> triedb:", elapsed1, "vs totaldb:", elapsed2, "-> reduced time:", elapsed2-elapsed1)
-
-	// print trie db index & search time for impt data log
-	logData := ""
-	logData += strconv.Itoa(dbIndex) + ","
-	logData += strconv.Itoa(int(elapsed1.Nanoseconds())) + ","
-	logData += strconv.Itoa(int(elapsed2.Nanoseconds())) + ","
-	logData += strconv.Itoa(int((elapsed2 - elapsed1).Nanoseconds())) + ","
-	// fmt.Println("	logData:", logData)
-	// fmt.Println(logData)
-
-	// append or write logData to file
-	// common.LogToFile("impt_data_log.txt", logData)
-
+	enc, err := db.diskNode(hash)
 	if err == nil && enc != nil {
 		if db.cleans != nil {
 			db.cleans.Set(string(hash[:]), enc)
@@ -813,7 +792,7 @@ func (db *Database) Cap(limit common.StorageSize) error {
 	// memory cache during commit but not yet in persistent storage). This is ensured
 	// by only uncaching existing data when the database write finalizes.
 	nodes, storage, start := len(db.dirties), db.dirtiesSize, time.Now()
-	batch := db.diskdb.NewBatch()
+	batch := db.newBatch()
 
 	// db.dirtiesSize only contains the useful data in the cache, but when reporting
 	// the total memory consumption, the maintenance metadata is also needed to be
@@ -846,6 +825,9 @@ func (db *Database) Cap(limit common.StorageSize) error {
 		if err := batch.Put(oldest[:], node.rlp()); err != nil {
 			return err
 		}
+		if err := db.indexNodeAge(batch, oldest, node); err != nil {
+			return err
+		}
 		// If we exceeded the ideal batch size, commit and reset
 		if batch.ValueSize() >= ethdb.IdealBatchSize {
 			if err := batch.Write(); err != nil {
@@ -915,7 +897,7 @@ func (db *Database) Commit(node common.Hash, report bool) error {
 	// memory cache during commit but not yet in persistent storage). This is ensured
 	// by only uncaching existing data when the database write finalizes.
 	start := time.Now()
-	batch := db.diskdb.NewBatch()
+	batch := db.newBatch()
 
 	// Move all of the accumulated preimages into a write batch
 	for hash, preimage := range db.preimages {
@@ -993,49 +975,13 @@ func (db *Database) commit(hash common.Hash, batch ethdb.Batch, uncacher *cleane
 		}
 	}
 
-	// impt: write indexed trie node to other leveldb (jmlee)
-	// fmt.Println("in commit(), hash ", hash.Hex(), "is Put to batch")
-
-	// if GlobalTrieNodeDB[0] != nil{
-
-	// 	// open the batch of proper db for the indexed trie node
-	// 	dbIndex := GetProperDBIndex(hash)
-	// 	imptBatch := GlobalTrieNodeDB[dbIndex].NewBatch()
-	// 	// fmt.Println("in commit(), node", hash.Hex(), "is in db", dbIndex)
-
-	// 	// fmt.Println("imptBatch Put", hash.Hex())
-	// 	if err := imptBatch.Put(hash[:], node.rlp()); err != nil {
-	// 		fmt.Println("imptBatch Put err")
-	// 		return err
-	// 	}
-	// 	// If we've reached an optimal batch size, commit and start over
-	// 	if imptBatch.ValueSize() >= ethdb.IdealBatchSize {
-	// 		if err := imptBatch.Write(); err != nil {
-	// 			return err
-	// 		}
-	// 		// maybe i dont need this (jmlee)
-	// 		// GlobalTrieNodeDB[dbIndex].lock.Lock()
-	// 		// imptBatch.Replay(uncacher)
-	// 		// imptBatch.Reset()
-	// 		// GlobalTrieNodeDB[dbIndex].lock.Unlock()
-	// 	}
-
-	// 	// Trie mostly committed to disk, flush any batch leftovers
-	// 	if err := imptBatch.Write(); err != nil {
-	// 		log.Error("Failed to write trie to disk", "err", err)
-	// 		return err
-	// 	}
-
-	// } else {
-	// 	fmt.Println("trie node level db not opened yet, just return")
-	// }
-
-	// impt: do not commit indexed trie nodes to total leveldb (jmlee)
-	// just comment out the code below
-	// but if you want to compare db search time, then do not comment out
+	// The batch routes the node to its shard, if sharded
 	if err := batch.Put(hash[:], node.rlp()); err != nil {
 		return err
 	}
+	if err := db.indexNodeAge(batch, hash, node); err != nil {
+		return err
+	}
 	// If we've reached an optimal batch size, commit and start over
 	if batch.ValueSize() >= ethdb.IdealBatchSize {
 		if err := batch.Write(); err != nil {
@@ -1062,6 +1008,10 @@ type cleaner struct {
 // the two-phase commit is to ensure ensure data availability while moving from
 // memory to disk.
 func (c *cleaner) Put(key []byte, rlp []byte) error {
+	// Node age index entries are not cached
+	if IsNodeAgeKey(key) {
+		return nil
+	}
 	hash := common.BytesToHash(key)
 
 	// If the node does not exist, we're done on this path
@@ -17,11 +17,46 @@
 package trie
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/ethereum/go-ethereum/common"
 )
 
+var (
+	// ErrMissingTrieNonce is returned by HashByNonce if the trie has more dirty
+	// nodes than the supplied nonce list has entries.
+	ErrMissingTrieNonce = errors.New("missing trie nonce")
+
+	// ErrUnusedTrieNonces is returned by HashByNonce if the supplied nonce list
+	// has more entries than the trie has dirty nodes.
+	ErrUnusedTrieNonces = errors.New("unused trie nonces")
+
+	// ErrInvalidTrieNonce is returned by HashByNonce if a nonce does not produce
+	// a node hash prefixed with the block number.
+	ErrInvalidTrieNonce = errors.New("invalid trie nonce")
+
+	// ErrNodeHashMismatch is returned by VerifyNodeHash and the trie sync if the
+	// node data, along with its nonce, doesn't hash to the requested hash.
+	ErrNodeHashMismatch = errors.New("node hash mismatch")
+
+	// ErrInvalidNodePrefix is returned by VerifyNodeHash and the trie sync if a
+	// node hash is not prefixed with a Trie-Hashimoto block up to the head.
+	ErrInvalidNodePrefix = errors.New("invalid node hash prefix")
+
+	// ErrHeaderDatasetMissing is returned by VerifyProofTH if the proof nodes mix
+	// in the header dataset, but no dataset to verify them against was given.
+	ErrHeaderDatasetMissing = errors.New("header dataset missing")
+
+	// ErrNodeTargetMissed is returned by VerifyProofTH if a proof node hash does
+	// not meet the trie difficulty target of the block it was mined in.
+	ErrNodeTargetMissed = errors.New("node hash misses trie difficulty target")
+
+	// errMiningAborted is returned while hashing a trie if HashWithNonce is
+	// aborted, and turned into a MiningAbortedError.
+	errMiningAborted = errors.New("trie mining aborted")
+)
+
 // MissingNodeError is returned by the trie functions (TryGet, TryUpdate, TryDelete)
 // in the case where a trie node is not present in the local database. It contains
 // information necessary for retrieving the missing node.
@@ -33,3 +68,15 @@ type MissingNodeError struct {
 func (err *MissingNodeError) Error() string {
 	return fmt.Sprintf("missing trie node %x (path %x)", err.NodeHash, err.Path)
 }
+
+// MiningAbortedError is returned by HashWithNonce if mining is aborted before
+// every dirty node of the trie got its nonce. It reports how far mining got, the
+// trie itself is left as it was before mining.
+type MiningAbortedError struct {
+	Number uint64 // block number the trie was mined for
+	Mined  int    // number of dirty nodes mined before the abort
+}
+
+func (err *MiningAbortedError) Error() string {
+	return fmt.Sprintf("trie mining for block %d aborted after %d nodes", err.Number, err.Mined)
+}
@@ -19,15 +19,18 @@ package trie
 import (
 	"hash"
 	"sync"
+	"sync/atomic"
 	"encoding/binary"
 	"bytes"
+	"math/big"
 	"math/rand"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/log"
-	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/params"
 	"golang.org/x/crypto/sha3"
 )
 
@@ -35,6 +38,12 @@ type hasher struct {
 	tmp    sliceBuffer
 	sha    keccakState
 	onleaf LeafCallback
+	th     *params.TrieHashimotoConfig // Trie-Hashimoto parameters for HashWithNonce and HashByNonce
+	light  HeaderDataset               // Header dataset rows for HashByNonce, nil to use the in-memory dataset
+	miner  *miningSession              // Session searching the nonces in HashWithNonce, if any
+	mix    *hashimotoState             // Header dataset mix buffers, created on first use
+	target []byte                      // Hash target of the trie difficulty mined or verified, see trieTarget
+	epoch  [8]byte                     // Prefix epoch buffer of the node hashes, see nodeHashWithNonce
 }
 
 // keccakState wraps sha3.state. In addition to the usual hash methods, it also supports
@@ -47,12 +56,6 @@ type keccakState interface {
 
 type sliceBuffer []byte
 
-const FakeIMPT bool = true	// forcely prefixing trie node's hash value with block number without mining
-const PrefixLength int = 2	// actually, this may be prefix bytes (ex. PrefixLength = 3 -> prefixes 6 characters)
-const DoReadHeader bool = true	// using header while mining trie nodes
-const loopAccesses int = 1 // Number of accesses in Trie-Hashimoto loop
-const EthashDatasetLen uint32 = 826277728 // Ethash dataset size (826277728: at block 8,000,000)
-
 func (b *sliceBuffer) Write(data []byte) (n int, err error) {
 	*b = append(*b, data...)
 	return len(data), nil
@@ -80,12 +83,16 @@ func newHasher(onleaf LeafCallback) *hasher {
 }
 
 func returnHasherToPool(h *hasher) {
+	h.th = nil
+	h.light = nil
+	h.miner = nil
+	h.target = nil
 	hasherPool.Put(h)
 }
 
 // hash collapses a node down into a hash node, also returning a copy of the
 // original node initialized with the computed hash to replace the original one.
-func (h *hasher) hash(n node, db *Database, force bool, trieNonces *[]uint64, isMining bool, blockNum uint64, threads int, count *uint64) (node, node, error) {
+func (h *hasher) hash(n node, db *Database, force bool, trieNonces *[]uint64, isMining bool, blockNum uint64, count *uint64) (node, node, error) {
 	// If we're not storing the node, just hashing, use available cached data
 	if hash, dirty := n.cache(); hash != nil {
 		// Returns cached hash directly only when normal Hash() was called.
@@ -109,12 +116,12 @@ func (h *hasher) hash(n node, db *Database, force bool, trieNonces *[]uint64, is
 		}
 	}
 	// Trie not processed yet or needs storage, walk the children
-	collapsed, cached, err := h.hashChildren(n, db, trieNonces, isMining, blockNum, threads, count)
+	collapsed, cached, err := h.hashChildren(n, db, trieNonces, isMining, blockNum, count)
 	if err != nil {
 		// fmt.Println("hasher.hash() finished 4")
 		return hashNode{}, n, err
 	}
-	hashed, nonce, err := h.store(collapsed, db, force, trieNonces, isMining, blockNum, threads, count)
+	hashed, nonce, err := h.store(collapsed, db, force, trieNonces, isMining, blockNum, count)
 	if err != nil {
 		// fmt.Println("hasher.hash() finished 5")
 		return hashNode{}, n, err
@@ -147,7 +154,7 @@ func (h *hasher) hash(n node, db *Database, force bool, trieNonces *[]uint64, is
 // hashChildren replaces the children of a node with their hashes if the encoded
 // size of the child is larger than a hash, returning the collapsed node as well
 // as a replacement for the original node with the child hashes cached in.
-func (h *hasher) hashChildren(original node, db *Database, trieNonces *[]uint64, isMining bool, blockNum uint64, threads int, count *uint64) (node, node, error) {
+func (h *hasher) hashChildren(original node, db *Database, trieNonces *[]uint64, isMining bool, blockNum uint64, count *uint64) (node, node, error) {
 	var err error
 
 	switch n := original.(type) {
@@ -158,7 +165,7 @@ func (h *hasher) hashChildren(original node, db *Database, trieNonces *[]uint64,
 		cached.Key = common.CopyBytes(n.Key)
 
 		if _, ok := n.Val.(valueNode); !ok {
-			collapsed.Val, cached.Val, err = h.hash(n.Val, db, false, trieNonces, isMining, blockNum, threads, count)
+			collapsed.Val, cached.Val, err = h.hash(n.Val, db, false, trieNonces, isMining, blockNum, count)
 			if err != nil {
 				return original, original, err
 			}
@@ -169,9 +176,16 @@ func (h *hasher) hashChildren(original node, db *Database, trieNonces *[]uint64,
 		// Hash the full node's children, caching the newly hashed subtrees
 		collapsed, cached := n.copy(), n.copy()
 
+		if isMining && db == nil && h.miner != nil && !h.miner.Sequential {
+			if err := h.mineChildren(n, collapsed, cached, trieNonces, blockNum, count); err != nil {
+				return original, original, err
+			}
+			cached.Children[16] = n.Children[16]
+			return collapsed, cached, nil
+		}
 		for i := 0; i < 16; i++ {
 			if n.Children[i] != nil {
-				collapsed.Children[i], cached.Children[i], err = h.hash(n.Children[i], db, false, trieNonces, isMining, blockNum, threads, count)
+				collapsed.Children[i], cached.Children[i], err = h.hash(n.Children[i], db, false, trieNonces, isMining, blockNum, count)
 				if err != nil {
 					return original, original, err
 				}
@@ -186,10 +200,64 @@ func (h *hasher) hashChildren(original node, db *Database, trieNonces *[]uint64,
 	}
 }
 
+// mineChildren mines the dirty subtrees of a full node concurrently, each with
+// a hasher of its own, and joins them in child order, so that the nonces are
+// listed in the same order as if the subtrees were mined one after another.
+func (h *hasher) mineChildren(n, collapsed, cached *fullNode, trieNonces *[]uint64, blockNum uint64, count *uint64) error {
+	var (
+		nonces [16][]uint64
+		errs   [16]error
+		pend   sync.WaitGroup
+	)
+	for i := 0; i < 16; i++ {
+		child := n.Children[i]
+		if child == nil {
+			continue
+		}
+		// Hashes, values and subtrees without dirty nodes are hashed right away
+		if !isDirtyBranch(child) {
+			collapsed.Children[i], cached.Children[i], errs[i] = h.hash(child, nil, false, &nonces[i], true, blockNum, count)
+			continue
+		}
+		pend.Add(1)
+		go func(i int, child node) {
+			defer pend.Done()
+
+			fork := newHasher(h.onleaf)
+			defer returnHasherToPool(fork)
+			fork.th, fork.light, fork.miner, fork.target = h.th, h.light, h.miner, h.target
+
+			collapsed.Children[i], cached.Children[i], errs[i] = fork.hash(child, nil, false, &nonces[i], true, blockNum, count)
+		}(i, child)
+	}
+	pend.Wait()
+
+	for i := 0; i < 16; i++ {
+		if errs[i] != nil {
+			return errs[i]
+		}
+		*trieNonces = append(*trieNonces, nonces[i]...)
+	}
+	return nil
+}
+
+// isDirtyBranch returns whether the node is a short or full node with changes
+// not hashed yet.
+func isDirtyBranch(n node) bool {
+	switch n := n.(type) {
+	case *shortNode:
+		return n.flags.dirty
+	case *fullNode:
+		return n.flags.dirty
+	default:
+		return false
+	}
+}
+
 // store hashes the node n and if we have a storage layer specified, it writes
 // the key/value pair to it and tracks any node->child references as well as any
 // node->external trie references.
-func (h *hasher) store(n node, db *Database, force bool, trieNonces *[]uint64, isMining bool, blockNum uint64, threads int, count *uint64) (node, uint64, error) {
+func (h *hasher) store(n node, db *Database, force bool, trieNonces *[]uint64, isMining bool, blockNum uint64, count *uint64) (node, uint64, error) {
 	// Don't store hashes or empty nodes.
 	if _, isHash := n.(hashNode); n == nil || isHash {
 		//fmt.Println("hasher.store End 1")
@@ -260,50 +328,47 @@ func (h *hasher) store(n node, db *Database, force bool, trieNonces *[]uint64, i
 			} else if isMining && dirty {
 				// Used for HashWithNonce()
 				// Make new hashNode even if hashNode info already exists in cache
-				if FakeIMPT {
+				if h.th.Fake {
 					hash = h.makeHashNode(h.tmp)
-					hash = modifyHash(n, hash, blockNum)
+					hash = modifyHash(n, hash, blockNum, h.th.PrefixLength)
 				} else {
 					// fmt.Println("start measure!")
 					// get original node hash: node hash with nonce 0
-					var copyNode node
-					switch n := n.(type) {
-					case *shortNode:
-						copyNode = n.copy()
-					case *fullNode:
-						copyNode = n.copy()
-					}
 					h.tmp.Reset()
 					n.setNonce(0)
-					if err := rlp.Encode(&h.tmp, copyNode); err != nil {
+					if err := rlp.Encode(&h.tmp, n); err != nil {
 						panic("encode error: " + err.Error())
 					}
 					originalNodeHash := h.makeHashNode(h.tmp)
 
 					// start trie node mining
-					start1 := time.Now()
-					nonce = trieNodeMining(n, blockNum, threads, originalNodeHash)
-					elapsed1 := time.Since(start1)
-					// fmt.Println("end measure!")
-					common.MiningTimes = append(common.MiningTimes, int64(elapsed1/time.Nanosecond))
-					// fmt.Println("  => mining single node time:", int64(elapsed1/time.Millisecond), "ms\n")
+					threads, ok := h.miner.acquire()
+					if !ok {
+						return nil, 0, errMiningAborted
+					}
+					start := time.Now()
+					var err error
+					nonce, err = trieNodeMining(n, blockNum, threads, originalNodeHash, h.tmp, h.th, h.target, h.miner.Miner)
+					h.miner.release(threads)
+					if err != nil {
+						return nil, 0, err
+					}
+					thNodeTimer.UpdateSince(start)
+					atomic.AddInt64(&h.miner.mined, 1)
+					if h.miner.Progress != nil {
+						h.miner.Progress.Inc(1)
+					}
 
 					h.tmp.Reset()
 					n.setNonce(nonce)
 					if err := rlp.Encode(&h.tmp, n); err != nil {
 						panic("encode error: " + err.Error())
 					}
-					hash = h.makeHashNode(h.tmp)
-					hash = modifyHash(n, hash, blockNum) // just set hash as we want (TODO: delete this later)
-					if DoReadHeader {
-						//
-						// TODO: need to hash with headers (jmlee)
-						//
-					}
+					hash = h.makeNodeHashWithNonce(h.tmp, originalNodeHash, nonce, blockNum)
 
-					if !validHash(hash, blockNum) {
-						panic("HashWithNonce error")
-					}	
+					if !validHash(hash, blockNum, h.th.PrefixLength) || !meetsTarget(hash, h.target, h.th.PrefixLength) {
+						return nil, 0, ErrInvalidTrieNonce
+					}
 				}
 				*trieNonces = append(*trieNonces, nonce)
 			} else if !isMining && dirty {
@@ -311,26 +376,44 @@ func (h *hasher) store(n node, db *Database, force bool, trieNonces *[]uint64, i
 				// Make new hashNode even if hashNode info already exists in cache
 				// Update normal MPT node to indexed MPT node
 				// Set nonce and generate new hashNode
-				if FakeIMPT {
+				if *count >= uint64(len(*trieNonces)) {
+					return nil, 0, ErrMissingTrieNonce
+				}
+				if h.th.Fake {
 					hash = h.makeHashNode(h.tmp)
-					hash = modifyHash(n, hash, blockNum)				
+					hash = modifyHash(n, hash, blockNum, h.th.PrefixLength)
 				} else {
+					// Rebuild the original node hash the miner mixed the header dataset with
+					var originalNodeHash hashNode
+					if h.th.ReadHeader {
+						h.tmp.Reset()
+						n.setNonce(0)
+						if err := rlp.Encode(&h.tmp, n); err != nil {
+							panic("encode error: " + err.Error())
+						}
+						originalNodeHash = h.makeHashNode(h.tmp)
+					}
 					h.tmp.Reset()
 					nonce = (*trieNonces)[*count]
 					n.setNonce(nonce)
 					if err := rlp.Encode(&h.tmp, n); err != nil {
 						panic("encode error: " + err.Error())
 					}
-					hash = h.makeHashNode(h.tmp)
-					// Panic if the hash is not valid
-					if !validHash(hash, blockNum) && blockNum != 0 {
-						//fmt.Println(nonce)
-						panic("HashByNonce error")
-					}					
+					hash = h.makeNodeHashWithNonce(h.tmp, originalNodeHash, nonce, blockNum)
+					thDatasetAccessMeter.Mark(h.datasetAccesses(blockNum))
+
+					// Reject the nonce if the hash is not indexed by the block number
+					// or doesn't meet the trie difficulty target
+					if (!validHash(hash, blockNum, h.th.PrefixLength) || !meetsTarget(hash, h.target, h.th.PrefixLength)) && blockNum != 0 {
+						return nil, 0, ErrInvalidTrieNonce
+					}
 				}
 				*count = *count + 1
 			}
-		} 
+		} else if hash == nil {
+			// Committing a trie that was never hashed, use the plain node hash
+			hash = h.makeHashNode(h.tmp)
+		}
 	case valueNode:
 		if hash == nil {
 			hash = h.makeHashNode(h.tmp)
@@ -366,14 +449,24 @@ func (h *hasher) store(n node, db *Database, force bool, trieNonces *[]uint64, i
 	return hash, nonce, nil
 }
 
-// mining trie nodes with threads (sjkim)
-func trieNodeMining(n node, blockNum uint64, threads int, originalNodeHash hashNode) uint64 {
+// mining trie nodes with threads (sjkim). If the miner has a remote channel, the
+// node is handed out to remote miners as well, and the first nonce found either
+// locally or remotely is returned. Aborting the miner fails the search with
+// errMiningAborted.
+func trieNodeMining(n node, blockNum uint64, threads int, originalNodeHash hashNode, enc []byte, config *params.TrieHashimotoConfig, target []byte, miner *Miner) (uint64, error) {
 	var (
 		pend   sync.WaitGroup
 		abort  = make(chan struct{})
 		locals = make(chan uint64)
 		result uint64
+
+		remote   chan<- *NodeWork
+		stop     <-chan struct{}
+		hashrate metrics.Meter
 	)
+	if miner != nil {
+		remote, stop, hashrate = miner.Remote, miner.Abort, miner.Hashrate
+	}
 	logger := log.New("miner_impt", -1)
 	logger.Trace("trieNodeMining started", "number", blockNum, "threads", threads)
 
@@ -389,28 +482,68 @@ func trieNodeMining(n node, blockNum uint64, threads int, originalNodeHash hashN
 			case *fullNode:
 				copyNode = n.copy()
 			}
-			imptMine(copyNode, id, blockNum, nonce, abort, locals, originalNodeHash)
+			imptMine(copyNode, id, blockNum, nonce, abort, locals, originalNodeHash, config, target, hashrate)
 		}(i, rand.Uint64())
 	}
-	
+	// Push the node to remote miners and wait for either of them to find a nonce
+	var (
+		work    *NodeWork
+		remotes chan uint64
+	)
+	if remote != nil {
+		work = newNodeWork(blockNum, originalNodeHash, enc, config, target)
+		defer close(work.done)
+
+		select {
+		case remote <- work:
+			remotes = work.found
+		case result = <-locals:
+			logger.Trace("trieNodeMining finished", "number", blockNum, "nonce", result)
+			close(abort)
+			pend.Wait()
+			return result, nil
+		case <-stop:
+			logger.Trace("trieNodeMining aborted", "number", blockNum)
+			close(abort)
+			pend.Wait()
+			return 0, errMiningAborted
+		}
+	}
+	var err error
 search:
 	for {
 		select {
 		case result = <-locals:
-			// One of the threads found a block, abort all others
+			// One of the threads found a nonce, abort all others
 			logger.Trace("trieNodeMining finished", "number", blockNum, "nonce", result)
-			close(abort)
 			break search
-		default:
+		case result = <-remotes:
+			// A remote miner found a nonce, abort the local threads if it's valid
+			if !work.Verify(result) {
+				logger.Warn("Invalid remote trie node nonce", "number", blockNum, "nonce", result)
+				continue
+			}
+			logger.Trace("trieNodeMining finished remotely", "number", blockNum, "nonce", result)
+			thRemoteNodeMeter.Mark(1)
+			break search
+		case <-stop:
+			// Outside abort, stop all miner threads
+			logger.Trace("trieNodeMining aborted", "number", blockNum)
+			err = errMiningAborted
+			break search
 		}
 	}
+	close(abort)
+
 	// Wait until sealing is terminated or a nonce is found
 	pend.Wait()
 
-	return result
+	return result, err
 }
 
-func imptMine(n node, id int, blockNum uint64, seed uint64, abort chan struct{}, found chan uint64, originalNodeHash hashNode) {
+// imptMine searches a nonce of the node starting from seed, marking the hash
+// attempts on the hashrate meter if one is given.
+func imptMine(n node, id int, blockNum uint64, seed uint64, abort chan struct{}, found chan uint64, originalNodeHash hashNode, config *params.TrieHashimotoConfig, target []byte, hashrate metrics.Meter) {
 	var (
 		attempts = int64(0)
 		nonce    = seed
@@ -420,6 +553,20 @@ func imptMine(n node, id int, blockNum uint64, seed uint64, abort chan struct{},
 
 	h := newHasher(nil)
 	defer returnHasherToPool(h)
+	h.th = config
+	h.target = target
+
+	// markAttempts updates the mining stats with the hashes calculated so far
+	accesses := h.datasetAccesses(blockNum)
+	markAttempts := func() {
+		thHashMeter.Mark(attempts)
+		thDatasetAccessMeter.Mark(attempts * accesses)
+		if hashrate != nil {
+			hashrate.Mark(attempts)
+		}
+		attempts = 0
+	}
+	defer markAttempts()
 
 	// encode trie node (with any nonce)
 	h.tmp.Reset()
@@ -428,7 +575,8 @@ func imptMine(n node, id int, blockNum uint64, seed uint64, abort chan struct{},
 		panic("encode error: " + err.Error())
 	}
 
-	var hash hashNode
+	// Every attempt hashes into the same buffer, the search loop doesn't allocate
+	hash := make(hashNode, h.sha.Size())
 
 search:
 	for {
@@ -436,75 +584,17 @@ search:
 		case <-abort:
 			// Mining terminated, update stats and abort
 			logger.Trace("IMPT nonce search aborted", "attempts", nonce-seed)
-			//ethash.hashrate.Mark(attempts)
 			break search
 
 		default:
 			// We don't have to update hash rate on every nonce, so update after after 2^X nonces
 			attempts++
-			
-			// change nonce bytes in RLPed trie node
-			copy(h.tmp[len(h.tmp)-8:], i64tob(nonce))
-
-			if DoReadHeader {
-
-				//
-				// mimicking ethash algorithm (consensus/ethash/algorithm.go -> "hashimoto" function) (jmlee)
-				//
-
-				// parameters for mining
-				mixBytes := 128
-				hashBytes := 64
-				hashWords := uint32(16)
-
-				// Calculate the number of theoretical rows
-				rows := uint32(uint64(len(common.RLPedBlockHeadersUint32s)*4) / uint64(mixBytes))
-
-				// Combine hash+nonce into a 64 byte seed
-				seed := make([]byte, 40)
-				copy(seed, originalNodeHash)
-				binary.LittleEndian.PutUint64(seed[32:], nonce)
-
-				seed = crypto.Keccak512(seed)
-				seedHead := binary.LittleEndian.Uint32(seed)
-
-				// Start the mix with replicated seed
-				mix := make([]uint32, mixBytes/4)
-				for i := 0; i < len(mix); i++ {
-					mix[i] = binary.LittleEndian.Uint32(seed[i%16*4:])
-				}
-				// Mix in random dataset nodes
-				temp := make([]uint32, len(mix))
-
-				for i := 0; i < loopAccesses; i++ {
-					parent := fnv(uint32(i)^seedHead, mix[i%len(mix)]) % rows
-					// fmt.Println("in imptMine(): thread #", id, "-> parent:", parent, "/ rows:", rows)
-					for j := uint32(0); j < uint32(mixBytes/hashBytes); j++ {
-						offset := (2*parent+j) * hashWords
-						copy(temp[j*hashWords:], common.RLPedBlockHeadersUint32s[offset : offset+hashWords])
-					}
-					fnvHash(mix, temp)
-				}
-				// Compress mix
-				for i := 0; i < len(mix); i += 4 {
-					mix[i/4] = fnv(fnv(fnv(mix[i], mix[i+1]), mix[i+2]), mix[i+3])
-				}
-				mix = mix[:len(mix)/4]
-
-				digest := make([]byte, common.HashLength)
-				for i, val := range mix {
-					binary.LittleEndian.PutUint32(digest[i*4:], val)
-				}
-
-				// calculate new trie node hash
-				hash = h.makeHashNode(append(h.tmp, digest...))
-				// fmt.Println("changed hash with header:", common.BytesToHash(hash).Hex())/
-			} else {
-				hash = h.makeHashNode(h.tmp)
+			if (attempts % (1 << 15)) == 0 {
+				markAttempts()
 			}
 
 			// Correct nonce found
-			if validHash(hash, blockNum) {
+			if h.tryNonce(hash, originalNodeHash, nonce, blockNum) {
 				// return nonce
 				select {
 				// Include IMPT mining result in the sealed block body
@@ -524,6 +614,68 @@ search:
 	return
 }
 
+// tryNonce hashes the node encoded in h.tmp with the given nonce into hash,
+// returning whether the hash is prefixed with the block number and meets the
+// trie difficulty target.
+func (h *hasher) tryNonce(hash hashNode, originalNodeHash hashNode, nonce uint64, blockNum uint64) bool {
+	// change nonce bytes in RLPed trie node
+	binary.LittleEndian.PutUint64(h.tmp[len(h.tmp)-8:], nonce)
+
+	h.nodeHashWithNonce(hash, h.tmp, originalNodeHash, nonce, blockNum)
+	return validHash(hash, blockNum, h.th.PrefixLength) && meetsTarget(hash, h.target, h.th.PrefixLength)
+}
+
+// datasetAccesses returns the number of header dataset rows read to hash a node
+// of the given block, zero if the header dataset isn't read.
+func (h *hasher) datasetAccesses(blockNum uint64) int64 {
+	if !h.th.ReadHeader {
+		return 0
+	}
+	size := uint64(len(common.RLPedBlockHeadersUint32s))
+	if h.light != nil {
+		size = h.light.Size(blockNum)
+	}
+	if size*4/hashimotoMixBytes == 0 {
+		return 0
+	}
+	return int64(h.th.LoopAccesses * hashimotoMixBytes / hashimotoHashBytes)
+}
+
+// makeNodeHashWithNonce returns the Trie-Hashimoto hash of an encoded node whose
+// last 8 bytes carry the nonce. If the header dataset is read, the digest mixed
+// from it is hashed along with the encoding. Past the first prefix epoch, the
+// big-endian epoch of the block is hashed last, so that a nonce found in one
+// epoch doesn't make the node valid in the others. Miners and verifiers must both
+// derive node hashes through this method or nodeHashWithNonce.
+func (h *hasher) makeNodeHashWithNonce(enc []byte, originalNodeHash hashNode, nonce uint64, blockNum uint64) hashNode {
+	hash := make(hashNode, h.sha.Size())
+	h.nodeHashWithNonce(hash, enc, originalNodeHash, nonce, blockNum)
+	return hash
+}
+
+// nodeHashWithNonce is makeNodeHashWithNonce writing the hash into the given
+// buffer. It reuses the buffers of the hasher and doesn't allocate, as it's the
+// body of the nonce search loop.
+func (h *hasher) nodeHashWithNonce(hash hashNode, enc []byte, originalNodeHash hashNode, nonce uint64, blockNum uint64) {
+	h.sha.Reset()
+	h.sha.Write(enc)
+	if h.th.ReadHeader {
+		if h.mix == nil {
+			h.mix = newHashimotoState()
+		}
+		if h.light != nil {
+			h.sha.Write(h.mix.hash(originalNodeHash, nonce, h.light.Size(blockNum), h.th.LoopAccesses, h.light.Lookup))
+		} else {
+			h.sha.Write(h.mix.hashFull(common.RLPedBlockHeadersUint32s, originalNodeHash, nonce, h.th.LoopAccesses))
+		}
+	}
+	if epoch := PrefixEpoch(blockNum, h.th.PrefixLength); epoch > 0 {
+		binary.BigEndian.PutUint64(h.epoch[:], epoch)
+		h.sha.Write(h.epoch[:])
+	}
+	h.sha.Read(hash)
+}
+
 func (h *hasher) makeHashNode(data []byte) hashNode {
 	n := make(hashNode, h.sha.Size())
 	h.sha.Reset()
@@ -533,16 +685,41 @@ func (h *hasher) makeHashNode(data []byte) hashNode {
 }
 
 // validHash returns whether the node hash has a valid prefix or not.
-// It returns true if the hash prefix is equal to the block number.
-func validHash(hash []byte, blockNum uint64) bool {
-	bs := make([]byte, 8)
-    binary.BigEndian.PutUint64(bs, blockNum)
-	return bytes.Equal(hash[:PrefixLength], bs[8-PrefixLength:])
+// It returns true if the first prefixLength bytes of the hash are equal to the
+// block number.
+func validHash(hash []byte, blockNum uint64, prefixLength int) bool {
+	var bs [8]byte
+	binary.BigEndian.PutUint64(bs[:], blockNum)
+	return bytes.Equal(hash[:prefixLength], bs[8-prefixLength:])
+}
+
+// trieTarget returns the target the node hashes of a block with the given trie
+// difficulty must meet. Read as a big-endian number, the hash bytes following
+// the block number prefix must be below 2^(8*(32-prefixLength)) / difficulty,
+// multiplying the expected attempts per node by the difficulty. A nil difficulty
+// or one of at most 1 only requires the prefix.
+func trieTarget(prefixLength int, difficulty *big.Int) []byte {
+	if difficulty == nil || difficulty.Sign() <= 0 {
+		difficulty = common.Big1
+	}
+	max := new(big.Int).Lsh(common.Big1, uint(8*(common.HashLength-prefixLength)))
+	if max.Div(max, difficulty).Sign() > 0 {
+		max.Sub(max, common.Big1)
+	}
+	return common.LeftPadBytes(max.Bytes(), common.HashLength)
+}
+
+// meetsTarget returns whether the node hash does not exceed the target past its
+// block number prefix. A nil target is met by any hash.
+func meetsTarget(hash []byte, target []byte, prefixLength int) bool {
+	return target == nil || bytes.Compare(hash[prefixLength:], target[prefixLength:]) <= 0
 }
 
 // modifyHash returns a new hashNode without finding proper nonce
 // Just overlap the hash prefix with what we want
-func modifyHash(n node, hash hashNode, blockNum uint64) hashNode {
+// The epoch of the block is not bound, as fake sync matches node hashes by the
+// plain node hash past the prefix.
+func modifyHash(n node, hash hashNode, blockNum uint64, prefixLength int) hashNode {
 	
 	// var blockNum = uint64(100)
 
@@ -553,7 +730,7 @@ func modifyHash(n node, hash hashNode, blockNum uint64) hashNode {
 	copy(newHash, hash)
 	switch n.(type) {
 	case *shortNode, *fullNode:
-		copy(newHash[:PrefixLength], bs[8-PrefixLength:])
+		copy(newHash[:prefixLength], bs[8-prefixLength:])
 		return newHash
 	default:
 		return nil
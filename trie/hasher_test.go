@@ -0,0 +1,401 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"fmt"
+	"math/big"
+	"runtime"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// testTrieHashimoto is a cheap, header-less Trie-Hashimoto config for tests.
+var testTrieHashimoto = &params.TrieHashimotoConfig{PrefixLength: 2, LoopAccesses: 1}
+
+// newTrieHashimotoTestTrie creates a trie with a handful of dirty nodes.
+func newTrieHashimotoTestTrie() *Trie {
+	trie := newEmpty()
+	for i := 0; i < 8; i++ {
+		updateString(trie, fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d", i))
+	}
+	return trie
+}
+
+func TestHashByNonce(t *testing.T) {
+	const number = 5
+
+	hashrate := metrics.NewMeterForced()
+	defer hashrate.Stop()
+
+	root, nonces, _ := newTrieHashimotoTestTrie().HashWithNonce(testTrieHashimoto, number, nil, &Miner{Threads: 2, Hashrate: hashrate})
+	if len(nonces) == 0 {
+		t.Fatalf("no trie nodes mined")
+	}
+	if attempts := hashrate.Count(); attempts < int64(len(nonces)) {
+		t.Fatalf("hash attempts not metered: have %d, want at least %d", attempts, len(nonces))
+	}
+	have, err := newTrieHashimotoTestTrie().HashByNonce(testTrieHashimoto, nonces, number, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to replay mined nonces: %v", err)
+	}
+	if have != root {
+		t.Fatalf("root mismatch: have %x, want %x", have, root)
+	}
+	if !validHash(root[:], number, testTrieHashimoto.PrefixLength) {
+		t.Fatalf("root %x not indexed by block %d", root, number)
+	}
+}
+
+func TestHashByNonceInvalid(t *testing.T) {
+	const number = 5
+
+	_, nonces, _ := newTrieHashimotoTestTrie().HashWithNonce(testTrieHashimoto, number, nil, &Miner{Threads: 2})
+
+	short := nonces[:len(nonces)-1]
+	if _, err := newTrieHashimotoTestTrie().HashByNonce(testTrieHashimoto, short, number, nil, nil); err != ErrMissingTrieNonce {
+		t.Errorf("short nonce list: have error %v, want %v", err, ErrMissingTrieNonce)
+	}
+	long := append(append([]uint64{}, nonces...), 0)
+	if _, err := newTrieHashimotoTestTrie().HashByNonce(testTrieHashimoto, long, number, nil, nil); err != ErrUnusedTrieNonces {
+		t.Errorf("long nonce list: have error %v, want %v", err, ErrUnusedTrieNonces)
+	}
+	bad := append([]uint64{}, nonces...)
+	bad[0]++
+	if _, err := newTrieHashimotoTestTrie().HashByNonce(testTrieHashimoto, bad, number, nil, nil); err != ErrInvalidTrieNonce {
+		t.Errorf("corrupted nonce: have error %v, want %v", err, ErrInvalidTrieNonce)
+	}
+	if _, err := newEmpty().HashByNonce(testTrieHashimoto, nonces, number, nil, nil); err != ErrUnusedTrieNonces {
+		t.Errorf("empty trie: have error %v, want %v", err, ErrUnusedTrieNonces)
+	}
+}
+
+// Tests that nonces are mined and verified against the trie difficulty target.
+func TestHashByNonceDifficulty(t *testing.T) {
+	const number = 5
+	difficulty := big.NewInt(8)
+
+	root, nonces, _ := newTrieHashimotoTestTrie().HashWithNonce(testTrieHashimoto, number, difficulty, &Miner{Threads: 2})
+	target := trieTarget(testTrieHashimoto.PrefixLength, difficulty)
+	if !meetsTarget(root[:], target, testTrieHashimoto.PrefixLength) {
+		t.Fatalf("root %x doesn't meet target %x", root, target)
+	}
+	have, err := newTrieHashimotoTestTrie().HashByNonce(testTrieHashimoto, nonces, number, difficulty, nil)
+	if err != nil {
+		t.Fatalf("failed to replay mined nonces: %v", err)
+	}
+	if have != root {
+		t.Fatalf("root mismatch: have %x, want %x", have, root)
+	}
+	// Nonces mined for a lower trie difficulty must not meet a higher one
+	_, plain, _ := newTrieHashimotoTestTrie().HashWithNonce(testTrieHashimoto, number, nil, &Miner{Threads: 2})
+	if _, err := newTrieHashimotoTestTrie().HashByNonce(testTrieHashimoto, plain, number, big.NewInt(1<<20), nil); err != ErrInvalidTrieNonce {
+		t.Errorf("nonces below the trie difficulty: have error %v, want %v", err, ErrInvalidTrieNonce)
+	}
+}
+
+func TestTrieTarget(t *testing.T) {
+	tests := []struct {
+		difficulty *big.Int
+		want       string
+	}{
+		{nil, "0000ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"},
+		{big.NewInt(1), "0000ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"},
+		{big.NewInt(2), "00007fffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"},
+		{big.NewInt(256), "000000ffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"},
+	}
+	for _, tt := range tests {
+		if have := fmt.Sprintf("%x", trieTarget(2, tt.difficulty)); have != tt.want {
+			t.Errorf("difficulty %v: target mismatch: have %s, want %s", tt.difficulty, have, tt.want)
+		}
+	}
+}
+
+// Tests that mining independent subtrees concurrently lists the nonces in the
+// order HashByNonce replays them in.
+func TestHashWithNonceConcurrent(t *testing.T) {
+	const number = 5
+	config := &params.TrieHashimotoConfig{PrefixLength: 1, LoopAccesses: 1}
+
+	newTrie := func() *Trie {
+		trie := newEmpty()
+		for i := 0; i < 300; i++ {
+			updateString(trie, fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d", i))
+		}
+		return trie
+	}
+	_, sequential, _ := newTrie().HashWithNonce(config, number, nil, &Miner{Threads: 4, Sequential: true})
+	for i := 0; i < 3; i++ {
+		root, nonces, err := newTrie().HashWithNonce(config, number, nil, &Miner{Threads: 4})
+		if err != nil {
+			t.Fatalf("failed to mine trie: %v", err)
+		}
+		if len(nonces) != len(sequential) {
+			t.Fatalf("nonce count mismatch: have %d, want %d", len(nonces), len(sequential))
+		}
+		have, err := newTrie().HashByNonce(config, nonces, number, nil, nil)
+		if err != nil {
+			t.Fatalf("failed to replay concurrently mined nonces: %v", err)
+		}
+		if have != root {
+			t.Fatalf("root mismatch: have %x, want %x", have, root)
+		}
+	}
+}
+
+// mineRemotely searches the nonces of the trie nodes handed out to remote miners
+// until the channel is closed.
+func mineRemotely(works <-chan *NodeWork) {
+	for work := range works {
+		nonce := uint64(0)
+		for !work.Verify(nonce) {
+			nonce++
+		}
+		work.Submit(nonce)
+	}
+}
+
+func TestHashWithNonceRemote(t *testing.T) {
+	const number = 5
+	config := &params.TrieHashimotoConfig{PrefixLength: 1, ReadHeader: true, LoopAccesses: 4}
+
+	defer func(dataset []uint32) { common.RLPedBlockHeadersUint32s = dataset }(common.RLPedBlockHeadersUint32s)
+	setTestHeaderDataset(4096, 1)
+
+	works := make(chan *NodeWork)
+	defer close(works)
+	go mineRemotely(works)
+
+	// Mine without any local threads, so every nonce is found remotely
+	root, nonces, _ := newTrieHashimotoTestTrie().HashWithNonce(config, number, nil, &Miner{Remote: works})
+	have, err := newTrieHashimotoTestTrie().HashByNonce(config, nonces, number, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to replay remotely mined nonces: %v", err)
+	}
+	if have != root {
+		t.Fatalf("root mismatch: have %x, want %x", have, root)
+	}
+}
+
+// Tests that invalid nonces submitted by remote miners are skipped and mining
+// goes on until a valid one is found.
+func TestHashWithNonceRemoteInvalid(t *testing.T) {
+	const number = 5
+	config := &params.TrieHashimotoConfig{PrefixLength: 1, LoopAccesses: 1}
+
+	works := make(chan *NodeWork)
+	defer close(works)
+	go func() {
+		for work := range works {
+			nonce, invalid := uint64(0), uint64(0)
+			for !work.Verify(nonce) {
+				nonce++
+			}
+			for work.Verify(invalid) {
+				invalid++
+			}
+			// The valid nonce only fits in once the invalid one was taken
+			work.Submit(invalid)
+			for !work.Submit(nonce) {
+				runtime.Gosched()
+			}
+		}
+	}()
+	root, nonces, err := newTrieHashimotoTestTrie().HashWithNonce(config, number, nil, &Miner{Remote: works})
+	if err != nil {
+		t.Fatalf("failed to mine remotely: %v", err)
+	}
+	have, err := newTrieHashimotoTestTrie().HashByNonce(config, nonces, number, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to replay remotely mined nonces: %v", err)
+	}
+	if have != root {
+		t.Fatalf("root mismatch: have %x, want %x", have, root)
+	}
+}
+
+func TestHashWithNonceAbort(t *testing.T) {
+	const number = 5
+	config := &params.TrieHashimotoConfig{PrefixLength: 1, LoopAccesses: 1}
+
+	trie := newTrieHashimotoTestTrie()
+	want := trie.Hash()
+
+	// Mine the first node remotely and abort while the second is pending
+	var (
+		works = make(chan *NodeWork)
+		abort = make(chan struct{})
+	)
+	go func() {
+		for i := 0; ; i++ {
+			work := <-works
+			if i == 1 {
+				close(abort)
+				return
+			}
+			nonce := uint64(0)
+			for !work.Verify(nonce) {
+				nonce++
+			}
+			work.Submit(nonce)
+		}
+	}()
+	_, nonces, err := trie.HashWithNonce(config, number, nil, &Miner{Remote: works, Abort: abort})
+	if aborted, ok := err.(*MiningAbortedError); !ok || aborted.Number != number || aborted.Mined != 1 {
+		t.Fatalf("error mismatch: have %v, want %v", err, &MiningAbortedError{Number: number, Mined: 1})
+	}
+	if nonces != nil {
+		t.Errorf("aborted mining returned nonces: %v", nonces)
+	}
+	if have := trie.Hash(); have != want {
+		t.Fatalf("aborted mining changed the trie: have root %x, want %x", have, want)
+	}
+}
+
+// setTestHeaderDataset replaces the header dataset with a deterministic one.
+func setTestHeaderDataset(words int, seed uint32) {
+	dataset := make([]uint32, words)
+	for i := range dataset {
+		dataset[i] = fnv(seed, uint32(i))
+	}
+	common.RLPedBlockHeadersUint32s = dataset
+}
+
+func TestHashByNonceHeaderDataset(t *testing.T) {
+	const number = 5
+	config := &params.TrieHashimotoConfig{PrefixLength: 2, ReadHeader: true, LoopAccesses: 4}
+
+	defer func(dataset []uint32) { common.RLPedBlockHeadersUint32s = dataset }(common.RLPedBlockHeadersUint32s)
+
+	setTestHeaderDataset(4096, 1)
+	root, nonces, _ := newTrieHashimotoTestTrie().HashWithNonce(config, number, nil, &Miner{Threads: 2})
+
+	have, err := newTrieHashimotoTestTrie().HashByNonce(config, nonces, number, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to replay mined nonces: %v", err)
+	}
+	if have != root {
+		t.Fatalf("root mismatch: have %x, want %x", have, root)
+	}
+	// Nonces mined without the header dataset must not verify with it
+	_, plain, _ := newTrieHashimotoTestTrie().HashWithNonce(testTrieHashimoto, number, nil, &Miner{Threads: 2})
+	if _, err := newTrieHashimotoTestTrie().HashByNonce(config, plain, number, nil, nil); err != ErrInvalidTrieNonce {
+		t.Errorf("nonces mined without dataset: have error %v, want %v", err, ErrInvalidTrieNonce)
+	}
+	// Nonces mined over a different header dataset must not verify either
+	setTestHeaderDataset(4096, 2)
+	if _, err := newTrieHashimotoTestTrie().HashByNonce(config, nonces, number, nil, nil); err != ErrInvalidTrieNonce {
+		t.Errorf("nonces mined over another dataset: have error %v, want %v", err, ErrInvalidTrieNonce)
+	}
+}
+
+// sliceHeaderDataset is a HeaderDataset over an in-memory word slice, counting
+// the rows looked up.
+type sliceHeaderDataset struct {
+	words   []uint32
+	lookups int
+}
+
+func (d *sliceHeaderDataset) Size(number uint64) uint64 { return uint64(len(d.words)) }
+
+func (d *sliceHeaderDataset) Lookup(index uint32) []uint32 {
+	d.lookups++
+	return d.words[index*hashimotoHashWords : (index+1)*hashimotoHashWords]
+}
+
+func TestHashByNonceLightHeaderDataset(t *testing.T) {
+	const number = 5
+	config := &params.TrieHashimotoConfig{PrefixLength: 2, ReadHeader: true, LoopAccesses: 4}
+
+	defer func(dataset []uint32) { common.RLPedBlockHeadersUint32s = dataset }(common.RLPedBlockHeadersUint32s)
+	defer SetHeaderDataset(nil)
+
+	setTestHeaderDataset(4096, 1)
+	root, nonces, _ := newTrieHashimotoTestTrie().HashWithNonce(config, number, nil, &Miner{Threads: 2})
+
+	// Verify without the in-memory dataset, reading rows from the light source
+	light := &sliceHeaderDataset{words: common.RLPedBlockHeadersUint32s}
+	common.RLPedBlockHeadersUint32s = nil
+	SetHeaderDataset(light)
+
+	have, err := newTrieHashimotoTestTrie().HashByNonce(config, nonces, number, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to replay mined nonces: %v", err)
+	}
+	if have != root {
+		t.Fatalf("root mismatch: have %x, want %x", have, root)
+	}
+	if want := 2 * config.LoopAccesses * len(nonces); light.lookups != want {
+		t.Errorf("row lookups mismatch: have %d, want %d", light.lookups, want)
+	}
+	// A light source over another dataset must reject the nonces
+	other := &sliceHeaderDataset{words: make([]uint32, 4096)}
+	SetHeaderDataset(other)
+	if _, err := newTrieHashimotoTestTrie().HashByNonce(config, nonces, number, nil, nil); err != ErrInvalidTrieNonce {
+		t.Errorf("nonces verified over another dataset: have error %v, want %v", err, ErrInvalidTrieNonce)
+	}
+	// A dataset passed in takes precedence over the one set
+	if have, err := newTrieHashimotoTestTrie().HashByNonce(config, nonces, number, nil, light); err != nil || have != root {
+		t.Errorf("nonces not verified over the given dataset: have %x, error %v", have, err)
+	}
+	SetHeaderDataset(light)
+	if _, err := newTrieHashimotoTestTrie().HashByNonce(config, nonces, number, nil, other); err != ErrInvalidTrieNonce {
+		t.Errorf("nonces verified over the set dataset: have error %v, want %v", err, ErrInvalidTrieNonce)
+	}
+}
+
+// Benchmarks a single attempt of the nonce search loop on a full node, with and
+// without mixing in the header dataset. Attempts per second are 1e9 / ns/op.
+func BenchmarkImptMine(b *testing.B) {
+	defer func(dataset []uint32) { common.RLPedBlockHeadersUint32s = dataset }(common.RLPedBlockHeadersUint32s)
+	setTestHeaderDataset(1<<20, 1)
+
+	b.Run("plain", func(b *testing.B) {
+		benchmarkImptMine(b, &params.TrieHashimotoConfig{PrefixLength: 8, LoopAccesses: 64})
+	})
+	b.Run("hashimoto", func(b *testing.B) {
+		benchmarkImptMine(b, &params.TrieHashimotoConfig{PrefixLength: 8, ReadHeader: true, LoopAccesses: 64})
+	})
+}
+
+func benchmarkImptMine(b *testing.B, config *params.TrieHashimotoConfig) {
+	n := &fullNode{}
+	for i := 0; i < 16; i++ {
+		n.Children[i] = hashNode(make([]byte, 32))
+	}
+	h := newHasher(nil)
+	defer returnHasherToPool(h)
+	h.th = config
+
+	h.tmp.Reset()
+	if err := rlp.Encode(&h.tmp, n); err != nil {
+		b.Fatalf("failed to encode node: %v", err)
+	}
+	var (
+		original = h.makeHashNode(h.tmp)
+		hash     = make(hashNode, 32)
+	)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.tryNonce(hash, original, uint64(i), 5)
+	}
+}
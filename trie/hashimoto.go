@@ -0,0 +1,151 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	hashimotoMixBytes  = 128 // Width of the mix
+	hashimotoHashBytes = 64  // Size of a dataset row read into the mix
+	hashimotoHashWords = 16  // Number of 32 bit words in a dataset row
+)
+
+// HeaderDataset gives random access to the header dataset, so that trie node
+// nonces can be verified without holding the whole dataset in memory, much like
+// ethash verifies seals from its cache instead of the full DAG.
+type HeaderDataset interface {
+	// Size returns the number of uint32 words of the dataset that the trie nodes
+	// of the given block were mined with.
+	Size(number uint64) uint64
+
+	// Lookup returns the 16 words of the dataset row at the given index.
+	Lookup(index uint32) []uint32
+}
+
+var (
+	lightDatasetLock sync.RWMutex
+	lightDataset     HeaderDataset
+)
+
+// SetHeaderDataset makes HashByNonce read the header dataset rows it needs from
+// the given source instead of common.RLPedBlockHeadersUint32s, which only has
+// the size of the latest block. Passing nil goes back to the in-memory dataset.
+// Mining always uses the in-memory dataset.
+func SetHeaderDataset(dataset HeaderDataset) {
+	lightDatasetLock.Lock()
+	defer lightDatasetLock.Unlock()
+
+	lightDataset = dataset
+}
+
+// headerDataset returns the header dataset source set for verification, if any.
+func headerDataset() HeaderDataset {
+	lightDatasetLock.RLock()
+	defer lightDatasetLock.RUnlock()
+
+	return lightDataset
+}
+
+// hashimotoState holds the buffers of the header dataset mix, so that nonce
+// searches can reuse them across attempts instead of allocating every time.
+type hashimotoState struct {
+	keccak512 keccakState                   // Keccak-512 hasher deriving the seed
+	seed      [64]byte                      // Seed derived from the node hash and nonce
+	mix       [hashimotoMixBytes / 4]uint32 // Mix of the dataset rows read
+	temp      [hashimotoMixBytes / 4]uint32 // Dataset rows read in the current round
+	digest    [common.HashLength]byte       // Compressed mix of the last run
+}
+
+// newHashimotoState creates the buffers for mixing in the header dataset.
+func newHashimotoState() *hashimotoState {
+	return &hashimotoState{keccak512: sha3.NewLegacyKeccak512().(keccakState)}
+}
+
+// hash mixes the header dataset into a trie node mining attempt, mimicking the
+// ethash hashimoto loop (consensus/ethash/algorithm.go), and returns the 32 byte
+// digest of the mix. The original node hash is the hash of the node encoded
+// with a zero nonce, size is the number of words in the dataset and lookup
+// retrieves its rows. The digest is overwritten by the next call.
+func (s *hashimotoState) hash(originalNodeHash hashNode, nonce uint64, size uint64, accesses int, lookup func(index uint32) []uint32) []byte {
+	// Calculate the number of theoretical rows
+	rows := uint32(size * 4 / hashimotoMixBytes)
+
+	// Combine hash+nonce into a 64 byte seed
+	copy(s.seed[:32], originalNodeHash)
+	binary.LittleEndian.PutUint64(s.seed[32:], nonce)
+
+	s.keccak512.Reset()
+	s.keccak512.Write(s.seed[:40])
+	s.keccak512.Read(s.seed[:])
+	seedHead := binary.LittleEndian.Uint32(s.seed[:])
+
+	// Start the mix with replicated seed
+	mix := s.mix[:]
+	for i := 0; i < len(mix); i++ {
+		mix[i] = binary.LittleEndian.Uint32(s.seed[i%16*4:])
+	}
+	// Mix in random dataset nodes
+	for i := 0; i < accesses && rows > 0; i++ {
+		parent := fnv(uint32(i)^seedHead, mix[i%len(mix)]) % rows
+		for j := uint32(0); j < uint32(hashimotoMixBytes/hashimotoHashBytes); j++ {
+			copy(s.temp[j*hashimotoHashWords:], lookup(2*parent+j))
+		}
+		fnvHash(mix, s.temp[:])
+	}
+	// Compress mix
+	for i := 0; i < len(mix); i += 4 {
+		mix[i/4] = fnv(fnv(fnv(mix[i], mix[i+1]), mix[i+2]), mix[i+3])
+	}
+	for i, val := range mix[:len(mix)/4] {
+		binary.LittleEndian.PutUint32(s.digest[i*4:], val)
+	}
+	return s.digest[:]
+}
+
+// hashFull mixes the header dataset held in memory into a trie node mining
+// attempt. The digest is overwritten by the next call.
+func (s *hashimotoState) hashFull(dataset []uint32, originalNodeHash hashNode, nonce uint64, accesses int) []byte {
+	lookup := func(index uint32) []uint32 {
+		offset := index * hashimotoHashWords
+		return dataset[offset : offset+hashimotoHashWords]
+	}
+	return s.hash(originalNodeHash, nonce, uint64(len(dataset)), accesses, lookup)
+}
+
+// hashimotoTrie mixes the header dataset into a trie node mining attempt with
+// fresh buffers, see hashimotoState.hash.
+func hashimotoTrie(originalNodeHash hashNode, nonce uint64, size uint64, accesses int, lookup func(index uint32) []uint32) []byte {
+	return newHashimotoState().hash(originalNodeHash, nonce, size, accesses, lookup)
+}
+
+// hashimotoTrieFull mixes the header dataset held in memory into a trie node
+// mining attempt.
+func hashimotoTrieFull(dataset []uint32, originalNodeHash hashNode, nonce uint64, accesses int) []byte {
+	return newHashimotoState().hashFull(dataset, originalNodeHash, nonce, accesses)
+}
+
+// hashimotoTrieLight mixes the header dataset of the given block into a trie
+// node mining attempt, reading only the rows it accesses from the source.
+func hashimotoTrieLight(dataset HeaderDataset, blockNum uint64, originalNodeHash hashNode, nonce uint64, accesses int) []byte {
+	return hashimotoTrie(originalNodeHash, nonce, dataset.Size(blockNum), accesses, dataset.Lookup)
+}
@@ -0,0 +1,216 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// NodeInfo is the content of an encoded trie node.
+type NodeInfo struct {
+	Full     bool     // Whether the node is a full node rather than a short node
+	Key      []byte   // Key nibbles of a short node, terminated with 16 for leaves
+	Value    []byte   // Value of a leaf or of the value slot of a full node
+	Children [][]byte // Child references, either hashes or embedded node encodings
+	Nonce    uint64   // Trie-Hashimoto nonce of the node
+}
+
+// DecodeNodeInfo decodes the content of a trie node encoding. Child references
+// are returned as they are encoded, empty for the missing children of a full
+// node.
+func DecodeNodeInfo(blob []byte) (*NodeInfo, error) {
+	if _, err := decodeNode(nil, blob); err != nil {
+		return nil, err
+	}
+	// The node is well formed, split it up keeping the embedded children as is
+	elems, _, _ := rlp.SplitList(blob)
+	var items [][]byte
+	for len(elems) > 0 {
+		kind, content, rest, err := rlp.Split(elems)
+		if err != nil {
+			return nil, err
+		}
+		if kind == rlp.List {
+			content = elems[:len(elems)-len(rest)]
+		}
+		items, elems = append(items, content), rest
+	}
+	info := &NodeInfo{Nonce: binary.LittleEndian.Uint64(items[len(items)-1])}
+	if len(items) == 18 {
+		info.Full = true
+		info.Children = items[:16]
+		if len(items[16]) > 0 {
+			info.Value = items[16]
+		}
+		return info, nil
+	}
+	info.Key = compactToHex(items[0])
+	if hasTerm(info.Key) {
+		info.Value = items[1]
+	} else {
+		info.Children = items[1:2]
+	}
+	return info, nil
+}
+
+// OriginalNodeHash returns the hash of a trie node encoding with its nonce set to
+// zero, which is the hash the node had before it was mined.
+func OriginalNodeHash(blob []byte) common.Hash {
+	h := newHasher(nil)
+	defer returnHasherToPool(h)
+
+	enc := common.CopyBytes(blob)
+	if len(enc) >= 8 {
+		copy(enc[len(enc)-8:], make([]byte, 8))
+	}
+	return common.BytesToHash(h.makeHashNode(enc))
+}
+
+// MinedNode is a trie node mined in a block.
+type MinedNode struct {
+	Hash         common.Hash // Hash of the mined node, prefixed with the block number
+	OriginalHash common.Hash // Hash of the node before it was mined, see OriginalNodeHash
+	Nonce        uint64      // Nonce the node was mined with
+}
+
+// MinedNodes returns the nodes of the trie with the given root that were mined
+// in the block, in the order their nonces are listed in the block. These are the
+// dirty nodes the block wrote, which are hashed children first, so the trie is
+// walked depth first in post-order, only descending into the nodes mined in the
+// block, as nodes left untouched can't have mined children.
+func (db *Database) MinedNodes(config *params.TrieHashimotoConfig, root common.Hash, number uint64) ([]MinedNode, error) {
+	if config == nil || (config.Block != nil && number < config.Block.Uint64()) {
+		return nil, nil
+	}
+	h := newHasher(nil)
+	defer returnHasherToPool(h)
+	h.th, h.light = config, headerDataset()
+
+	var nodes []MinedNode
+	err := db.walkMinedNodes(h, root, number, nil, &nodes)
+	return nodes, err
+}
+
+// walkMinedNodes collects the nodes of the subtrie at the given hash mined in the
+// block, see MinedNodes.
+func (db *Database) walkMinedNodes(h *hasher, hash common.Hash, number uint64, path []byte, nodes *[]MinedNode) error {
+	if !HasBlockPrefix(hash, number, h.th.PrefixLength) {
+		return nil
+	}
+	blob, err := db.Node(hash)
+	if err != nil {
+		return &MissingNodeError{NodeHash: hash, Path: path}
+	}
+	// The prefix may have been mined in an older epoch, check the hash is ours
+	mined := MinedNode{Hash: hash, OriginalHash: OriginalNodeHash(blob), Nonce: binary.LittleEndian.Uint64(blob[len(blob)-8:])}
+	if h.th.Fake {
+		if !bytes.Equal(h.makeHashNode(blob)[h.th.PrefixLength:], hash[h.th.PrefixLength:]) {
+			return nil
+		}
+	} else {
+		var original hashNode
+		if h.th.ReadHeader {
+			original = mined.OriginalHash[:]
+		}
+		if !bytes.Equal(h.makeNodeHashWithNonce(blob, original, mined.Nonce, number), hash[:]) {
+			return nil
+		}
+	}
+	n, err := decodeNode(hash[:], blob)
+	if err != nil {
+		return err
+	}
+	switch n := n.(type) {
+	case *shortNode:
+		if child, ok := n.Val.(hashNode); ok {
+			if err := db.walkMinedNodes(h, common.BytesToHash(child), number, append(path, n.Key...), nodes); err != nil {
+				return err
+			}
+		}
+	case *fullNode:
+		for i := 0; i < 16; i++ {
+			if child, ok := n.Children[i].(hashNode); ok {
+				if err := db.walkMinedNodes(h, common.BytesToHash(child), number, append(path, byte(i)), nodes); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	*nodes = append(*nodes, mined)
+	return nil
+}
+
+// LeafParentHash returns the hash of the stored node referencing the leaf of the
+// key, which is the parent of the leaf, or the node embedding it if the leaf is
+// too small to be stored on its own. It returns false if the key is missing or
+// its leaf is the root.
+func (t *Trie) LeafParentHash(key []byte) (common.Hash, bool, error) {
+	var (
+		hashes []common.Hash // Hashes of the stored nodes on the path to the key
+		stored bool          // Whether the current node is stored on its own
+		tn     = t.root
+		pos    int
+	)
+	key = keybytesToHex(key)
+	for {
+		switch n := tn.(type) {
+		case hashNode:
+			resolved, err := t.resolveHash(n, key[:pos])
+			if err != nil {
+				return common.Hash{}, false, err
+			}
+			tn = resolved
+			continue
+		case *shortNode:
+			if len(key)-pos < len(n.Key) || !bytes.Equal(n.Key, key[pos:pos+len(n.Key)]) {
+				return common.Hash{}, false, nil
+			}
+			if stored = n.flags.hash != nil; stored {
+				hashes = append(hashes, common.BytesToHash(n.flags.hash))
+			}
+			tn, pos = n.Val, pos+len(n.Key)
+		case *fullNode:
+			if stored = n.flags.hash != nil; stored {
+				hashes = append(hashes, common.BytesToHash(n.flags.hash))
+			}
+			tn, pos = n.Children[key[pos]], pos+1
+		default:
+			return common.Hash{}, false, nil
+		}
+		if _, ok := tn.(valueNode); ok {
+			// The node just left is the leaf, skip it if it's stored on its own
+			if stored {
+				hashes = hashes[:len(hashes)-1]
+			}
+			if len(hashes) == 0 {
+				return common.Hash{}, false, nil
+			}
+			return hashes[len(hashes)-1], true, nil
+		}
+	}
+}
+
+// LeafParentHash returns the hash of the stored node referencing the leaf of the
+// key, see Trie.LeafParentHash.
+func (t *SecureTrie) LeafParentHash(key []byte) (common.Hash, bool, error) {
+	return t.trie.LeafParentHash(t.hashKey(key))
+}
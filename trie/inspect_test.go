@@ -0,0 +1,168 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+)
+
+// Tests that the nodes mined in a block are found in the order of their nonces.
+func TestMinedNodes(t *testing.T) {
+	triedb := NewDatabase(memorydb.New())
+
+	mine := func(trie *Trie, number uint64) (common.Hash, []uint64) {
+		root, nonces, err := trie.HashWithNonce(testTrieHashimoto, number, nil, &Miner{Threads: 2})
+		if err != nil {
+			t.Fatalf("block %d: failed to mine trie: %v", number, err)
+		}
+		if _, err := trie.Commit(nil); err != nil {
+			t.Fatalf("block %d: failed to commit trie: %v", number, err)
+		}
+		return root, nonces
+	}
+	check := func(root common.Hash, number uint64, nonces []uint64) {
+		nodes, err := triedb.MinedNodes(testTrieHashimoto, root, number)
+		if err != nil {
+			t.Fatalf("block %d: failed to find mined nodes: %v", number, err)
+		}
+		if len(nodes) != len(nonces) {
+			t.Fatalf("block %d: mined node count mismatch: have %d, want %d", number, len(nodes), len(nonces))
+		}
+		for i, node := range nodes {
+			if node.Nonce != nonces[i] {
+				t.Errorf("block %d: node %d nonce mismatch: have %d, want %d", number, i, node.Nonce, nonces[i])
+			}
+			if !HasBlockPrefix(node.Hash, number, testTrieHashimoto.PrefixLength) {
+				t.Errorf("block %d: node %x not prefixed with the block", number, node.Hash)
+			}
+			blob, _ := triedb.Node(node.Hash)
+			copy(blob[len(blob)-8:], make([]byte, 8))
+			if hash := newHasher(nil).makeHashNode(blob); !bytes.Equal(hash, node.OriginalHash[:]) {
+				t.Errorf("block %d: node %d original hash mismatch: have %x, want %x", number, i, node.OriginalHash, hash)
+			}
+		}
+		if nodes[len(nodes)-1].Hash != root {
+			t.Errorf("block %d: last mined node %x, want root %x", number, nodes[len(nodes)-1].Hash, root)
+		}
+	}
+	trie, _ := New(common.Hash{}, triedb)
+	for i := 0; i < 32; i++ {
+		trie.Update([]byte{byte(i), 0x01}, bytes.Repeat([]byte{byte(i)}, 32))
+	}
+	oldRoot, oldNonces := mine(trie, 3)
+	check(oldRoot, 3, oldNonces)
+
+	// Only the path to the updated leaf must be mined in the next block
+	trie.Update([]byte{0x00, 0x01}, bytes.Repeat([]byte{0xff}, 32))
+	newRoot, newNonces := mine(trie, 4)
+	if len(newNonces) >= len(oldNonces) {
+		t.Fatalf("whole trie mined again: %d nodes, %d before", len(newNonces), len(oldNonces))
+	}
+	check(newRoot, 4, newNonces)
+
+	if nodes, _ := triedb.MinedNodes(testTrieHashimoto, newRoot, 3); len(nodes) != 0 {
+		t.Errorf("found %d nodes mined in block 3 from the block 4 root", len(nodes))
+	}
+}
+
+// Tests that trie node encodings are decoded along with their nonces.
+func TestDecodeNodeInfo(t *testing.T) {
+	triedb := NewDatabase(memorydb.New())
+	trie, _ := New(common.Hash{}, triedb)
+	for i := 0; i < 16; i++ {
+		trie.Update([]byte{byte(i) << 4}, bytes.Repeat([]byte{byte(i)}, 32))
+	}
+	root, nonces, err := trie.HashWithNonce(testTrieHashimoto, 1, nil, &Miner{Threads: 1})
+	if err != nil {
+		t.Fatalf("failed to mine trie: %v", err)
+	}
+	trie.Commit(nil)
+
+	blob, err := triedb.Node(root)
+	if err != nil {
+		t.Fatalf("failed to read root: %v", err)
+	}
+	info, err := DecodeNodeInfo(blob)
+	if err != nil {
+		t.Fatalf("failed to decode root: %v", err)
+	}
+	if !info.Full || len(info.Children) != 16 || info.Value != nil {
+		t.Fatalf("root not decoded as a full node: %+v", info)
+	}
+	if info.Nonce != nonces[len(nonces)-1] {
+		t.Errorf("root nonce mismatch: have %d, want %d", info.Nonce, nonces[len(nonces)-1])
+	}
+	leaf, err := triedb.Node(common.BytesToHash(info.Children[0]))
+	if err != nil {
+		t.Fatalf("failed to read leaf: %v", err)
+	}
+	if info, err = DecodeNodeInfo(leaf); err != nil {
+		t.Fatalf("failed to decode leaf: %v", err)
+	}
+	if info.Full || !hasTerm(info.Key) || !bytes.Equal(info.Value, bytes.Repeat([]byte{0}, 32)) {
+		t.Errorf("leaf mismatch: %+v", info)
+	}
+	if _, err := DecodeNodeInfo([]byte{0xc0}); err == nil {
+		t.Errorf("empty list decoded")
+	}
+}
+
+// Tests that the parent of a leaf is the stored node referencing it, or the one
+// embedding it.
+func TestLeafParentHash(t *testing.T) {
+	triedb := NewDatabase(memorydb.New())
+	trie, _ := New(common.Hash{}, triedb)
+	trie.Update([]byte{0x00}, bytes.Repeat([]byte{0x01}, 32))
+	trie.Update([]byte{0x01}, bytes.Repeat([]byte{0x02}, 32))
+	trie.Update([]byte{0x10}, []byte{0x03})
+	root, _ := trie.Commit(nil)
+
+	trie, _ = New(root, triedb)
+	blob, _ := triedb.Node(root)
+	info, _ := DecodeNodeInfo(blob)
+
+	tests := []struct {
+		key    []byte
+		parent common.Hash
+		found  bool
+	}{
+		{[]byte{0x00}, common.BytesToHash(info.Children[0]), true}, // Stored leaf below the root
+		{[]byte{0x10}, root, true},                                 // Leaf embedded in the root
+		{[]byte{0x11}, common.Hash{}, false},                       // Missing key
+	}
+	for i, tt := range tests {
+		parent, found, err := trie.LeafParentHash(tt.key)
+		if err != nil {
+			t.Fatalf("test %d: failed to find leaf parent: %v", i, err)
+		}
+		if parent != tt.parent || found != tt.found {
+			t.Errorf("test %d: leaf parent mismatch: have %x (%v), want %x (%v)", i, parent, found, tt.parent, tt.found)
+		}
+	}
+	// A leaf at the root has no parent
+	single, _ := New(common.Hash{}, triedb)
+	single.Update([]byte{0x00}, bytes.Repeat([]byte{0x01}, 32))
+	root, _ = single.Commit(nil)
+	single, _ = New(root, triedb)
+	if parent, found, err := single.LeafParentHash([]byte{0x00}); found || err != nil {
+		t.Errorf("root leaf parent found: %x, err %v", parent, err)
+	}
+}
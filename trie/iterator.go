@@ -187,8 +187,8 @@ func (it *nodeIterator) LeafProof() [][]byte {
 
 			for i, item := range it.stack[:len(it.stack)-1] {
 				// Gather nodes that end up as hash nodes (or the root)
-				node, _, _ := hasher.hashChildren(item.node, nil, nil, false, 0, 0, nil)
-				hashed, _, _ := hasher.store(node, nil, false, nil, false, 0, 0, nil)
+				node, _, _ := hasher.hashChildren(item.node, nil, nil, false, 0, nil)
+				hashed, _, _ := hasher.store(node, nil, false, nil, false, 0, nil)
 				if _, ok := hashed.(hashNode); ok || i == 0 {
 					enc, _ := rlp.EncodeToBytes(node)
 					proofs = append(proofs, enc)
@@ -0,0 +1,202 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"encoding/binary"
+	"runtime"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Miner configures how HashWithNonce searches the nonces of dirty trie nodes.
+type Miner struct {
+	// Threads is the number of local search threads. If it's zero or less, nodes
+	// are only mined by remote miners.
+	Threads int
+
+	// Remote, if set, hands every node out to remote miners while it's searched,
+	// the first valid nonce found either locally or remotely wins.
+	Remote chan<- *NodeWork
+
+	// Abort, if set, stops mining once closed, failing with MiningAbortedError.
+	Abort <-chan struct{}
+
+	// Hashrate, if set, is marked with the nonces tried by local threads.
+	Hashrate metrics.Meter
+
+	// Progress, if set, is incremented with every node mined, either locally or
+	// remotely.
+	Progress metrics.Counter
+
+	// Sequential mines one node at a time with all threads instead of mining
+	// independent subtrees concurrently, mostly useful for comparison.
+	Sequential bool
+}
+
+var (
+	// Trie-Hashimoto mining and verification stats, exported through the
+	// metrics registry like any other.
+	thHashMeter          = metrics.NewRegisteredMeter("trie/th/hashes", nil)
+	thDatasetAccessMeter = metrics.NewRegisteredMeter("trie/th/dataset/accesses", nil)
+	thNodeTimer          = metrics.NewRegisteredTimer("trie/th/node/time", nil)
+	thRemoteNodeMeter    = metrics.NewRegisteredMeter("trie/th/node/remote", nil)
+)
+
+// localMiner returns a miner searching nonces on all CPUs without remote help.
+func localMiner() *Miner {
+	return &Miner{Threads: runtime.NumCPU()}
+}
+
+// miningSession tracks a single HashWithNonce run. Nodes whose subtrees are
+// mined already are mined concurrently, sharing the local threads: every node
+// takes one thread, waiting for it if all are busy, and the idle ones if no
+// other node is waiting.
+type miningSession struct {
+	*Miner
+
+	slots   chan struct{} // Local threads in use, nil if mining remotely only
+	waiting int32         // Number of nodes waiting for a local thread (atomic)
+	mined   int64         // Number of nodes mined so far (atomic)
+}
+
+// newMiningSession creates a session mining with the given miner.
+func newMiningSession(miner *Miner) *miningSession {
+	s := &miningSession{Miner: miner}
+	if miner.Threads > 0 {
+		s.slots = make(chan struct{}, miner.Threads)
+	}
+	return s
+}
+
+// acquire takes the local threads to mine a node with, returning false if the
+// session is aborted while waiting for them.
+func (s *miningSession) acquire() (int, bool) {
+	if s.slots == nil {
+		return 0, true
+	}
+	atomic.AddInt32(&s.waiting, 1)
+	select {
+	case s.slots <- struct{}{}:
+		atomic.AddInt32(&s.waiting, -1)
+	case <-s.Abort:
+		atomic.AddInt32(&s.waiting, -1)
+		return 0, false
+	}
+	threads := 1
+	for threads < cap(s.slots) && atomic.LoadInt32(&s.waiting) == 0 {
+		select {
+		case s.slots <- struct{}{}:
+			threads++
+		default:
+			return threads, true
+		}
+	}
+	return threads, true
+}
+
+// release returns the local threads a node was mined with.
+func (s *miningSession) release(threads int) {
+	for i := 0; i < threads; i++ {
+		<-s.slots
+	}
+}
+
+// NodeWork is a dirty trie node awaiting its nonce, handed out to remote miners.
+// A node hash is the Keccak256 hash of the encoding with the nonce in its last 8
+// bytes, followed by the header dataset digest of the original hash and nonce if
+// the header dataset is read, followed by the big-endian prefix epoch of the
+// block if it's not zero. It must be prefixed with the block number and not
+// exceed the target past the prefix.
+type NodeWork struct {
+	Number       uint64                      // Block number the node hash has to be prefixed with
+	OriginalHash common.Hash                 // Hash of the node encoded with a zero nonce
+	Encoding     []byte                      // Encoding of the node with a zero nonce
+	Config       *params.TrieHashimotoConfig // Trie-Hashimoto parameters the node is mined with
+	DatasetSize  uint64                      // Number of header dataset words the node is mined with
+	Target       common.Hash                 // Hash target of the block's trie difficulty past the prefix
+
+	dataset []uint32      // Header dataset the node is mined with
+	found   chan uint64   // Delivers a remotely found nonce
+	done    chan struct{} // Closed when the node is mined
+}
+
+// newNodeWork creates the remote work package of a node encoding.
+func newNodeWork(number uint64, originalHash hashNode, enc []byte, config *params.TrieHashimotoConfig, target []byte) *NodeWork {
+	if target == nil {
+		target = trieTarget(config.PrefixLength, nil)
+	}
+	return &NodeWork{
+		Number:       number,
+		OriginalHash: common.BytesToHash(originalHash),
+		Encoding:     common.CopyBytes(enc),
+		Config:       config,
+		DatasetSize:  uint64(len(common.RLPedBlockHeadersUint32s)),
+		Target:       common.BytesToHash(target),
+		dataset:      common.RLPedBlockHeadersUint32s,
+		found:        make(chan uint64, 1),
+		done:         make(chan struct{}),
+	}
+}
+
+// Hash returns the Trie-Hashimoto hash of the node with the given nonce, mixing
+// in the header dataset the node is mined with.
+func (w *NodeWork) Hash(nonce uint64) common.Hash {
+	enc := common.CopyBytes(w.Encoding)
+	copy(enc[len(enc)-8:], i64tob(nonce))
+	if w.Config.ReadHeader {
+		enc = append(enc, hashimotoTrieFull(w.dataset, w.OriginalHash[:], nonce, w.Config.LoopAccesses)...)
+	}
+	if epoch := PrefixEpoch(w.Number, w.Config.PrefixLength); epoch > 0 {
+		var bs [8]byte
+		binary.BigEndian.PutUint64(bs[:], epoch)
+		enc = append(enc, bs[:]...)
+	}
+	return crypto.Keccak256Hash(enc)
+}
+
+// Verify returns whether the nonce makes the node hash prefixed with the block
+// number and meet the target.
+func (w *NodeWork) Verify(nonce uint64) bool {
+	hash := w.Hash(nonce)
+	return validHash(hash[:], w.Number, w.Config.PrefixLength) && meetsTarget(hash[:], w.Target[:], w.Config.PrefixLength)
+}
+
+// Submit delivers a nonce found remotely, returning false if the node has been
+// mined already. The nonce is not verified.
+func (w *NodeWork) Submit(nonce uint64) bool {
+	select {
+	case <-w.done:
+		return false
+	default:
+	}
+	select {
+	case w.found <- nonce:
+		return true
+	default:
+		return false
+	}
+}
+
+// Done returns a channel closed once the node has been mined.
+func (w *NodeWork) Done() <-chan struct{} {
+	return w.done
+}
@@ -30,6 +30,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
 )
 
 var indices = []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9", "a", "b", "c", "d", "e", "f", "[17]"}
@@ -407,11 +408,11 @@ func trieSize(db ethdb.KeyValueReader, buf []byte) uint64 {
 	return size
 }
 
-func MiningTime(db ethdb.KeyValueReader, buf []byte, blockNum uint64, threads int) uint64 {
-	return miningTime(db, buf, blockNum, threads)
+func MiningTime(db ethdb.KeyValueReader, buf []byte, blockNum uint64, threads int, config *params.TrieHashimotoConfig) uint64 {
+	return miningTime(db, buf, blockNum, threads, config)
 }
 
-func miningTime(db ethdb.KeyValueReader, buf []byte, blockNum uint64, threads int) uint64 {
+func miningTime(db ethdb.KeyValueReader, buf []byte, blockNum uint64, threads int, config *params.TrieHashimotoConfig) uint64 {
 	// If the trie node is not updated exactly at this block number, bypass it
 	// get the rlp encoded trie node data from LevelDB
 	data, _ := db.Get(buf)
@@ -421,7 +422,7 @@ func miningTime(db ethdb.KeyValueReader, buf []byte, blockNum uint64, threads in
 
 	node := mustDecodeNode(buf, data)
 	startTime := time.Now()
-	_ = trieNodeMining(node, blockNum, threads, hashNode{}) // simulateMining(node, blockNum)
+	_, _ = trieNodeMining(node, blockNum, threads, hashNode{}, nil, config, nil, nil) // simulateMining(node, blockNum)
 	elapsedMiningTime := uint64(time.Since(startTime).Nanoseconds())
 
 	switch n := node.(type) {
@@ -430,14 +431,14 @@ func miningTime(db ethdb.KeyValueReader, buf []byte, blockNum uint64, threads in
 		for _, child := range &n.Children {
 			if child != nil {
 				hash, _ = child.(hashNode)
-				if validHash(hash, blockNum) { elapsedMiningTime += miningTime(db, hash, blockNum, threads); }
+				if validHash(hash, blockNum, config.PrefixLength) { elapsedMiningTime += miningTime(db, hash, blockNum, threads, config); }
 			}
 		}
 	case *shortNode:
 		switch val := n.Val.(type) {
 		case hashNode:
 			//fmt.Println("hash")
-			if validHash(val, blockNum) { elapsedMiningTime += miningTime(db, val, blockNum, threads); }
+			if validHash(val, blockNum, config.PrefixLength) { elapsedMiningTime += miningTime(db, val, blockNum, threads, config); }
 		default:
 		}
 	default:
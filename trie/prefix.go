@@ -0,0 +1,279 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// The block number prefix of a Trie-Hashimoto node hash only holds the low
+// PrefixLength bytes of the number, so it wraps around every 2^(8*PrefixLength)
+// blocks. The blocks sharing the same high bytes form a prefix epoch. Node
+// hashes of every epoch but the first are bound to their epoch, so that a node
+// mined in one epoch is never valid in another, and the database keeps an index
+// of the full block number of every node it flushes, so that node age stays
+// unambiguous past the wraparound.
+
+// NodeAgePrefix is the database key prefix of the trie node age index:
+// NodeAgePrefix + block number (uint64 big endian) + node hash -> nil.
+// Iterating over it yields the flushed trie nodes ordered by age.
+var NodeAgePrefix = []byte("th-age-")
+
+// nodeAgeKeyLength is the length of the above prefix + 8 byte number + 32 byte hash.
+const nodeAgeKeyLength = 7 + 8 + common.HashLength
+
+// PrefixEpochLength returns the number of blocks after which the block number
+// prefixes of the given length wrap around, or 0 if they never do.
+func PrefixEpochLength(prefixLength int) uint64 {
+	if prefixLength >= 8 {
+		return 0
+	}
+	return 1 << (8 * uint(prefixLength))
+}
+
+// PrefixEpoch returns the prefix epoch of a block, i.e. the number of times the
+// block number prefix wrapped around before it.
+func PrefixEpoch(number uint64, prefixLength int) uint64 {
+	if prefixLength >= 8 {
+		return 0
+	}
+	return number >> (8 * uint(prefixLength))
+}
+
+// HashPrefix returns the block number prefix of a node hash, i.e. the block
+// number of the node modulo the prefix epoch length.
+func HashPrefix(hash common.Hash, prefixLength int) uint64 {
+	var bs [8]byte
+	copy(bs[8-prefixLength:], hash[:prefixLength])
+	return binary.BigEndian.Uint64(bs[:])
+}
+
+// HasBlockPrefix returns whether the node hash is prefixed with the block number,
+// ignoring the epoch of the block.
+func HasBlockPrefix(hash common.Hash, number uint64, prefixLength int) bool {
+	return validHash(hash[:], number, prefixLength)
+}
+
+// LatestPrefixBlock returns the most recent block up to and including head whose
+// number the node hash is prefixed with. It's only the block the node was mined
+// in if the node is less than a prefix epoch old, use ReadNodeAge otherwise. The
+// second return value is false if no such block exists.
+func LatestPrefixBlock(hash common.Hash, prefixLength int, head uint64) (uint64, bool) {
+	prefix := HashPrefix(hash, prefixLength)
+	epochLength := PrefixEpochLength(prefixLength)
+	if epochLength == 0 {
+		return prefix, prefix <= head
+	}
+	number := head - head%epochLength + prefix
+	if number > head {
+		if number < epochLength {
+			return 0, false
+		}
+		number -= epochLength
+	}
+	return number, true
+}
+
+// VerifyNodeHash checks that a trie node blob, carrying its nonce in the last 8
+// bytes, hashes to the given hash. Blobs matching their plain hash, like the
+// contract code, the nodes written before Trie-Hashimoto and the first epoch
+// nodes hashed without the header dataset, are valid as is. Otherwise the hash
+// must be prefixed with a TH block up to head, and since the epoch is bound into
+// the hash, every epoch of the prefix is tried from the latest down. The trie
+// difficulty target isn't checked here, the caller must have verified the block
+// whose state commits to the node. Nonces mixing in the header dataset are
+// verified against the rows of the given one, which must hold the headers up to
+// head, a nil dataset stands for the one set by SetHeaderDataset.
+func VerifyNodeHash(config *params.TrieHashimotoConfig, hash common.Hash, blob []byte, head uint64, dataset HeaderDataset) error {
+	_, _, err := verifyNodeHash(config, hash, blob, head, dataset)
+	return err
+}
+
+// verifyNodeHash is VerifyNodeHash also returning the block the node hash was
+// mined in, or true instead if the blob matches its plain hash.
+func verifyNodeHash(config *params.TrieHashimotoConfig, hash common.Hash, blob []byte, head uint64, dataset HeaderDataset) (uint64, bool, error) {
+	h := newHasher(nil)
+	defer returnHasherToPool(h)
+
+	if bytes.Equal(h.makeHashNode(blob), hash[:]) {
+		return 0, true, nil
+	}
+	if config == nil || len(blob) < 8 {
+		return 0, false, ErrNodeHashMismatch
+	}
+	start := uint64(0)
+	if config.Block != nil {
+		start = config.Block.Uint64()
+	}
+	number, ok := LatestPrefixBlock(hash, config.PrefixLength, head)
+	if !ok || number < start {
+		return 0, false, ErrInvalidNodePrefix
+	}
+	// Fake nodes only overwrite the prefix of their plain hash
+	if config.Fake {
+		if !bytes.Equal(h.makeHashNode(blob)[config.PrefixLength:], hash[config.PrefixLength:]) {
+			return 0, false, ErrNodeHashMismatch
+		}
+		return number, false, nil
+	}
+	h.th = config
+	if h.light = dataset; h.light == nil {
+		h.light = headerDataset()
+	}
+
+	var original hashNode
+	if config.ReadHeader {
+		enc := common.CopyBytes(blob)
+		copy(enc[len(enc)-8:], make([]byte, 8))
+		original = h.makeHashNode(enc)
+	}
+	nonce := binary.LittleEndian.Uint64(blob[len(blob)-8:])
+	epochLength := PrefixEpochLength(config.PrefixLength)
+
+	sum := make(hashNode, common.HashLength)
+	for {
+		h.nodeHashWithNonce(sum, blob, original, nonce, number)
+		if bytes.Equal(sum, hash[:]) {
+			return number, false, nil
+		}
+		if epochLength == 0 || number < start+epochLength {
+			return 0, false, ErrNodeHashMismatch
+		}
+		number -= epochLength
+	}
+}
+
+// nodeAgeKey = NodeAgePrefix + num (uint64 big endian) + hash
+func nodeAgeKey(number uint64, hash common.Hash) []byte {
+	key := make([]byte, nodeAgeKeyLength)
+	copy(key, NodeAgePrefix)
+	binary.BigEndian.PutUint64(key[len(NodeAgePrefix):], number)
+	copy(key[len(NodeAgePrefix)+8:], hash[:])
+	return key
+}
+
+// IsNodeAgeKey returns whether the database key belongs to the node age index.
+func IsNodeAgeKey(key []byte) bool {
+	return len(key) == nodeAgeKeyLength && bytes.HasPrefix(key, NodeAgePrefix)
+}
+
+// SplitNodeAgeKey returns the block number and node hash of a node age index key.
+func SplitNodeAgeKey(key []byte) (uint64, common.Hash) {
+	return binary.BigEndian.Uint64(key[len(NodeAgePrefix):]), common.BytesToHash(key[len(NodeAgePrefix)+8:])
+}
+
+// WriteNodeAge records in the node age index that the node was mined in the
+// given block.
+func WriteNodeAge(db ethdb.KeyValueWriter, number uint64, hash common.Hash) error {
+	return db.Put(nodeAgeKey(number, hash), nil)
+}
+
+// ReadNodeAge returns the block a node was mined in according to the node age
+// index. Only the blocks up to head carrying the prefix of the node hash are
+// looked up, newest first, so it takes one lookup per prefix epoch at most.
+func ReadNodeAge(db ethdb.KeyValueReader, hash common.Hash, prefixLength int, head uint64) (uint64, bool) {
+	number, ok := LatestPrefixBlock(hash, prefixLength, head)
+	if !ok {
+		return 0, false
+	}
+	epochLength := PrefixEpochLength(prefixLength)
+	for {
+		if has, _ := db.Has(nodeAgeKey(number, hash)); has {
+			return number, true
+		}
+		if epochLength == 0 || number < epochLength {
+			return 0, false
+		}
+		number -= epochLength
+	}
+}
+
+// NodeAge returns the block a node was mined in according to the node age index,
+// or else the latest block up to head carrying the prefix of the node hash. It
+// returns false if that block predates Trie-Hashimoto activation.
+func NodeAge(db ethdb.KeyValueReader, config *params.TrieHashimotoConfig, hash common.Hash, head uint64) (uint64, bool) {
+	if config == nil {
+		return 0, false
+	}
+	number, ok := ReadNodeAge(db, hash, config.PrefixLength, head)
+	if !ok {
+		number, ok = LatestPrefixBlock(hash, config.PrefixLength, head)
+	}
+	if !ok || (config.Block != nil && number < config.Block.Uint64()) {
+		return 0, false
+	}
+	return number, true
+}
+
+// nodeAgeIndex makes a Database record the block number of the trie nodes it
+// flushes, see Database.IndexNodeAge.
+type nodeAgeIndex struct {
+	prefixLength int    // Length of the block number prefix of the node hashes
+	head         uint64 // Most recent block the flushed nodes may be mined in
+}
+
+// number resolves the block number of a flushed node, returning false if the
+// node hash is not prefixed with a block number up to the head.
+func (idx *nodeAgeIndex) number(hash common.Hash) (uint64, bool) {
+	return LatestPrefixBlock(hash, idx.prefixLength, idx.head)
+}
+
+// AgedNode is a flushed trie node along with the block it was mined in.
+type AgedNode struct {
+	Number uint64      // Block the node was mined in
+	Hash   common.Hash // Hash of the node
+	Blob   []byte      // Encoding of the node, carrying its nonce
+}
+
+// NodesByAge returns the trie nodes the age index records in the blocks from
+// `from` up to but excluding `to`, oldest first, resuming after the origin node
+// of the first block if one is given. Since the index is ordered by block, they
+// are gathered with a single range scan, stopping once maxNodes nodes or about
+// maxBytes bytes are found. Nodes no longer available are skipped.
+func (db *Database) NodesByAge(from uint64, origin common.Hash, to uint64, maxNodes int, maxBytes int) []AgedNode {
+	it := db.diskdb.NewIteratorWithStart(nodeAgeKey(from, origin))
+	defer it.Release()
+
+	var (
+		nodes []AgedNode
+		size  int
+	)
+	for it.Next() && len(nodes) < maxNodes && size < maxBytes {
+		if !IsNodeAgeKey(it.Key()) {
+			break
+		}
+		number, hash := SplitNodeAgeKey(it.Key())
+		if number >= to {
+			break
+		}
+		if number == from && hash == origin {
+			continue
+		}
+		blob, err := db.Node(hash)
+		if err != nil {
+			continue
+		}
+		nodes = append(nodes, AgedNode{Number: number, Hash: hash, Blob: blob})
+		size += len(blob)
+	}
+	return nodes
+}
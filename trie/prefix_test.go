@@ -0,0 +1,223 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// testWrapTrieHashimoto is a Trie-Hashimoto config whose block number prefixes
+// wrap around every 256 blocks.
+var testWrapTrieHashimoto = &params.TrieHashimotoConfig{PrefixLength: 1, LoopAccesses: 1}
+
+func TestPrefixEpoch(t *testing.T) {
+	tests := []struct {
+		number       uint64
+		prefixLength int
+		epoch        uint64
+	}{
+		{0, 1, 0}, {255, 1, 0}, {256, 1, 1}, {257, 1, 1}, {1 << 16, 1, 256},
+		{65535, 2, 0}, {65536, 2, 1}, {1<<40 + 5, 2, 1 << 24},
+		{1<<63 + 1, 8, 0},
+	}
+	for _, tt := range tests {
+		if have := PrefixEpoch(tt.number, tt.prefixLength); have != tt.epoch {
+			t.Errorf("block %d, prefix length %d: epoch mismatch: have %d, want %d", tt.number, tt.prefixLength, have, tt.epoch)
+		}
+	}
+}
+
+func TestLatestPrefixBlock(t *testing.T) {
+	tests := []struct {
+		prefix byte
+		head   uint64
+		number uint64
+		ok     bool
+	}{
+		{0x05, 4, 0, false},
+		{0x05, 5, 5, true},
+		{0x05, 260, 5, true},
+		{0x05, 261, 261, true},
+		{0xff, 256, 255, true},
+		{0x00, 256, 256, true},
+		{0x01, 1000, 769, true},
+	}
+	for _, tt := range tests {
+		hash := common.Hash{tt.prefix}
+		number, ok := LatestPrefixBlock(hash, 1, tt.head)
+		if ok != tt.ok || number != tt.number {
+			t.Errorf("prefix %#x, head %d: have %d (%v), want %d (%v)", tt.prefix, tt.head, number, ok, tt.number, tt.ok)
+		}
+	}
+}
+
+// Tests that node hashes are bound to the prefix epoch of their block, so that
+// nonces mined before the prefix wraps around are not valid after it.
+func TestHashWithNonceWraparound(t *testing.T) {
+	roots := make(map[uint64]common.Hash)
+	for _, number := range []uint64{1, 255, 256, 257} {
+		root, nonces, err := newTrieHashimotoTestTrie().HashWithNonce(testWrapTrieHashimoto, number, nil, &Miner{Threads: 2})
+		if err != nil {
+			t.Fatalf("block %d: failed to mine trie: %v", number, err)
+		}
+		if !HasBlockPrefix(root, number, testWrapTrieHashimoto.PrefixLength) {
+			t.Fatalf("block %d: root %x not prefixed", number, root)
+		}
+		have, err := newTrieHashimotoTestTrie().HashByNonce(testWrapTrieHashimoto, nonces, number, nil, nil)
+		if err != nil {
+			t.Fatalf("block %d: failed to replay mined nonces: %v", number, err)
+		}
+		if have != root {
+			t.Fatalf("block %d: root mismatch: have %x, want %x", number, have, root)
+		}
+		roots[number] = root
+	}
+	// Blocks 1 and 257 share their prefix, but not their epoch
+	if roots[1] == roots[257] {
+		t.Fatalf("same root %x in different prefix epochs", roots[1])
+	}
+	_, nonces, _ := newTrieHashimotoTestTrie().HashWithNonce(testWrapTrieHashimoto, 1, nil, &Miner{Threads: 2})
+	if _, err := newTrieHashimotoTestTrie().HashByNonce(testWrapTrieHashimoto, nonces, 257, nil, nil); err != ErrInvalidTrieNonce {
+		t.Fatalf("nonces replayed in a later epoch: have error %v, want %v", err, ErrInvalidTrieNonce)
+	}
+	// Remote miners must hash nodes in the same way
+	enc := []byte{0xc2, 0x80, 0x80, 0, 0, 0, 0, 0, 0, 0, 0}
+	for _, number := range []uint64{1, 257} {
+		work := newNodeWork(number, nil, enc, testWrapTrieHashimoto, nil)
+
+		h := newHasher(nil)
+		h.th = testWrapTrieHashimoto
+		want := h.makeNodeHashWithNonce(enc, nil, 0, number)
+		returnHasherToPool(h)
+
+		if have := work.Hash(0); have != common.BytesToHash(want) {
+			t.Errorf("block %d: remote node hash mismatch: have %x, want %x", number, have, want)
+		}
+	}
+}
+
+// Tests that the node age index keeps the block number of flushed nodes past
+// the prefix wraparound.
+func TestNodeAgeIndex(t *testing.T) {
+	diskdb := memorydb.New()
+	triedb := NewDatabase(diskdb)
+
+	// Mine the same content in blocks sharing their prefix
+	var roots []common.Hash
+	for _, number := range []uint64{1, 257, 513} {
+		trie, _ := New(common.Hash{}, triedb)
+		for i := 0; i < 8; i++ {
+			trie.Update([]byte{byte(i)}, []byte{byte(i)})
+		}
+		root, _, err := trie.HashWithNonce(testWrapTrieHashimoto, number, nil, &Miner{Threads: 2})
+		if err != nil {
+			t.Fatalf("block %d: failed to mine trie: %v", number, err)
+		}
+		if _, err := trie.Commit(nil); err != nil {
+			t.Fatalf("block %d: failed to commit trie: %v", number, err)
+		}
+		triedb.IndexNodeAge(testWrapTrieHashimoto.PrefixLength, number)
+		if err := triedb.Commit(root, false); err != nil {
+			t.Fatalf("block %d: failed to flush trie: %v", number, err)
+		}
+		roots = append(roots, root)
+	}
+	// All three roots share the same prefix, their ages must still resolve
+	for i, number := range []uint64{1, 257, 513} {
+		have, ok := ReadNodeAge(diskdb, roots[i], testWrapTrieHashimoto.PrefixLength, 1000)
+		if !ok || have != number {
+			t.Errorf("root %x: age mismatch: have %d (%v), want %d", roots[i], have, ok, number)
+		}
+	}
+	if _, ok := ReadNodeAge(diskdb, roots[2], testWrapTrieHashimoto.PrefixLength, 300); ok {
+		t.Errorf("root %x found before it was mined", roots[2])
+	}
+	// The index must list the nodes by age
+	var (
+		it     = diskdb.NewIteratorWithPrefix(NodeAgePrefix)
+		last   uint64
+		counts = make(map[uint64]int)
+	)
+	defer it.Release()
+	for it.Next() {
+		if !IsNodeAgeKey(it.Key()) {
+			t.Fatalf("unexpected key in node age index: %x", it.Key())
+		}
+		number, hash := SplitNodeAgeKey(it.Key())
+		if number < last {
+			t.Fatalf("node age index out of order: block %d after %d", number, last)
+		}
+		if !HasBlockPrefix(hash, number, testWrapTrieHashimoto.PrefixLength) {
+			t.Fatalf("node %x indexed in block %d", hash, number)
+		}
+		last = number
+		counts[number]++
+	}
+	for _, number := range []uint64{1, 257, 513} {
+		if counts[number] == 0 {
+			t.Errorf("no nodes indexed in block %d", number)
+		}
+	}
+}
+
+// Tests that the nodes mined in a block range are served from the age index in
+// order, resuming where a limited scan left off.
+func TestNodesByAge(t *testing.T) {
+	triedb := NewDatabase(memorydb.New())
+	trie, _ := New(common.Hash{}, triedb)
+	for i := 0; i < 16; i++ {
+		trie.Update([]byte{byte(i), 0x01}, []byte{byte(i)})
+	}
+	for number := uint64(1); number <= 3; number++ {
+		trie.Update([]byte{byte(number), 0x01}, []byte{byte(number), byte(number)})
+		commitMinedTrie(t, trie, triedb, number)
+	}
+	all := triedb.NodesByAge(2, common.Hash{}, 4, 1000, 1<<20)
+	if len(all) == 0 {
+		t.Fatalf("no nodes served for blocks [2, 4)")
+	}
+	for i, node := range all {
+		if node.Number < 2 || node.Number >= 4 {
+			t.Fatalf("node %d: block %d out of range", i, node.Number)
+		}
+		if err := VerifyNodeHash(testTrieHashimoto, node.Hash, node.Blob, 3, nil); err != nil {
+			t.Fatalf("node %d: invalid node %x: %v", i, node.Hash, err)
+		}
+	}
+	// Fetching one node at a time must yield the same nodes
+	var (
+		from   = uint64(2)
+		origin common.Hash
+	)
+	for i := 0; ; i++ {
+		nodes := triedb.NodesByAge(from, origin, 4, 1, 1<<20)
+		if len(nodes) == 0 {
+			if i != len(all) {
+				t.Fatalf("resumed scan ended after %d nodes, want %d", i, len(all))
+			}
+			break
+		}
+		if i >= len(all) || nodes[0].Hash != all[i].Hash {
+			t.Fatalf("resumed node %d mismatch", i)
+		}
+		from, origin = nodes[0].Number, nodes[0].Hash
+	}
+}
@@ -18,11 +18,14 @@ package trie
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
+	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/rlp"
 )
 
@@ -70,8 +73,8 @@ func (t *Trie) Prove(key []byte, fromLevel uint, proofDb ethdb.KeyValueWriter) e
 	for i, n := range nodes {
 		// Don't bother checking for errors here since hasher panics
 		// if encoding doesn't work and we're not writing to any database.
-		n, _, _ = hasher.hashChildren(n, nil, nil, false, 0, 0, nil)
-		hn, _, _ := hasher.store(n, nil, false, nil, false, 0, 0, nil)
+		n, _, _ = hasher.hashChildren(n, nil, nil, false, 0, nil)
+		hn, _, _ := hasher.store(n, nil, false, nil, false, 0, nil)
 		if hash, ok := hn.(hashNode); ok || i == 0 {
 			// If the node's database encoding is a hash (or is the
 			// root node), it becomes a proof element.
@@ -104,6 +107,33 @@ func (t *SecureTrie) Prove(key []byte, fromLevel uint, proofDb ethdb.KeyValueWri
 // key in a trie with the given root hash. VerifyProof returns an error if the
 // proof contains invalid trie nodes or the wrong value.
 func VerifyProof(rootHash common.Hash, key []byte, proofDb ethdb.KeyValueReader) (value []byte, nodes int, err error) {
+	return verifyProof(rootHash, key, proofDb, nil)
+}
+
+// VerifyProofTH checks merkle proofs of Trie-Hashimoto tries, whose node hashes
+// are mined rather than plain hashes of the nodes, like VerifyProof does. Every
+// node must hash to its hash under the TH rules, the hash must be prefixed with
+// a TH block up to the given header number and meet the trie difficulty target
+// of that block, as returned by difficulty. Nodes carrying a zero nonce under
+// their plain hash were written before Trie-Hashimoto and are taken as is.
+//
+// If the config mixes the header dataset into the node hashes, the nonces are
+// verified against the given dataset, which must hold the headers up to the
+// header number. Proofs are checked by clients without the chain, so there is
+// no falling back to a local dataset, and ErrHeaderDatasetMissing is returned
+// if none is given.
+func VerifyProofTH(config *params.TrieHashimotoConfig, rootHash common.Hash, key []byte, proofDb ethdb.KeyValueReader, headerNumber uint64, difficulty func(number uint64) *big.Int, dataset HeaderDataset) (value []byte, nodes int, err error) {
+	if config != nil && config.ReadHeader && !config.Fake && dataset == nil {
+		return nil, 0, ErrHeaderDatasetMissing
+	}
+	return verifyProof(rootHash, key, proofDb, func(hash common.Hash, blob []byte) error {
+		return verifyProofNode(config, hash, blob, headerNumber, difficulty, dataset)
+	})
+}
+
+// verifyProof walks the proof of key from the root, checking every node with the
+// given function if any.
+func verifyProof(rootHash common.Hash, key []byte, proofDb ethdb.KeyValueReader, check func(hash common.Hash, blob []byte) error) (value []byte, nodes int, err error) {
 	key = keybytesToHex(key)
 	wantHash := rootHash
 	for i := 0; ; i++ {
@@ -111,6 +141,11 @@ func VerifyProof(rootHash common.Hash, key []byte, proofDb ethdb.KeyValueReader)
 		if buf == nil {
 			return nil, i, fmt.Errorf("proof node %d (hash %064x) missing", i, wantHash)
 		}
+		if check != nil {
+			if err := check(wantHash, buf); err != nil {
+				return nil, i, fmt.Errorf("bad proof node %d (hash %064x): %v", i, wantHash, err)
+			}
+		}
 		n, err := decodeNode(wantHash[:], buf)
 		if err != nil {
 			return nil, i, fmt.Errorf("bad proof node %d: %v", i, err)
@@ -129,6 +164,39 @@ func VerifyProof(rootHash common.Hash, key []byte, proofDb ethdb.KeyValueReader)
 	}
 }
 
+// verifyProofNode checks the Trie-Hashimoto properties of a proof node, see
+// VerifyProofTH.
+func verifyProofNode(config *params.TrieHashimotoConfig, hash common.Hash, blob []byte, head uint64, difficulty func(number uint64) *big.Int, dataset HeaderDataset) error {
+	number, plain, err := verifyNodeHash(config, hash, blob, head, dataset)
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		return nil
+	}
+	if plain {
+		// First epoch nodes mined without the header dataset hash plainly too
+		if len(blob) < 8 || binary.LittleEndian.Uint64(blob[len(blob)-8:]) == 0 {
+			return nil
+		}
+		number = HashPrefix(hash, config.PrefixLength)
+		if number > head || (config.Block != nil && number < config.Block.Uint64()) {
+			return ErrInvalidNodePrefix
+		}
+	}
+	if config.Fake {
+		return nil
+	}
+	td := difficulty(number)
+	if td == nil {
+		return fmt.Errorf("unknown trie difficulty of block %d", number)
+	}
+	if !meetsTarget(hash[:], trieTarget(config.PrefixLength, td), config.PrefixLength) {
+		return ErrNodeTargetMissed
+	}
+	return nil
+}
+
 func get(tn node, key []byte) ([]byte, node) {
 	for {
 		switch n := tn.(type) {
@@ -19,6 +19,7 @@ package trie
 import (
 	"bytes"
 	crand "crypto/rand"
+	"math/big"
 	mrand "math/rand"
 	"testing"
 	"time"
@@ -26,6 +27,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/params"
 )
 
 func init() {
@@ -221,3 +223,108 @@ func randBytes(n int) []byte {
 	crand.Read(r)
 	return r
 }
+
+// Tests that proofs of mined tries are only accepted if their nodes are mined in
+// a block up to the header and meet its trie difficulty target.
+func TestVerifyProofTH(t *testing.T) {
+	config := &params.TrieHashimotoConfig{PrefixLength: 1, LoopAccesses: 1}
+	triedb := NewDatabase(memorydb.New())
+	trie, _ := New(common.Hash{}, triedb)
+	for i := 0; i < 64; i++ {
+		trie.Update([]byte{byte(i), 0x01}, bytes.Repeat([]byte{byte(i)}, 32))
+	}
+	difficulty := big.NewInt(4)
+	root, _, err := trie.HashWithNonce(config, 3, difficulty, &Miner{Threads: 2})
+	if err != nil {
+		t.Fatalf("failed to mine trie: %v", err)
+	}
+	trie.Commit(nil)
+	trie, _ = New(root, triedb)
+
+	key := []byte{0x2a, 0x01}
+	proof := memorydb.New()
+	if err := trie.Prove(key, 0, proof); err != nil {
+		t.Fatalf("failed to prove key: %v", err)
+	}
+	difficulties := func(td *big.Int) func(uint64) *big.Int {
+		return func(number uint64) *big.Int {
+			if number != 3 {
+				return nil
+			}
+			return td
+		}
+	}
+	val, _, err := VerifyProofTH(config, root, key, proof, 3, difficulties(difficulty), nil)
+	if err != nil {
+		t.Fatalf("failed to verify proof: %v", err)
+	}
+	if !bytes.Equal(val, bytes.Repeat([]byte{0x2a}, 32)) {
+		t.Fatalf("verified value mismatch: have %x", val)
+	}
+	if _, _, err := VerifyProofTH(config, root, key, proof, 2, difficulties(difficulty), nil); err == nil {
+		t.Errorf("proof of block 3 accepted at block 2")
+	}
+	if _, _, err := VerifyProofTH(config, root, key, proof, 3, difficulties(new(big.Int).Lsh(difficulty, 32)), nil); err == nil {
+		t.Errorf("proof accepted above the trie difficulty target")
+	}
+	// Tamper with the nonce of the root
+	blob, _ := proof.Get(root[:])
+	blob = common.CopyBytes(blob)
+	blob[len(blob)-1]++
+	proof.Put(root[:], blob)
+	if _, _, err := VerifyProofTH(config, root, key, proof, 3, difficulties(difficulty), nil); err == nil {
+		t.Errorf("proof accepted with a tampered nonce")
+	}
+}
+
+// Tests that proofs of tries mixing in the header dataset are verified against
+// the dataset given, without any dataset installed globally, and are rejected
+// without one.
+func TestVerifyProofTHHeaderDataset(t *testing.T) {
+	config := &params.TrieHashimotoConfig{PrefixLength: 1, ReadHeader: true, LoopAccesses: 4}
+
+	defer func(dataset []uint32) { common.RLPedBlockHeadersUint32s = dataset }(common.RLPedBlockHeadersUint32s)
+	SetHeaderDataset(nil)
+
+	setTestHeaderDataset(4096, 1)
+	triedb := NewDatabase(memorydb.New())
+	trie, _ := New(common.Hash{}, triedb)
+	for i := 0; i < 64; i++ {
+		trie.Update([]byte{byte(i), 0x01}, bytes.Repeat([]byte{byte(i)}, 32))
+	}
+	root, _, err := trie.HashWithNonce(config, 3, nil, &Miner{Threads: 2})
+	if err != nil {
+		t.Fatalf("failed to mine trie: %v", err)
+	}
+	trie.Commit(nil)
+	trie, _ = New(root, triedb)
+
+	key := []byte{0x2a, 0x01}
+	proof := memorydb.New()
+	if err := trie.Prove(key, 0, proof); err != nil {
+		t.Fatalf("failed to prove key: %v", err)
+	}
+	difficulty := func(uint64) *big.Int { return common.Big1 }
+
+	// Verify without the in-memory dataset the nonces were mined with
+	dataset := &sliceHeaderDataset{words: common.RLPedBlockHeadersUint32s}
+	common.RLPedBlockHeadersUint32s = nil
+
+	val, _, err := VerifyProofTH(config, root, key, proof, 3, difficulty, dataset)
+	if err != nil {
+		t.Fatalf("failed to verify proof: %v", err)
+	}
+	if !bytes.Equal(val, bytes.Repeat([]byte{0x2a}, 32)) {
+		t.Fatalf("verified value mismatch: have %x", val)
+	}
+	if dataset.lookups == 0 {
+		t.Errorf("proof verified without reading the header dataset")
+	}
+	if _, _, err := VerifyProofTH(config, root, key, proof, 3, difficulty, nil); err != ErrHeaderDatasetMissing {
+		t.Errorf("proof without header dataset: have error %v, want %v", err, ErrHeaderDatasetMissing)
+	}
+	other := &sliceHeaderDataset{words: make([]uint32, 4096)}
+	if _, _, err := VerifyProofTH(config, root, key, proof, 3, difficulty, other); err == nil {
+		t.Errorf("proof verified over another header dataset")
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+var (
+	pruneTimeTimer  = metrics.NewRegisteredResettingTimer("trie/prune/time", nil)
+	pruneNodesMeter = metrics.NewRegisteredMeter("trie/prune/nodes", nil)
+)
+
+// LeafResolver returns the roots of the tries a trie leaf references, like the
+// storage trie of an account.
+type LeafResolver func(leaf []byte) []common.Hash
+
+// Prune deletes the trie nodes flushed to disk that the node age index records
+// in blocks before the given one and that are not reachable from any of the
+// given roots, along with their index entries. Nodes moved into the cold store
+// are dropped from its index only, their data stays in the append-only file.
+// The index is sorted by age, so only its old end is iterated instead of the
+// whole database. Nodes flushed before the index was kept are never pruned.
+// Leaves reached are passed to the resolver, if any, to mark the tries they
+// reference too.
+//
+// Pruning may run while the database is in use, as long as every state built on
+// from now on descends from one of the given roots. It returns the number of
+// nodes deleted.
+func (db *Database) Prune(roots []common.Hash, before uint64, resolve LeafResolver) (int, error) {
+	start := time.Now()
+
+	// Mark every node still reachable from the live tries
+	reachable := make(map[common.Hash]struct{})
+	for _, root := range roots {
+		if err := db.markReachable(root, reachable, resolve); err != nil {
+			return 0, err
+		}
+	}
+	marked := time.Since(start)
+
+	// Delete the unreachable nodes of the old blocks in the index
+	var (
+		pruned int
+		batch  = db.newBatch()
+		it     = db.diskdb.NewIteratorWithPrefix(NodeAgePrefix)
+	)
+	defer it.Release()
+
+	for it.Next() {
+		if !IsNodeAgeKey(it.Key()) {
+			continue
+		}
+		number, hash := SplitNodeAgeKey(it.Key())
+		if number >= before {
+			break
+		}
+		if _, ok := reachable[hash]; ok {
+			continue
+		}
+		if err := batch.Delete(hash[:]); err != nil {
+			return pruned, err
+		}
+		if err := batch.Delete(coldIndexKey(hash)); err != nil {
+			return pruned, err
+		}
+		if err := batch.Delete(it.Key()); err != nil {
+			return pruned, err
+		}
+		if db.cleans != nil {
+			db.cleans.Delete(string(hash[:]))
+		}
+		pruned++
+
+		if batch.ValueSize() >= ethdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return pruned, err
+			}
+			batch.Reset()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return pruned, err
+	}
+	if err := batch.Write(); err != nil {
+		return pruned, err
+	}
+	pruneTimeTimer.UpdateSince(start)
+	pruneNodesMeter.Mark(int64(pruned))
+
+	log.Info("Pruned stale trie nodes", "before", before, "roots", len(roots), "reachable", len(reachable),
+		"pruned", pruned, "marktime", common.PrettyDuration(marked), "elapsed", common.PrettyDuration(time.Since(start)))
+	return pruned, nil
+}
+
+// markReachable adds the hashes of the trie nodes reachable from the root to
+// the set, skipping the subtries of nodes marked already.
+func (db *Database) markReachable(root common.Hash, reachable map[common.Hash]struct{}, resolve LeafResolver) error {
+	if root == emptyRoot || root == (common.Hash{}) {
+		return nil
+	}
+	if _, ok := reachable[root]; ok {
+		return nil
+	}
+	n := db.node(root)
+	if n == nil {
+		return &MissingNodeError{NodeHash: root}
+	}
+	reachable[root] = struct{}{}
+	return db.markChildren(n, reachable, resolve)
+}
+
+// markChildren marks the nodes reachable from the children of a node, including
+// those embedded in it.
+func (db *Database) markChildren(n node, reachable map[common.Hash]struct{}, resolve LeafResolver) error {
+	switch n := n.(type) {
+	case *shortNode:
+		return db.markChildren(n.Val, reachable, resolve)
+	case *fullNode:
+		for _, child := range n.Children {
+			if child == nil {
+				continue
+			}
+			if err := db.markChildren(child, reachable, resolve); err != nil {
+				return err
+			}
+		}
+	case hashNode:
+		return db.markReachable(common.BytesToHash(n), reachable, resolve)
+	case valueNode:
+		if resolve == nil {
+			return nil
+		}
+		for _, root := range resolve(n) {
+			if err := db.markReachable(root, reachable, resolve); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+)
+
+// commitMinedTrie mines the dirty nodes of a trie in the given block and flushes
+// them to disk along with their node age index entries.
+func commitMinedTrie(t *testing.T, trie *Trie, triedb *Database, number uint64) common.Hash {
+	root, _, err := trie.HashWithNonce(testTrieHashimoto, number, nil, &Miner{Threads: 2})
+	if err != nil {
+		t.Fatalf("block %d: failed to mine trie: %v", number, err)
+	}
+	if _, err := trie.Commit(nil); err != nil {
+		t.Fatalf("block %d: failed to commit trie: %v", number, err)
+	}
+	triedb.IndexNodeAge(testTrieHashimoto.PrefixLength, number)
+	if err := triedb.Commit(root, false); err != nil {
+		t.Fatalf("block %d: failed to flush trie: %v", number, err)
+	}
+	return root
+}
+
+// checkTrieComplete checks that every node of the trie is available on disk.
+func checkTrieComplete(t *testing.T, diskdb *memorydb.Database, root common.Hash) {
+	trie, err := New(root, NewDatabase(diskdb))
+	if err != nil {
+		t.Fatalf("failed to open trie %x: %v", root, err)
+	}
+	it := trie.NodeIterator(nil)
+	for it.Next(true) {
+	}
+	if err := it.Error(); err != nil {
+		t.Fatalf("trie %x incomplete: %v", root, err)
+	}
+}
+
+// Tests that pruning deletes the nodes of old states only, keeping every node
+// reachable from the live roots or mined in recent blocks.
+func TestPrune(t *testing.T) {
+	diskdb := memorydb.New()
+	triedb := NewDatabase(diskdb)
+
+	// Create a subtrie referenced from a leaf, then two states in a row
+	sub, _ := New(common.Hash{}, triedb)
+	for i := 0; i < 8; i++ {
+		sub.Update([]byte{0xff, byte(i)}, []byte{byte(i)})
+	}
+	subRoot := commitMinedTrie(t, sub, triedb, 1)
+
+	trie, _ := New(common.Hash{}, triedb)
+	for i := 0; i < 16; i++ {
+		trie.Update([]byte{byte(i), 0x01}, []byte{byte(i)})
+	}
+	trie.Update([]byte("sub"), subRoot[:])
+	oldRoot := commitMinedTrie(t, trie, triedb, 2)
+
+	trie, _ = New(oldRoot, triedb)
+	trie.Update([]byte{0x00, 0x01}, []byte("updated"))
+	trie.Update([]byte{0x0f, 0x01}, []byte("updated"))
+	newRoot := commitMinedTrie(t, trie, triedb, 3)
+
+	// A fresh state in the recent block, unreachable but not old enough
+	fresh, _ := New(common.Hash{}, triedb)
+	fresh.Update([]byte("fresh"), []byte("fresh"))
+	freshRoot := commitMinedTrie(t, fresh, triedb, 3)
+
+	resolve := func(leaf []byte) []common.Hash {
+		if len(leaf) == common.HashLength {
+			return []common.Hash{common.BytesToHash(leaf)}
+		}
+		return nil
+	}
+	pruned, err := NewDatabase(diskdb).Prune([]common.Hash{newRoot}, 3, resolve)
+	if err != nil {
+		t.Fatalf("failed to prune: %v", err)
+	}
+	if pruned == 0 {
+		t.Fatalf("no nodes pruned")
+	}
+	if has, _ := diskdb.Has(oldRoot[:]); has {
+		t.Errorf("old root %x not pruned", oldRoot)
+	}
+	if _, ok := ReadNodeAge(diskdb, oldRoot, testTrieHashimoto.PrefixLength, 3); ok {
+		t.Errorf("old root %x still indexed", oldRoot)
+	}
+	checkTrieComplete(t, diskdb, newRoot)
+	checkTrieComplete(t, diskdb, subRoot)
+	checkTrieComplete(t, diskdb, freshRoot)
+
+	// Pruning again must not find anything else
+	if pruned, err := NewDatabase(diskdb).Prune([]common.Hash{newRoot}, 3, resolve); err != nil || pruned != 0 {
+		t.Fatalf("second pruning: have %d nodes pruned (err %v), want 0", pruned, err)
+	}
+}
+
+// Tests that pruning fails without deleting anything if a live root is missing.
+func TestPruneMissingRoot(t *testing.T) {
+	diskdb := memorydb.New()
+	triedb := NewDatabase(diskdb)
+
+	trie, _ := New(common.Hash{}, triedb)
+	for i := 0; i < 16; i++ {
+		trie.Update([]byte{byte(i)}, []byte{byte(i)})
+	}
+	root := commitMinedTrie(t, trie, triedb, 1)
+	count := diskdb.Len()
+
+	if _, err := triedb.Prune([]common.Hash{{0x01}}, 2, nil); err == nil {
+		t.Fatalf("pruning with a missing root succeeded")
+	}
+	if diskdb.Len() != count {
+		t.Fatalf("database modified: have %d entries, want %d", diskdb.Len(), count)
+	}
+	checkTrieComplete(t, diskdb, root)
+}
@@ -18,10 +18,12 @@ package trie
 
 import (
 	"fmt"
+	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethdb/memorydb"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
 )
 
 // SecureTrie wraps a trie with key hashing. In a secure trie, all
@@ -161,13 +163,18 @@ func (t *SecureTrie) Hash() common.Hash {
 	return t.trie.Hash()
 }
 
-
-func (t *SecureTrie) HashWithNonce(blockNum uint64, threads int) (common.Hash, []uint64) {
-	return t.trie.HashWithNonce(blockNum, threads)
+// HashWithNonce mines every dirty node of the trie with the given Trie-Hashimoto
+// parameters and trie difficulty, returning the indexed root hash and the mined
+// nonces.
+func (t *SecureTrie) HashWithNonce(config *params.TrieHashimotoConfig, blockNum uint64, difficulty *big.Int, miner *Miner) (common.Hash, []uint64, error) {
+	return t.trie.HashWithNonce(config, blockNum, difficulty, miner)
 }
 
-func (t *SecureTrie) HashByNonce(trieNonces []uint64, blockNum uint64) common.Hash {
-	return t.trie.HashByNonce(trieNonces, blockNum)
+// HashByNonce rebuilds the indexed root hash of the trie from previously mined
+// nonces with the given Trie-Hashimoto parameters and trie difficulty, verified
+// against the given header dataset, see Trie.HashByNonce.
+func (t *SecureTrie) HashByNonce(config *params.TrieHashimotoConfig, trieNonces []uint64, blockNum uint64, difficulty *big.Int, dataset HeaderDataset) (common.Hash, error) {
+	return t.trie.HashByNonce(config, trieNonces, blockNum, difficulty, dataset)
 }
 
 // Copy returns a copy of SecureTrie.
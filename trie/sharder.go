@@ -0,0 +1,395 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// Trie node sharding policy names, as accepted by ParseTrieNodeSharder.
+const (
+	ShardNone        = "none"   // All nodes in the main key-value store
+	ShardHex         = "hex"    // 16 shards by the first hex digit of the hash
+	ShardPrefixRange = "prefix" // Contiguous ranges of the block number prefix
+	ShardBlockEpoch  = "epoch"  // Rotating windows of blocks of the block number prefix
+)
+
+// TrieNodeSharder is a policy splitting the trie nodes flushed to disk across a
+// number of key-value stores by their hash. Shard 0 is the main key-value store.
+type TrieNodeSharder interface {
+	// Shards returns the number of shards the nodes are split across.
+	Shards() int
+
+	// Shard returns the shard the node with the given hash is stored in.
+	Shard(hash common.Hash) int
+
+	// String returns the spec of the policy, as accepted by ParseTrieNodeSharder.
+	String() string
+}
+
+// ParseTrieNodeSharder creates the trie node sharding policy of the given spec:
+//
+//	none                  keeps every node in the main key-value store
+//	hex                   splits the nodes into 16 shards by the first hex digit of their hash
+//	prefix:<n>            splits the block number prefix space into n contiguous ranges
+//	epoch:<n>:<blocks>    rotates through n shards every given number of blocks
+//
+// The prefix and epoch policies group the nodes by the block they were mined
+// in, so they need the block number prefixes of Trie-Hashimoto.
+func ParseTrieNodeSharder(spec string, prefixLength int) (TrieNodeSharder, error) {
+	parts := strings.Split(spec, ":")
+	switch parts[0] {
+	case "", ShardNone:
+		if len(parts) != 1 {
+			return nil, fmt.Errorf("invalid trie node sharding policy %q", spec)
+		}
+		return noneSharder{}, nil
+
+	case ShardHex:
+		if len(parts) != 1 {
+			return nil, fmt.Errorf("invalid trie node sharding policy %q", spec)
+		}
+		return hexSharder{}, nil
+
+	case ShardPrefixRange:
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid trie node sharding policy %q, want %s:<shards>", spec, ShardPrefixRange)
+		}
+		shards, err := parseShards(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		return NewPrefixRangeSharder(prefixLength, shards)
+
+	case ShardBlockEpoch:
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid trie node sharding policy %q, want %s:<shards>:<blocks>", spec, ShardBlockEpoch)
+		}
+		shards, err := parseShards(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		blocks, err := strconv.ParseUint(parts[2], 10, 64)
+		if err != nil || blocks == 0 {
+			return nil, fmt.Errorf("invalid trie node shard epoch length %q", parts[2])
+		}
+		return NewBlockEpochSharder(prefixLength, shards, blocks)
+	}
+	return nil, fmt.Errorf("unknown trie node sharding policy %q", spec)
+}
+
+// parseShards parses the number of shards of a sharding policy spec.
+func parseShards(s string) (int, error) {
+	shards, err := strconv.Atoi(s)
+	if err != nil || shards < 1 || shards > 256 {
+		return 0, fmt.Errorf("invalid number of trie node shards %q, want 1-256", s)
+	}
+	return shards, nil
+}
+
+// noneSharder keeps every trie node in the main key-value store.
+type noneSharder struct{}
+
+func (noneSharder) Shards() int                { return 1 }
+func (noneSharder) Shard(hash common.Hash) int { return 0 }
+func (noneSharder) String() string             { return ShardNone }
+
+// hexSharder splits the trie nodes into 16 shards by the first hex digit of
+// their hash, spreading them evenly whatever the block they were mined in.
+type hexSharder struct{}
+
+func (hexSharder) Shards() int                { return 16 }
+func (hexSharder) Shard(hash common.Hash) int { return int(hash[0] >> 4) }
+func (hexSharder) String() string             { return ShardHex }
+
+// NewHexSharder creates a sharding policy splitting the trie nodes into 16
+// shards by the first hex digit of their hash.
+func NewHexSharder() TrieNodeSharder {
+	return hexSharder{}
+}
+
+// prefixRangeSharder splits the block number prefix space into contiguous
+// ranges of equal length, so nodes mined in neighbouring blocks share a shard.
+type prefixRangeSharder struct {
+	prefixLength int
+	shards       int
+	length       uint64 // Number of prefixes in a range
+}
+
+// NewPrefixRangeSharder creates a sharding policy splitting the block number
+// prefix space of the given length into the given number of contiguous ranges.
+func NewPrefixRangeSharder(prefixLength int, shards int) (TrieNodeSharder, error) {
+	if prefixLength < 1 || prefixLength > 8 {
+		return nil, errors.New("prefix range sharding needs block number prefixes")
+	}
+	if shards < 1 {
+		return nil, fmt.Errorf("invalid number of trie node shards %d", shards)
+	}
+	// Round the range length up, so the last range may be a bit shorter. A single
+	// range over the full 8 byte prefixes would be 2^64 long, overflowing, so its
+	// length is left zero and every node goes into the one shard.
+	var length uint64
+	if epoch := PrefixEpochLength(prefixLength); epoch != 0 {
+		length = (epoch + uint64(shards) - 1) / uint64(shards)
+	} else if shards > 1 {
+		length = math.MaxUint64/uint64(shards) + 1
+	}
+	return &prefixRangeSharder{prefixLength: prefixLength, shards: shards, length: length}, nil
+}
+
+func (s *prefixRangeSharder) Shards() int { return s.shards }
+
+func (s *prefixRangeSharder) Shard(hash common.Hash) int {
+	if s.length == 0 {
+		return 0
+	}
+	return int(HashPrefix(hash, s.prefixLength) / s.length)
+}
+
+func (s *prefixRangeSharder) String() string {
+	return fmt.Sprintf("%s:%d", ShardPrefixRange, s.shards)
+}
+
+// blockEpochSharder moves on to the next shard every given number of blocks of
+// the block number prefix, wrapping around after the last one. The nodes of the
+// recent blocks, which are read the most, thus stay in one shard at a time.
+type blockEpochSharder struct {
+	prefixLength int
+	shards       int
+	blocks       uint64
+}
+
+// NewBlockEpochSharder creates a sharding policy rotating through the given
+// number of shards every given number of blocks of the block number prefix.
+func NewBlockEpochSharder(prefixLength int, shards int, blocks uint64) (TrieNodeSharder, error) {
+	if prefixLength < 1 || prefixLength > 8 {
+		return nil, errors.New("block epoch sharding needs block number prefixes")
+	}
+	return &blockEpochSharder{prefixLength: prefixLength, shards: shards, blocks: blocks}, nil
+}
+
+func (s *blockEpochSharder) Shards() int { return s.shards }
+
+func (s *blockEpochSharder) Shard(hash common.Hash) int {
+	return int((HashPrefix(hash, s.prefixLength) / s.blocks) % uint64(s.shards))
+}
+
+func (s *blockEpochSharder) String() string {
+	return fmt.Sprintf("%s:%d:%d", ShardBlockEpoch, s.shards, s.blocks)
+}
+
+// ShardedNodeStore is implemented by disk databases that split the trie nodes
+// across shards. Databases created on top of them route the nodes transparently.
+type ShardedNodeStore interface {
+	NodeShards() *NodeShards
+}
+
+// NodeShards is the set of key-value stores the trie nodes are split across by
+// a sharding policy, the first of which is the main key-value store. Nodes
+// written around the policy, like the ones state sync commits straight into the
+// main store, are still found there until resharded.
+type NodeShards struct {
+	sharder TrieNodeSharder
+	stores  []ethdb.KeyValueStore
+}
+
+// NewNodeShards creates the set of shards of the given sharding policy, with
+// one key-value store for every shard.
+func NewNodeShards(sharder TrieNodeSharder, stores []ethdb.KeyValueStore) (*NodeShards, error) {
+	if len(stores) != sharder.Shards() {
+		return nil, fmt.Errorf("trie node shard count mismatch: have %d stores, want %d", len(stores), sharder.Shards())
+	}
+	return &NodeShards{sharder: sharder, stores: stores}, nil
+}
+
+// Sharder returns the sharding policy of the shards.
+func (s *NodeShards) Sharder() TrieNodeSharder {
+	return s.sharder
+}
+
+// Get retrieves a trie node from its shard, falling back to the main store.
+func (s *NodeShards) Get(hash common.Hash) ([]byte, error) {
+	shard := s.sharder.Shard(hash)
+	enc, err := s.stores[shard].Get(hash[:])
+	if (err != nil || len(enc) == 0) && shard != 0 {
+		enc, err = s.stores[0].Get(hash[:])
+	}
+	return enc, err
+}
+
+// NewBatch creates a write-only batch routing the trie nodes written to their
+// shards and anything else to the main store.
+func (s *NodeShards) NewBatch() ethdb.Batch {
+	batches := make([]ethdb.Batch, len(s.stores))
+	for i, store := range s.stores {
+		batches[i] = store.NewBatch()
+	}
+	return &shardedBatch{sharder: s.sharder, batches: batches}
+}
+
+// shardedBatch is a batch spread over the shards of the trie node database.
+type shardedBatch struct {
+	sharder TrieNodeSharder
+	batches []ethdb.Batch
+}
+
+// Put inserts the given value into the batch of its shard.
+func (b *shardedBatch) Put(key []byte, value []byte) error {
+	if len(key) != common.HashLength {
+		return b.batches[0].Put(key, value)
+	}
+	return b.batches[b.sharder.Shard(common.BytesToHash(key))].Put(key, value)
+}
+
+// Delete removes the key from the batch of its shard, as well as from the main
+// store where nodes written around the policy may live.
+func (b *shardedBatch) Delete(key []byte) error {
+	if len(key) == common.HashLength {
+		if shard := b.sharder.Shard(common.BytesToHash(key)); shard != 0 {
+			if err := b.batches[shard].Delete(key); err != nil {
+				return err
+			}
+		}
+	}
+	return b.batches[0].Delete(key)
+}
+
+// ValueSize retrieves the amount of data queued up for writing in all shards.
+func (b *shardedBatch) ValueSize() int {
+	var size int
+	for _, batch := range b.batches {
+		size += batch.ValueSize()
+	}
+	return size
+}
+
+// Write flushes the main store batch first, then the shard ones. Index entries
+// referencing a node are thus never behind its removal from a shard.
+func (b *shardedBatch) Write() error {
+	for _, batch := range b.batches {
+		if err := batch.Write(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reset resets the batches of all shards for reuse.
+func (b *shardedBatch) Reset() {
+	for _, batch := range b.batches {
+		batch.Reset()
+	}
+}
+
+// Replay replays the batches of all shards.
+func (b *shardedBatch) Replay(w ethdb.KeyValueWriter) error {
+	for _, batch := range b.batches {
+		if err := batch.Replay(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reshard moves the trie nodes in the given key-value stores, the first of which
+// is the main store, into the shards of the given sharding policy. There must be
+// a store for every shard of both the old and the new policy. Every node is
+// copied before it is deleted, so an interrupted run may just be restarted. It
+// returns the number of nodes moved.
+func Reshard(stores []ethdb.KeyValueStore, sharder TrieNodeSharder) (int, error) {
+	if len(stores) < sharder.Shards() {
+		return 0, fmt.Errorf("trie node shard count mismatch: have %d stores, want at least %d", len(stores), sharder.Shards())
+	}
+	var (
+		start   = time.Now()
+		logged  = time.Now()
+		moved   int
+		batches = make([]ethdb.Batch, len(stores))
+	)
+	for i, store := range stores {
+		batches[i] = store.NewBatch()
+	}
+	// flush writes the copies into the target shards ahead of the deletions
+	flush := func(source int) error {
+		for i, batch := range batches {
+			if i == source {
+				continue
+			}
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+		if err := batches[source].Write(); err != nil {
+			return err
+		}
+		batches[source].Reset()
+		return nil
+	}
+	for source, store := range stores {
+		it := store.NewIterator()
+		for it.Next() {
+			key := it.Key()
+			if len(key) != common.HashLength {
+				continue
+			}
+			target := sharder.Shard(common.BytesToHash(key))
+			if target == source {
+				continue
+			}
+			if err := batches[target].Put(key, it.Value()); err != nil {
+				it.Release()
+				return moved, err
+			}
+			if err := batches[source].Delete(key); err != nil {
+				it.Release()
+				return moved, err
+			}
+			moved++
+
+			if batches[source].ValueSize()+batches[target].ValueSize() >= ethdb.IdealBatchSize {
+				if err := flush(source); err != nil {
+					it.Release()
+					return moved, err
+				}
+			}
+			if time.Since(logged) > 8*time.Second {
+				log.Info("Resharding trie nodes", "shard", source, "moved", moved, "elapsed", common.PrettyDuration(time.Since(start)))
+				logged = time.Now()
+			}
+		}
+		err := it.Error()
+		it.Release()
+		if err != nil {
+			return moved, err
+		}
+		if err := flush(source); err != nil {
+			return moved, err
+		}
+	}
+	log.Info("Resharded trie nodes", "policy", sharder, "moved", moved, "elapsed", common.PrettyDuration(time.Since(start)))
+	return moved, nil
+}
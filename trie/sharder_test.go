@@ -0,0 +1,203 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+)
+
+// Tests that sharding policy specs are parsed and printed back identically.
+func TestParseTrieNodeSharder(t *testing.T) {
+	tests := []struct {
+		spec   string
+		prefix int
+		shards int
+		fail   bool
+	}{
+		{spec: "", prefix: 0, shards: 1},
+		{spec: "none", prefix: 0, shards: 1},
+		{spec: "hex", prefix: 0, shards: 16},
+		{spec: "prefix:4", prefix: 2, shards: 4},
+		{spec: "prefix:1", prefix: 8, shards: 1},
+		{spec: "epoch:3:1000", prefix: 2, shards: 3},
+		{spec: "prefix:4", prefix: 0, fail: true},
+		{spec: "epoch:3:1000", prefix: 0, fail: true},
+		{spec: "prefix", prefix: 2, fail: true},
+		{spec: "prefix:0", prefix: 2, fail: true},
+		{spec: "prefix:257", prefix: 2, fail: true},
+		{spec: "epoch:3", prefix: 2, fail: true},
+		{spec: "epoch:3:0", prefix: 2, fail: true},
+		{spec: "hex:2", prefix: 2, fail: true},
+		{spec: "random", prefix: 2, fail: true},
+	}
+	for i, tt := range tests {
+		sharder, err := ParseTrieNodeSharder(tt.spec, tt.prefix)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("test %d: spec %q accepted", i, tt.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: spec %q rejected: %v", i, tt.spec, err)
+			continue
+		}
+		if sharder.Shards() != tt.shards {
+			t.Errorf("test %d: shard count mismatch: have %d, want %d", i, sharder.Shards(), tt.shards)
+		}
+		if spec := sharder.String(); spec != tt.spec && tt.spec != "" {
+			t.Errorf("test %d: spec mismatch: have %q, want %q", i, spec, tt.spec)
+		}
+	}
+}
+
+// Tests that the block number prefix policies assign the expected shards.
+func TestPrefixShards(t *testing.T) {
+	prefixed := func(prefix ...byte) common.Hash {
+		var hash common.Hash
+		copy(hash[:], prefix)
+		hash[31] = 0xff
+		return hash
+	}
+	ranges, _ := NewPrefixRangeSharder(1, 4)
+	epochs, _ := NewBlockEpochSharder(2, 3, 10)
+	full, _ := NewPrefixRangeSharder(8, 3)
+	single, _ := NewPrefixRangeSharder(8, 1)
+
+	tests := []struct {
+		sharder TrieNodeSharder
+		hash    common.Hash
+		shard   int
+	}{
+		{ranges, prefixed(0), 0},
+		{ranges, prefixed(63), 0},
+		{ranges, prefixed(64), 1},
+		{ranges, prefixed(255), 3},
+		{epochs, prefixed(0, 9), 0},
+		{epochs, prefixed(0, 10), 1},
+		{epochs, prefixed(0, 29), 2},
+		{epochs, prefixed(0, 30), 0},
+		{full, prefixed(0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff), 2},
+		{single, prefixed(0), 0},
+		{single, prefixed(0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff), 0},
+		{NewHexSharder(), prefixed(0xa0), 10},
+	}
+	for i, tt := range tests {
+		if shard := tt.sharder.Shard(tt.hash); shard != tt.shard {
+			t.Errorf("test %d: shard mismatch for %x: have %d, want %d", i, tt.hash[:8], shard, tt.shard)
+		}
+	}
+}
+
+// shardedTestDB is a key-value store with the trie nodes split across shards.
+type shardedTestDB struct {
+	*memorydb.Database
+	shards *NodeShards
+}
+
+func (db *shardedTestDB) NodeShards() *NodeShards { return db.shards }
+
+// checkShards checks that every trie node in the stores is in its shard.
+func checkShards(t *testing.T, stores []ethdb.KeyValueStore, sharder TrieNodeSharder) {
+	for i, store := range stores {
+		it := store.NewIterator()
+		for it.Next() {
+			if len(it.Key()) != common.HashLength {
+				continue
+			}
+			if shard := sharder.Shard(common.BytesToHash(it.Key())); shard != i {
+				t.Errorf("node %x in shard %d, want %d", it.Key(), i, shard)
+			}
+		}
+		it.Release()
+	}
+}
+
+// Tests that trie nodes are flushed into and read from their shards, and that
+// they are moved into the right ones on resharding.
+func TestShardedDatabase(t *testing.T) {
+	stores := make([]ethdb.KeyValueStore, 16)
+	for i := range stores {
+		stores[i] = memorydb.New()
+	}
+	sharder, _ := NewBlockEpochSharder(testTrieHashimoto.PrefixLength, 4, 1)
+	shards, err := NewNodeShards(sharder, stores[:4])
+	if err != nil {
+		t.Fatalf("failed to create shards: %v", err)
+	}
+	diskdb := &shardedTestDB{Database: stores[0].(*memorydb.Database), shards: shards}
+	triedb := NewDatabase(diskdb)
+
+	var roots []common.Hash
+	for number := uint64(1); number <= 3; number++ {
+		trie, _ := New(common.Hash{}, triedb)
+		for i := 0; i < 16; i++ {
+			trie.Update([]byte{byte(number), byte(i)}, []byte{byte(i)})
+		}
+		roots = append(roots, commitMinedTrie(t, trie, triedb, number))
+	}
+	for i, root := range roots {
+		if has, _ := stores[i+1].Has(root[:]); !has {
+			t.Errorf("root %x not in shard %d", root, i+1)
+		}
+	}
+	checkShards(t, stores[:4], sharder)
+
+	// Nodes written around the policy must be found in the main store
+	maindb := NewDatabase(stores[0])
+	trie, _ := New(common.Hash{}, maindb)
+	trie.Update([]byte("synced"), []byte("synced"))
+	synced := commitMinedTrie(t, trie, maindb, 2)
+
+	for _, root := range append(roots, synced) {
+		trie, err := New(root, NewDatabase(diskdb))
+		if err != nil {
+			t.Fatalf("failed to open trie %x: %v", root, err)
+		}
+		it := trie.NodeIterator(nil)
+		for it.Next(true) {
+		}
+		if err := it.Error(); err != nil {
+			t.Fatalf("trie %x incomplete: %v", root, err)
+		}
+	}
+	// Reshard into the 16 hex shards and check everything moved
+	if _, err := Reshard(stores, NewHexSharder()); err != nil {
+		t.Fatalf("failed to reshard: %v", err)
+	}
+	checkShards(t, stores, NewHexSharder())
+
+	if diskdb.shards, err = NewNodeShards(NewHexSharder(), stores); err != nil {
+		t.Fatalf("failed to create shards: %v", err)
+	}
+	for _, root := range append(roots, synced) {
+		trie, err := New(root, NewDatabase(diskdb))
+		if err != nil {
+			t.Fatalf("failed to open resharded trie %x: %v", root, err)
+		}
+		it := trie.NodeIterator(nil)
+		for it.Next(true) {
+		}
+		if err := it.Error(); err != nil {
+			t.Fatalf("resharded trie %x incomplete: %v", root, err)
+		}
+	}
+}
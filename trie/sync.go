@@ -19,16 +19,13 @@ package trie
 import (
 	"errors"
 	"fmt"
-	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/prque"
 	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
 )
 
-// overhead of correctly prefixing for fake IMPT data (nanoseconds)
-var TotalPrefixingOverhead uint64 = 0
-
 // ErrNotRequested is returned by the trie sync when it's requested to process a
 // node it did not request.
 var ErrNotRequested = errors.New("not requested")
@@ -82,9 +79,9 @@ type Sync struct {
 	queue    *prque.Prque             // Priority queue with the pending requests
 	bloom    *SyncBloom               // Bloom filter for fast node existence checks
 
-	// s.hashes[fakeHash_withoutBlockNumberPrefix] = fakeHash (this is for fast sync with fake Trie-Hashimoto data) (jmlee)
-	// (this is not necessary with real Trie-Hashimoto data which has really PoW-mined trie nodes)
-	hashes	map[string]common.Hash
+	th      *params.TrieHashimotoConfig // Trie-Hashimoto config the retrieved nodes are hashed with, if any
+	pivot   uint64                      // Most recent block the retrieved nodes may be mined in
+	dataset HeaderDataset               // Header dataset up to the pivot the nonces are verified against
 }
 
 // NewSync creates a new trie data download scheduler.
@@ -95,12 +92,20 @@ func NewSync(root common.Hash, database ethdb.KeyValueReader, callback LeafCallb
 		requests: make(map[common.Hash]*request),
 		queue:    prque.New(nil),
 		bloom:    bloom,
-		hashes: make(map[string]common.Hash),
 	}
 	ts.AddSubTrie(root, 0, common.Hash{}, callback)
 	return ts
 }
 
+// SetTrieHashimoto makes the sync accept trie nodes whose hashes are mined with
+// their nonce under the given Trie-Hashimoto config, in blocks up to the pivot.
+// Since such hashes can't be derived from the node data alone, every retrieved
+// node is checked against its request with VerifyNodeHash, reading the rows of
+// the given header dataset, see there.
+func (s *Sync) SetTrieHashimoto(config *params.TrieHashimotoConfig, pivot uint64, dataset HeaderDataset) {
+	s.th, s.pivot, s.dataset = config, pivot, dataset
+}
+
 // AddSubTrie registers a new trie to the sync code, rooted at the designated parent.
 func (s *Sync) AddSubTrie(root common.Hash, depth int, parent common.Hash, callback LeafCallback) {
 	// Short circuit if the trie is empty or already known
@@ -186,11 +191,16 @@ func (s *Sync) Missing(max int) []common.Hash {
 
 // Process injects a batch of retrieved trie nodes data, returning if something
 // was committed to the database and also the index of an entry if processing of
-// it failed.
+// it failed. Data not hashing to its result hash is rejected with an error from
+// VerifyNodeHash before anything else is checked, so that the callers can try
+// the data against other requested hashes.
 func (s *Sync) Process(results []SyncResult) (bool, int, error) {
 	committed := false
 
 	for i, item := range results {
+		if err := VerifyNodeHash(s.th, item.Hash, item.Data, s.pivot, s.dataset); err != nil {
+			return committed, i, err
+		}
 		// If the item was not requested, bail out
 		request := s.requests[item.Hash]
 		if request == nil {
@@ -265,15 +275,6 @@ func (s *Sync) schedule(req *request) {
 	// Schedule the request for future retrieval
 	s.queue.Push(req.hash, int64(req.depth))
 	s.requests[req.hash] = req
-
-	// insert hash info for syncing with fake TH data (jmlee)
-	startTime := time.Now()
-	prefixLen := PrefixLength*2 + 2 // = blockNumberLen + "0x"
-	hashWithoutPrefix := string([]rune(req.hash.Hex())[prefixLen:])
-	s.hashes[hashWithoutPrefix] = req.hash
-	elapsedTime := time.Since(startTime)
-	TotalPrefixingOverhead += uint64(elapsedTime.Nanoseconds())
-	// fmt.Println("hashWithoutPrefix:", hashWithoutPrefix, "/ fakeHash:", req.hash.Hex())
 }
 
 // children retrieves all the missing children of a state trie entry for future
@@ -352,14 +353,6 @@ func (s *Sync) commit(req *request) (err error) {
 
 	delete(s.requests, req.hash)
 
-	// delete hash info for syncing with fake TH data (jmlee)
-	startTime := time.Now()
-	prefixLen := PrefixLength*2 + 2 // = blockNumberLen + "0x"
-	hashWithoutPrefix := string([]rune(req.hash.Hex())[prefixLen:])
-	delete(s.hashes, hashWithoutPrefix)
-	elapsedTime := time.Since(startTime)
-	TotalPrefixingOverhead += uint64(elapsedTime.Nanoseconds())
-
 	// Check all parents for completion
 	for _, parent := range req.parents {
 		parent.deps--
@@ -371,19 +364,3 @@ func (s *Sync) commit(req *request) (err error) {
 	}
 	return nil
 }
-
-// forcely change trie node's hash value for syncing with fake TH data (jmlee)
-func (s *Sync) PrefixingTrieNodeHash(resHash *common.Hash) {
-	
-	prefixLen := PrefixLength*2 + 2 // = blockNumberLen + "0x"
-	subRune1 := []rune(resHash.Hex())
-	subStr1 := string(subRune1[prefixLen:])
-
-	if fakeHash, ok := s.hashes[subStr1]; ok {
-		// fmt.Println("matched!")
-		// fmt.Println("resHash:", resHash.Hex(), "/ fakeHash:", fakeHash.Hex())
-		*resHash = fakeHash
-		return
-	}
-	
-}
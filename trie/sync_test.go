@@ -356,3 +356,89 @@ func TestIncompleteSync(t *testing.T) {
 		diskdb.Put(key, value)
 	}
 }
+
+// Tests that tries mined across prefix epochs sync by their mined node hashes,
+// and that nodes are rejected if they don't hash to the requested hash in any
+// block up to the pivot.
+func TestTrieHashimotoSync(t *testing.T) {
+	srcDb := NewDatabase(memorydb.New())
+	trie, _ := New(common.Hash{}, srcDb)
+	for i := 0; i < 64; i++ {
+		trie.Update([]byte{byte(i), 0x01}, []byte{byte(i)})
+	}
+	commit := func(number uint64) common.Hash {
+		root, _, err := trie.HashWithNonce(testWrapTrieHashimoto, number, nil, &Miner{Threads: 2})
+		if err != nil {
+			t.Fatalf("block %d: failed to mine trie: %v", number, err)
+		}
+		if _, err := trie.Commit(nil); err != nil {
+			t.Fatalf("block %d: failed to commit trie: %v", number, err)
+		}
+		return root
+	}
+	commit(3)
+
+	// Blocks 3 and 259 share their prefix, so nodes of both are tried by epoch
+	trie.Update([]byte{0x00, 0x01}, []byte("updated"))
+	root := commit(259)
+
+	// Syncing up to an earlier pivot or without the config must fail
+	for _, pivot := range []uint64{258, 259} {
+		diskdb := memorydb.New()
+		sched := NewSync(root, diskdb, nil, NewSyncBloom(1, diskdb))
+		if pivot == 258 {
+			if _, _, err := sched.Process([]SyncResult{{root, mustNode(t, srcDb, root)}}); err != ErrNodeHashMismatch {
+				t.Fatalf("unconfigured sync: have error %v, want %v", err, ErrNodeHashMismatch)
+			}
+		}
+		sched.SetTrieHashimoto(testWrapTrieHashimoto, pivot, nil)
+
+		queue := append([]common.Hash{}, sched.Missing(0)...)
+		for len(queue) > 0 {
+			results := make([]SyncResult, len(queue))
+			for i, hash := range queue {
+				results[i] = SyncResult{hash, mustNode(t, srcDb, hash)}
+			}
+			_, index, err := sched.Process(results)
+			if pivot == 258 {
+				if err != ErrNodeHashMismatch {
+					t.Fatalf("pivot %d: have error %v, want %v", pivot, err, ErrNodeHashMismatch)
+				}
+				break
+			}
+			if err != nil {
+				t.Fatalf("failed to process result #%d: %v", index, err)
+			}
+			// Swapped nodes and tampered nonces must not be accepted
+			if len(results) > 1 {
+				swapped := []SyncResult{{results[0].Hash, results[1].Data}}
+				if _, _, err := sched.Process(swapped); err != ErrNodeHashMismatch {
+					t.Fatalf("swapped node: have error %v, want %v", err, ErrNodeHashMismatch)
+				}
+			}
+			if index, err := sched.Commit(diskdb); err != nil {
+				t.Fatalf("failed to commit data #%d: %v", index, err)
+			}
+			queue = append(queue[:0], sched.Missing(0)...)
+		}
+		if pivot == 259 {
+			if err := checkTrieConsistency(NewDatabase(diskdb), root); err != nil {
+				t.Fatalf("synced trie inconsistent: %v", err)
+			}
+		}
+	}
+	blob := common.CopyBytes(mustNode(t, srcDb, root))
+	blob[len(blob)-1] ^= 0xff
+	if err := VerifyNodeHash(testWrapTrieHashimoto, root, blob, 259, nil); err != ErrNodeHashMismatch {
+		t.Fatalf("tampered nonce: have error %v, want %v", err, ErrNodeHashMismatch)
+	}
+}
+
+// mustNode retrieves the data of a trie node, failing the test if it's missing.
+func mustNode(t *testing.T, db *Database, hash common.Hash) []byte {
+	data, err := db.Node(hash)
+	if err != nil {
+		t.Fatalf("failed to retrieve node data for %x: %v", hash, err)
+	}
+	return data
+}
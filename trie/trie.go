@@ -20,11 +20,14 @@ package trie
 import (
 	"bytes"
 	"fmt"
-	
+	"math/big"
+	"sync/atomic"
+
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethdb/memorydb"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
 )
 
 var (
@@ -405,7 +408,7 @@ func (t *Trie) resolveHash(n hashNode, prefix []byte) (node, error) {
 // Hash returns the root hash of the trie. It does not write to the
 // database and can be used even if the trie doesn't have one.
 func (t *Trie) Hash() common.Hash {
-	hash, cached, _ := t.hashRoot(nil, nil, nil, false, 0, 0)
+	hash, cached, _ := t.hashRoot(nil, nil, nil, nil, false, 0, nil, nil, nil)
 	t.root = cached
 	return common.BytesToHash(hash.(hashNode))
 }
@@ -413,20 +416,46 @@ func (t *Trie) Hash() common.Hash {
 // HashWithNonce returns the root hash of the indexed MPT and the IMPT mining results.
 // It recursively does mining work for each state trie node and stores the mining results.
 // It does not write to the database and can be used even if the trie doesn't have one.
-func (t *Trie) HashWithNonce(blockNum uint64, threads int) (common.Hash, []uint64) {
+// Every node hash is prefixed with the block number and meets the target of the
+// block's trie difficulty, a nil difficulty only requiring the prefix.
+// A nil miner, or one without threads or remote miners, searches nonces on all
+// CPUs locally. If the miner is aborted, a MiningAbortedError is returned and
+// the trie is left unchanged.
+func (t *Trie) HashWithNonce(config *params.TrieHashimotoConfig, blockNum uint64, difficulty *big.Int, miner *Miner) (common.Hash, []uint64, error) {
+	if miner == nil {
+		miner = localMiner()
+	} else if miner.Threads <= 0 && miner.Remote == nil {
+		miner = &Miner{Threads: localMiner().Threads, Abort: miner.Abort}
+	}
+	session := newMiningSession(miner)
 	trieNonces := []uint64{}
-	hash, cached, _ := t.hashRoot(nil, nil, &trieNonces, true, blockNum, threads)
+	hash, cached, err := t.hashRoot(nil, nil, config, &trieNonces, true, blockNum, difficulty, nil, session)
+	if err == errMiningAborted {
+		return common.Hash{}, nil, &MiningAbortedError{Number: blockNum, Mined: int(atomic.LoadInt64(&session.mined))}
+	}
+	if err != nil {
+		return common.Hash{}, nil, err
+	}
 	t.root = cached
-	return common.BytesToHash(hash.(hashNode)), trieNonces
+	return common.BytesToHash(hash.(hashNode)), trieNonces, nil
 }
 
 // HashByNonce returns the root hash of the indexed MPT which node is indexed by the trieNonces field in the block body. 
 // It modifies each state trie node of locals to the indexed one by miner.
 // It does not write to the database and can be used even if the trie doesn't have one.
-func (t *Trie) HashByNonce(trieNonces []uint64, blockNum uint64) common.Hash {
-	hash, cached, _ := t.hashRoot(nil, nil, &trieNonces, false, blockNum, 0)
+// An error is returned if the nonces do not match the dirty nodes of the trie one to one
+// or if any of them fails to index its node with the block number or to meet the
+// target of the block's trie difficulty.
+// The nonces are verified against the rows of the given header dataset, which
+// must hold the headers before the block. A nil dataset stands for the one set by
+// SetHeaderDataset, or the in-memory dataset if none is.
+func (t *Trie) HashByNonce(config *params.TrieHashimotoConfig, trieNonces []uint64, blockNum uint64, difficulty *big.Int, dataset HeaderDataset) (common.Hash, error) {
+	hash, cached, err := t.hashRoot(nil, nil, config, &trieNonces, false, blockNum, difficulty, dataset, nil)
+	if err != nil {
+		return common.Hash{}, err
+	}
 	t.root = cached
-	return common.BytesToHash(hash.(hashNode))
+	return common.BytesToHash(hash.(hashNode)), nil
 }
 
 // Commit writes all nodes to the trie's memory database, tracking the internal
@@ -437,7 +466,7 @@ func (t *Trie) Commit(onleaf LeafCallback) (root common.Hash, err error) {
 	}
 	// Print the size of state trie
 	// if t.root != nil { fmt.Println("trie size: ", t.TrieSize()) }
-	hash, cached, err := t.hashRoot(t.db, onleaf, nil, false, 0, 0)
+	hash, cached, err := t.hashRoot(t.db, onleaf, nil, nil, false, 0, nil, nil, nil)
 	if err != nil {
 		return common.Hash{}, err
 	}
@@ -445,14 +474,35 @@ func (t *Trie) Commit(onleaf LeafCallback) (root common.Hash, err error) {
 	return common.BytesToHash(hash.(hashNode)), nil
 }
 
-func (t *Trie) hashRoot(db *Database, onleaf LeafCallback, trieNonces *[]uint64, isMining bool, blockNum uint64, threads int) (node, node, error) {
+func (t *Trie) hashRoot(db *Database, onleaf LeafCallback, config *params.TrieHashimotoConfig, trieNonces *[]uint64, isMining bool, blockNum uint64, difficulty *big.Int, dataset HeaderDataset, miner *miningSession) (node, node, error) {
 	if t.root == nil {
+		if trieNonces != nil && !isMining && len(*trieNonces) != 0 {
+			return hashNode{}, nil, ErrUnusedTrieNonces
+		}
 		return hashNode(emptyRoot.Bytes()), nil, nil
 	}
 	h := newHasher(onleaf)
 	defer returnHasherToPool(h)
+	h.th = config
+	h.miner = miner
+	if config != nil {
+		h.target = trieTarget(config.PrefixLength, difficulty)
+	}
+	if trieNonces != nil && !isMining {
+		if h.light = dataset; h.light == nil {
+			h.light = headerDataset()
+		}
+	}
 	var count = uint64(0)
-	return h.hash(t.root, db, true, trieNonces, isMining, blockNum, threads, &count)
+	hashed, cached, err := h.hash(t.root, db, true, trieNonces, isMining, blockNum, &count)
+	if err != nil {
+		return hashed, cached, err
+	}
+	// Every supplied nonce must have been consumed by a dirty node
+	if trieNonces != nil && !isMining && count != uint64(len(*trieNonces)) {
+		return hashNode{}, t.root, ErrUnusedTrieNonces
+	}
+	return hashed, cached, nil
 }
 
 // TrieSize returns the total node size in the state trie (sjkim)